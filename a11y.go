@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/* -------------------------------
+   Accessibility (-a11y)
+
+   Checks article content, as it
+   will be rendered, for
+   - <img> without an alt attribute
+   - links with no text, no alt'd
+     image and no aria-label/title
+   - skipped heading levels (h2 → h4),
+     counting from the title's <h1>
+
+   report – log and count them
+   fix    – also give alt-less images
+            alt="" (decorative) and
+            unwrap empty links, keeping
+            what is inside them;
+            heading levels are left to
+            -heading-base
+
+   -a11y-report writes each run's
+   findings to a JSON file.
+--------------------------------*/
+
+const (
+	a11yMissingAlt   = "missing-alt"
+	a11yEmptyLink    = "empty-link"
+	a11ySkippedLevel = "skipped-heading-level"
+)
+
+type a11yIssue struct {
+	Kind   string `json:"kind"`
+	Detail string `json:"detail"` // the image src, link href or "h2 → h4"
+	Fixed  bool   `json:"fixed"`
+}
+
+type a11yArticle struct {
+	File   string      `json:"file"`
+	Issues []a11yIssue `json:"issues"`
+}
+
+type Accessibility struct {
+	fix    bool
+	report string // -a11y-report path
+
+	mu       sync.Mutex
+	articles []a11yArticle
+}
+
+func NewAccessibility(mode, report string) (*Accessibility, error) {
+	switch mode {
+	case "report", "fix":
+	default:
+		return nil, fmt.Errorf("a11y %q: want off, report or fix", mode)
+	}
+	return &Accessibility{fix: mode == "fix", report: report}, nil
+}
+
+// Apply lists the content's accessibility issues in doc.a11y and, in fix
+// mode, fixes what it can. It runs after the other content passes.
+func (a *Accessibility) Apply(doc *renderDoc, toks []htmlToken) []htmlToken {
+	var issues []a11yIssue
+	out := make([]htmlToken, 0, len(toks))
+	dropEnd := map[int]bool{} // end tags of unwrapped links
+	level := 1                // the title's <h1>
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if dropEnd[i] {
+			continue
+		}
+		switch {
+		case t.isTag("img"):
+			if _, ok := t.attr("alt"); !ok && !decorative(t) {
+				src, _ := t.attr("src")
+				issues = append(issues, a11yIssue{Kind: a11yMissingAlt, Detail: src, Fixed: a.fix})
+				if a.fix {
+					t.setAttr("alt", "")
+				}
+			}
+		case t.isTag("a"):
+			href, ok := t.attr("href")
+			if !ok || t.kind == htmlSelfClosingTag || accessibleName(t) {
+				break
+			}
+			end := elementEnd(toks, i)
+			if end == i || linkLabelled(toks[i+1:end]) {
+				break
+			}
+			issues = append(issues, a11yIssue{Kind: a11yEmptyLink, Detail: href, Fixed: a.fix})
+			if a.fix {
+				dropEnd[end] = true
+				continue
+			}
+		case t.isTag("h1", "h2", "h3", "h4", "h5", "h6"):
+			n := int(t.data[1] - '0')
+			if n > level+1 {
+				issues = append(issues, a11yIssue{Kind: a11ySkippedLevel, Detail: fmt.Sprintf("h%d → h%d", level, n)})
+			}
+			level = n
+		}
+		out = append(out, t)
+	}
+	doc.a11y = issues
+	if !a.fix {
+		return toks
+	}
+	return out
+}
+
+// record logs an uploaded article's issues and keeps them for the run's
+// report.
+func (a *Accessibility) record(file string, issues []a11yIssue) {
+	if len(issues) == 0 {
+		return
+	}
+	a.mu.Lock()
+	a.articles = append(a.articles, a11yArticle{File: file, Issues: issues})
+	a.mu.Unlock()
+	log.Printf("A11Y  %s: %s", file, a11yCounts(issues))
+}
+
+// decorative reports whether an image is hidden from assistive technology.
+func decorative(img htmlToken) bool {
+	role, _ := img.attr("role")
+	hidden, _ := img.attr("aria-hidden")
+	return role == "presentation" || role == "none" || hidden == "true"
+}
+
+// accessibleName reports whether an element is labelled by attribute.
+func accessibleName(t htmlToken) bool {
+	for _, k := range []string{"aria-label", "aria-labelledby", "title"} {
+		if v, _ := t.attr(k); strings.TrimSpace(v) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// linkLabelled reports whether a link's content gives it a name: text, an
+// image with alt text, or a labelled element.
+func linkLabelled(inner []htmlToken) bool {
+	if strings.TrimSpace(textContent(inner)) != "" {
+		return true
+	}
+	for _, t := range inner {
+		if t.kind != htmlStartTag && t.kind != htmlSelfClosingTag {
+			continue
+		}
+		if alt, _ := t.attr("alt"); t.isTag("img") && strings.TrimSpace(alt) != "" {
+			return true
+		}
+		if accessibleName(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// a11yCounts summarises issues as "2 missing-alt, 1 empty-link".
+func a11yCounts(issues []a11yIssue) string {
+	counts := map[string]int{}
+	for _, is := range issues {
+		counts[is.Kind]++
+	}
+	kinds := make([]string, 0, len(counts))
+	for k := range counts {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, k := range kinds {
+		parts[i] = fmt.Sprintf("%d %s", counts[k], k)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// finish writes the run's totals to w and the -a11y-report file, then
+// starts afresh for the next run.
+func (a *Accessibility) finish(w io.Writer, runID string) {
+	a.mu.Lock()
+	articles := a.articles
+	a.articles = nil
+	a.mu.Unlock()
+
+	var all []a11yIssue
+	fixed := 0
+	for _, art := range articles {
+		for _, is := range art.Issues {
+			all = append(all, is)
+			if is.Fixed {
+				fixed++
+			}
+		}
+	}
+	if len(all) > 0 {
+		fmt.Fprintf(w, "Accessibility: %d issues in %d articles (%s)", len(all), len(articles), a11yCounts(all))
+		if fixed > 0 {
+			fmt.Fprintf(w, ", %d fixed", fixed)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if a.report == "" {
+		return
+	}
+	sort.Slice(articles, func(i, j int) bool { return articles[i].File < articles[j].File })
+	doc := struct {
+		RunID    string        `json:"run_id"`
+		Issues   int           `json:"issues"`
+		Fixed    int           `json:"fixed"`
+		Articles []a11yArticle `json:"articles"`
+	}{runID, len(all), fixed, articles}
+	if doc.Articles == nil {
+		doc.Articles = []a11yArticle{}
+	}
+	b, _ := json.MarshalIndent(doc, "", "  ")
+	if err := os.WriteFile(a.report, append(b, '\n'), 0o644); err != nil {
+		log.Printf("a11y report: %v", err)
+	}
+}
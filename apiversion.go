@@ -0,0 +1,219 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   API versions (-api-version)
+
+   v2 and v3 take the same multipart
+   upload under different endpoint
+   and field names; apiDialects holds
+   the differences. At startup the
+   server is asked which versions it
+   speaks (GET {root}/versions, root
+   being -api without its /vN), and
+   -api's version segment is swapped
+   for the one picked.
+
+   The same document may publish
+   field length limits ("limits":
+   {"title": 255} or {"title":
+   {"max_length": 255}}), which
+   -title-max falls back on.
+--------------------------------*/
+
+// apiDialect is how one API version spells an upload.
+type apiDialect struct {
+	version         string
+	uploadPath      string // POST target, under the versioned base
+	htmlField       string
+	metadataField   string
+	collectionField string // repeated for several collections
+	attachmentField string
+	coverField      string // the item's cover image, "" = not supported
+	dateKey         string // metadata key of the publish date
+	sourceKey       string // metadata key of the source URL
+}
+
+var apiDialects = map[string]*apiDialect{
+	"v2": {
+		version:         "v2",
+		uploadPath:      "/omnipub",
+		htmlField:       "html_content",
+		metadataField:   "metadata",
+		collectionField: "collection_id",
+		attachmentField: "attachments",
+		dateKey:         "creation_date",
+		sourceKey:       "source_url",
+	},
+	"v3": {
+		version:         "v3",
+		uploadPath:      "/items",
+		htmlField:       "html",
+		metadataField:   "metadata",
+		collectionField: "collection_ids",
+		attachmentField: "files",
+		coverField:      "cover",
+		dateKey:         "published_at",
+		sourceKey:       "source_url",
+	},
+}
+
+// knownVersions lists the versions this build speaks, newest first.
+var knownVersions = []string{"v3", "v2"}
+
+var versionSegment = regexp.MustCompile(`/(v[0-9]+)$`)
+
+// splitVersion splits an API base into its root and trailing /vN
+// version segment, if it has one.
+func splitVersion(base string) (root, version string) {
+	if m := versionSegment.FindStringSubmatchIndex(base); m != nil {
+		return base[:m[0]], base[m[2]:m[3]]
+	}
+	return base, ""
+}
+
+// withVersion replaces the /vN segment at the end of base, if any, with
+// version.
+func withVersion(base, version string) string {
+	base = strings.TrimSuffix(base, "/")
+	if root, v := splitVersion(base); v != "" {
+		return root + "/" + version
+	}
+	return base
+}
+
+// serverVersions asks the API which versions it supports, and any field
+// length limits it publishes. The answer may be a list, or an object with
+// "versions" / "supported_versions", of strings or of objects naming the
+// version.
+func (t *Transformer) serverVersions(ctx context.Context, root string) ([]string, map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, root+"/versions", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header = t.headers.Clone()
+	t.auth(req, t.keyPool().pick().key)
+	req.Header.Set("Accept", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("GET %s: http %d", req.URL.Path, resp.StatusCode)
+	}
+
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, fmt.Errorf("GET %s: %w", req.URL.Path, err)
+	}
+	list, _ := doc.([]any)
+	var limits map[string]int
+	if obj, ok := doc.(map[string]any); ok {
+		for _, k := range []string{"versions", "supported_versions"} {
+			if l, ok := obj[k].([]any); ok {
+				list = l
+				break
+			}
+		}
+		limits = fieldLimits(obj["limits"])
+	}
+	var versions []string
+	for _, e := range list {
+		if obj, ok := e.(map[string]any); ok {
+			e = obj["version"]
+			if e == nil {
+				e = obj["id"]
+			}
+		}
+		if s, ok := e.(string); ok {
+			s = strings.ToLower(strings.TrimSpace(s))
+			s, _, _ = strings.Cut(s, ".") // 3.1 → 3
+			if !strings.HasPrefix(s, "v") {
+				s = "v" + s
+			}
+			versions = append(versions, s)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, nil, fmt.Errorf("GET %s: no versions listed", req.URL.Path)
+	}
+	return versions, limits, nil
+}
+
+// fieldLimits reads a "limits" object: field names to a length, or to an
+// object with "max_length" or "max".
+func fieldLimits(v any) map[string]int {
+	obj, _ := v.(map[string]any)
+	limits := make(map[string]int, len(obj))
+	for field, l := range obj {
+		if o, ok := l.(map[string]any); ok {
+			l = cmp.Or(o["max_length"], o["max"])
+		}
+		if n, ok := l.(float64); ok && n > 0 {
+			limits[field] = int(n)
+		}
+	}
+	return limits
+}
+
+// negotiateVersion picks the API version – want, or for "auto" the one in
+// the -api URL if the server has it, else the newest both sides speak –
+// and points t at it.
+func (t *Transformer) negotiateVersion(ctx context.Context, want string) error {
+	if want != "auto" && apiDialects[want] == nil {
+		return fmt.Errorf("api-version %q: want auto, %s", want, strings.Join(knownVersions, " or "))
+	}
+	root, inURL := splitVersion(t.apiBase)
+	server, limits, err := t.serverVersions(ctx, root)
+	if err != nil {
+		log.Printf("Could not ask the API for its versions (%v)", err)
+	}
+	t.fieldLimits = limits
+
+	version := want
+	switch {
+	case want != "auto":
+		if server != nil && !slices.Contains(server, want) {
+			return fmt.Errorf("api-version %s: the server supports %s", want, strings.Join(server, ", "))
+		}
+	case server == nil || slices.Contains(server, inURL):
+		if inURL != "" && apiDialects[inURL] == nil {
+			return fmt.Errorf("-api names API %s; this build speaks %s", inURL, strings.Join(knownVersions, ", "))
+		}
+		version = cmp.Or(inURL, "v2")
+	default:
+		version = ""
+		for _, v := range knownVersions {
+			if slices.Contains(server, v) {
+				version = v
+				break
+			}
+		}
+		if version == "" {
+			return fmt.Errorf("the server supports API %s; this build speaks %s",
+				strings.Join(server, ", "), strings.Join(knownVersions, ", "))
+		}
+	}
+
+	t.api = apiDialects[version]
+	t.apiBase = withVersion(t.apiBase, version)
+	log.Printf("Using API %s at %s", t.api.version, t.apiBase)
+	return nil
+}
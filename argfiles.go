@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/* -------------------------------
+   Explicit file lists
+
+   Files can be named after the
+   flags instead of -dir/-glob:
+
+   transform -workers 8 a.json b.json
+
+   An argument @FILE is replaced by
+   the lines of FILE, one argument
+   per line, so a list of a million
+   paths – or a set of flags – fits
+   where a Windows command line
+   would not. Lines are trimmed of
+   surrounding white space; spaces
+   inside need no quoting. Blank
+   lines and lines starting with #
+   are left out; @@name is a
+   literal @name.
+
+   Arguments with wildcards are
+   globbed, as cmd.exe and
+   PowerShell pass them unexpanded.
+--------------------------------*/
+
+// expandResponseFiles replaces every @FILE argument by FILE's lines.
+func expandResponseFiles(args []string) ([]string, error) {
+	var out []string
+	for _, a := range args {
+		switch {
+		case strings.HasPrefix(a, "@@"):
+			out = append(out, a[1:])
+		case strings.HasPrefix(a, "@") && len(a) > 1:
+			lines, err := readLines(a[1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", a, err)
+			}
+			for _, l := range lines {
+				if !strings.HasPrefix(l, "#") {
+					out = append(out, l)
+				}
+			}
+		default:
+			out = append(out, a)
+		}
+	}
+	return out, nil
+}
+
+// readLines returns the non-blank lines of a text file, trimmed of
+// leading and trailing white space as -retry lists always were. Files
+// written by Windows tools – a BOM, CRLF line ends, or the UTF-16 of
+// PowerShell's > redirection – read the same as plain ones.
+func readLines(path string) ([]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(raw, []byte("\xFF\xFE")):
+		if raw, err = decodeUTF16(raw[2:], false); err != nil {
+			return nil, err
+		}
+	case bytes.HasPrefix(raw, []byte("\xFE\xFF")):
+		if raw, err = decodeUTF16(raw[2:], true); err != nil {
+			return nil, err
+		}
+	}
+	raw = bytes.TrimPrefix(raw, []byte("\xEF\xBB\xBF"))
+	var lines []string
+	for _, l := range strings.Split(string(raw), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+// argFiles lists the files named on the command line, globbing the
+// arguments with wildcards.
+func argFiles(args []string) ([]string, error) {
+	var files []string
+	for _, a := range args {
+		if !strings.ContainsAny(a, "*?[") {
+			files = append(files, a)
+			continue
+		}
+		matches, err := filepath.Glob(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", a, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no files match", a)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+/* -------------------------------
+   Audit log (-audit-log)
+
+   One JSON line per upload request,
+   appended and never rewritten.
+   Each line carries the SHA-256 of
+   the line before it ("prev"), so
+   `audit inspect -verify` detects
+   edits, deletions and reordering.
+
+   Response snippets and errors are
+   scrubbed of the API key and of
+   anything that looks like a token
+   before they are written.
+--------------------------------*/
+
+const auditSnippetBytes = 512
+
+type auditRecord struct {
+	Time       time.Time `json:"ts"`
+	RunID      string    `json:"run_id"`
+	RequestID  string    `json:"request_id"`
+	File       string    `json:"file"`
+	Title      string    `json:"title,omitempty"`
+	RequestSHA string    `json:"request_sha256"` // of the multipart body
+	Status     int       `json:"status"`         // HTTP status, 0 if no response
+	DurationMS int64     `json:"duration_ms"`
+	Response   string    `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Prev       string    `json:"prev"` // SHA-256 of the previous line, "" for the first
+}
+
+func (r auditRecord) ok() bool { return r.Status >= 200 && r.Status < 300 }
+
+type AuditLog struct {
+	mu      sync.Mutex
+	f       *os.File
+	prev    string
+	secrets []string
+}
+
+// OpenAuditLog opens path for appending and picks up the hash chain from
+// its last line. secrets are literal values to redact (the API key).
+func OpenAuditLog(path string, secrets []string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	last, err := lastLine(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("audit log %s: %w", path, err)
+	}
+	l := &AuditLog{f: f}
+	if last != nil {
+		l.prev = lineHash(last)
+	}
+	for _, s := range secrets {
+		if s != "" {
+			l.secrets = append(l.secrets, s)
+		}
+	}
+	return l, nil
+}
+
+// lastLine returns the final non-empty line of f, reading only its tail.
+func lastLine(f *os.File) ([]byte, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := st.Size()
+	if size == 0 {
+		return nil, nil
+	}
+	off := max(size-64<<10, 0)
+	buf := make([]byte, size-off)
+	if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = bytes.TrimRight(buf, "\n")
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[i+1:]
+	} else if off > 0 {
+		return nil, errors.New("last line longer than 64 KiB")
+	}
+	return buf, nil
+}
+
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`(?i)("?(?:api[_-]?key|access[_-]?token|token|secret|password|authorization)"?\s*[:=]\s*"?)[^"&,\s}]+`),
+}
+
+func (l *AuditLog) redact(s string) string {
+	for _, sec := range l.secrets {
+		s = strings.ReplaceAll(s, sec, "[REDACTED]")
+	}
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}[REDACTED]")
+	}
+	return s
+}
+
+// addSecret adds a value to redact, e.g. a renewed API key. A nil log
+// ignores it.
+func (l *AuditLog) addSecret(s string) {
+	if l == nil || s == "" {
+		return
+	}
+	l.mu.Lock()
+	l.secrets = append(l.secrets, s)
+	l.mu.Unlock()
+}
+
+// Append writes r to the log, chaining it to the previous line.
+func (l *AuditLog) Append(r auditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r.Response = l.redact(r.Response)
+	r.Error = l.redact(r.Error)
+	r.Prev = l.prev
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.prev = lineHash(line)
+	return nil
+}
+
+// request completes r (identity fields already set by the caller) with
+// the outcome of one upload attempt; a nil log records nothing.
+func (l *AuditLog) request(ctx context.Context, r auditRecord, start time.Time, status int, response string, reqErr error) {
+	if l == nil {
+		return
+	}
+	r.Time = start.UTC()
+	r.File = inputFile(ctx)
+	r.Status = status
+	r.DurationMS = time.Since(start).Milliseconds()
+	r.Response = strings.TrimSpace(truncateUTF8(response, auditSnippetBytes))
+	if reqErr != nil {
+		r.Error = reqErr.Error()
+	}
+	if err := l.Append(r); err != nil {
+		// an audit gap must not go unnoticed
+		fmt.Fprintf(os.Stderr, "audit log: %v\n", err)
+	}
+}
+
+func (l *AuditLog) Close() error { return l.f.Close() }
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+/* ---------- audit subcommand ---------- */
+
+const auditUsage = `usage:
+  transform audit inspect [-file S] [-run ID] [-status ok|fail|CODE] [-since T] [-json] [-verify] LOG
+  transform audit replay  [-file S] [-run ID] [-status ok|fail|CODE] [-since T] [-out FILE] LOG`
+
+// auditMain implements "transform audit ..."; it returns the exit code.
+func auditMain(args []string) int {
+	if len(args) == 0 || (args[0] != "inspect" && args[0] != "replay") {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return exitSetup
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("audit "+cmd, flag.ContinueOnError)
+	fileSub := fs.String("file", "", "Only records whose file path contains this")
+	runID := fs.String("run", "", "Only records from this run ID")
+	status := fs.String("status", "", "Only records with this outcome: ok, fail or an HTTP status")
+	sinceArg := fs.String("since", "", "Only records at/after this RFC 3339 time or this long ago (e.g. 24h)")
+	asJSON := fs.Bool("json", false, "inspect: print matching records as JSON lines")
+	verify := fs.Bool("verify", false, "inspect: check the hash chain and report tampering")
+	out := fs.String("out", "", "replay: write the file list here instead of stdout")
+	if cmd == "replay" {
+		*status = "fail"
+	}
+	if code, ok := parseFlags(fs, args[1:]); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, auditUsage)
+		return exitSetup
+	}
+	var since time.Time
+	if *sinceArg != "" {
+		var err error
+		if since, err = parseSince(*sinceArg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitSetup
+		}
+	}
+	match := func(r auditRecord) bool {
+		if *fileSub != "" && !strings.Contains(r.File, *fileSub) {
+			return false
+		}
+		if *runID != "" && r.RunID != *runID {
+			return false
+		}
+		if !since.IsZero() && r.Time.Before(since) {
+			return false
+		}
+		switch *status {
+		case "":
+		case "ok":
+			return r.ok()
+		case "fail":
+			return !r.ok()
+		default:
+			code, err := strconv.Atoi(*status)
+			return err == nil && r.Status == code
+		}
+		return true
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	defer f.Close()
+
+	var (
+		matched []auditRecord
+		latest  = map[string]auditRecord{} // replay: last attempt per file
+		order   []string
+		prev    string
+		broken  []string
+	)
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var r auditRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			broken = append(broken, fmt.Sprintf("line %d: %v", n, err))
+			prev = lineHash(line)
+			continue
+		}
+		if r.Prev != prev {
+			broken = append(broken, fmt.Sprintf("line %d: chain broken (prev %.12s…, want %.12s…)", n, r.Prev, prev))
+		}
+		prev = lineHash(line)
+		if cmd == "replay" {
+			if _, seen := latest[r.File]; !seen {
+				order = append(order, r.File)
+			}
+			latest[r.File] = r
+			continue
+		}
+		if match(r) {
+			matched = append(matched, r)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+
+	if cmd == "replay" {
+		var files []string
+		for _, file := range order {
+			if file != "" && match(latest[file]) {
+				files = append(files, file)
+			}
+		}
+		if *out != "" {
+			if err := saveFilesToFile(*out, files); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return exitSetup
+			}
+			fmt.Fprintf(os.Stderr, "wrote %d files to %s; upload them with -retry %s\n", len(files), *out, *out)
+		} else {
+			for _, file := range files {
+				fmt.Println(file)
+			}
+		}
+		return exitOK
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range matched {
+			_ = enc.Encode(r)
+		}
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TIME\tSTATUS\tDURATION\tFILE\tTITLE\tDETAIL")
+		for _, r := range matched {
+			detail := r.Error
+			if detail == "" {
+				detail = r.Response
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%dms\t%s\t%s\t%s\n", r.Time.Local().Format(time.DateTime), r.Status,
+				r.DurationMS, r.File, truncateUTF8(r.Title, 40), truncateUTF8(strings.Join(strings.Fields(detail), " "), 80))
+		}
+		tw.Flush()
+	}
+	if *verify {
+		if len(broken) > 0 {
+			for _, b := range broken {
+				fmt.Fprintln(os.Stderr, b)
+			}
+			fmt.Fprintf(os.Stderr, "audit log FAILED verification (%d problems)\n", len(broken))
+			return exitFailures
+		}
+		fmt.Fprintln(os.Stderr, "audit log chain OK")
+	}
+	return exitOK
+}
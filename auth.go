@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/* -------------------------------
+   Auth schemes (-auth)
+
+   How the API key is attached to
+   each upload:
+
+   bearer        Authorization: Bearer KEY
+   basic         Authorization: Basic …
+                 (KEY is user:password)
+   header:NAME   NAME: KEY
+   cookie:NAME   Cookie: NAME=KEY
+--------------------------------*/
+
+// authScheme attaches key to req.
+type authScheme func(req *http.Request, key string)
+
+func bearerAuth(req *http.Request, key string) {
+	req.Header.Set("Authorization", "Bearer "+key)
+}
+
+func parseAuthScheme(spec string) (authScheme, error) {
+	scheme, arg, _ := strings.Cut(spec, ":")
+	switch {
+	case scheme == "bearer" && arg == "":
+		return bearerAuth, nil
+	case scheme == "basic" && arg == "":
+		return func(req *http.Request, key string) {
+			req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(key)))
+		}, nil
+	case scheme == "header" && arg != "":
+		name := http.CanonicalHeaderKey(arg)
+		return func(req *http.Request, key string) {
+			req.Header.Set(name, key)
+		}, nil
+	case scheme == "cookie" && arg != "":
+		return func(req *http.Request, key string) {
+			req.AddCookie(&http.Cookie{Name: arg, Value: key})
+		}, nil
+	}
+	return nil, fmt.Errorf("auth %q: want bearer, basic, header:NAME or cookie:NAME", spec)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/mail"
+	"os"
+	"strings"
+)
+
+/* -------------------------------
+   Author names (-author-map)
+
+   One variant per line, mapped to
+   the name Omnipub should show:
+
+   # name variants
+   J. Smith           = Jane Smith
+   SMITH, JANE        = Jane Smith
+   # addresses
+   jsmith@example.com = Jane Smith
+
+   Matching ignores case and runs of
+   whitespace. "Name <address>"
+   authors are looked up whole, then
+   by address, then by name, so a
+   mapped address also covers its
+   name variants. Unmapped authors
+   only get their whitespace tidied.
+--------------------------------*/
+
+type AuthorMap struct {
+	names map[string]string // by authorKey of the variant
+}
+
+func LoadAuthorMap(path string) (*AuthorMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("author-map: %w", err)
+	}
+	defer f.Close()
+
+	m := &AuthorMap{names: make(map[string]string)}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		variant, to, ok := strings.Cut(line, "=")
+		key, to := authorKey(variant), tidyAuthor(to)
+		if !ok || key == "" || to == "" {
+			return nil, fmt.Errorf("%s:%d: want variant = name", path, n)
+		}
+		if prev, dup := m.names[key]; dup && prev != to {
+			return nil, fmt.Errorf("%s:%d: %q already maps to %q", path, n, tidyAuthor(variant), prev)
+		}
+		m.names[key] = to
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// tidyAuthor trims an author and collapses runs of whitespace.
+func tidyAuthor(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func authorKey(s string) string {
+	return strings.ToLower(tidyAuthor(s))
+}
+
+// Normalize returns the display name for author.
+func (m *AuthorMap) Normalize(author string) string {
+	author = tidyAuthor(author)
+	if author == "" {
+		return ""
+	}
+	if to, ok := m.names[authorKey(author)]; ok {
+		return to
+	}
+	if addr, err := mail.ParseAddress(author); err == nil {
+		if to, ok := m.names[authorKey(addr.Address)]; ok {
+			return to
+		}
+		if to, ok := m.names[authorKey(addr.Name)]; ok && addr.Name != "" {
+			return to
+		}
+	}
+	return author
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   AWS key sources
+   (-key-source aws-sm:… / ssm:…)
+
+   Secrets Manager and SSM Parameter
+   Store over their JSON APIs, signed
+   with SigV4. Credentials come from
+   the environment, the ECS task role
+   or the EC2 instance role (IMDSv2),
+   in that order, so on an upload
+   host the key never touches env or
+   disk.
+
+   AWS_REGION / AWS_DEFAULT_REGION
+   set the region (else the ARN or
+   instance metadata does);
+   AWS_ENDPOINT_URL overrides the
+   service endpoint.
+--------------------------------*/
+
+const imdsDefault = "http://169.254.169.254"
+
+type awsCreds struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// awsKey reads the API key from Secrets Manager (service
+// "secretsmanager") or SSM Parameter Store (service "ssm").
+type awsKey struct {
+	service string
+	id      string // secret ID/ARN or parameter name
+	field   string // JSON field of a Secrets Manager secret, optional
+	client  *http.Client
+}
+
+func newAWSKey(service, spec string) (*awsKey, error) {
+	id, field, _ := strings.Cut(spec, "#")
+	if id == "" {
+		return nil, fmt.Errorf("key-source: empty %s name", service)
+	}
+	if field != "" && service != "secretsmanager" {
+		return nil, errors.New("key-source: #field only applies to aws-sm")
+	}
+	return &awsKey{service: service, id: id, field: field, client: &http.Client{Timeout: 15 * time.Second}}, nil
+}
+
+func (k *awsKey) String() string {
+	switch k.service {
+	case "ssm":
+		return "ssm " + k.id
+	case "s3":
+		return "s3 " + k.id
+	}
+	return "aws-sm " + k.id
+}
+
+func (k *awsKey) Key(ctx context.Context) (string, error) {
+	creds, err := k.credentials(ctx)
+	if err != nil {
+		return "", err
+	}
+	region, err := k.region(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var target string
+	var body any
+	if k.service == "ssm" {
+		target = "AmazonSSM.GetParameter"
+		body = map[string]any{"Name": k.id, "WithDecryption": true}
+	} else {
+		target = "secretsmanager.GetSecretValue"
+		body = map[string]any{"SecretId": k.id}
+	}
+	var out struct {
+		SecretString string
+		Parameter    struct{ Value string }
+	}
+	if err := k.call(ctx, creds, region, target, body, &out); err != nil {
+		return "", err
+	}
+
+	key := out.Parameter.Value
+	if k.service == "secretsmanager" {
+		key = out.SecretString
+		if k.field != "" {
+			var fields map[string]any
+			if err := json.Unmarshal([]byte(key), &fields); err != nil {
+				return "", fmt.Errorf("%s: secret is not a JSON object", k)
+			}
+			key, _ = fields[k.field].(string)
+		}
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("%s: empty value", k)
+	}
+	return key, nil
+}
+
+func (k *awsKey) call(ctx context.Context, creds awsCreds, region, target string, body, out any) error {
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", k.service, region)
+	}
+	payload, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signV4(req, payload, creds, region, k.service, time.Now())
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", k, err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(raw, &e)
+		return fmt.Errorf("%s: http %d %s %s", k, resp.StatusCode, e.Type, e.Message)
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// region comes from the environment, the secret's ARN or instance
+// metadata.
+func (k *awsKey) region(ctx context.Context) (string, error) {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if r := os.Getenv(env); r != "" {
+			return r, nil
+		}
+	}
+	if parts := strings.Split(k.id, ":"); len(parts) > 3 && parts[0] == "arn" {
+		return parts[3], nil
+	}
+	r, err := k.imds(ctx, "/latest/meta-data/placement/region")
+	if err != nil {
+		return "", fmt.Errorf("%s: no region (set AWS_REGION): %w", k, err)
+	}
+	return r, nil
+}
+
+func (k *awsKey) credentials(ctx context.Context) (awsCreds, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCreds{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	var creds awsCreds
+	if rel := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); rel != "" {
+		err := k.getJSON(ctx, "http://169.254.170.2"+rel, nil, &creds)
+		return creds, err
+	}
+	role, err := k.imds(ctx, "/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return creds, fmt.Errorf("%s: no AWS credentials in env, ECS or instance metadata: %w", k, err)
+	}
+	role, _, _ = strings.Cut(strings.TrimSpace(role), "\n")
+	body, err := k.imds(ctx, "/latest/meta-data/iam/security-credentials/"+role)
+	if err != nil {
+		return creds, err
+	}
+	return creds, json.Unmarshal([]byte(body), &creds)
+}
+
+// imds GETs an instance metadata path using an IMDSv2 session token.
+func (k *awsKey) imds(ctx context.Context, path string) (string, error) {
+	base := os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	if base == "" {
+		base = imdsDefault
+	}
+	base = strings.TrimSuffix(base, "/")
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, base+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	token, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("imds token: http %d", resp.StatusCode)
+	}
+
+	var out string
+	err = k.getJSON(ctx, base+path, http.Header{"X-Aws-Ec2-Metadata-Token": {string(token)}}, &out)
+	return out, err
+}
+
+// getJSON GETs url; a *string out receives the raw body.
+func (k *awsKey) getJSON(ctx context.Context, url string, h http.Header, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, v := range h {
+		req.Header[name] = v
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: http %d", req.URL.Path, resp.StatusCode)
+	}
+	if s, ok := out.(*string); ok {
+		*s = string(raw)
+		return nil
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// signV4 adds AWS Signature Version 4 headers to req.
+func signV4(req *http.Request, payload []byte, creds awsCreds, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, v := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var canonQuery []string
+	for _, k := range keys {
+		vals := append([]string(nil), query[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			canonQuery = append(canonQuery, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(payload)
+	canonical := strings.Join([]string{
+		req.Method, path, strings.Join(canonQuery, "&"),
+		canonHeaders.String(), signed, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	canonHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{day, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// awsEscape is RFC 3986 percent-encoding as SigV4 wants it.
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+/* -------------------------------
+   Boilerplate removal (-readability)
+
+   Readability-style cleanup for
+   scraped content:
+   - drops nav, aside, footer, form,
+     button and noscript elements
+   - drops elements whose class/id
+     looks like chrome (share, social,
+     related, comments, newsletter,
+     breadcrumbs, ads, cookie banners…)
+     unless they carry most of the text
+   - drops elements matching any
+     -remove-selector
+   - unwraps empty wrappers left behind
+--------------------------------*/
+
+var boilerplateTags = map[string]bool{
+	"nav": true, "aside": true, "footer": true, "form": true, "button": true, "noscript": true,
+}
+
+var boilerplateHint = regexp.MustCompile(`(?i)(^|[-_ ])(share|sharing|social|related|recommend|comments?|newsletter|subscribe|signup|sidebar|breadcrumbs?|promo|sponsor|advert|ads?|banner|cookie|popup|modal|author-bio|tags|pagination|outbrain|taboola)([-_ ]|$)`)
+
+// wrapperElems are removed when they end up with no text and no media.
+var wrapperElems = map[string]bool{"div": true, "span": true, "section": true, "p": true, "article": true, "header": true, "li": true, "ul": true, "ol": true}
+
+var mediaElems = []string{"img", "picture", "video", "audio", "iframe", "embed", "object", "svg", "hr", "br", "table", "canvas", "math"}
+
+type Boilerplate struct {
+	heuristics bool
+	remove     []Selector
+}
+
+func (p *Boilerplate) Apply(_ *renderDoc, toks []htmlToken) []htmlToken {
+	total := len(strings.TrimSpace(textContent(toks)))
+	out := make([]htmlToken, 0, len(toks))
+	for i := 0; i < len(toks); i++ {
+		t := &toks[i]
+		if t.kind == htmlStartTag || t.kind == htmlSelfClosingTag {
+			if p.isBoilerplate(t) {
+				end := elementEnd(toks, i)
+				// never throw away the bulk of the article on a class-name guess
+				if !p.heuristics || boilerplateTags[t.data] || p.userMatch(t) ||
+					len(strings.TrimSpace(textContent(toks[i:end+1]))) < total/2 {
+					i = end
+					continue
+				}
+			}
+		}
+		out = append(out, *t)
+	}
+	return removeEmptyWrappers(out)
+}
+
+func (p *Boilerplate) userMatch(t *htmlToken) bool {
+	for _, sel := range p.remove {
+		if sel.Match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Boilerplate) isBoilerplate(t *htmlToken) bool {
+	if p.userMatch(t) {
+		return true
+	}
+	if !p.heuristics {
+		return false
+	}
+	if boilerplateTags[t.data] {
+		return true
+	}
+	if t.kind != htmlStartTag || !wrapperElems[t.data] {
+		return false
+	}
+	class, _ := t.attr("class")
+	id, _ := t.attr("id")
+	role, _ := t.attr("role")
+	if role == "navigation" || role == "complementary" || role == "banner" || role == "contentinfo" {
+		return true
+	}
+	for _, name := range append(strings.Fields(class), id) {
+		if name != "" && boilerplateHint.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeEmptyWrappers repeatedly drops wrapper elements that contain no
+// text and no media, innermost first.
+func removeEmptyWrappers(toks []htmlToken) []htmlToken {
+	for changed := true; changed; {
+		changed = false
+		out := make([]htmlToken, 0, len(toks))
+		for i := 0; i < len(toks); i++ {
+			t := toks[i]
+			if t.kind == htmlStartTag && wrapperElems[t.data] {
+				end := elementEnd(toks, i)
+				if end > i && isEmptyElement(toks[i+1:end]) {
+					if _, hasID := t.attr("id"); !hasID {
+						i = end
+						changed = true
+						continue
+					}
+				}
+			}
+			out = append(out, t)
+		}
+		toks = out
+	}
+	return toks
+}
+
+func isEmptyElement(inner []htmlToken) bool {
+	for i := range inner {
+		t := &inner[i]
+		switch t.kind {
+		case htmlText:
+			if strings.TrimSpace(strings.ReplaceAll(t.data, "&nbsp;", " ")) != "" {
+				return false
+			}
+		case htmlStartTag, htmlSelfClosingTag:
+			if t.isTag(mediaElems...) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// emptyContent reports whether rendered article content has no visible
+// text or media left, e.g. "<p>&nbsp;</p><div><br></div>". Unlike inside
+// a wrapper, line breaks and rules alone do not count as content.
+func emptyContent(s string) bool {
+	var toks []htmlToken
+	for _, t := range tokenizeHTML(s) {
+		if !t.isTag("br", "hr") {
+			toks = append(toks, t)
+		}
+	}
+	return isEmptyElement(toks)
+}
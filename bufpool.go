@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+/* -------------------------------
+   Buffer pool
+
+   Multipart bodies and rendered
+   pages are built in buffers taken
+   from a sync.Pool, so a run with
+   many workers and large articles
+   reuses a working set of buffers
+   instead of growing fresh ones
+   for every file.
+
+   Buffers that grew past
+   maxPooledBuffer are left to the
+   GC rather than kept around.
+--------------------------------*/
+
+const maxPooledBuffer = 16 << 20
+
+var bufPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// getBuffer returns an empty buffer; hand it back with putBuffer once
+// nothing refers to its bytes any more.
+func getBuffer() *bytes.Buffer {
+	b := bufPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	bufPool.Put(b)
+}
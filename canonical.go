@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Canonical source URLs
+   (-canonical-links)
+
+   normalize – https, lower-case
+               host, no default
+               port, fragment or
+               tracking parameters
+               (utm_*, fbclid, …),
+               sorted query
+   resolve   – also follow the link's
+               redirects and take the
+               page's <link
+               rel=canonical>
+
+   The result goes into metadata as
+   canonical_url. An article whose
+   canonical URL was already
+   uploaded in this process is
+   skipped as a duplicate.
+--------------------------------*/
+
+const canonicalReadBytes = 256 << 10
+
+// trackingParams are query parameters that never change what a URL
+// points to.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true,
+	"mc_cid": true, "mc_eid": true, "igshid": true, "_ga": true, "_gl": true,
+	"ref_src": true, "ref_url": true,
+}
+
+type canonicalEntry struct {
+	url  string
+	wait chan struct{}
+}
+
+type Canonicalizer struct {
+	resolve bool
+	client  *http.Client
+
+	mu       sync.Mutex
+	resolved map[string]*canonicalEntry // by normalized link
+	claimed  map[string]string          // canonical URL → file that has it
+}
+
+func NewCanonicalizer(mode string) (*Canonicalizer, error) {
+	switch mode {
+	case "normalize", "resolve":
+	default:
+		return nil, fmt.Errorf("canonical-links %q: want off, normalize or resolve", mode)
+	}
+	return &Canonicalizer{
+		resolve:  mode == "resolve",
+		client:   &http.Client{Timeout: 15 * time.Second},
+		resolved: make(map[string]*canonicalEntry),
+		claimed:  make(map[string]string),
+	}, nil
+}
+
+// normalizeURL strips the parts of an http(s) URL that do not change what
+// it points to. Other values come back as they are.
+func normalizeURL(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return raw
+	}
+	u.Scheme = "https"
+	u.Host = strings.ToLower(u.Host)
+	if host, port, ok := strings.Cut(u.Host, ":"); ok && (port == "80" || port == "443") {
+		u.Host = host
+	}
+	u.Fragment, u.RawFragment = "", ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	q := u.Query()
+	for k := range q {
+		if trackingParams[strings.ToLower(k)] || strings.HasPrefix(strings.ToLower(k), "utm_") {
+			q.Del(k)
+		}
+	}
+	u.RawQuery = q.Encode() // sorted by key
+	return u.String()
+}
+
+// Canonical returns the canonical form of link; for resolve mode this
+// follows redirects and <link rel=canonical>, once per link. A link that
+// cannot be fetched keeps its normalized form.
+func (c *Canonicalizer) Canonical(ctx context.Context, link string) string {
+	norm := normalizeURL(link)
+	if !c.resolve || !checkable(link) {
+		return norm
+	}
+	c.mu.Lock()
+	e, ok := c.resolved[norm]
+	if !ok {
+		e = &canonicalEntry{url: norm, wait: make(chan struct{})}
+		c.resolved[norm] = e
+	}
+	c.mu.Unlock()
+	if ok {
+		<-e.wait
+		return e.url
+	}
+	defer close(e.wait)
+	canon, err := c.fetch(ctx, link)
+	if err != nil {
+		log.Printf("Canonical URL of %s: %v; using %s", link, err, norm)
+		return norm
+	}
+	e.url = normalizeURL(canon)
+	return e.url
+}
+
+// fetch GETs link, following redirects, and returns the page's declared
+// canonical URL, else the URL it ended up at.
+func (c *Canonicalizer) fetch(ctx context.Context, link string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSpace(link), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "transform-to-omnipub/"+toolVersion()+" (canonical link)")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	final := resp.Request.URL
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("http %d", resp.StatusCode)
+	}
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct != "text/html" && ct != "application/xhtml+xml" {
+		return final.String(), nil
+	}
+	page, _ := io.ReadAll(io.LimitReader(resp.Body, canonicalReadBytes))
+	for _, t := range tokenizeHTML(string(page)) {
+		if !t.isTag("link") {
+			continue
+		}
+		rel, _ := t.attr("rel")
+		href, _ := t.attr("href")
+		if !hasToken(rel, "canonical") || strings.TrimSpace(href) == "" {
+			continue
+		}
+		if u, err := final.Parse(strings.TrimSpace(href)); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			return u.String(), nil
+		}
+	}
+	return final.String(), nil
+}
+
+// hasToken reports whether the space-separated list s holds tok.
+func hasToken(s, tok string) bool {
+	for _, f := range strings.Fields(s) {
+		if strings.EqualFold(f, tok) {
+			return true
+		}
+	}
+	return false
+}
+
+// claim records file as the one uploading canonical URL u. If another
+// file already has it, that file is returned instead.
+func (c *Canonicalizer) claim(u, file string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if first, ok := c.claimed[u]; ok && first != file {
+		return first, false
+	}
+	c.claimed[u] = file
+	return file, true
+}
+
+// release gives up a claim after the upload failed, so a duplicate in a
+// later retry can still go through.
+func (c *Canonicalizer) release(u, file string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.claimed[u] == file {
+		delete(c.claimed, u)
+	}
+}
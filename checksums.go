@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+/* -------------------------------
+   Input checksums (-checksums)
+
+   A sha256sum-style file lists the
+   expected SHA-256 of each input:
+
+   9f86d08…  posts/2019-04.json
+   SHA256 (posts/2019-05.json) = 60303ae…
+
+   Relative paths are taken from the
+   checksum file's directory. Inputs
+   whose bytes don't match, or that
+   aren't listed, fail as input
+   errors before decoding, so a file
+   cut short in transit is not
+   uploaded. Listed files missing
+   from disk are logged at startup.
+--------------------------------*/
+
+var bsdChecksumLine = regexp.MustCompile(`^SHA256 ?\((.+)\) ?= ?([0-9a-fA-F]{64})$`)
+
+type Checksums struct {
+	sums map[string]string // by absolute path
+}
+
+func LoadChecksums(file string) (*Checksums, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("checksums: %w", err)
+	}
+	defer f.Close()
+
+	base := filepath.Dir(file)
+	c := &Checksums{sums: make(map[string]string)}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var sum, name string
+		if m := bsdChecksumLine.FindStringSubmatch(line); m != nil {
+			name, sum = m[1], m[2]
+		} else {
+			var ok bool
+			sum, name, ok = strings.Cut(line, " ")
+			name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*") // "*" marks binary mode
+			if _, err := hex.DecodeString(sum); !ok || err != nil || len(sum) != 64 || name == "" {
+				return nil, fmt.Errorf("%s:%d: want a SHA-256 and a path", file, n)
+			}
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(base, filepath.FromSlash(name))
+		}
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+		c.sums[abs] = strings.ToLower(sum)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for p := range c.sums {
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("Missing from disk: %d files listed in %s, e.g. %s", len(missing), file, missing[0])
+	}
+	return c, nil
+}
+
+// Verify checks raw, the content of file, against its listed checksum.
+func (c *Checksums) Verify(file string, sum [32]byte, size int) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	want, ok := c.sums[abs]
+	if !ok {
+		return errors.New("checksum: file not listed")
+	}
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum mismatch (%d bytes): sha256 %s…, want %s…", size, got[:12], want[:12])
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   Posting into several collections
+   (-collection 12,15, per-article
+   "collections")
+
+   With -cross-post one request
+   carries every collection as a
+   repeated collection_id field and
+   the API files the item in each.
+   Otherwise the item is POSTed once
+   per collection.
+
+   An article may route itself: a
+   "collections" array, else a
+   "collection_id", else a
+   "collection" name (looked up once
+   per run through GET
+   {api}/collections?name=…) takes
+   the place of -collection.
+--------------------------------*/
+
+// parseCollections parses a comma-separated list of collection IDs.
+func parseCollections(s string) ([]int, error) {
+	var ids []int
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		id, err := strconv.Atoi(f)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("collection %q: want a positive number", f)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// jsonCollections converts a decoded JSON "collections" value, numbers or
+// numeric strings, into IDs.
+func jsonCollections(v any) []int {
+	list, _ := v.([]any)
+	var ids []int
+	for _, e := range list {
+		switch e := e.(type) {
+		case float64:
+			ids = append(ids, int(e))
+		case string:
+			if id, err := strconv.Atoi(e); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// collectionRef is an article's own collection: an ID, or a name to look
+// up. Values of any other JSON type are ignored rather than failing the
+// whole article.
+type collectionRef struct {
+	id   int
+	name string
+}
+
+func refFromJSON(v any) collectionRef {
+	switch v := v.(type) {
+	case float64:
+		if v > 0 {
+			return collectionRef{id: int(v)}
+		}
+	case string:
+		v = strings.TrimSpace(v)
+		if id, err := strconv.Atoi(v); err == nil {
+			return collectionRef{id: id}
+		}
+		return collectionRef{name: v}
+	}
+	return collectionRef{}
+}
+
+func (r *collectionRef) UnmarshalJSON(b []byte) error {
+	var v any
+	if json.Unmarshal(b, &v) == nil {
+		*r = refFromJSON(v)
+	}
+	return nil
+}
+
+func (r collectionRef) MarshalJSON() ([]byte, error) {
+	switch {
+	case r.id > 0:
+		return json.Marshal(r.id)
+	case r.name != "":
+		return json.Marshal(r.name)
+	}
+	return []byte("null"), nil
+}
+
+// articleCollections picks the collections art goes into: its own, if it
+// names any, else def (from -collection).
+func (t *Transformer) articleCollections(ctx context.Context, art *Article, def []int) ([]int, error) {
+	if len(art.Collections) > 0 {
+		return art.Collections, nil
+	}
+	for _, ref := range []collectionRef{art.CollectionID, art.Collection} {
+		if ref.id > 0 {
+			return []int{ref.id}, nil
+		}
+		if ref.name != "" {
+			id, err := t.collectionByName(ctx, ref.name)
+			if err != nil {
+				return nil, err
+			}
+			return []int{id}, nil
+		}
+	}
+	return def, nil
+}
+
+// collectionByName resolves a collection name to its ID. Answers, also
+// "no such collection", are cached for the run; lookups that failed are
+// not.
+func (t *Transformer) collectionByName(ctx context.Context, name string) (int, error) {
+	t.collMu.Lock()
+	defer t.collMu.Unlock()
+	key := strings.ToLower(name)
+	if id, ok := t.collByName[key]; ok {
+		if id == 0 {
+			return 0, &inputError{fmt.Errorf("no collection named %q", name)}
+		}
+		return id, nil
+	}
+	ids, err := t.lookupCollection(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	if t.collByName == nil {
+		t.collByName = make(map[string]int)
+	}
+	switch len(ids) {
+	case 0:
+		t.collByName[key] = 0
+		return 0, &inputError{fmt.Errorf("no collection named %q", name)}
+	case 1:
+		t.collByName[key] = ids[0]
+		return ids[0], nil
+	}
+	return 0, &inputError{fmt.Errorf("collection name %q is ambiguous: IDs %v", name, ids)}
+}
+
+// lookupCollection lists the collections matching name. Servers that
+// ignore the name parameter return them all; only exact (case-insensitive)
+// name or title matches count.
+func (t *Transformer) lookupCollection(ctx context.Context, name string) ([]int, error) {
+	var ids []int
+	err := t.listAll(ctx, "/collections", url.Values{"name": {name}}, func(c map[string]any) {
+		id, ok := firstInt(c, []string{"id", "collection_id"})
+		if !ok {
+			return
+		}
+		for _, k := range []string{"name", "title"} {
+			if s, _ := c[k].(string); strings.EqualFold(strings.TrimSpace(s), name) {
+				ids = append(ids, id)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("looking up collection %q: %w", name, err)
+	}
+	return ids, nil
+}
+
+// postToCollections uploads an item into every collection in ids (none
+// for no collection_id at all). With one POST per collection, the item
+// only counts as uploaded if every collection took it; a 304 from each
+// one means it was already everywhere. page renders the item for the
+// collections of one request, which -truncate may cut to their limit.
+func (t *Transformer) postToCollections(ctx context.Context, page func(ids []int) string, metadata map[string]any, ids []int, attachments []attachment) error {
+	if len(ids) <= 1 || t.crossPost {
+		return t.postItem(ctx, page(ids), metadata, ids, attachments)
+	}
+	var done, unchanged []string
+	var firstErr error
+	failed := 0
+	for _, id := range ids {
+		err := t.postItem(ctx, page([]int{id}), metadata, []int{id}, attachments)
+		switch {
+		case err == nil:
+			done = append(done, strconv.Itoa(id))
+		case errors.Is(err, errNotModified):
+			unchanged = append(unchanged, strconv.Itoa(id))
+		default:
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("collection %d: %w", id, err)
+			}
+		}
+	}
+	if failed > 0 {
+		if len(done)+len(unchanged) > 0 {
+			return fmt.Errorf("%d/%d collections failed (already in %s), first: %w",
+				failed, len(ids), strings.Join(append(done, unchanged...), ","), firstErr)
+		}
+		return fmt.Errorf("%d/%d collections failed, first: %w", failed, len(ids), firstErr)
+	}
+	if len(done) == 0 {
+		return errNotModified
+	}
+	return nil
+}
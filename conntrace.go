@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Connection reuse
+
+   Every upload is traced with
+   httptrace: did it get a pooled
+   keep-alive connection, or dial
+   (and TLS-handshake) a new one?
+   The run's totals show whether
+   -workers and the transport's
+   per-host limit keep connections
+   warm, or thrash handshakes:
+
+   Connections: 12000 requests,
+     11990 reused (99.9%), 10 new;
+     10 TLS handshakes, 84ms avg
+--------------------------------*/
+
+type connStats struct {
+	workers int
+
+	mu         sync.Mutex
+	requests   int
+	reused     int
+	idle       time.Duration // summed idle time of reused connections
+	dials      int
+	dialTime   time.Duration
+	handshakes int
+	tlsTime    time.Duration
+	dialErrors int
+}
+
+func newConnStats(workers int) *connStats {
+	return &connStats{workers: workers}
+}
+
+// trace returns ctx with a ClientTrace feeding c; a nil connStats traces
+// nothing.
+func (c *connStats) trace(ctx context.Context) context.Context {
+	if c == nil {
+		return ctx
+	}
+	var dialStart, tlsStart time.Time
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.mu.Lock()
+			c.requests++
+			if info.Reused {
+				c.reused++
+				c.idle += info.IdleTime
+			}
+			c.mu.Unlock()
+		},
+		ConnectStart: func(string, string) { dialStart = time.Now() },
+		ConnectDone: func(_, _ string, err error) {
+			c.mu.Lock()
+			if err != nil {
+				c.dialErrors++
+			} else {
+				c.dials++
+				c.dialTime += time.Since(dialStart)
+			}
+			c.mu.Unlock()
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err != nil {
+				return
+			}
+			c.mu.Lock()
+			c.handshakes++
+			c.tlsTime += time.Since(tlsStart)
+			c.mu.Unlock()
+		},
+	})
+}
+
+// connSummary is the run's connection use as reported.
+type connSummary struct {
+	Requests    int     `json:"requests"`
+	Reused      int     `json:"reused"`
+	New         int     `json:"new"`
+	AvgIdleSec  float64 `json:"avg_idle_sec,omitempty"` // of reused connections
+	Dials       int     `json:"dials"`
+	AvgDialSec  float64 `json:"avg_dial_sec,omitempty"`
+	DialErrors  int     `json:"dial_errors,omitempty"`
+	Handshakes  int     `json:"tls_handshakes"`
+	AvgTLSSec   float64 `json:"avg_tls_sec,omitempty"`
+	Workers     int     `json:"workers"`
+	ReusedShare float64 `json:"reused_share"`
+}
+
+// summary returns nil before the first request.
+func (c *connStats) summary() *connSummary {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.requests == 0 {
+		return nil
+	}
+	s := &connSummary{
+		Requests:   c.requests,
+		Reused:     c.reused,
+		New:        c.requests - c.reused,
+		Dials:      c.dials,
+		DialErrors: c.dialErrors,
+		Handshakes: c.handshakes,
+		Workers:    c.workers,
+
+		ReusedShare: float64(c.reused) / float64(c.requests),
+	}
+	if c.reused > 0 {
+		s.AvgIdleSec = c.idle.Seconds() / float64(c.reused)
+	}
+	if c.dials > 0 {
+		s.AvgDialSec = c.dialTime.Seconds() / float64(c.dials)
+	}
+	if c.handshakes > 0 {
+		s.AvgTLSSec = c.tlsTime.Seconds() / float64(c.handshakes)
+	}
+	return s
+}
+
+func (s *connSummary) text() string {
+	ms := func(sec float64) time.Duration {
+		return time.Duration(sec * float64(time.Second)).Round(time.Millisecond)
+	}
+	line := fmt.Sprintf("Connections: %d requests, %d reused (%.1f%%), %d new", s.Requests, s.Reused, 100*s.ReusedShare, s.New)
+	if s.Dials > 0 {
+		line += fmt.Sprintf("; %d dials, %s avg", s.Dials, ms(s.AvgDialSec))
+	}
+	if s.Handshakes > 0 {
+		line += fmt.Sprintf("; %d TLS handshakes, %s avg", s.Handshakes, ms(s.AvgTLSSec))
+	}
+	if s.DialErrors > 0 {
+		line += fmt.Sprintf("; %d failed dials", s.DialErrors)
+	}
+	// a warm pool opens about one connection per worker
+	if s.New > 2*max(1, s.Workers) && s.ReusedShare < 0.9 {
+		line += fmt.Sprintf("\n  %d new connections for %d workers: the server or a proxy is closing keep-alive connections", s.New, s.Workers)
+	}
+	return line
+}
+
+// report writes the connection use, if there was any.
+func (c *connStats) report(w io.Writer) {
+	if s := c.summary(); s != nil {
+		fmt.Fprintln(w, s.text())
+	}
+}
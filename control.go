@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+/* -------------------------------
+   Control server (-control-addr)
+
+   GET  /status          progress + pool state (JSON)
+   POST /pause           stop dispatching new files
+   POST /resume          resume dispatching
+   POST /workers?n=N     change the worker count
+
+   With -control-token every
+   request needs
+     Authorization: Bearer TOKEN
+   Without one the server only
+   listens on loopback addresses.
+--------------------------------*/
+
+type controlStatus struct {
+	ProgressSnapshot
+	poolState
+}
+
+func startControlServer(addr, token string, ctl *runControl) error {
+	if token == "" && !isLoopbackAddr(addr) {
+		return fmt.Errorf("%s is not a loopback address; set -control-token to serve it", addr)
+	}
+	mux := http.NewServeMux()
+	writeStatus := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(ctl.status())
+	}
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		ctl.SetPaused(true)
+		log.Printf("control: dispatching paused")
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		ctl.SetPaused(false)
+		log.Printf("control: dispatching resumed")
+		writeStatus(w)
+	})
+	mux.HandleFunc("POST /workers", func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.URL.Query().Get("n"))
+		if err != nil || n < 1 {
+			http.Error(w, "want ?n=<workers ≥ 1>", http.StatusBadRequest)
+			return
+		}
+		ctl.Resize(n)
+		log.Printf("control: workers set to %d", n)
+		writeStatus(w)
+	})
+
+	var h http.Handler = mux
+	if token != "" {
+		h = requireToken(token, mux)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("control server listening on %s", ln.Addr())
+	go func() {
+		if err := http.Serve(ln, h); err != nil {
+			log.Printf("control server: %v", err)
+		}
+	}()
+	return nil
+}
+
+// requireToken answers 401 to requests without the bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or wrong control token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopbackAddr reports whether a listen address only accepts local
+// connections. An empty host listens on every interface.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+/* -------------------------------
+   Data-URI images (-data-uri)
+
+   keep    – leave data: images inline
+             (default)
+   strip   – replace them with their
+             alt text
+   extract – send them as multipart
+             "attachments" parts and
+             point src at the file name
+
+   With -data-uri-max-kb N only images
+   larger than N KiB are affected;
+   smaller ones stay inline.
+--------------------------------*/
+
+type DataURIPolicy struct {
+	mode     string
+	maxBytes int
+}
+
+func NewDataURIPolicy(mode string, maxKB int) (*DataURIPolicy, error) {
+	switch mode {
+	case "keep", "strip", "extract":
+	default:
+		return nil, fmt.Errorf("data-uri %q: want keep, strip or extract", mode)
+	}
+	return &DataURIPolicy{mode: mode, maxBytes: maxKB << 10}, nil
+}
+
+func (p *DataURIPolicy) Apply(doc *renderDoc, toks []htmlToken) []htmlToken {
+	out := toks[:0]
+	for _, t := range toks {
+		if !t.isTag("img", "source") {
+			out = append(out, t)
+			continue
+		}
+		attr := "src"
+		if t.data == "source" {
+			attr = "srcset"
+		}
+		src, _ := t.attr(attr)
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(src)), "data:") {
+			out = append(out, t)
+			continue
+		}
+		ctype, data, err := decodeDataURI(src)
+		if err != nil || len(data) <= p.maxBytes {
+			out = append(out, t)
+			continue
+		}
+
+		switch p.mode {
+		case "strip":
+			if alt, _ := t.attr("alt"); strings.TrimSpace(alt) != "" {
+				out = append(out, htmlToken{kind: htmlText, data: escapeText(alt)})
+			}
+			continue
+		case "extract":
+			name := fmt.Sprintf("image-%d%s", len(doc.attachments)+1, extensionFor(ctype))
+			doc.attachments = append(doc.attachments, attachment{name: name, contentType: ctype, data: data})
+			t.setAttr(attr, name)
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// decodeDataURI parses "data:[<mediatype>][;base64],<data>".
+func decodeDataURI(uri string) (string, []byte, error) {
+	uri = strings.TrimSpace(uri)[len("data:"):]
+	meta, payload, ok := strings.Cut(uri, ",")
+	if !ok {
+		return "", nil, fmt.Errorf("data uri: missing ','")
+	}
+	isB64 := false
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		isB64 = true
+		meta = meta[:len(meta)-len(";base64")]
+	}
+	ctype := meta
+	if ctype == "" {
+		ctype = "text/plain"
+	}
+	if !isB64 {
+		s, err := url.PathUnescape(payload)
+		return ctype, []byte(s), err
+	}
+	payload = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\n' || r == '\r' || r == '\t' {
+			return -1
+		}
+		return r
+	}, payload)
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+	}
+	return ctype, data, err
+}
+
+// encodeDataURI inlines data as a base64 data: URI.
+func encodeDataURI(ctype string, data []byte) string {
+	return "data:" + ctype + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+func extensionFor(ctype string) string {
+	mt, _, _ := mime.ParseMediaType(ctype)
+	switch mt {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/svg+xml":
+		return ".svg"
+	}
+	if exts, _ := mime.ExtensionsByType(mt); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// escapeText escapes the characters that matter in HTML text content.
+func escapeText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
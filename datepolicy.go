@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   Bad publish dates
+
+   -invalid-date (unparseable) and
+   -future-date (after the run
+   started) pick what happens to
+   such a published_date:
+
+   keep    – send it as given
+   drop    – send no date
+   clamp   – send the run's start
+   updated – send updated_date if
+             it is valid and not in
+             the future, else drop
+   fail    – count the file as an
+             input failure
+
+   Dates are read with the layouts
+   -order publish-date knows, after
+   -assume-tz has applied.
+--------------------------------*/
+
+type DatePolicy struct {
+	invalid, future string
+}
+
+func NewDatePolicy(invalid, future string) (*DatePolicy, error) {
+	for _, f := range [][2]string{{"invalid-date", invalid}, {"future-date", future}} {
+		switch f[1] {
+		case "keep", "drop", "clamp", "updated", "fail":
+		default:
+			return nil, fmt.Errorf("%s %q: want keep, drop, clamp, updated or fail", f[0], f[1])
+		}
+	}
+	return &DatePolicy{invalid: invalid, future: future}, nil
+}
+
+// Apply fixes a's publish date as of now. drop means the date is to be left
+// out of the metadata; note describes a change, for the log.
+func (p *DatePolicy) Apply(a *Article, now time.Time) (drop bool, note string, err error) {
+	date := strings.TrimSpace(a.PublishDate)
+	if date == "" {
+		return false, "", nil
+	}
+	action, problem := "keep", ""
+	if d, ok := parseArticleDate(date); !ok {
+		action, problem = p.invalid, "is not a date"
+	} else if d.After(now) {
+		action, problem = p.future, "is in the future"
+	}
+	switch action {
+	case "keep":
+		return false, "", nil
+	case "fail":
+		return false, "", &inputError{fmt.Errorf("published_date %q %s", date, problem)}
+	case "clamp":
+		a.PublishDate = now.UTC().Format(time.RFC3339)
+		return false, fmt.Sprintf("published_date %q %s → %s", date, problem, a.PublishDate), nil
+	case "updated":
+		if u, ok := parseArticleDate(a.UpdatedDate); ok && !u.After(now) {
+			a.PublishDate = strings.TrimSpace(a.UpdatedDate)
+			return false, fmt.Sprintf("published_date %q %s → updated_date %s", date, problem, a.PublishDate), nil
+		}
+	}
+	a.PublishDate = ""
+	return true, fmt.Sprintf("published_date %q %s → dropped", date, problem), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+)
+
+/* -------------------------------
+   Duplicate files
+
+   Files whose decoded articles are
+   identical – one export saved
+   under two names – are uploaded
+   once per run. The others are
+   skipped as aliases of the first
+   and listed at the end of the run,
+   in the status dump and in
+   -notify-url summaries.
+
+   -allow-duplicates uploads them
+   all.
+--------------------------------*/
+
+// duplicateError marks an article identical to one of an earlier file.
+type duplicateError struct{ of string }
+
+func (e *duplicateError) Error() string { return "identical to " + e.of }
+
+// duplicateAlias is a file skipped as a duplicate.
+type duplicateAlias struct {
+	File string `json:"file"`
+	Of   string `json:"of"`
+}
+
+type runDedupe struct {
+	mu    sync.Mutex
+	first map[[32]byte]string // file that claimed each article
+}
+
+// reset forgets the previous run's articles.
+func (d *runDedupe) reset() {
+	d.mu.Lock()
+	d.first = make(map[[32]byte]string)
+	d.mu.Unlock()
+}
+
+// articleSum hashes an article as decoded.
+func articleSum(a *Article) [32]byte {
+	b, _ := json.Marshal(a)
+	return sha256.Sum256(b)
+}
+
+// claim records file as the one uploading the article with sum; first
+// names the earlier file when there is one.
+func (d *runDedupe) claim(sum [32]byte, file string) (first string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.first == nil {
+		d.first = make(map[[32]byte]string)
+	}
+	if f, taken := d.first[sum]; taken && !sameFile(f, file) {
+		return f, false
+	}
+	d.first[sum] = file
+	return "", true
+}
+
+// release gives up file's claim after its upload failed.
+func (d *runDedupe) release(sum [32]byte, file string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, taken := d.first[sum]; taken && sameFile(f, file) {
+		delete(d.first, sum)
+	}
+}
+
+// sameFile reports whether two paths name the same file.
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return absA == absB
+}
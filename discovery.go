@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   File discovery filters
+
+   Applied to the -dir listing (and
+   -retry lists) before a run, so a
+   watch folder's junk never reaches
+   the API:
+
+   - -exclude globs; a pattern with
+     a "/" matches the path below
+     -dir, "**" any number of
+     directories; one without
+     matches the file name
+   - dotfiles, editor swap/backup
+     files, Office lock files and
+     unfinished downloads
+   - files outside -min-size /
+     -max-size
+   - files still being written: any
+     file touched within -settle is
+     stat-ed again after -settle
+     and skipped if its size or
+     mtime moved
+
+   Size and half-written skips are
+   listed in the run report.
+--------------------------------*/
+
+// skippedFile is a file left out before the run, with why.
+type skippedFile struct {
+	Path   string `json:"path"`
+	Kind   string `json:"kind"` // excluded, temporary, size, partial or unchanged
+	Reason string `json:"reason"`
+}
+
+// listed reports whether the run report names the file, rather than only
+// counting it.
+func (s skippedFile) listed() bool { return s.Kind == "size" || s.Kind == "partial" }
+
+type Discovery struct {
+	dir      string
+	excludes []string
+	settle   time.Duration
+	minSize  int64 // 0 = no limit
+	maxSize  int64 // 0 = no limit
+}
+
+// NewDiscovery checks the -exclude patterns, each of which may hold
+// several comma-separated globs.
+func NewDiscovery(dir string, excludes []string, settle time.Duration, minSize, maxSize int64) (*Discovery, error) {
+	if maxSize > 0 && minSize > maxSize {
+		return nil, fmt.Errorf("min-size %s is over max-size %s", formatSize(minSize), formatSize(maxSize))
+	}
+	d := &Discovery{dir: dir, settle: settle, minSize: minSize, maxSize: maxSize}
+	for _, list := range excludes {
+		for _, p := range strings.Split(list, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			if _, err := path.Match(strings.ReplaceAll(p, "**", "*"), ""); err != nil {
+				return nil, fmt.Errorf("exclude %q: %w", p, err)
+			}
+			d.excludes = append(d.excludes, filepath.ToSlash(p))
+		}
+	}
+	return d, nil
+}
+
+// Filter drops excluded, temporary and half-written files.
+func (d *Discovery) Filter(files []string) (keep []string, skipped []skippedFile) {
+	type stamp struct {
+		size int64
+		mod  time.Time
+	}
+	recent := make(map[string]stamp)
+	now := time.Now()
+	for _, f := range files {
+		if kind, reason := d.exclude(f); kind != "" {
+			skipped = append(skipped, skippedFile{f, kind, reason})
+			continue
+		}
+		if st, err := os.Stat(f); err == nil {
+			if reason := d.checkSize(st.Size()); reason != "" {
+				skipped = append(skipped, skippedFile{f, "size", reason})
+				continue
+			}
+			if d.settle > 0 && now.Sub(st.ModTime()) < d.settle {
+				recent[f] = stamp{st.Size(), st.ModTime()}
+			}
+		}
+		keep = append(keep, f)
+	}
+	if len(recent) == 0 {
+		return keep, skipped
+	}
+
+	time.Sleep(d.settle)
+	settled := keep[:0]
+	for _, f := range keep {
+		if before, ok := recent[f]; ok {
+			st, err := os.Stat(f)
+			if err != nil || st.Size() != before.size || !st.ModTime().Equal(before.mod) {
+				skipped = append(skipped, skippedFile{f, "partial", "still being written"})
+				continue
+			}
+		}
+		settled = append(settled, f)
+	}
+	return settled, skipped
+}
+
+// checkSize says why a file of size bytes is left out, or "".
+func (d *Discovery) checkSize(size int64) string {
+	if size < d.minSize {
+		return fmt.Sprintf("%s, under -min-size %s", formatSize(size), formatSize(d.minSize))
+	}
+	if d.maxSize > 0 && size > d.maxSize {
+		return fmt.Sprintf("%s, over -max-size %s", formatSize(size), formatSize(d.maxSize))
+	}
+	return ""
+}
+
+// exclude says whether and why f is left out by name.
+func (d *Discovery) exclude(f string) (kind, reason string) {
+	base := filepath.Base(f)
+	if isTempFile(base) {
+		return "temporary", "temporary file"
+	}
+	rel := f
+	if r, err := filepath.Rel(d.dir, f); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	rel = filepath.ToSlash(rel)
+	for _, p := range d.excludes {
+		target := base
+		if strings.Contains(p, "/") {
+			target = rel
+		}
+		if globMatch(p, target) {
+			return "excluded", "excluded by " + p
+		}
+	}
+	return "", ""
+}
+
+var sizeUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"B", 1},
+}
+
+// parseSize reads a byte count such as 0, 512, 64K, 1.5MB or 2GiB; bare K,
+// M and G are binary, KB/MB/GB decimal.
+func parseSize(s string) (int64, error) {
+	num := strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(num, u.suffix) {
+			num, mult = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("size %q: want a byte count such as 512, 64K or 1.5MB", s)
+	}
+	return int64(f * float64(mult)), nil
+}
+
+// formatSize renders n bytes for logs: 0 B, 812 B, 64.0 KiB, 1.5 MiB.
+func formatSize(n int64) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, unit := float64(n)/(1<<10), "KiB"
+	for _, u := range []string{"MiB", "GiB", "TiB"} {
+		if v < 1<<10 {
+			break
+		}
+		v, unit = v/(1<<10), u
+	}
+	return fmt.Sprintf("%.1f %s", v, unit)
+}
+
+// reportSkipped prints counts of files left out before the run by kind,
+// naming those the report lists.
+func reportSkipped(w io.Writer, skipped []skippedFile) {
+	if len(skipped) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	for _, s := range skipped {
+		counts[s.Kind]++
+	}
+	var parts []string
+	for _, kind := range []string{"excluded", "temporary", "size", "partial", "unchanged"} {
+		if counts[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	fmt.Fprintf(w, "Skipped up front: %d (%s)\n", len(skipped), strings.Join(parts, ", "))
+	for _, s := range skipped {
+		if s.listed() {
+			fmt.Fprintf(w, "  %s: %s\n", s.Path, s.Reason)
+		}
+	}
+}
+
+var tempSuffixes = []string{"~", ".swp", ".swo", ".swx", ".tmp", ".temp", ".part", ".partial", ".crdownload", ".download"}
+
+// isTempFile spots dotfiles and the droppings of editors, Office and
+// browsers.
+func isTempFile(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~$") ||
+		(strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#")) {
+		return true
+	}
+	lower := strings.ToLower(name)
+	for _, s := range tempSuffixes {
+		if strings.HasSuffix(lower, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch matches a slash-separated path against a pattern in which a
+// "**" segment stands for any number of directories.
+func globMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+/* -------------------------------
+   Embed policy (-embed-policy)
+
+   keep      – pass <iframe>/<embed>/
+               <object> through untouched
+               (default)
+   strip     – remove them
+   link      – replace with a link to
+               the embedded URL
+   allowlist – keep embeds whose host
+               is in -embed-hosts, turn
+               the rest into links
+
+   Allowlisted embeds are defanged:
+   event handlers, srcdoc and
+   javascript: URLs are removed.
+--------------------------------*/
+
+const defaultEmbedHosts = "youtube.com,youtube-nocookie.com,player.vimeo.com"
+
+type EmbedPolicy struct {
+	mode  string
+	hosts []string
+}
+
+func NewEmbedPolicy(mode, hosts string) (*EmbedPolicy, error) {
+	switch mode {
+	case "keep", "strip", "link", "allowlist":
+	default:
+		return nil, fmt.Errorf("embed-policy %q: want keep, strip, link or allowlist", mode)
+	}
+	p := &EmbedPolicy{mode: mode}
+	for _, h := range strings.Split(hosts, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			p.hosts = append(p.hosts, h)
+		}
+	}
+	return p, nil
+}
+
+var embedElems = []string{"iframe", "embed", "object"}
+
+// Apply rewrites every embed element in toks according to the policy.
+func (p *EmbedPolicy) Apply(_ *renderDoc, toks []htmlToken) []htmlToken {
+	out := make([]htmlToken, 0, len(toks))
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if !t.isTag(embedElems...) {
+			out = append(out, t)
+			continue
+		}
+		end := elementEnd(toks, i)
+		src := embedSource(&t)
+
+		action := p.mode
+		if action == "allowlist" {
+			if p.allowed(src) {
+				action = "keep"
+			} else {
+				action = "link"
+			}
+		}
+
+		switch action {
+		case "keep":
+			for j := i; j <= end; j++ {
+				if toks[j].kind == htmlStartTag || toks[j].kind == htmlSelfClosingTag {
+					defangTag(&toks[j])
+				}
+				out = append(out, toks[j])
+			}
+		case "link":
+			if src != "" && safeURL(src) {
+				out = append(out, linkTokens(src, src)...)
+			}
+		}
+		i = end
+	}
+	return out
+}
+
+func (p *EmbedPolicy) allowed(src string) bool {
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "") {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	for _, h := range p.hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
+
+func embedSource(t *htmlToken) string {
+	for _, k := range []string{"src", "data"} {
+		if v, ok := t.attr(k); ok && v != "" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// defangTag strips attributes that can run script from a kept tag.
+func defangTag(t *htmlToken) {
+	t.removeAttrs(func(a htmlAttr) bool {
+		if strings.HasPrefix(a.key, "on") || a.key == "srcdoc" {
+			return true
+		}
+		switch a.key {
+		case "src", "href", "data", "xlink:href", "action", "formaction":
+			return !safeURL(a.val)
+		}
+		return false
+	})
+}
+
+// safeURL rejects script-capable URL schemes.
+func safeURL(v string) bool {
+	v = strings.ToLower(strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, v))
+	return !strings.HasPrefix(v, "javascript:") && !strings.HasPrefix(v, "vbscript:") && !strings.HasPrefix(v, "data:text/html")
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+/* -------------------------------
+   Input encoding (-input-encoding)
+
+   auto (default) – strip a UTF-8 BOM,
+     decode UTF-16 (BOM or detected by
+     NUL-byte pattern), and transcode
+     input that isn't valid UTF-8 as
+     Windows-1252 (a superset of the
+     printable Latin-1 range)
+   utf-8, windows-1252, latin1,
+   utf-16le, utf-16be – force one
+
+   -nfc composes decomposed sequences
+   (e + U+0301 → é) in every Article
+   field; -fix-mojibake undoes the
+   classic UTF-8-read-as-1252 damage
+   ("CafÃ©" → "Café").
+
+   -invalid-utf8 decides what
+   happens to byte sequences that
+   are not UTF-8 – in input that is
+   otherwise UTF-8, and in fields
+   once plugins, hooks and
+   enrichment are done:
+
+   replace       – U+FFFD (default)
+   transliterate – read the byte as
+                   Windows-1252
+   fail          – input failure
+
+   Control characters other than
+   tab and line breaks are dropped
+   with them.
+--------------------------------*/
+
+var inputEncodings = map[string]bool{
+	"auto": true, "utf-8": true, "windows-1252": true, "latin1": true, "utf-16le": true, "utf-16be": true,
+}
+
+// toUTF8 converts raw input bytes to UTF-8 according to enc.
+func toUTF8(raw []byte, enc string) ([]byte, error) {
+	switch enc {
+	case "utf-8":
+		return bytes.TrimPrefix(raw, []byte("\xEF\xBB\xBF")), nil
+	case "windows-1252":
+		return decode1252(raw, true), nil
+	case "latin1":
+		return decode1252(raw, false), nil
+	case "utf-16le", "utf-16be":
+		return decodeUTF16(raw, enc == "utf-16be")
+	case "auto":
+	default:
+		return nil, fmt.Errorf("input-encoding %q: unsupported", enc)
+	}
+
+	switch {
+	case bytes.HasPrefix(raw, []byte("\xEF\xBB\xBF")):
+		return raw[3:], nil
+	case bytes.HasPrefix(raw, []byte("\xFF\xFE")):
+		return decodeUTF16(raw[2:], false)
+	case bytes.HasPrefix(raw, []byte("\xFE\xFF")):
+		return decodeUTF16(raw[2:], true)
+	}
+	if be, ok := looksUTF16(raw); ok {
+		return decodeUTF16(raw, be)
+	}
+	if utf8.Valid(raw) || mixedUTF8(raw) {
+		return raw, nil // stray bytes are left to repairUTF8
+	}
+	return decode1252(raw, true), nil
+}
+
+// mixedUTF8 reports whether raw, though not valid UTF-8, has multi-byte
+// UTF-8 sequences: UTF-8 with stray bytes rather than a legacy charset.
+func mixedUTF8(raw []byte) bool {
+	for len(raw) > 0 {
+		r, n := utf8.DecodeRune(raw)
+		if n > 1 && r != utf8.RuneError {
+			return true
+		}
+		raw = raw[n:]
+	}
+	return false
+}
+
+var invalidUTF8Modes = map[string]bool{"replace": true, "transliterate": true, "fail": true}
+
+// repairUTF8 fixes invalid UTF-8 in s by mode and drops control characters
+// other than tab and line breaks; n counts the fixes. For "fail", err
+// names the offset of the first invalid byte instead.
+func repairUTF8(s, mode string) (out string, n int, err error) {
+	if utf8.ValidString(s) && !strings.ContainsFunc(s, badControl) {
+		return s, 0, nil
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	inRun := false
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		bad := r == utf8.RuneError && size == 1
+		switch {
+		case bad && mode == "fail":
+			return s, 0, fmt.Errorf("invalid UTF-8 at byte %d", i)
+		case bad && mode == "transliterate":
+			b.Write(decode1252([]byte{s[i]}, true))
+			n++
+		case bad:
+			if !inRun {
+				b.WriteRune(utf8.RuneError) // one per run of bad bytes
+				n++
+			}
+		case badControl(r):
+			n++
+		default:
+			b.WriteString(s[i : i+size])
+		}
+		inRun = bad
+		i += size
+	}
+	return b.String(), n, nil
+}
+
+func badControl(r rune) bool {
+	return r < 0x20 && r != '\t' && r != '\n' && r != '\r' || r == 0x7F
+}
+
+// looksUTF16 spots BOM-less UTF-16 JSON: ASCII-heavy text where every
+// other byte is NUL.
+func looksUTF16(raw []byte) (bigEndian, ok bool) {
+	n := min(len(raw), 512) &^ 1
+	if n < 4 {
+		return false, false
+	}
+	var evenNul, oddNul int
+	for i := 0; i < n; i += 2 {
+		if raw[i] == 0 {
+			evenNul++
+		}
+		if raw[i+1] == 0 {
+			oddNul++
+		}
+	}
+	half := n / 2
+	switch {
+	case oddNul > half/2 && evenNul < half/16:
+		return false, true
+	case evenNul > half/2 && oddNul < half/16:
+		return true, true
+	}
+	return false, false
+}
+
+func decodeUTF16(raw []byte, bigEndian bool) ([]byte, error) {
+	if len(raw)%2 != 0 {
+		return nil, fmt.Errorf("utf-16 input has odd length %d", len(raw))
+	}
+	u := make([]uint16, len(raw)/2)
+	for i := range u {
+		if bigEndian {
+			u[i] = uint16(raw[2*i])<<8 | uint16(raw[2*i+1])
+		} else {
+			u[i] = uint16(raw[2*i+1])<<8 | uint16(raw[2*i])
+		}
+	}
+	return []byte(string(utf16.Decode(u))), nil
+}
+
+// cp1252 maps 0x80–0x9F; the rest of the byte range equals Latin-1.
+var cp1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
+
+func decode1252(raw []byte, win bool) []byte {
+	var b bytes.Buffer
+	b.Grow(len(raw) + len(raw)/4)
+	for _, c := range raw {
+		switch {
+		case c < 0x80:
+			b.WriteByte(c)
+		case win && c < 0xA0:
+			b.WriteRune(cp1252[c-0x80])
+		default:
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.Bytes()
+}
+
+// fixMojibake reverses UTF-8 text that was decoded as Windows-1252 and
+// re-encoded. It only rewrites s when the round trip yields valid UTF-8
+// containing multi-byte sequences.
+func fixMojibake(s string) string {
+	if !strings.ContainsAny(s, "ÃÂâÅÐÑ") {
+		return s
+	}
+	var b []byte
+	for _, r := range s {
+		c, ok := encode1252(r)
+		if !ok {
+			return s
+		}
+		b = append(b, c)
+	}
+	if !utf8.Valid(b) || utf8.RuneCount(b) == len(b) {
+		return s
+	}
+	return string(b)
+}
+
+func encode1252(r rune) (byte, bool) {
+	if r < 0x80 || (r >= 0xA0 && r <= 0xFF) {
+		return byte(r), true
+	}
+	for i, c := range cp1252 {
+		if c == r {
+			return byte(0x80 + i), true
+		}
+	}
+	return 0, false
+}
+
+/* ---------- NFC composition ---------- */
+
+var nfcCompose map[[2]rune]rune
+
+func init() {
+	nfcCompose = make(map[[2]rune]rune, len(nfcPairs))
+	for _, p := range nfcPairs {
+		nfcCompose[[2]rune{p[0], p[1]}] = p[2]
+	}
+}
+
+func combiningClass(r rune) uint8 {
+	i := sort.Search(len(cccRanges), func(i int) bool { return cccRanges[i].hi >= r })
+	if i < len(cccRanges) && cccRanges[i].lo <= r {
+		return cccRanges[i].ccc
+	}
+	return 0
+}
+
+const (
+	hangulSBase, hangulLBase, hangulVBase, hangulTBase = 0xAC00, 0x1100, 0x1161, 0x11A7
+	hangulLCount, hangulVCount, hangulTCount           = 19, 21, 28
+	hangulNCount                                       = hangulVCount * hangulTCount
+)
+
+// composeNFC canonically composes s. Input that is already composed
+// (the overwhelmingly common case) is returned unchanged. Combining marks
+// are reordered by class before composing, as NFC requires.
+func composeNFC(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	rs := []rune(s)
+	// canonical ordering of runs of combining marks
+	for i := 0; i < len(rs); {
+		if combiningClass(rs[i]) == 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(rs) && combiningClass(rs[j]) != 0 {
+			j++
+		}
+		run := rs[i:j]
+		sort.SliceStable(run, func(a, b int) bool { return combiningClass(run[a]) < combiningClass(run[b]) })
+		i = j
+	}
+
+	out := rs[:0]
+	starter := -1
+	var lastCCC uint8
+	for _, r := range rs {
+		ccc := combiningClass(r)
+		if starter >= 0 {
+			st := out[starter]
+			blocked := len(out)-1 != starter && (lastCCC == 0 || lastCCC >= ccc)
+			if !blocked {
+				if c, ok := composePair(st, r); ok {
+					out[starter] = c
+					continue
+				}
+			}
+		}
+		if ccc == 0 {
+			starter = len(out)
+		}
+		lastCCC = ccc
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func composePair(a, b rune) (rune, bool) {
+	// Hangul LV and LVT syllables are composed algorithmically
+	if a >= hangulLBase && a < hangulLBase+hangulLCount && b >= hangulVBase && b < hangulVBase+hangulVCount {
+		return hangulSBase + ((a-hangulLBase)*hangulVCount+(b-hangulVBase))*hangulTCount, true
+	}
+	if a >= hangulSBase && a < hangulSBase+hangulLCount*hangulNCount && (a-hangulSBase)%hangulTCount == 0 &&
+		b > hangulTBase && b < hangulTBase+hangulTCount {
+		return a + (b - hangulTBase), true
+	}
+	c, ok := nfcCompose[[2]rune{a, b}]
+	return c, ok
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
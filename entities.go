@@ -0,0 +1,97 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   Entity and punctuation cleanup
+   (-normalize-entities,
+    -ascii-punctuation)
+
+   Title and excerpt are plain text
+   (the renderer escapes them), so
+   entities there are decoded until
+   stable: "Tom &amp;amp; Jerry" →
+   "Tom & Jerry".
+
+   Content is HTML, so only the
+   double encoding is undone
+   ("&amp;amp;" → "&amp;",
+   "&amp;#8217;" → "&#8217;") and
+   numeric entities for printable
+   characters become the characters
+   themselves; markup-significant
+   ones (< > & " ') stay encoded.
+--------------------------------*/
+
+var doubleEncoded = regexp.MustCompile(`&amp;(#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+
+var numericEntity = regexp.MustCompile(`&#([0-9]+|[xX][0-9a-fA-F]+);`)
+
+// unescapeText decodes entities in a plain-text field, repeatedly, to
+// undo multiple layers of encoding.
+func unescapeText(s string) string {
+	for range 4 {
+		if !strings.Contains(s, "&") {
+			break
+		}
+		u := html.UnescapeString(s)
+		if u == s {
+			break
+		}
+		s = u
+	}
+	return s
+}
+
+// normalizeHTMLEntities fixes entity encoding inside HTML content.
+func normalizeHTMLEntities(s string) string {
+	if !strings.Contains(s, "&") {
+		return s
+	}
+	for range 4 {
+		u := doubleEncoded.ReplaceAllString(s, "&$1;")
+		if u == s {
+			break
+		}
+		s = u
+	}
+	return numericEntity.ReplaceAllStringFunc(s, func(m string) string {
+		num := m[2 : len(m)-1]
+		var n int64
+		var err error
+		if num[0] == 'x' || num[0] == 'X' {
+			n, err = strconv.ParseInt(num[1:], 16, 32)
+		} else {
+			n, err = strconv.ParseInt(num, 10, 32)
+		}
+		if err != nil || n < 0x20 || (n >= 0x7F && n < 0xA0) || n > 0x10FFFF || (n >= 0xD800 && n < 0xE000) {
+			return m
+		}
+		switch n {
+		case '<', '>', '&', '"', '\'':
+			return m
+		}
+		return string(rune(n))
+	})
+}
+
+var asciiPunct = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "′", "'",
+	"“", `"`, "”", `"`, "„", `"`, "‟", `"`, "″", `"`, "«", `"`, "»", `"`,
+	"–", "-", "—", "--", "―", "--", "−", "-", "‐", "-", "‑", "-",
+	"…", "...", " ", " ", " ", " ", " ", " ",
+)
+
+// asciiPunctuation replaces typographic quotes, dashes, ellipses and
+// special spaces with their plain ASCII forms.
+func asciiPunctuation(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	return asciiPunct.Replace(s)
+}
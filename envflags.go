@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   Flags from the environment
+
+   Every flag can be set as an
+   OMNIPUB_ variable: the name in
+   upper case, dashes as
+   underscores.
+
+   OMNIPUB_WORKERS=8
+   OMNIPUB_API_VERSION=v2
+   OMNIPUB_SAVE_FAILURES=/out/f.txt
+
+   The command line wins over the
+   environment, which wins over a
+   -profile. Boolean flags also take
+   yes/no and on/off; repeatable
+   flags take one value (most accept
+   a comma-separated list).
+--------------------------------*/
+
+const envPrefix = "OMNIPUB_"
+
+const envFlagsHelp = `
+Every flag can also be set in the environment as OMNIPUB_<NAME>, e.g.
+OMNIPUB_WORKERS=8 for -workers or OMNIPUB_API_VERSION=v2 for -api-version.
+Flags on the command line take precedence.
+`
+
+// envName is the variable that sets flag name, e.g. OMNIPUB_API_VERSION.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvFlags sets each flag of fs not given on the command line from
+// its variable, and returns the variables used.
+func applyEnvFlags(fs *flag.FlagSet) ([]string, error) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var used []string
+	var firstErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if set[f.Name] || firstErr != nil {
+			return
+		}
+		env := envName(f.Name)
+		v, ok := os.LookupEnv(env)
+		if !ok || v == "" {
+			return
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			if v, ok = envBool(v); !ok {
+				firstErr = fmt.Errorf("%s=%q: want true or false", env, os.Getenv(env))
+				return
+			}
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			firstErr = fmt.Errorf("%s=%q: %w", env, v, err)
+			return
+		}
+		used = append(used, env)
+	})
+	return used, firstErr
+}
+
+// envBool normalizes a boolean variable to "true" or "false".
+func envBool(v string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "yes", "on":
+		return "true", true
+	case "no", "off":
+		return "false", true
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		return "", false
+	}
+	return strconv.FormatBool(b), true
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/* -------------------------------
+   EPUB books (.epub)
+
+   -epub chapters (default) makes
+   one item per chapter, book one
+   item for the whole book. Title,
+   authors, date, subjects and
+   description come from the OPF
+   package; chapter titles from the
+   table of contents (NCX or EPUB 3
+   nav), else the chapter's own
+   <title> or first heading.
+
+   Chapters are the spine's linear
+   documents. In chapters mode the
+   ones without text – cover and
+   plate pages – are left out.
+   Images are inlined as data: URIs,
+   so -data-uri extract sends them
+   as attachments.
+--------------------------------*/
+
+var epubModes = map[string]bool{"chapters": true, "book": true}
+
+const epubMaxEntry = 64 << 20 // bytes read from one file in the archive
+
+// the expressions that find their way around a book
+var (
+	epubRootfile   = mustCompileXPath("//rootfile/@full-path")
+	epubSpine      = mustCompileXPath("//spine/itemref[not(@linear='no')]/@idref")
+	epubTitle      = mustCompileXPath("//metadata/title")
+	epubCreators   = mustCompileXPath("//metadata/creator")
+	epubDate       = mustCompileXPath("//metadata/date")
+	epubDesc       = mustCompileXPath("//metadata/description")
+	epubSubjects   = mustCompileXPath("//metadata/subject")
+	epubLinks      = mustCompileXPath("//metadata/identifier | //metadata/source")
+	epubManifest   = mustCompileXPath("//manifest/item")
+	epubNavLinks   = mustCompileXPath("//nav[@type='toc']//a")
+	epubNavPoints  = mustCompileXPath("//navPoint")
+	epubNavLabel   = mustCompileXPath("navLabel/text")
+	epubNavSrc     = mustCompileXPath("content/@src")
+	epubBody       = mustCompileXPath("//body")
+	epubHeadTitle  = mustCompileXPath("//head/title")
+	epubFirstHeads = mustCompileXPath(".//h1 | .//h2 | .//h3")
+)
+
+// isEPUBFile reports whether file is read as an EPUB book.
+func isEPUBFile(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".epub")
+}
+
+type epubBook struct {
+	zip     *zip.Reader
+	files   map[string]*zip.File
+	opfDir  string
+	title   string
+	authors []string
+	date    string
+	desc    string
+	link    string // identifier or source, if it is a URL
+	tags    termList
+	items   map[string]epubItem // manifest, by id
+	toc     map[string]string   // chapter path → table of contents label
+}
+
+type epubItem struct {
+	path, mediaType, properties string
+}
+
+// decodeEPUB reads raw as an EPUB and returns its chapters, or the whole
+// book as one article, per mode.
+func decodeEPUB(raw []byte, mode string) ([]Article, error) {
+	zr, err := zip.NewReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		return nil, fmt.Errorf("epub: %w", err)
+	}
+	b := &epubBook{zip: zr, files: make(map[string]*zip.File), items: make(map[string]epubItem), toc: make(map[string]string)}
+	for _, f := range zr.File {
+		b.files[f.Name] = f
+	}
+	opf, err := b.readPackage()
+	if err != nil {
+		return nil, err
+	}
+	b.readTOC()
+
+	type chapter struct {
+		label, html string
+	}
+	var chapters []chapter
+	for _, ref := range xpathNodes(opf, epubSpine) {
+		item, ok := b.items[ref.data]
+		if !ok || !strings.Contains(item.mediaType, "html") {
+			continue
+		}
+		label, body, err := b.chapter(item.path)
+		if err != nil {
+			return nil, err
+		}
+		if mode == "chapters" && strings.TrimSpace(body.stringValue()) == "" {
+			continue
+		}
+		chapters = append(chapters, chapter{label: cmp.Or(b.toc[item.path], label), html: strings.TrimSpace(body.innerMarkup())})
+	}
+	if len(chapters) == 0 {
+		return nil, errors.New("epub: no chapters with text in the spine")
+	}
+
+	base := Article{
+		Author:      strings.Join(b.authors, ", "),
+		PublishDate: b.date,
+		Tags:        b.tags,
+	}
+	if mode == "book" {
+		a := base
+		a.Title, a.Excerpt, a.Link = b.title, b.desc, b.link
+		var content strings.Builder
+		for _, c := range chapters {
+			content.WriteString("<section>\n" + c.html + "\n</section>\n")
+		}
+		a.Content = content.String()
+		return []Article{a}, nil
+	}
+	arts := make([]Article, 0, len(chapters))
+	for i, c := range chapters {
+		a := base
+		label := c.label
+		if label == "" || label == b.title {
+			label = fmt.Sprintf("Chapter %d", i+1)
+		}
+		a.Title = label
+		if b.title != "" {
+			a.Title = b.title + ": " + label
+		}
+		a.Content = c.html
+		if b.link != "" {
+			a.Link = fmt.Sprintf("%s#chapter-%d", b.link, i+1)
+		}
+		arts = append(arts, a)
+	}
+	return arts, nil
+}
+
+// readPackage finds and reads the OPF: metadata and manifest.
+func (b *epubBook) readPackage() (*xmlNode, error) {
+	container, err := b.xml("META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	opfPath := xpathText(container, epubRootfile)
+	if opfPath == "" {
+		return nil, errors.New("epub: container.xml names no package")
+	}
+	opf, err := b.xml(opfPath)
+	if err != nil {
+		return nil, err
+	}
+	b.opfDir = path.Dir(opfPath)
+
+	b.title = strings.Join(strings.Fields(xpathText(opf, epubTitle)), " ")
+	for _, n := range xpathNodes(opf, epubCreators) {
+		if s := strings.TrimSpace(n.stringValue()); s != "" {
+			b.authors = append(b.authors, s)
+		}
+	}
+	b.date = xpathText(opf, epubDate)
+	b.desc = xpathText(opf, epubDesc)
+	for _, n := range xpathNodes(opf, epubSubjects) {
+		if s := strings.TrimSpace(n.stringValue()); s != "" {
+			b.tags = append(b.tags, s)
+		}
+	}
+	for _, n := range xpathNodes(opf, epubLinks) {
+		if s := strings.TrimSpace(n.stringValue()); strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+			b.link = s
+			break
+		}
+	}
+	for _, n := range xpathNodes(opf, epubManifest) {
+		id, href := attrValue(n, "id"), attrValue(n, "href")
+		if id == "" || href == "" {
+			continue
+		}
+		b.items[id] = epubItem{path: b.resolve(b.opfDir, href), mediaType: attrValue(n, "media-type"), properties: attrValue(n, "properties")}
+	}
+	return opf, nil
+}
+
+// readTOC labels chapters from the EPUB 3 nav document, then the NCX.
+func (b *epubBook) readTOC() {
+	var navs, ncxs []string
+	for _, item := range b.items {
+		switch {
+		case strings.Contains(" "+item.properties+" ", " nav "):
+			navs = append(navs, item.path)
+		case item.mediaType == "application/x-dtbncx+xml":
+			ncxs = append(ncxs, item.path)
+		}
+	}
+	slices.Sort(navs)
+	slices.Sort(ncxs)
+	for _, p := range navs {
+		b.readTOCFile(p, epubNavLinks)
+	}
+	for _, p := range ncxs {
+		b.readTOCFile(p, epubNavPoints)
+	}
+}
+
+func (b *epubBook) readTOCFile(file string, expr *xpathExpr) {
+	doc, err := b.xml(file)
+	if err != nil {
+		return // a broken table of contents only costs the labels
+	}
+	for _, n := range xpathNodes(doc, expr) {
+		label, href := n.stringValue(), attrValue(n, "href")
+		if expr == epubNavPoints {
+			label = xpathText(n, epubNavLabel)
+			href = xpathText(n, epubNavSrc)
+		}
+		label = strings.Join(strings.Fields(label), " ")
+		if label == "" || href == "" {
+			continue
+		}
+		p := b.resolve(path.Dir(file), href)
+		if _, seen := b.toc[p]; !seen {
+			b.toc[p] = label
+		}
+	}
+}
+
+// chapter reads one spine document: its own title and its body, with
+// images inlined and links to other chapters cut to their fragment.
+func (b *epubBook) chapter(p string) (string, *xmlNode, error) {
+	doc, err := b.xml(p)
+	if err != nil {
+		return "", nil, err
+	}
+	body := doc
+	if nodes := xpathNodes(doc, epubBody); len(nodes) > 0 {
+		body = nodes[0]
+	}
+	label := xpathText(doc, epubHeadTitle)
+	if label == "" {
+		if heads := xpathNodes(body, epubFirstHeads); len(heads) > 0 {
+			label = strings.TrimSpace(heads[0].stringValue())
+		}
+	}
+	dir := path.Dir(p)
+	for _, n := range body.descendantsOrSelf() {
+		if n.kind != xmlElement {
+			continue
+		}
+		for _, a := range n.attrs {
+			switch {
+			case (n.name.Local == "img" && a.name.Local == "src") || (n.name.Local == "image" && a.name.Local == "href"):
+				if uri, ok := b.dataURI(dir, a.data); ok {
+					a.data = uri
+				}
+			case n.name.Local == "a" && a.name.Local == "href" && !strings.Contains(a.data, ":"):
+				if _, frag, ok := strings.Cut(a.data, "#"); ok {
+					a.data = "#" + frag
+				} else {
+					a.data = "#"
+				}
+			}
+		}
+	}
+	return strings.Join(strings.Fields(label), " "), body, nil
+}
+
+// dataURI inlines the archive file ref points to.
+func (b *epubBook) dataURI(dir, ref string) (string, bool) {
+	if strings.Contains(ref, ":") {
+		return "", false // data: or remote
+	}
+	raw, err := b.read(b.resolve(dir, ref))
+	if err != nil {
+		return "", false
+	}
+	ctype := mime.TypeByExtension(strings.ToLower(path.Ext(ref)))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	return encodeDataURI(ctype, raw), true
+}
+
+// resolve joins an href (URL-escaped, relative to dir) to an archive path.
+func (b *epubBook) resolve(dir, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if u, err := url.PathUnescape(href); err == nil {
+		href = u
+	}
+	return path.Clean(path.Join(dir, href))
+}
+
+func (b *epubBook) read(name string) ([]byte, error) {
+	f, ok := b.files[name]
+	if !ok {
+		return nil, fmt.Errorf("epub: %s missing from the archive", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("epub: %s: %w", name, err)
+	}
+	defer rc.Close()
+	raw, err := io.ReadAll(io.LimitReader(rc, epubMaxEntry+1))
+	if err != nil {
+		return nil, fmt.Errorf("epub: %s: %w", name, err)
+	}
+	if len(raw) > epubMaxEntry {
+		return nil, fmt.Errorf("epub: %s: larger than %s", name, formatSize(epubMaxEntry))
+	}
+	return raw, nil
+}
+
+func (b *epubBook) xml(name string) (*xmlNode, error) {
+	raw, err := b.read(name)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseXMLTree(raw)
+	if err != nil {
+		return nil, fmt.Errorf("epub: %s: %w", name, err)
+	}
+	return doc, nil
+}
+
+/* ---------- XPath helpers ---------- */
+
+// xpathNodes returns the nodes e selects from n.
+func xpathNodes(n *xmlNode, e *xpathExpr) []*xmlNode {
+	v, _ := e.eval(n)
+	nodes, _ := v.([]*xmlNode)
+	return nodes
+}
+
+// xpathText is the trimmed string value of the first node e selects.
+func xpathText(n *xmlNode, e *xpathExpr) string {
+	nodes := xpathNodes(n, e)
+	if len(nodes) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(nodes[0].stringValue())
+}
+
+// attrValue returns the value of n's attribute local, "" if unset.
+func attrValue(n *xmlNode, local string) string {
+	for _, a := range n.attrs {
+		if a.name.Local == local {
+			return a.data
+		}
+	}
+	return ""
+}
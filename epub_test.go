@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testEPUB zips files into an EPUB.
+func testEPUB(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+const epubContainer = `<?xml version="1.0"?>
+<container xmlns="urn:oasis:names:tc:opendocument:xmlns:container" version="1.0">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+// epub3Files is a small EPUB 3 book: a cover without text, two chapters
+// labelled by the nav document, and an appendix outside the linear spine.
+func epub3Files() map[string]string {
+	return map[string]string{
+		"mimetype":               "application/epub+zip",
+		"META-INF/container.xml": epubContainer,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>The
+      Book</dc:title>
+    <dc:creator>Ann</dc:creator>
+    <dc:creator>Bob</dc:creator>
+    <dc:date>2020-05-01</dc:date>
+    <dc:subject>Fiction</dc:subject>
+    <dc:identifier>urn:isbn:123</dc:identifier>
+    <dc:source>https://books.example.com/the-book</dc:source>
+    <dc:description>About the book.</dc:description>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c1" href="text/ch%201.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c2" href="text/ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="app" href="text/app.xhtml" media-type="application/xhtml+xml"/>
+    <item id="img" href="img/dot.png" media-type="image/png"/>
+  </manifest>
+  <spine>
+    <itemref idref="cover"/>
+    <itemref idref="c1"/>
+    <itemref idref="c2"/>
+    <itemref idref="app" linear="no"/>
+    <itemref idref="img"/>
+  </spine>
+</package>`,
+		"OEBPS/nav.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>
+  <nav epub:type="toc"><ol>
+    <li><a href="text/ch%201.xhtml">Beginnings</a></li>
+    <li><a href="text/ch2.xhtml#top">  The
+      End </a></li>
+  </ol></nav></body></html>`,
+		"OEBPS/cover.xhtml": `<html><body><img src="img/dot.png"/></body></html>`,
+		"OEBPS/text/ch 1.xhtml": `<html><head><title>Ignored</title></head><body>
+  <p>It began.</p><img src="../img/dot.png" alt="dot"/><a href="ch2.xhtml#end">on</a><a href="https://e.com/">out</a>
+</body></html>`,
+		"OEBPS/text/ch2.xhtml": `<html><body><p id="end">It ended.</p></body></html>`,
+		"OEBPS/text/app.xhtml": `<html><body><p>Appendix.</p></body></html>`,
+		"OEBPS/img/dot.png":    "PNG",
+	}
+}
+
+func TestDecodeEPUBChapters(t *testing.T) {
+	arts, err := decodeEPUB(testEPUB(t, epub3Files()), "chapters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arts) != 2 {
+		t.Fatalf("got %d articles, want the 2 chapters with text", len(arts))
+	}
+	for i, want := range []struct{ title, link, content string }{
+		{"The Book: Beginnings", "https://books.example.com/the-book#chapter-1",
+			`<p>It began.</p><img src="data:image/png;base64,UE5H" alt="dot"><a href="#end">on</a><a href="https://e.com/">out</a>`},
+		{"The Book: The End", "https://books.example.com/the-book#chapter-2", `<p id="end">It ended.</p>`},
+	} {
+		a := arts[i]
+		if a.Title != want.title || a.Link != want.link || a.Content != want.content {
+			t.Errorf("chapter %d: %q %q\n%s\nwant %q %q\n%s", i+1, a.Title, a.Link, a.Content, want.title, want.link, want.content)
+		}
+		if a.Author != "Ann, Bob" || a.PublishDate != "2020-05-01" || len(a.Tags) != 1 || a.Tags[0] != "Fiction" {
+			t.Errorf("chapter %d metadata: %q %q %v", i+1, a.Author, a.PublishDate, a.Tags)
+		}
+	}
+}
+
+func TestDecodeEPUBBook(t *testing.T) {
+	// EPUB 2: labels from the NCX; the book keeps its image-only cover
+	files := epub3Files()
+	files["OEBPS/content.opf"] = strings.NewReplacer(
+		`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`,
+		`<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>`,
+	).Replace(files["OEBPS/content.opf"])
+	delete(files, "OEBPS/nav.xhtml")
+	files["OEBPS/toc.ncx"] = `<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/"><navMap>
+  <navPoint id="p1"><navLabel><text>One</text></navLabel><content src="text/ch%201.xhtml"/></navPoint>
+  <navPoint id="p2"><navLabel><text>Two</text></navLabel><content src="text/ch2.xhtml"/></navPoint>
+</navMap></ncx>`
+
+	arts, err := decodeEPUB(testEPUB(t, files), "book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arts) != 1 {
+		t.Fatalf("got %d articles, want 1", len(arts))
+	}
+	a := arts[0]
+	if a.Title != "The Book" || a.Excerpt != "About the book." || a.Link != "https://books.example.com/the-book" {
+		t.Errorf("book: %q %q %q", a.Title, a.Excerpt, a.Link)
+	}
+	if n := strings.Count(a.Content, "<section>"); n != 3 {
+		t.Errorf("%d sections, want the cover and 2 chapters:\n%s", n, a.Content)
+	}
+	if strings.Contains(a.Content, "Appendix") {
+		t.Error("the non-linear appendix is in the book")
+	}
+
+	chapters, err := decodeEPUB(testEPUB(t, files), "chapters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 2 || chapters[0].Title != "The Book: One" || chapters[1].Title != "The Book: Two" {
+		t.Errorf("NCX labels: %+v", chapters)
+	}
+}
+
+func TestDecodeEPUBChapterLabels(t *testing.T) {
+	// without a table of contents: the <title>, else the first heading,
+	// and a label that only repeats the book title is numbered instead
+	files := epub3Files()
+	delete(files, "OEBPS/nav.xhtml")
+	files["OEBPS/text/ch2.xhtml"] = `<html><body><div><h2>Heading  Two</h2><p>x</p></div></body></html>`
+	files["OEBPS/cover.xhtml"] = `<html><head><title>The Book</title></head><body><p>By Ann</p></body></html>`
+	arts, err := decodeEPUB(testEPUB(t, files), "chapters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, a := range arts {
+		titles = append(titles, a.Title)
+	}
+	if want := "The Book: Chapter 1|The Book: Ignored|The Book: Heading Two"; strings.Join(titles, "|") != want {
+		t.Errorf("titles %q, want %q", titles, want)
+	}
+}
+
+func TestDecodeEPUBErrors(t *testing.T) {
+	noText := epub3Files()
+	for _, p := range []string{"OEBPS/text/ch 1.xhtml", "OEBPS/text/ch2.xhtml"} {
+		noText[p] = `<html><body><img src="../img/dot.png"/></body></html>`
+	}
+	missing := epub3Files()
+	delete(missing, "OEBPS/text/ch2.xhtml")
+	for _, tc := range []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{"not a zip", []byte("plain text"), "epub: zip"},
+		{"no container", testEPUB(t, map[string]string{"mimetype": "application/epub+zip"}), "META-INF/container.xml missing"},
+		{"no rootfile", testEPUB(t, map[string]string{"META-INF/container.xml": "<container/>"}), "names no package"},
+		{"no text", testEPUB(t, noText), "no chapters with text"},
+		{"missing chapter", testEPUB(t, missing), "OEBPS/text/ch2.xhtml missing"},
+	} {
+		_, err := decodeEPUB(tc.raw, "chapters")
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got error %v, want one containing %q", tc.name, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+/* -------------------------------
+   Exit codes
+
+   0  every file uploaded or
+      skipped
+   1  setup error: bad flags,
+      credentials, config files
+   2  the run finished, but some
+      files failed
+   3  the run was aborted: signal,
+      retry budget, -require abort,
+      -quota-check abort
+
+   A -schedule process stopped
+   during a run exits 3, between
+   runs 0.
+
+   Subcommands (login, audit,
+   mock-server, gen-fixtures) use
+   the same codes: 1 for bad usage
+   or a failed setup, 2 when audit
+   -verify finds problems.
+--------------------------------*/
+
+const (
+	exitOK       = 0
+	exitSetup    = 1 // also what log.Fatal exits with
+	exitFailures = 2
+	exitAborted  = 3
+)
+
+const exitCodesHelp = `
+Exit codes:
+  0  all files uploaded or skipped
+  1  setup error (flags, credentials, configuration)
+  2  completed, but some files failed
+  3  aborted by a signal, the retry budget, -require-action abort or -quota-check abort
+Subcommands exit 1 for bad usage; audit -verify exits 2 when the log fails verification.
+`
+
+// exitCode is the process exit code for a finished batch.
+func exitCode(p *Progress) int {
+	switch {
+	case p.aborted != nil:
+		return exitAborted
+	case p.fail.Load() > 0:
+		return exitFailures
+	}
+	return exitOK
+}
+
+// parseFlags parses a subcommand's flags. ok is false when parsing ends
+// the command, which then exits with code: 0 after -help, exitSetup for
+// a bad flag.
+func parseFlags(fs *flag.FlagSet, args []string) (code int, ok bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitSetup, false
+	}
+	return exitOK, true
+}
+
+// usage is -help: the flags, then the exit codes.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprint(out, envFlagsHelp)
+	fmt.Fprint(out, exitCodesHelp)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// checkUsageExits runs a subcommand's main with each of bad, expecting
+// exitSetup, and with help, expecting exitOK. Stderr is discarded.
+func checkUsageExits(t *testing.T, main func([]string) int, help []string, bad ...[]string) {
+	t.Helper()
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer null.Close()
+	stderr := os.Stderr
+	os.Stderr = null
+	defer func() { os.Stderr = stderr }()
+	for _, args := range bad {
+		if code := main(args); code != exitSetup {
+			t.Errorf("%q: exit %d, want %d", args, code, exitSetup)
+		}
+	}
+	if code := main(help); code != exitOK {
+		t.Errorf("%q: exit %d, want %d", help, code, exitOK)
+	}
+}
+
+func TestLoginUsageExits(t *testing.T) {
+	checkUsageExits(t, loginMain, []string{"-help"},
+		[]string{"-bogus"},
+		[]string{"extra"},
+		[]string{"-device-url", "https://sso.example.com/device"}, // without -token-url
+	)
+}
+
+func TestAuditUsageExits(t *testing.T) {
+	checkUsageExits(t, auditMain, []string{"inspect", "-help"},
+		[]string{},
+		[]string{"rewind", "a.jsonl"},
+		[]string{"inspect", "-bogus", "a.jsonl"},
+		[]string{"inspect"},
+		[]string{"replay", "-since", "soon", "a.jsonl"},
+	)
+}
+
+func TestMockServerUsageExits(t *testing.T) {
+	checkUsageExits(t, mockMain, []string{"-help"},
+		[]string{"-bogus"},
+		[]string{"extra"},
+		[]string{"-latency", "fast"},
+		[]string{"-error-rate", "2"},
+		[]string{"-error-status", "200"},
+	)
+}
+
+func TestGenFixturesUsageExits(t *testing.T) {
+	checkUsageExits(t, fixturesMain, []string{"-help"},
+		[]string{"-bogus"},
+		[]string{}, // no -out
+		[]string{"-out", "x", "-count", "0"},
+		[]string{"-out", "x", "-edge-rate", "-1"},
+		[]string{"-out", "x", "-size", "big"},
+		[]string{"-out", "x", "-edge-cases", "nope"},
+	)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* -------------------------------
+   Region failover (-failover-api)
+
+   An item the primary API base
+   fails with a connection error
+   before any attempt sent the
+   request (after its retries) is
+   sent once more to the secondary
+   base, with the same key. Once
+   the primary saw the request it
+   may have created the item, so
+   a 5xx or a connection lost
+   mid-request is not resent.
+
+   Network errors and 5xx both
+   count as the primary failing:
+   after -failover-after such
+   items in a row, new uploads
+   skip the primary for a while;
+   when that ends the primary gets
+   another try. The manifest records
+   which base took each file.
+--------------------------------*/
+
+const failoverCooldown = 5 * time.Minute
+
+type failover struct {
+	secondary string
+	after     int // consecutive primary failures that trip it
+
+	mu       sync.Mutex
+	failures int
+	until    time.Time // uploads go to the secondary until then
+}
+
+func newFailover(secondary string, after int) *failover {
+	return &failover{secondary: strings.TrimSuffix(secondary, "/"), after: after}
+}
+
+// uploadBase is where the next upload goes.
+func (t *Transformer) uploadBase() string {
+	f := t.failover
+	if f == nil {
+		return t.apiBase
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if time.Now().Before(f.until) {
+		return f.secondary
+	}
+	return t.apiBase
+}
+
+// report takes the outcome of an upload to the primary and says whether
+// to try the secondary with it: only if sent, whether any attempt wrote
+// the request, is false. A nil failover never does.
+func (f *failover) report(err error, sent bool) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !regionalFailure(err) {
+		// the primary answered, even if with a 4xx
+		f.failures = 0
+		return false
+	}
+	f.failures++
+	if f.failures >= f.after && !time.Now().Before(f.until) {
+		f.until = time.Now().Add(failoverCooldown)
+		log.Printf("Primary API failed %d uploads in a row; sending uploads to %s for %s", f.failures, f.secondary, failoverCooldown)
+	}
+	return !sent
+}
+
+// traceSent returns ctx with a trace that sets sent once a request's
+// headers have been written to the connection.
+func traceSent(ctx context.Context, sent *atomic.Bool) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() { sent.Store(true) },
+	})
+}
+
+// regionalFailure reports whether err suggests the API base itself is in
+// trouble (no connection, 5xx), as opposed to this request or key (4xx,
+// including 429).
+func regionalFailure(err error) bool {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.status >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,865 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/* -------------------------------
+   Article filter (-filter)
+
+   A small subset of CEL evaluated
+   against each decoded article:
+
+     article.published_date >= "2020" &&
+       size(article.content) > 500
+
+   Supported: string/int/float/bool
+   literals, article.<field> (tags,
+   categories and collections are
+   lists), ! - + *
+   / % == != < <= > >= && || ?:, in,
+   parentheses, size(), and the
+   string methods contains,
+   startsWith, endsWith, matches,
+   lowerAscii, upperAscii, trim.
+
+   -match-title / -match-link are
+   plain RE2 shorthands for the
+   common "matches" case.
+--------------------------------*/
+
+// Filter is a compiled -filter expression.
+type Filter struct {
+	src  string
+	root celNode
+}
+
+// NewFilter parses a filter expression.
+func NewFilter(src string) (*Filter, error) {
+	p := &celParser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	root, err := p.parseExpr(0)
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("filter: unexpected %q", p.toks[p.pos].text)
+	}
+	if err := celCheck(root); err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+	return &Filter{src: src, root: root}, nil
+}
+
+// Match reports whether the article passes the filter.
+func (f *Filter) Match(a *Article) (bool, error) {
+	env := map[string]any{}
+	for name, field := range articleFields {
+		env[name] = *field(a)
+	}
+	for name, field := range filterListFields {
+		env[name] = field(a)
+	}
+	v, err := f.root.eval(map[string]any{"article": env})
+	if err != nil {
+		return false, fmt.Errorf("filter: %w", err)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter: expression yields %T, want bool", v)
+	}
+	return b, nil
+}
+
+// filterListFields are the list-valued article fields a filter sees
+// besides the string ones in articleFields.
+var filterListFields = map[string]func(a *Article) []any{
+	"tags":       func(a *Article) []any { return celStrings(a.Tags) },
+	"categories": func(a *Article) []any { return celStrings(a.Categories) },
+	"collections": func(a *Article) []any {
+		out := make([]any, len(a.Collections))
+		for i, id := range a.Collections {
+			out[i] = int64(id)
+		}
+		return out
+	},
+}
+
+func celStrings(l []string) []any {
+	out := make([]any, len(l))
+	for i, s := range l {
+		out[i] = s
+	}
+	return out
+}
+
+// FieldMatch keeps articles whose field matches a regular expression.
+type FieldMatch struct {
+	field string
+	re    *regexp.Regexp
+}
+
+func NewFieldMatch(field, expr string) (*FieldMatch, error) {
+	if articleFields[field] == nil {
+		return nil, fmt.Errorf("match: unknown article field %q", field)
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("match-%s: %w", field, err)
+	}
+	return &FieldMatch{field: field, re: re}, nil
+}
+
+func (m *FieldMatch) Match(a *Article) bool {
+	return m.re.MatchString(*articleFields[m.field](a))
+}
+
+func (m *FieldMatch) String() string { return fmt.Sprintf("%s does not match %q", m.field, m.re) }
+
+// ---------- lexer ----------
+
+type celTokKind int
+
+const (
+	celEOF celTokKind = iota
+	celIdent
+	celString
+	celNumber
+	celOp
+)
+
+type celTok struct {
+	kind celTokKind
+	text string
+}
+
+type celParser struct {
+	src  string
+	toks []celTok
+	pos  int
+}
+
+var celOps = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "+", "-", "*", "/", "%", "(", ")", "[", "]", ".", ",", "?", ":"}
+
+// celRequote turns the body of a CEL string in either quotes into the
+// body of a Go double-quoted one: \' becomes ' and a bare " is escaped.
+func celRequote(lit string) string {
+	var b strings.Builder
+	for k := 0; k < len(lit); k++ {
+		switch {
+		case lit[k] == '\\' && k+1 < len(lit):
+			k++
+			if lit[k] == '\'' {
+				b.WriteByte('\'')
+			} else {
+				b.WriteByte('\\')
+				b.WriteByte(lit[k])
+			}
+		case lit[k] == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(lit[k])
+		}
+	}
+	return b.String()
+}
+
+func (p *celParser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != s[i] {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string at %d", i)
+			}
+			str, err := strconv.Unquote(`"` + celRequote(s[i+1:j]) + `"`)
+			if err != nil {
+				return fmt.Errorf("bad string at %d: %v", i, err)
+			}
+			p.toks = append(p.toks, celTok{celString, str})
+			i = j + 1
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			p.toks = append(p.toks, celTok{celNumber, s[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			p.toks = append(p.toks, celTok{celIdent, s[i:j]})
+			i = j
+		default:
+			matched := false
+			for _, op := range celOps {
+				if strings.HasPrefix(s[i:], op) {
+					p.toks = append(p.toks, celTok{celOp, op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return fmt.Errorf("unexpected %q at %d", c, i)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *celParser) peek() celTok {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return celTok{kind: celEOF}
+}
+
+func (p *celParser) next() celTok {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *celParser) expect(op string) error {
+	if t := p.next(); t.kind != celOp || t.text != op {
+		return fmt.Errorf("expected %q, got %q", op, t.text)
+	}
+	return nil
+}
+
+// ---------- parser (precedence climbing) ----------
+
+var celPrec = map[string]int{
+	"?":  1,
+	"||": 2,
+	"&&": 3,
+	"==": 4, "!=": 4, "<": 4, "<=": 4, ">": 4, ">=": 4, "in": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+func (p *celParser) parseExpr(minPrec int) (celNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != celOp && !(t.kind == celIdent && t.text == "in") {
+			return left, nil
+		}
+		prec, ok := celPrec[t.text]
+		if !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+		if t.text == "?" {
+			then, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			els, err := p.parseExpr(prec - 1)
+			if err != nil {
+				return nil, err
+			}
+			left = &celCond{left, then, els}
+			continue
+		}
+		right, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = &celBinary{t.text, left, right}
+	}
+}
+
+func (p *celParser) parseUnary() (celNode, error) {
+	if t := p.peek(); t.kind == celOp && (t.text == "!" || t.text == "-") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &celUnary{t.text, x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *celParser) parsePostfix() (celNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != celOp || (t.text != "." && t.text != "[") {
+			return x, nil
+		}
+		p.next()
+		if t.text == "[" {
+			idx, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &celIndex{x, idx}
+			continue
+		}
+		name := p.next()
+		if name.kind != celIdent {
+			return nil, fmt.Errorf("expected field name after '.', got %q", name.text)
+		}
+		if nt := p.peek(); nt.kind == celOp && nt.text == "(" {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			x = &celCall{name.text, append([]celNode{x}, args...)}
+			continue
+		}
+		x = &celIndex{x, &celLit{name.text}}
+	}
+}
+
+func (p *celParser) parseArgs() ([]celNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []celNode
+	if t := p.peek(); t.kind == celOp && t.text == ")" {
+		p.next()
+		return args, nil
+	}
+	for {
+		a, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		t := p.next()
+		if t.kind == celOp && t.text == ")" {
+			return args, nil
+		}
+		if t.kind != celOp || t.text != "," {
+			return nil, fmt.Errorf("expected ',' or ')', got %q", t.text)
+		}
+	}
+}
+
+func (p *celParser) parsePrimary() (celNode, error) {
+	t := p.next()
+	switch t.kind {
+	case celString:
+		return &celLit{t.text}, nil
+	case celNumber:
+		if strings.Contains(t.text, ".") {
+			f, err := strconv.ParseFloat(t.text, 64)
+			if err != nil {
+				return nil, err
+			}
+			return &celLit{f}, nil
+		}
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &celLit{n}, nil
+	case celIdent:
+		switch t.text {
+		case "true":
+			return &celLit{true}, nil
+		case "false":
+			return &celLit{false}, nil
+		case "null":
+			return &celLit{nil}, nil
+		}
+		if nt := p.peek(); nt.kind == celOp && nt.text == "(" {
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &celCall{t.text, args}, nil
+		}
+		return &celIdentNode{t.text}, nil
+	case celOp:
+		if t.text == "(" {
+			x, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		}
+		if t.text == "[" {
+			var elems []celNode
+			if nt := p.peek(); nt.kind == celOp && nt.text == "]" {
+				p.next()
+				return &celList{elems}, nil
+			}
+			for {
+				e, err := p.parseExpr(0)
+				if err != nil {
+					return nil, err
+				}
+				elems = append(elems, e)
+				sep := p.next()
+				if sep.kind == celOp && sep.text == "]" {
+					return &celList{elems}, nil
+				}
+				if sep.kind != celOp || sep.text != "," {
+					return nil, fmt.Errorf("expected ',' or ']', got %q", sep.text)
+				}
+			}
+		}
+	case celEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+// ---------- checks ----------
+
+// celFuncs are the functions and methods celCall implements.
+var celFuncs = map[string]bool{
+	"size": true, "int": true, "contains": true, "startsWith": true, "endsWith": true,
+	"matches": true, "lowerAscii": true, "upperAscii": true, "trim": true,
+}
+
+// celCheck rejects names that could only fail at run time: identifiers
+// other than article, article fields that don't exist and unknown
+// functions.
+func celCheck(n celNode) error {
+	switch n := n.(type) {
+	case *celIdentNode:
+		if n.name != "article" {
+			return fmt.Errorf("undeclared reference %q (fields are article.<name>)", n.name)
+		}
+	case *celIndex:
+		if id, ok := n.x.(*celIdentNode); ok && id.name == "article" {
+			if lit, ok := n.idx.(*celLit); ok {
+				name, _ := lit.v.(string)
+				if articleFields[name] == nil && filterListFields[name] == nil {
+					return fmt.Errorf("unknown field article.%v", lit.v)
+				}
+				return nil
+			}
+		}
+		return errors.Join(celCheck(n.x), celCheck(n.idx))
+	case *celList:
+		for _, e := range n.elems {
+			if err := celCheck(e); err != nil {
+				return err
+			}
+		}
+	case *celUnary:
+		return celCheck(n.x)
+	case *celBinary:
+		return errors.Join(celCheck(n.l), celCheck(n.r))
+	case *celCond:
+		return errors.Join(celCheck(n.cond), celCheck(n.then), celCheck(n.els))
+	case *celCall:
+		if !celFuncs[n.fn] {
+			return fmt.Errorf("unknown function %q", n.fn)
+		}
+		for _, a := range n.args {
+			if err := celCheck(a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ---------- evaluation ----------
+
+type celNode interface {
+	eval(env map[string]any) (any, error)
+}
+
+type (
+	celLit       struct{ v any }
+	celIdentNode struct{ name string }
+	celList      struct{ elems []celNode }
+	celIndex     struct{ x, idx celNode }
+	celUnary     struct {
+		op string
+		x  celNode
+	}
+	celBinary struct {
+		op   string
+		l, r celNode
+	}
+	celCond struct{ cond, then, els celNode }
+	celCall struct {
+		fn   string
+		args []celNode
+	}
+)
+
+func (n *celLit) eval(map[string]any) (any, error) { return n.v, nil }
+
+func (n *celIdentNode) eval(env map[string]any) (any, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared reference %q", n.name)
+	}
+	return v, nil
+}
+
+func (n *celList) eval(env map[string]any) (any, error) {
+	out := make([]any, len(n.elems))
+	for i, e := range n.elems {
+		v, err := e.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (n *celIndex) eval(env map[string]any) (any, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := n.idx.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch c := x.(type) {
+	case map[string]any:
+		k, ok := idx.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be string")
+		}
+		v, ok := c[k]
+		if !ok {
+			return nil, fmt.Errorf("no such key %q", k)
+		}
+		return v, nil
+	case []any:
+		i, ok := idx.(int64)
+		if !ok || i < 0 || int(i) >= len(c) {
+			return nil, fmt.Errorf("index %v out of range", idx)
+		}
+		return c[i], nil
+	case string:
+		i, ok := idx.(int64)
+		if !ok || i < 0 || int(i) >= len(c) {
+			return nil, fmt.Errorf("index %v out of range", idx)
+		}
+		return string(c[i]), nil
+	}
+	return nil, fmt.Errorf("cannot index %T", x)
+}
+
+func (n *celUnary) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("'!' on %T", v)
+		}
+		return !b, nil
+	default:
+		switch x := v.(type) {
+		case int64:
+			return -x, nil
+		case float64:
+			return -x, nil
+		}
+		return nil, fmt.Errorf("'-' on %T", v)
+	}
+}
+
+func (n *celCond) eval(env map[string]any) (any, error) {
+	c, err := n.cond.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := c.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition is %T, want bool", c)
+	}
+	if b {
+		return n.then.eval(env)
+	}
+	return n.els.eval(env)
+}
+
+func (n *celBinary) eval(env map[string]any) (any, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	// short-circuit logical operators
+	if n.op == "&&" || n.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%q on %T", n.op, l)
+		}
+		if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+			return lb, nil
+		}
+		r, err := n.r.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%q on %T", n.op, r)
+		}
+		return rb, nil
+	}
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return celEqual(l, r), nil
+	case "!=":
+		return !celEqual(l, r), nil
+	case "in":
+		list, ok := r.([]any)
+		if !ok {
+			if s, ok := r.(string); ok {
+				ls, ok := l.(string)
+				return ok && strings.Contains(s, ls), nil
+			}
+			return nil, fmt.Errorf("'in' on %T", r)
+		}
+		for _, e := range list {
+			if celEqual(l, e) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "<", "<=", ">", ">=":
+		c, err := celCompare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	}
+	return celArith(n.op, l, r)
+}
+
+func celEqual(l, r any) bool {
+	if lf, lok := celNum(l); lok {
+		if rf, rok := celNum(r); rok {
+			return lf == rf
+		}
+	}
+	return l == r
+}
+
+func celNum(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+func celCompare(l, r any) (int, error) {
+	if ls, ok := l.(string); ok {
+		rs, ok := r.(string)
+		if !ok {
+			return 0, fmt.Errorf("cannot compare string with %T", r)
+		}
+		return strings.Compare(ls, rs), nil
+	}
+	lf, lok := celNum(l)
+	rf, rok := celNum(r)
+	if !lok || !rok {
+		return 0, fmt.Errorf("cannot compare %T with %T", l, r)
+	}
+	switch {
+	case lf < rf:
+		return -1, nil
+	case lf > rf:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func celArith(op string, l, r any) (any, error) {
+	if op == "+" {
+		if ls, ok := l.(string); ok {
+			rs, ok := r.(string)
+			if !ok {
+				return nil, fmt.Errorf("cannot add string and %T", r)
+			}
+			return ls + rs, nil
+		}
+	}
+	li, lint := l.(int64)
+	ri, rint := r.(int64)
+	if lint && rint {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/", "%":
+			if ri == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return li / ri, nil
+			}
+			return li % ri, nil
+		}
+	}
+	lf, lok := celNum(l)
+	rf, rok := celNum(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("%q on %T and %T", op, l, r)
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		return lf / rf, nil
+	}
+	return nil, fmt.Errorf("%q on floats", op)
+}
+
+func (n *celCall) eval(env map[string]any) (any, error) {
+	args := make([]any, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	argc := func(want int) error {
+		if len(args) != want {
+			return fmt.Errorf("%s: want %d argument(s), got %d", n.fn, want, len(args))
+		}
+		return nil
+	}
+	str := func(i int) (string, error) {
+		s, ok := args[i].(string)
+		if !ok {
+			return "", fmt.Errorf("%s: argument %d is %T, want string", n.fn, i, args[i])
+		}
+		return s, nil
+	}
+	switch n.fn {
+	case "size":
+		if err := argc(1); err != nil {
+			return nil, err
+		}
+		switch x := args[0].(type) {
+		case string:
+			return int64(len([]rune(x))), nil
+		case []any:
+			return int64(len(x)), nil
+		case map[string]any:
+			return int64(len(x)), nil
+		}
+		return nil, fmt.Errorf("size: unsupported %T", args[0])
+	case "int":
+		if err := argc(1); err != nil {
+			return nil, err
+		}
+		switch x := args[0].(type) {
+		case int64:
+			return x, nil
+		case float64:
+			return int64(x), nil
+		case string:
+			return strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+		}
+		return nil, fmt.Errorf("int: unsupported %T", args[0])
+	case "contains", "startsWith", "endsWith", "matches":
+		if err := argc(2); err != nil {
+			return nil, err
+		}
+		s, err := str(0)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := str(1)
+		if err != nil {
+			return nil, err
+		}
+		switch n.fn {
+		case "contains":
+			return strings.Contains(s, sub), nil
+		case "startsWith":
+			return strings.HasPrefix(s, sub), nil
+		case "endsWith":
+			return strings.HasSuffix(s, sub), nil
+		}
+		re, err := regexp.Compile(sub)
+		if err != nil {
+			return nil, fmt.Errorf("matches: %v", err)
+		}
+		return re.MatchString(s), nil
+	case "lowerAscii", "upperAscii", "trim":
+		if err := argc(1); err != nil {
+			return nil, err
+		}
+		s, err := str(0)
+		if err != nil {
+			return nil, err
+		}
+		switch n.fn {
+		case "lowerAscii":
+			return strings.ToLower(s), nil
+		case "upperAscii":
+			return strings.ToUpper(s), nil
+		}
+		return strings.TrimSpace(s), nil
+	}
+	return nil, fmt.Errorf("unknown function %q", n.fn)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilterMatch(t *testing.T) {
+	a := &Article{
+		Title:   `It's "here"`,
+		Content: "line one\nline two",
+		Link:    "https://example.com/a",
+		Author:  "Ann",
+
+		Tags:        termList{"go", "news"},
+		Collections: []int{7},
+	}
+	for _, tc := range []struct {
+		src  string
+		want bool
+	}{
+		// precedence
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 4 - 3 == 3", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"!false && true", true},
+		{"-2 * 3 == -6", true},
+		{"1 < 2 == true", true},
+		{"false ? 1 == 1 : true ? true : false", true},
+		{"1 in [1, 2] && !(3 in [1, 2])", true},
+		// string escapes
+		{`article.title == "It's \"here\""`, true},
+		{`article.title == 'It\'s "here"'`, true},
+		{`article.content.contains("\n")`, true},
+		{`"it\x27s" == "it\'s"`, true},
+		{`"café" == "café"`, true},
+		{`"a\\b".size() == 3`, true},
+		// methods and functions
+		{`article.title.startsWith("It")`, true},
+		{`article.link.endsWith("/a")`, true},
+		{`article.link.matches("^https://")`, true},
+		{`article.author.lowerAscii() == "ann"`, true},
+		{`article.author.upperAscii() == "ANN"`, true},
+		{`size(article.author) == 3 && article.author.size() == 3`, true},
+		{`"  x ".trim() == "x"`, true},
+		{`int("12") + 1 == 13`, true},
+		{`article.excerpt == "" && article["author"] == "Ann"`, true},
+		// list fields
+		{`article.tags.size() == 2 && "go" in article.tags`, true},
+		{`"draft" in article.tags`, false},
+		{`article.categories.size() == 0 && size(article["categories"]) == 0`, true},
+		{`7 in article.collections && !(8 in article.collections)`, true},
+		{`article.collections[0] == 7`, true},
+	} {
+		f, err := NewFilter(tc.src)
+		if err != nil {
+			t.Errorf("NewFilter(%s): %v", tc.src, err)
+			continue
+		}
+		got, err := f.Match(a)
+		if err != nil {
+			t.Errorf("%s: %v", tc.src, err)
+		} else if got != tc.want {
+			t.Errorf("%s = %v, want %v", tc.src, got, tc.want)
+		}
+	}
+}
+
+func TestNewFilterRejects(t *testing.T) {
+	for _, tc := range []struct{ src, err string }{
+		{"article.titel == 'x'", "article.titel"},
+		{"article['titel'] == 'x'", "article.titel"},
+		{"article.tag.size() == 0", "article.tag"},
+		{"size(article.contnet) > 0", "article.contnet"},
+		{"title == 'x'", `"title"`},
+		{"article.title.startswith('x')", `"startswith"`},
+		{"1 +", ""},
+		{"'unterminated", ""},
+		{"(1 == 1", ""},
+	} {
+		_, err := NewFilter(tc.src)
+		if err == nil {
+			t.Errorf("NewFilter(%s): want error", tc.src)
+		} else if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("NewFilter(%s): %v, want it to mention %s", tc.src, err, tc.err)
+		}
+	}
+}
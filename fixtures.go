@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+/* -------------------------------
+   Fixture corpora
+   (transform gen-fixtures)
+
+   Writes N article files shaped
+   like a real CMS export: titled,
+   dated, tagged HTML with
+   headings, lists, links and
+   images. The same -seed always
+   gives the same files, so a
+   benchmark or a regression run
+   can be repeated exactly.
+
+   -edge-rate of them carry one
+   edge case each, taken from
+   -edge-cases:
+
+   bad-date     unparseable date
+   future-date  dated 2099
+   huge         -huge-size content
+   empty        no title or text
+   broken-json  cut off mid-file
+   bom          UTF-8 BOM
+   utf16        UTF-16LE with BOM
+   latin1       Windows-1252 bytes
+   mojibake     "CafÃ©" damage
+   decomposed   e + U+0301
+   entities     &nbsp;, &#8217; …
+   tag-string   tags as "a, b"
+--------------------------------*/
+
+const fixturesUsage = `usage:
+  transform gen-fixtures -out DIR [-count N] [-size BYTES] [-seed N]
+                         [-edge-rate F] [-edge-cases LIST] [-huge-size BYTES]`
+
+var fixtureEdgeCases = []string{
+	"bad-date", "future-date", "huge", "empty", "broken-json", "bom",
+	"utf16", "latin1", "mojibake", "decomposed", "entities", "tag-string",
+}
+
+var fixtureWords = strings.Fields(`
+	archive board budget city council county data district editor election
+	energy festival funding harbor health housing library market museum
+	neighborhood officials park plan police policy project public rail
+	region report residents river school season service station street
+	study survey teachers traffic transit union university vote water
+	weather workers year announced approved delayed expanded opened
+	proposed reported reviewed said scheduled voted early final local
+	major new quarterly regional annual community historic late`)
+
+var fixtureAuthors = []string{
+	"Ana Lima", "Ben Okafor", "Chen Wei", "Dana Novak", "Eli Brooks",
+	"Fatima Zahra", "Greta Lind", "Hiro Tanaka", "Ines Duarte", "Jonas Berg",
+}
+
+var fixtureTags = []string{
+	"Politics", "Transit", "Education", "Health", "Housing", "Culture",
+	"Weather", "Business", "Sports", "Environment", "Local", "Opinion",
+}
+
+// fixtureArticle is the JSON written for one fixture.
+type fixtureArticle struct {
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+	Excerpt     string `json:"excerpt,omitempty"`
+	Link        string `json:"link"`
+	PublishDate string `json:"published_date"`
+	UpdatedDate string `json:"updated_date,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Image       string `json:"image,omitempty"`
+	Tags        any    `json:"tags,omitempty"`
+	Categories  any    `json:"categories,omitempty"`
+}
+
+type fixtureGen struct {
+	rng      *rand.Rand
+	size     int64
+	hugeSize int64
+}
+
+// fixturesMain implements "transform gen-fixtures"; it returns the exit code.
+func fixturesMain(args []string) int {
+	fs := flag.NewFlagSet("gen-fixtures", flag.ContinueOnError)
+	out := fs.String("out", "", "Write the files into this directory")
+	count := fs.Int("count", 100, "Number of files")
+	size := fs.String("size", "4KB", "Typical content size; each file varies from a quarter to twice this")
+	seed := fs.Uint64("seed", 1, "Random seed; the same seed gives the same files")
+	edgeRate := fs.Float64("edge-rate", 0.1, "Fraction of files with an edge case, 0 to 1")
+	edgeCases := fs.String("edge-cases", "all", "Comma-separated edge cases to draw from: "+strings.Join(fixtureEdgeCases, ", "))
+	hugeSize := fs.String("huge-size", "5MB", "Content size of the huge edge case")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *out == "" || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, fixturesUsage)
+		return exitSetup
+	}
+	if *count < 1 {
+		fmt.Fprintln(os.Stderr, "count: want at least 1")
+		return exitSetup
+	}
+	if *edgeRate < 0 || *edgeRate > 1 {
+		fmt.Fprintln(os.Stderr, "edge-rate: want a fraction between 0 and 1")
+		return exitSetup
+	}
+	g := &fixtureGen{rng: rand.New(rand.NewPCG(*seed, *seed^0x9e3779b97f4a7c15))}
+	var err error
+	if g.size, err = parseSize(*size); err != nil || g.size == 0 {
+		fmt.Fprintf(os.Stderr, "size %q: want a byte count such as 512, 64K or 1.5MB\n", *size)
+		return exitSetup
+	}
+	if g.hugeSize, err = parseSize(*hugeSize); err != nil || g.hugeSize == 0 {
+		fmt.Fprintf(os.Stderr, "huge-size %q: want a byte count such as 512, 64K or 1.5MB\n", *hugeSize)
+		return exitSetup
+	}
+	cases := fixtureEdgeCases
+	if *edgeCases != "all" {
+		cases = nil
+		for _, c := range strings.Split(*edgeCases, ",") {
+			c = strings.TrimSpace(c)
+			if c == "" {
+				continue
+			}
+			if !slices.Contains(fixtureEdgeCases, c) {
+				fmt.Fprintf(os.Stderr, "edge-cases %q: want %s\n", c, strings.Join(fixtureEdgeCases, ", "))
+				return exitSetup
+			}
+			cases = append(cases, c)
+		}
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+
+	width := len(fmt.Sprint(*count))
+	byKind := make(map[string]int)
+	var total int64
+	for i := 1; i <= *count; i++ {
+		kind := "clean"
+		if len(cases) > 0 && g.rng.Float64() < *edgeRate {
+			kind = cases[g.rng.IntN(len(cases))]
+		}
+		b, err := g.file(i, kind)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitSetup
+		}
+		name := filepath.Join(*out, fmt.Sprintf("article-%0*d.json", width, i))
+		if err := os.WriteFile(name, b, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitSetup
+		}
+		byKind[kind]++
+		total += int64(len(b))
+	}
+	fmt.Printf("Wrote %d files (%s) to %s: %s\n", *count, formatSize(total), *out, kindBreakdown(byKind))
+	return exitOK
+}
+
+// file renders fixture i with edge case kind ("clean" for none).
+func (g *fixtureGen) file(i int, kind string) ([]byte, error) {
+	a := g.article(i)
+	switch kind {
+	case "bad-date":
+		a.PublishDate = []string{"31/02/2019", "yesterday", "0000-00-00", "2019-13-45T25:61:00Z", "Q3 2018"}[g.rng.IntN(5)]
+	case "future-date":
+		a.PublishDate = fmt.Sprintf("2099-%02d-%02dT09:00:00Z", 1+g.rng.IntN(12), 1+g.rng.IntN(28))
+	case "huge":
+		a.Content = g.content(g.hugeSize)
+	case "empty":
+		a.Title, a.Content, a.Excerpt = "", "", ""
+	case "mojibake":
+		a.Title = strings.Replace(a.Title, " ", " CafÃ© ", 1)
+		a.Content = "<p>Itâ€™s the rÃ©sumÃ© of a naÃ¯ve approach.</p>\n" + a.Content
+	case "decomposed":
+		a.Title = "Cafe\u0301 " + a.Title
+		a.Content = "<p>Re\u0301sume\u0301 of the nai\u0308ve plan.</p>\n" + a.Content
+	case "entities":
+		a.Title = "Council&#8217;s plan &amp; the &quot;budget&quot;"
+		a.Content = "<p>Fees&nbsp;rise &mdash; again &hellip;</p>\n" + a.Content
+	case "tag-string":
+		a.Tags = strings.Join(a.Tags.([]string), ", ")
+	case "latin1":
+		a.Title = "Café société: " + a.Title
+		a.Content = "<p>Crème brûlée à la carte, 5 € each.</p>\n" + a.Content
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(a); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	switch kind {
+	case "broken-json":
+		b = b[:len(b)/2]
+	case "bom":
+		b = append([]byte("\xEF\xBB\xBF"), b...)
+	case "utf16":
+		units := utf16.Encode([]rune(string(b)))
+		out := make([]byte, 2, 2+2*len(units))
+		out[0], out[1] = 0xFF, 0xFE
+		for _, u := range units {
+			out = append(out, byte(u), byte(u>>8))
+		}
+		b = out
+	case "latin1":
+		b = to1252(b)
+	}
+	return b, nil
+}
+
+// article makes a clean article.
+func (g *fixtureGen) article(i int) fixtureArticle {
+	published := time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC).
+		Add(time.Duration(g.rng.Int64N(int64(14 * 365 * 24 * time.Hour)))).Truncate(time.Minute)
+	title := g.sentence(4 + g.rng.IntN(7))
+	title = strings.TrimSuffix(title, ".")
+	slug := strings.ToLower(strings.Join(strings.Fields(title), "-"))
+	size := g.size/4 + g.rng.Int64N(g.size*7/4+1)
+	a := fixtureArticle{
+		Title:       title,
+		Content:     g.content(size),
+		Excerpt:     g.sentence(12 + g.rng.IntN(12)),
+		Link:        fmt.Sprintf("https://news.example.com/%d/%02d/%s-%d", published.Year(), published.Month(), slug, i),
+		PublishDate: published.Format(time.RFC3339),
+		Author:      fixtureAuthors[g.rng.IntN(len(fixtureAuthors))],
+		Tags:        g.pick(fixtureTags, 1+g.rng.IntN(3)),
+		Categories:  g.pick(fixtureTags[:6], 1),
+	}
+	if g.rng.IntN(3) == 0 {
+		a.UpdatedDate = published.Add(time.Duration(1+g.rng.IntN(90*24)) * time.Hour).Format(time.RFC3339)
+	}
+	if g.rng.IntN(2) == 0 {
+		a.Image = fmt.Sprintf("https://cdn.example.com/images/%d/%s.jpg", published.Year(), slug)
+	}
+	return a
+}
+
+// content makes roughly size bytes of article HTML.
+func (g *fixtureGen) content(size int64) string {
+	var b strings.Builder
+	for int64(b.Len()) < size {
+		switch n := g.rng.IntN(20); {
+		case n == 0:
+			fmt.Fprintf(&b, "<h2>%s</h2>\n", strings.TrimSuffix(g.sentence(3+g.rng.IntN(4)), "."))
+		case n == 1:
+			b.WriteString("<ul>\n")
+			for range 2 + g.rng.IntN(4) {
+				fmt.Fprintf(&b, "  <li>%s</li>\n", g.sentence(4+g.rng.IntN(6)))
+			}
+			b.WriteString("</ul>\n")
+		case n == 2:
+			fmt.Fprintf(&b, "<figure><img src=\"https://cdn.example.com/images/%d.jpg\" alt=\"%s\"><figcaption>%s</figcaption></figure>\n",
+				g.rng.IntN(1e6), strings.TrimSuffix(g.sentence(3), "."), g.sentence(6))
+		case n == 3:
+			fmt.Fprintf(&b, "<blockquote><p>%s</p></blockquote>\n", g.sentence(10+g.rng.IntN(10)))
+		default:
+			b.WriteString("<p>")
+			for s := range 2 + g.rng.IntN(5) {
+				if s > 0 {
+					b.WriteByte(' ')
+				}
+				if g.rng.IntN(8) == 0 {
+					fmt.Fprintf(&b, "See <a href=\"https://news.example.com/related/%d\">%s</a>.", g.rng.IntN(1e5), strings.TrimSuffix(g.sentence(3), "."))
+					continue
+				}
+				b.WriteString(g.sentence(8 + g.rng.IntN(14)))
+			}
+			b.WriteString("</p>\n")
+		}
+	}
+	return b.String()
+}
+
+func (g *fixtureGen) sentence(words int) string {
+	w := make([]string, words)
+	for i := range w {
+		w[i] = fixtureWords[g.rng.IntN(len(fixtureWords))]
+	}
+	w[0] = strings.ToUpper(w[0][:1]) + w[0][1:]
+	return strings.Join(w, " ") + "."
+}
+
+// pick returns n distinct entries of from, in a stable order.
+func (g *fixtureGen) pick(from []string, n int) []string {
+	idx := g.rng.Perm(len(from))[:n]
+	sort.Ints(idx)
+	out := make([]string, n)
+	for i, j := range idx {
+		out[i] = from[j]
+	}
+	return out
+}
+
+// to1252 re-encodes UTF-8 text as Windows-1252, for the latin1 case.
+func to1252(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, r := range string(b) {
+		c, ok := encode1252(r)
+		if !ok {
+			c = '?'
+		}
+		out = append(out, c)
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+)
+
+/* -------------------------------
+   Heading normalization
+   (-heading-base, -heading-mode)
+
+   The tool emits its own <h1> for the
+   title, so content headings should
+   start one level below it.
+
+   shift – move every heading by the
+           same offset so the
+           highest one lands on base
+           (keeps the outline shape)
+   clamp – only push headings above
+           base down to base
+
+   Levels are capped at h6.
+--------------------------------*/
+
+type HeadingNormalizer struct {
+	base  int
+	shift bool
+}
+
+func NewHeadingNormalizer(base int, mode string) (*HeadingNormalizer, error) {
+	if base < 1 || base > 6 {
+		return nil, fmt.Errorf("heading-base %d: want 1–6", base)
+	}
+	switch mode {
+	case "shift":
+		return &HeadingNormalizer{base: base, shift: true}, nil
+	case "clamp":
+		return &HeadingNormalizer{base: base}, nil
+	}
+	return nil, fmt.Errorf("heading-mode %q: want shift or clamp", mode)
+}
+
+func (n *HeadingNormalizer) Apply(_ *renderDoc, toks []htmlToken) []htmlToken {
+	top := 7
+	for i := range toks {
+		if l := headingLevel(&toks[i]); l > 0 && l < top {
+			top = l
+		}
+	}
+	if top == 7 {
+		return toks
+	}
+	for i := range toks {
+		t := &toks[i]
+		l := headingLevel(t)
+		if l == 0 {
+			continue
+		}
+		nl := l
+		if n.shift {
+			nl = l + n.base - top
+		} else if l < n.base {
+			nl = n.base
+		}
+		nl = min(max(nl, 1), 6)
+		if nl != l {
+			t.data = fmt.Sprintf("h%d", nl)
+			t.name = ""
+			t.raw = ""
+		}
+	}
+	return toks
+}
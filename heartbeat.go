@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+/* -------------------------------
+   Heartbeat (-heartbeat)
+
+   Without a terminal – CI logs,
+   -log-file, systemd – a progress
+   line is logged every -heartbeat
+   (default 1m) while a batch runs:
+
+   PROGRESS 5230/12000 (43.6%)  6.4/s  failures 30  ETA 17m38s
+
+   so long runs show they are
+   alive between failures.
+--------------------------------*/
+
+// logIsTerminal reports whether the log goes to an interactive terminal.
+func logIsTerminal() bool {
+	if log.Writer() != os.Stderr {
+		return false
+	}
+	st, err := os.Stderr.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// heartbeat logs p's progress every interval until stopped.
+func (p *Progress) heartbeat(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+	return every(interval, p.logHeartbeat)
+}
+
+func (p *Progress) logHeartbeat() {
+	s := p.Snapshot()
+	pct := 0.0
+	if s.Total > 0 {
+		pct = 100 * float64(s.Done) / float64(s.Total)
+	}
+	log.Printf("PROGRESS %d/%d (%.1f%%)  %.1f/s  failures %d  ETA %s", s.Done, s.Total, pct, s.RecentRate, s.Failure, s.eta())
+}
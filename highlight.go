@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+/* -------------------------------
+   Syntax highlighting (-highlight)
+
+   Finds <pre><code class="language-x">
+   (or lang-x) blocks and wraps
+   keywords, strings, comments and
+   numbers in spans:
+
+   classes – <span class="hl-kw">
+   inline  – <span style="color:…">
+             for renderers without a
+             stylesheet
+
+   The lexer is deliberately generic
+   (one table per language family);
+   unknown languages are left as-is.
+--------------------------------*/
+
+type langSpec struct {
+	lineComments  []string
+	blockComments [][2]string
+	quotes        string
+	keywords      map[string]bool
+}
+
+func words(s string) map[string]bool {
+	m := map[string]bool{}
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var cLike = [][2]string{{"/*", "*/"}}
+
+var langs = map[string]*langSpec{
+	"go": {[]string{"//"}, cLike, "\"'`", words(`break case chan const continue default defer else fallthrough for func go goto if
+		import interface map package range return select struct switch type var nil true false iota`)},
+	"javascript": {[]string{"//"}, cLike, "\"'`", words(`async await break case catch class const continue debugger default delete do
+		else export extends finally for function if import in instanceof let new null of return super switch this throw
+		true false try typeof undefined var void while with yield`)},
+	"typescript": {[]string{"//"}, cLike, "\"'`", words(`abstract any as async await boolean break case catch class const continue
+		declare default do else enum export extends false finally for from function if implements import in interface
+		let new null number private protected public readonly return string super switch this throw true try type
+		typeof undefined var void while`)},
+	"java": {[]string{"//"}, cLike, "\"'", words(`abstract boolean break byte case catch char class const continue default do double
+		else enum extends final finally float for if implements import instanceof int interface long new null package
+		private protected public return short static super switch this throw throws true false try void while var`)},
+	"c": {[]string{"//"}, cLike, "\"'", words(`auto break case char const continue default do double else enum extern float for goto if
+		int long register return short signed sizeof static struct switch typedef union unsigned void volatile while
+		NULL true false class namespace template typename public private protected virtual new delete nullptr`)},
+	"rust": {[]string{"//"}, cLike, "\"", words(`as async await break const continue crate dyn else enum extern false fn for if impl in let
+		loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while`)},
+	"python": {[]string{"#"}, nil, "\"'", words(`and as assert async await break class continue def del elif else except False finally for
+		from global if import in is lambda None nonlocal not or pass raise return True try while with yield self`)},
+	"ruby": {[]string{"#"}, nil, "\"'", words(`alias and begin break case class def defined do else elsif end ensure false for if in module
+		next nil not or redo rescue retry return self super then true undef unless until when while yield`)},
+	"bash": {[]string{"#"}, nil, "\"'", words(`if then else elif fi case esac for while until do done in function return local export
+		echo exit set unset readonly shift`)},
+	"sql": {[]string{"--"}, cLike, "'\"", words(`select from where and or not insert into values update set delete create table drop
+		alter index join left right inner outer on group by order having limit offset as distinct null is in like
+		between union all primary key foreign references default case when then else end
+		SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER INDEX JOIN LEFT
+		RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT OFFSET AS DISTINCT NULL IS IN LIKE BETWEEN UNION ALL
+		PRIMARY KEY FOREIGN REFERENCES DEFAULT CASE WHEN THEN ELSE END`)},
+	"json": {nil, nil, "\"", words(`true false null`)},
+	"yaml": {[]string{"#"}, nil, "\"'", words(`true false null yes no on off`)},
+	"css":  {nil, cLike, "\"'", words(`important inherit initial unset none auto`)},
+}
+
+var langAliases = map[string]string{
+	"golang": "go", "js": "javascript", "jsx": "javascript", "ts": "typescript", "tsx": "typescript",
+	"cpp": "c", "c++": "c", "h": "c", "csharp": "c", "cs": "c", "kotlin": "java", "scala": "java",
+	"py": "python", "python3": "python", "rb": "ruby", "sh": "bash", "shell": "bash", "zsh": "bash",
+	"console": "bash", "rs": "rust", "yml": "yaml", "postgresql": "sql", "mysql": "sql", "scss": "css",
+}
+
+var hlColors = map[string]string{
+	"hl-kw":  "color:#d73a49;font-weight:bold",
+	"hl-str": "color:#032f62",
+	"hl-com": "color:#6a737d;font-style:italic",
+	"hl-num": "color:#005cc5",
+}
+
+type Highlighter struct {
+	inline bool
+}
+
+func NewHighlighter(mode string) (*Highlighter, error) {
+	switch mode {
+	case "classes":
+		return &Highlighter{}, nil
+	case "inline":
+		return &Highlighter{inline: true}, nil
+	}
+	return nil, fmt.Errorf("highlight %q: want classes or inline", mode)
+}
+
+func (h *Highlighter) Apply(_ *renderDoc, toks []htmlToken) []htmlToken {
+	out := make([]htmlToken, 0, len(toks))
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		out = append(out, t)
+		if t.kind != htmlStartTag || t.data != "code" || !insidePre(out[:len(out)-1]) {
+			continue
+		}
+		spec := langOf(&t)
+		end := elementEnd(toks, i)
+		if spec == nil || end == i || !plainText(toks[i+1:end]) {
+			continue
+		}
+		src := textContent(toks[i+1 : end])
+		out = append(out, htmlToken{kind: htmlText, data: h.highlight(spec, src)})
+		i = end - 1
+	}
+	return out
+}
+
+// insidePre reports whether the last tag emitted so far is an open <pre>.
+func insidePre(out []htmlToken) bool {
+	for j := len(out) - 1; j >= 0; j-- {
+		switch out[j].kind {
+		case htmlText:
+			if strings.TrimSpace(out[j].data) != "" {
+				return false
+			}
+		case htmlStartTag:
+			return out[j].data == "pre"
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func plainText(toks []htmlToken) bool {
+	for _, t := range toks {
+		if t.kind != htmlText {
+			return false
+		}
+	}
+	return true
+}
+
+func langOf(t *htmlToken) *langSpec {
+	class, _ := t.attr("class")
+	for _, c := range strings.Fields(strings.ToLower(class)) {
+		name, ok := strings.CutPrefix(c, "language-")
+		if !ok {
+			name, ok = strings.CutPrefix(c, "lang-")
+		}
+		if !ok {
+			continue
+		}
+		if alias, ok := langAliases[name]; ok {
+			name = alias
+		}
+		return langs[name]
+	}
+	return nil
+}
+
+func (h *Highlighter) span(b *strings.Builder, class, text string) {
+	if h.inline {
+		fmt.Fprintf(b, `<span style="%s">`, hlColors[class])
+	} else {
+		fmt.Fprintf(b, `<span class="%s">`, class)
+	}
+	b.WriteString(html.EscapeString(text))
+	b.WriteString("</span>")
+}
+
+func (h *Highlighter) highlight(spec *langSpec, src string) string {
+	var b strings.Builder
+	plain := 0 // start of pending unhighlighted text
+	flush := func(i int) {
+		b.WriteString(html.EscapeString(src[plain:i]))
+	}
+	i := 0
+	for i < len(src) {
+		if n := matchComment(spec, src[i:]); n > 0 {
+			flush(i)
+			h.span(&b, "hl-com", src[i:i+n])
+			i += n
+			plain = i
+			continue
+		}
+		c := src[i]
+		if strings.IndexByte(spec.quotes, c) >= 0 {
+			j := i + 1
+			for j < len(src) && src[j] != c && !(src[j] == '\n' && c != '`') {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(src) && src[j] == c {
+				j++
+			}
+			if j > len(src) {
+				j = len(src)
+			}
+			flush(i)
+			h.span(&b, "hl-str", src[i:j])
+			i = j
+			plain = i
+			continue
+		}
+		r := rune(c)
+		if unicode.IsDigit(r) && (i == 0 || !isIdentByte(src[i-1])) {
+			j := i
+			for j < len(src) && (isIdentByte(src[j]) || src[j] == '.') {
+				j++
+			}
+			flush(i)
+			h.span(&b, "hl-num", src[i:j])
+			i = j
+			plain = i
+			continue
+		}
+		if isIdentByte(c) && (i == 0 || !isIdentByte(src[i-1])) {
+			j := i
+			for j < len(src) && isIdentByte(src[j]) {
+				j++
+			}
+			if spec.keywords[src[i:j]] {
+				flush(i)
+				h.span(&b, "hl-kw", src[i:j])
+				plain = j
+			}
+			i = j
+			continue
+		}
+		i++
+	}
+	flush(len(src))
+	return b.String()
+}
+
+func matchComment(spec *langSpec, s string) int {
+	for _, lc := range spec.lineComments {
+		if strings.HasPrefix(s, lc) {
+			if n := strings.IndexByte(s, '\n'); n >= 0 {
+				return n
+			}
+			return len(s)
+		}
+	}
+	for _, bc := range spec.blockComments {
+		if strings.HasPrefix(s, bc[0]) {
+			if n := strings.Index(s[len(bc[0]):], bc[1]); n >= 0 {
+				return len(bc[0]) + n + len(bc[1])
+			}
+			return len(s)
+		}
+	}
+	return 0
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 0x80 || isASCIILetter(c) || (c >= '0' && c <= '9')
+}
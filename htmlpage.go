@@ -0,0 +1,503 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+/* -------------------------------
+   Saved web pages (.html, .htm)
+
+   Each page is one article. The
+   title, link, dates, author,
+   excerpt, image and tags come
+   from the page's JSON-LD Article,
+   then its Open Graph and <meta>
+   tags, then <title>, <link
+   rel=canonical> and <time>.
+
+   The content is the articleBody,
+   <article> or <main> element, if
+   one holds most of the text; else
+   the element whose paragraphs
+   score highest, as Readability
+   does. Scripts, styles and page
+   chrome (see -readability) are
+   dropped. Images saved next to
+   the page are inlined as data:
+   URIs; other relative links are
+   made absolute against the page's
+   URL, if it has one.
+
+   Web archives (webarchive.go)
+   extract their pages the same
+   way, with images from the
+   archive.
+--------------------------------*/
+
+const pageImageMax = 16 << 20 // bytes of one saved image inlined
+
+// pageSkipElems never hold article text.
+var pageSkipElems = []string{"head", "script", "style", "noscript", "template", "link", "meta"}
+
+// pageTextElems are scored for the text they hold.
+var pageTextElems = []string{"p", "pre", "blockquote", "td"}
+
+// pageBlockElems end a <p> left unclosed.
+var pageBlockElems = map[string]bool{
+	"p": true, "div": true, "section": true, "article": true, "main": true, "table": true, "ul": true, "ol": true,
+	"blockquote": true, "pre": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"td": true, "li": true, "body": true,
+}
+
+// isHTMLFile reports whether file is read as a saved web page.
+func isHTMLFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+// pageImages inlines an image a page refers to, as written in the page,
+// given the page's URL (nil if unknown).
+type pageImages func(ref, base *url.URL) (string, bool)
+
+// decodeHTMLPage extracts the article from a page fetched from pageURL
+// ("" if unknown), inlining the images that images has. article reports
+// whether the page says it is an article, by og:type or JSON-LD.
+func decodeHTMLPage(raw []byte, pageURL string, images pageImages) (a Article, article bool, err error) {
+	toks := tokenizeHTML(string(raw))
+	meta := pageMeta(toks)
+	head := readPageHead(toks)
+	ld := head.ld
+	article = head.ldFound || strings.EqualFold(meta["og:type"], "article")
+	content := (&Boilerplate{heuristics: true}).Apply(nil, pageContent(toks))
+	if emptyContent(renderHTML(content)) {
+		return Article{}, article, errors.New("html: no article text found")
+	}
+
+	a.Link = cmp.Or(ld.url, head.canonical, meta["og:url"], pageURL)
+	base, _ := url.Parse(cmp.Or(pageURL, a.Link))
+	if head.base != "" && base != nil {
+		if b, err := base.Parse(head.base); err == nil {
+			base = b
+		}
+	}
+	if base != nil && !base.IsAbs() {
+		base = nil
+	}
+
+	a.Title = cmp.Or(ld.headline, meta["og:title"], meta["twitter:title"], pageHeading(content, head.title))
+	content = dropTitleHeading(content, a.Title)
+	a.PublishDate = cmp.Or(ld.published, meta["article:published_time"], meta["date"], meta["pubdate"],
+		meta["dc.date"], meta["dcterms.created"], pageTime(content))
+	a.UpdatedDate = cmp.Or(ld.modified, meta["article:modified_time"], meta["og:updated_time"], meta["dcterms.modified"])
+	a.Excerpt = cmp.Or(ld.description, meta["og:description"], meta["description"])
+	a.Author = ld.author
+	for _, author := range []string{meta["article:author"], meta["author"], meta["dc.creator"]} {
+		if a.Author == "" && author != "" && !checkable(author) {
+			a.Author = author
+		}
+	}
+	if img := cmp.Or(ld.image, meta["og:image"], meta["twitter:image"]); img != "" {
+		if u, err := url.Parse(img); err == nil && base != nil {
+			img = base.ResolveReference(u).String()
+		}
+		if checkable(img) {
+			a.Image = img
+		}
+	}
+	a.Tags = ld.keywords
+	if len(a.Tags) == 0 {
+		a.Tags = termsFromJSON(toAnySlice(head.tags))
+	}
+	if len(a.Tags) == 0 && meta["keywords"] != "" {
+		a.Tags = termsFromJSON(meta["keywords"])
+	}
+
+	rewritePageRefs(content, base, images)
+	a.Content = strings.TrimSpace(renderHTML(content))
+	return a, article, nil
+}
+
+/* ---------- head ---------- */
+
+type pageHead struct {
+	title     string   // <title>
+	base      string   // <base href>
+	canonical string   // <link rel=canonical>
+	tags      []string // article:tag, one <meta> each
+	ld        ldArticle
+	ldFound   bool
+}
+
+// ldArticle is the schema.org Article a page describes in JSON-LD.
+type ldArticle struct {
+	headline, description, url  string
+	published, modified, author string
+	image                       string
+	keywords                    termList
+}
+
+// ldArticleTypes are the schema.org types read as the page's article.
+var ldArticleTypes = []string{
+	"Article", "NewsArticle", "BlogPosting", "Report", "ScholarlyArticle", "TechArticle",
+	"OpinionNewsArticle", "AnalysisNewsArticle", "ReportageNewsArticle", "LiveBlogPosting",
+}
+
+func readPageHead(toks []htmlToken) pageHead {
+	var h pageHead
+	for i := range toks {
+		t := &toks[i]
+		switch {
+		case t.isTag("title") && h.title == "" && i+1 < len(toks) && toks[i+1].kind == htmlText:
+			h.title = strings.Join(strings.Fields(textContent(toks[i+1:i+2])), " ")
+		case t.isTag("base") && h.base == "":
+			h.base, _ = t.attr("href")
+		case t.isTag("link") && h.canonical == "":
+			if rel, _ := t.attr("rel"); strings.EqualFold(strings.TrimSpace(rel), "canonical") {
+				h.canonical, _ = t.attr("href")
+			}
+		case t.isTag("meta"):
+			if p, _ := t.attr("property"); strings.EqualFold(p, "article:tag") {
+				if c, _ := t.attr("content"); strings.TrimSpace(c) != "" {
+					h.tags = append(h.tags, strings.TrimSpace(c))
+				}
+			}
+		case t.isTag("script") && !h.ldFound && i+1 < len(toks) && toks[i+1].kind == htmlText:
+			if typ, _ := t.attr("type"); strings.EqualFold(strings.TrimSpace(typ), "application/ld+json") {
+				var v any
+				if json.Unmarshal([]byte(toks[i+1].data), &v) == nil {
+					if obj := findLDArticle(v); obj != nil {
+						h.ld, h.ldFound = readLDArticle(obj), true
+					}
+				}
+			}
+		}
+	}
+	return h
+}
+
+// findLDArticle returns the first object of an article type in a JSON-LD
+// document, looking through arrays, @graph and nested objects.
+func findLDArticle(v any) map[string]any {
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			if obj := findLDArticle(e); obj != nil {
+				return obj
+			}
+		}
+	case map[string]any:
+		types := termsFromJSON(v["@type"])
+		for _, t := range types {
+			if slices.Contains(ldArticleTypes, t) {
+				return v
+			}
+		}
+		for _, k := range []string{"@graph", "mainEntity", "mainEntityOfPage"} {
+			if obj := findLDArticle(v[k]); obj != nil {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+func readLDArticle(obj map[string]any) ldArticle {
+	text := func(key string) string {
+		s, _ := obj[key].(string)
+		return strings.TrimSpace(s)
+	}
+	a := ldArticle{
+		headline:    cmp.Or(text("headline"), text("name")),
+		description: text("description"),
+		url:         text("url"),
+		published:   text("datePublished"),
+		modified:    text("dateModified"),
+		keywords:    termsFromJSON(obj["keywords"]),
+	}
+	a.author = strings.Join(termsFromJSON(obj["author"]), ", ")
+	switch img := obj["image"].(type) {
+	case string:
+		a.image = img
+	case map[string]any:
+		a.image, _ = img["url"].(string)
+	case []any:
+		if len(img) > 0 {
+			a.image, _ = img[0].(string)
+			if m, ok := img[0].(map[string]any); ok {
+				a.image, _ = m["url"].(string)
+			}
+		}
+	}
+	return a
+}
+
+/* ---------- content ---------- */
+
+// pageContent returns the markup of the page's main text.
+func pageContent(toks []htmlToken) []htmlToken {
+	body := pageBody(toks)
+	total := len(strings.TrimSpace(textContent(body)))
+
+	// a semantic element, if it holds most of the page's text
+	best, bestLen := -1, 0
+	for i := range body {
+		t := &body[i]
+		if t.kind != htmlStartTag {
+			continue
+		}
+		role, _ := t.attr("role")
+		prop, _ := t.attr("itemprop")
+		if !t.isTag("article", "main") && role != "main" && prop != "articleBody" {
+			continue
+		}
+		end := elementEnd(body, i)
+		if n := len(strings.TrimSpace(textContent(body[i : end+1]))); n > bestLen {
+			best, bestLen = i, n
+		}
+	}
+	if best >= 0 && bestLen*2 >= total {
+		return body[best+1 : elementEnd(body, best)]
+	}
+
+	// else the container whose paragraphs score highest
+	scores := map[int]float64{}
+	var open []int
+	for i := range body {
+		t := &body[i]
+		switch t.kind {
+		case htmlStartTag:
+			if voidElems[t.data] {
+				continue
+			}
+			if n := len(open); n > 0 && t.data == "p" && body[open[n-1]].data == "p" {
+				open = open[:n-1] // <p> closes an unclosed <p>
+			}
+			if t.isTag(pageTextElems...) && len(open) > 0 {
+				text := strings.TrimSpace(textContent(body[i : paragraphEnd(body, i)+1]))
+				if len(text) >= 25 {
+					score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+					scores[open[len(open)-1]] += score
+					if len(open) > 1 {
+						scores[open[len(open)-2]] += score / 2
+					}
+				}
+			}
+			open = append(open, i)
+		case htmlEndTag:
+			for j := len(open) - 1; j >= 0; j-- {
+				if body[open[j]].data == t.data {
+					open = open[:j]
+					break
+				}
+			}
+		}
+	}
+	best = -1
+	var bestScore float64
+	for i, score := range scores {
+		end := elementEnd(body, i)
+		score *= 1 - linkDensity(body[i:end+1])
+		if score > bestScore || (score == bestScore && i < best) {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return body
+	}
+	return body[best+1 : elementEnd(body, best)]
+}
+
+// pageBody returns the tokens inside <body>, without scripts, styles and
+// comments.
+func pageBody(toks []htmlToken) []htmlToken {
+	for i := range toks {
+		if toks[i].isTag("body") {
+			toks = toks[i+1:]
+			break
+		}
+	}
+	out := make([]htmlToken, 0, len(toks))
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch {
+		case t.kind == htmlComment || t.kind == htmlDoctype:
+			continue
+		case t.isTag(pageSkipElems...):
+			i = elementEnd(toks, i)
+			continue
+		case t.kind == htmlEndTag && (t.data == "body" || t.data == "html"):
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// paragraphEnd is elementEnd, except that a <p> left unclosed ends where
+// the next block starts or its parent ends.
+func paragraphEnd(toks []htmlToken, i int) int {
+	if end := elementEnd(toks, i); end > i || toks[i].data != "p" {
+		return end
+	}
+	for j := i + 1; j < len(toks); j++ {
+		t := &toks[j]
+		if (t.kind == htmlStartTag && pageBlockElems[t.data]) || (t.kind == htmlEndTag && pageBlockElems[t.data]) {
+			return j - 1
+		}
+	}
+	return len(toks) - 1
+}
+
+// linkDensity is the share of toks' text that is link text.
+func linkDensity(toks []htmlToken) float64 {
+	total := len(strings.TrimSpace(textContent(toks)))
+	if total == 0 {
+		return 0
+	}
+	links := 0
+	for i := 0; i < len(toks); i++ {
+		if toks[i].isTag("a") {
+			end := elementEnd(toks, i)
+			links += len(strings.TrimSpace(textContent(toks[i : end+1])))
+			i = end
+		}
+	}
+	return min(float64(links)/float64(total), 1)
+}
+
+// pageHeading picks the title when the page has no metadata for it: the
+// first <h1>, if <title> contains it ("Headline | Site"), else <title>.
+func pageHeading(content []htmlToken, title string) string {
+	for i := range content {
+		if content[i].isTag("h1") {
+			h1 := strings.Join(strings.Fields(textContent(content[i:elementEnd(content, i)+1])), " ")
+			if h1 != "" && (title == "" || strings.Contains(title, h1)) {
+				return h1
+			}
+			break
+		}
+	}
+	return title
+}
+
+// dropTitleHeading removes a heading that repeats the title before any
+// other text; the title is sent separately.
+func dropTitleHeading(content []htmlToken, title string) []htmlToken {
+	for i := range content {
+		t := &content[i]
+		if t.isTag("h1", "h2") {
+			end := elementEnd(content, i)
+			if strings.Join(strings.Fields(textContent(content[i:end+1])), " ") == title {
+				return slices.Delete(slices.Clone(content), i, end+1)
+			}
+			return content
+		}
+		if t.kind == htmlText && strings.TrimSpace(textContent(content[i:i+1])) != "" {
+			return content
+		}
+	}
+	return content
+}
+
+// pageTime is the datetime of the content's first <time> element.
+func pageTime(content []htmlToken) string {
+	for i := range content {
+		if content[i].isTag("time") {
+			if dt, _ := content[i].attr("datetime"); strings.TrimSpace(dt) != "" {
+				return strings.TrimSpace(dt)
+			}
+		}
+	}
+	return ""
+}
+
+// rewritePageRefs inlines the images that images has and makes other
+// relative image and link URLs absolute against base, if the page has one.
+func rewritePageRefs(content []htmlToken, base *url.URL, images pageImages) {
+	for i := range content {
+		t := &content[i]
+		var key string
+		switch {
+		case t.isTag("img"):
+			key = "src"
+			if src, _ := t.attr("src"); src == "" || strings.HasPrefix(src, "data:image/gif") {
+				// lazy-loaded: the real image is in a data- attribute
+				for _, lazy := range []string{"data-src", "data-original", "data-lazy-src"} {
+					if v, ok := t.attr(lazy); ok && v != "" {
+						t.setAttr("src", v)
+						t.removeAttrs(func(a htmlAttr) bool { return a.key == lazy })
+						break
+					}
+				}
+			}
+		case t.isTag("a"):
+			key = "href"
+		default:
+			continue
+		}
+		ref, ok := t.attr(key)
+		if !ok || ref == "" || strings.HasPrefix(ref, "#") {
+			continue
+		}
+		u, err := url.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			continue
+		}
+		if key == "src" && images != nil {
+			if uri, ok := images(u, base); ok {
+				t.setAttr("src", uri)
+				t.removeAttrs(func(a htmlAttr) bool { return a.key == "srcset" || a.key == "sizes" })
+				continue
+			}
+		}
+		if base != nil && !u.IsAbs() {
+			t.setAttr(key, base.ResolveReference(u).String())
+		}
+	}
+}
+
+// savedImages reads the images saved with a page in dir, e.g.
+// "Page_files/a.jpg". Paths leaving dir are not read.
+func savedImages(dir string) pageImages {
+	return func(ref, _ *url.URL) (string, bool) {
+		if ref.IsAbs() || ref.Host != "" {
+			return "", false
+		}
+		return savedImage(dir, ref.Path)
+	}
+}
+
+func savedImage(dir, ref string) (string, bool) {
+	rel := filepath.FromSlash(ref)
+	if !filepath.IsLocal(rel) {
+		return "", false
+	}
+	p := filepath.Join(dir, rel)
+	if fi, err := os.Stat(p); err != nil || !fi.Mode().IsRegular() || fi.Size() > pageImageMax {
+		return "", false
+	}
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		return "", false
+	}
+	ctype := mime.TypeByExtension(strings.ToLower(filepath.Ext(p)))
+	if ctype == "" {
+		ctype = http.DetectContentType(raw)
+	}
+	if !strings.HasPrefix(ctype, "image/") {
+		return "", false
+	}
+	return encodeDataURI(ctype, raw), true
+}
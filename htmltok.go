@@ -0,0 +1,363 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+/* -------------------------------
+   Minimal HTML tokenizer
+
+   Good enough for the rewriting
+   passes over article content: it
+   splits markup into text, tags,
+   comments and doctypes, keeps the
+   original bytes of every token so
+   untouched markup round-trips
+   verbatim, and treats the content
+   of raw-text elements (script,
+   style, textarea, title) as text.
+--------------------------------*/
+
+type htmlTokKind int
+
+const (
+	htmlText htmlTokKind = iota
+	htmlStartTag
+	htmlEndTag
+	htmlSelfClosingTag
+	htmlComment
+	htmlDoctype
+)
+
+type htmlAttr struct {
+	name   string // as written, e.g. viewBox
+	key    string // lowercased
+	val    string // unescaped
+	hasVal bool
+}
+
+type htmlToken struct {
+	kind  htmlTokKind
+	data  string // lowercased tag name, or raw text/comment body
+	name  string // tag name as written, e.g. linearGradient
+	attrs []htmlAttr
+	raw   string // original markup; empty once the token is modified
+}
+
+var rawTextElems = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// voidElems never have an end tag.
+var voidElems = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+func tokenizeHTML(s string) []htmlToken {
+	var toks []htmlToken
+	text := func(t string) {
+		if t != "" {
+			toks = append(toks, htmlToken{kind: htmlText, data: t, raw: t})
+		}
+	}
+	i := 0
+	for i < len(s) {
+		lt := strings.IndexByte(s[i:], '<')
+		if lt < 0 {
+			text(s[i:])
+			break
+		}
+		text(s[i : i+lt])
+		i += lt
+		rest := s[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			end := strings.Index(rest[4:], "-->")
+			n := len(rest)
+			if end >= 0 {
+				n = 4 + end + 3
+			}
+			body := strings.TrimSuffix(rest[4:n], "-->")
+			toks = append(toks, htmlToken{kind: htmlComment, data: body, raw: rest[:n]})
+			i += n
+		case strings.HasPrefix(rest, "<!") || strings.HasPrefix(rest, "<?"):
+			n := strings.IndexByte(rest, '>') + 1
+			if n == 0 {
+				n = len(rest)
+			}
+			toks = append(toks, htmlToken{kind: htmlDoctype, data: rest[2 : n-1], raw: rest[:n]})
+			i += n
+		case len(rest) > 1 && (isASCIILetter(rest[1]) || (rest[1] == '/' && len(rest) > 2 && isASCIILetter(rest[2]))):
+			tok, n := parseTag(rest)
+			toks = append(toks, tok)
+			i += n
+			if tok.kind == htmlStartTag && rawTextElems[tok.data] {
+				closing := "</" + tok.data
+				end := indexFold(s[i:], closing)
+				if end < 0 {
+					text(s[i:])
+					i = len(s)
+				} else {
+					text(s[i : i+end])
+					i += end
+				}
+			}
+		default:
+			text("<")
+			i++
+		}
+	}
+	return mergeText(toks)
+}
+
+// parseTag parses a start or end tag at the beginning of s and returns the
+// token and the number of bytes consumed.
+func parseTag(s string) (htmlToken, int) {
+	tok := htmlToken{kind: htmlStartTag}
+	i := 1
+	if s[i] == '/' {
+		tok.kind = htmlEndTag
+		i++
+	}
+	j := i
+	for j < len(s) && !isTagSpace(s[j]) && s[j] != '>' && s[j] != '/' {
+		j++
+	}
+	tok.name = s[i:j]
+	tok.data = strings.ToLower(tok.name)
+	i = j
+	for i < len(s) {
+		for i < len(s) && isTagSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) {
+			break
+		}
+		if s[i] == '>' {
+			i++
+			tok.raw = s[:i]
+			return tok, i
+		}
+		if s[i] == '/' {
+			if i+1 < len(s) && s[i+1] == '>' {
+				if tok.kind == htmlStartTag {
+					tok.kind = htmlSelfClosingTag
+				}
+				i += 2
+				tok.raw = s[:i]
+				return tok, i
+			}
+			i++
+			continue
+		}
+		k := i
+		for k < len(s) && !isTagSpace(s[k]) && s[k] != '=' && s[k] != '>' && !(s[k] == '/' && k+1 < len(s) && s[k+1] == '>') {
+			k++
+		}
+		attr := htmlAttr{name: s[i:k], key: strings.ToLower(s[i:k])}
+		i = k
+		for i < len(s) && isTagSpace(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isTagSpace(s[i]) {
+				i++
+			}
+			attr.hasVal = true
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				q := s[i]
+				end := strings.IndexByte(s[i+1:], q)
+				if end < 0 {
+					attr.val = html.UnescapeString(s[i+1:])
+					i = len(s)
+				} else {
+					attr.val = html.UnescapeString(s[i+1 : i+1+end])
+					i += end + 2
+				}
+			} else {
+				v := i
+				for i < len(s) && !isTagSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				attr.val = html.UnescapeString(s[v:i])
+			}
+		}
+		if attr.key != "" && tok.kind != htmlEndTag {
+			tok.attrs = append(tok.attrs, attr)
+		}
+	}
+	tok.raw = s
+	return tok, len(s)
+}
+
+func mergeText(toks []htmlToken) []htmlToken {
+	out := toks[:0]
+	for _, t := range toks {
+		if n := len(out); n > 0 && t.kind == htmlText && out[n-1].kind == htmlText {
+			out[n-1].data += t.data
+			out[n-1].raw += t.raw
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// String renders the token, preferring its original markup.
+func (t htmlToken) String() string {
+	if t.raw != "" {
+		return t.raw
+	}
+	switch t.kind {
+	case htmlText:
+		return t.data
+	case htmlComment:
+		return "<!--" + t.data + "-->"
+	case htmlDoctype:
+		return "<!" + t.data + ">"
+	}
+	name := t.name
+	if name == "" {
+		name = t.data
+	}
+	if t.kind == htmlEndTag {
+		return "</" + name + ">"
+	}
+	var b strings.Builder
+	b.WriteByte('<')
+	b.WriteString(name)
+	for _, a := range t.attrs {
+		b.WriteByte(' ')
+		if a.name != "" {
+			b.WriteString(a.name)
+		} else {
+			b.WriteString(a.key)
+		}
+		if a.hasVal {
+			b.WriteString(`="`)
+			b.WriteString(html.EscapeString(a.val))
+			b.WriteByte('"')
+		}
+	}
+	if t.kind == htmlSelfClosingTag {
+		b.WriteString(" /")
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+func renderHTML(toks []htmlToken) string {
+	b := getBuffer()
+	defer putBuffer(b)
+	for _, t := range toks {
+		b.WriteString(t.String())
+	}
+	return b.String()
+}
+
+func (t *htmlToken) attr(key string) (string, bool) {
+	for _, a := range t.attrs {
+		if a.key == key {
+			return a.val, true
+		}
+	}
+	return "", false
+}
+
+func (t *htmlToken) setAttr(key, val string) {
+	t.raw = ""
+	for i := range t.attrs {
+		if t.attrs[i].key == key {
+			t.attrs[i].val, t.attrs[i].hasVal = val, true
+			return
+		}
+	}
+	t.attrs = append(t.attrs, htmlAttr{key: key, val: val, hasVal: true})
+}
+
+// removeAttrs drops every attribute for which drop returns true.
+func (t *htmlToken) removeAttrs(drop func(htmlAttr) bool) {
+	kept := t.attrs[:0]
+	for _, a := range t.attrs {
+		if drop(a) {
+			t.raw = ""
+			continue
+		}
+		kept = append(kept, a)
+	}
+	t.attrs = kept
+}
+
+// isTag reports whether t opens (or self-closes) one of the named elements.
+func (t *htmlToken) isTag(names ...string) bool {
+	if t.kind != htmlStartTag && t.kind != htmlSelfClosingTag {
+		return false
+	}
+	for _, n := range names {
+		if t.data == n {
+			return true
+		}
+	}
+	return false
+}
+
+// elementEnd returns the index of the token closing the element opened at
+// toks[i], or i itself for void, self-closing and unclosed elements.
+func elementEnd(toks []htmlToken, i int) int {
+	t := toks[i]
+	if t.kind == htmlSelfClosingTag || voidElems[t.data] {
+		return i
+	}
+	depth := 0
+	for j := i; j < len(toks); j++ {
+		switch {
+		case toks[j].kind == htmlStartTag && toks[j].data == t.data:
+			depth++
+		case toks[j].kind == htmlEndTag && toks[j].data == t.data:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return i
+}
+
+// linkTokens builds <p><a href="href">label</a></p>.
+func linkTokens(href, label string) []htmlToken {
+	a := htmlToken{kind: htmlStartTag, data: "a"}
+	a.setAttr("href", href)
+	return []htmlToken{
+		{kind: htmlStartTag, data: "p"},
+		a,
+		{kind: htmlText, data: html.EscapeString(label)},
+		{kind: htmlEndTag, data: "a"},
+		{kind: htmlEndTag, data: "p"},
+	}
+}
+
+// textContent concatenates the unescaped text of toks.
+func textContent(toks []htmlToken) string {
+	var b strings.Builder
+	for _, t := range toks {
+		if t.kind == htmlText {
+			b.WriteString(html.UnescapeString(t.data))
+		}
+	}
+	return b.String()
+}
+
+func isASCIILetter(c byte) bool { return (c|0x20) >= 'a' && (c|0x20) <= 'z' }
+
+func isTagSpace(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' }
+
+// indexFold is strings.Index with ASCII case folding.
+func indexFold(s, sub string) int {
+	for j := 0; j+len(sub) <= len(s); j++ {
+		if strings.EqualFold(s[j:j+len(sub)], sub) {
+			return j
+		}
+	}
+	return -1
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/* -------------------------------
+   Published item URLs
+
+   When the API answers with the
+   item's URL, it is logged with the
+   success ("OK  file → url"),
+   recorded in the -manifest, and
+   -open N opens the first N of the
+   run in a browser for spot checks.
+--------------------------------*/
+
+// itemURLKeys are the response fields that may hold the item URL, in
+// order of preference.
+var itemURLKeys = []string{"url", "item_url", "public_url", "permalink", "web_url", "html_url"}
+
+// itemURL digs the published item URL out of an upload response, looking
+// at the top level and inside "item" or "data".
+func itemURL(body []byte) string {
+	var resp map[string]any
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	for _, obj := range []any{resp, resp["item"], resp["data"]} {
+		m, ok := obj.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, k := range itemURLKeys {
+			if u, ok := m[k].(string); ok && (strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://")) {
+				return u
+			}
+		}
+	}
+	return ""
+}
+
+// itemID digs the item's ID out of an upload response, like itemURL.
+func itemID(body []byte) string {
+	var resp map[string]any
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	for _, obj := range []any{resp, resp["item"], resp["data"]} {
+		m, _ := obj.(map[string]any)
+		switch id := m["id"].(type) {
+		case string:
+			return id
+		case float64:
+			return strconv.FormatFloat(id, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// uploadResult collects the item URLs and IDs of one file's uploads, the
+// API bases that took them, and how the attempts went.
+type uploadResult struct {
+	mu          sync.Mutex
+	urls        []string
+	ids         []string
+	endpoints   []string
+	fingerprint string // simhash (hex) for the manifest, -near-dup
+	attempts    int
+	status      int      // of the last attempt, 0 = no answer
+	collections []int    // posted to, for metrics
+	notes       []string // input fixes, for the report
+	items       []string // of a multi-item file: sums of the items uploaded
+}
+
+// add records a successful upload to base; u and id may be empty.
+func (r *uploadResult) add(base, u, id string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !slices.Contains(r.endpoints, base) {
+		r.endpoints = append(r.endpoints, base)
+	}
+	if u != "" {
+		r.urls = append(r.urls, u)
+	}
+	if id != "" {
+		r.ids = append(r.ids, id)
+	}
+}
+
+// attempt records one upload attempt and the HTTP status it got.
+func (r *uploadResult) attempt(status int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempts++
+	r.status = status
+}
+
+// Status returns the last attempt's HTTP status and the number of attempts.
+func (r *uploadResult) Status() (status, attempts int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status, r.attempts
+}
+
+// setCollections records the collections an item is posted to.
+func (r *uploadResult) setCollections(ids []int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		if !slices.Contains(r.collections, id) {
+			r.collections = append(r.collections, id)
+		}
+	}
+}
+
+// Collections returns the collections posted to; a nil result has none.
+func (r *uploadResult) Collections() []int {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int(nil), r.collections...)
+}
+
+func (r *uploadResult) IDs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.ids...)
+}
+
+// note records a fix made to the input, for the report.
+func (r *uploadResult) note(s string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notes = append(r.notes, s)
+}
+
+func (r *uploadResult) Notes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.notes...)
+}
+
+// setFingerprint records the article's simhash.
+func (r *uploadResult) setFingerprint(fp uint64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fingerprint = fmt.Sprintf("%016x", fp)
+}
+
+func (r *uploadResult) Fingerprint() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fingerprint
+}
+
+func (r *uploadResult) URLs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.urls...)
+}
+
+// addItem records that the item of a multi-item file with sum (see
+// itemSum) is uploaded.
+func (r *uploadResult) addItem(sum string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, sum)
+}
+
+func (r *uploadResult) Items() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.items...)
+}
+
+func (r *uploadResult) Endpoints() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.endpoints...)
+}
+
+type uploadResultKey struct{}
+
+func withUploadResult(ctx context.Context, r *uploadResult) context.Context {
+	return context.WithValue(ctx, uploadResultKey{}, r)
+}
+
+func uploadResultFrom(ctx context.Context) *uploadResult {
+	r, _ := ctx.Value(uploadResultKey{}).(*uploadResult)
+	return r
+}
+
+// browserOpener opens up to n item URLs in the default browser.
+type browserOpener struct {
+	mu   sync.Mutex
+	left int
+}
+
+func (o *browserOpener) open(u string) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	if o.left <= 0 {
+		o.mu.Unlock()
+		return
+	}
+	o.left--
+	o.mu.Unlock()
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err != nil {
+		log.Printf("Could not open %s: %v", u, err)
+		return
+	}
+	go cmd.Wait()
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Stored tokens
+
+   Tokens stored by `transform
+   login` live in the platform
+   secret store, not a plain file:
+     macOS    Keychain (security)
+     Windows  Credential Manager
+     Linux…   libsecret (secret-tool)
+
+   Entries are keyed by service
+   "omnipub" and the profile name
+   ("default" without -profile).
+
+   Servers without a keychain use
+   -no-keychain (or
+   OMNIPUB_NO_KEYCHAIN=1): tokens
+   then go to owner-only files under
+   ~/.config/omnipub/tokens/.
+
+   A pasted token is stored as is.
+   The device flow stores JSON with
+   the refresh token, so runs trade
+   it for a new access token when
+   the old one expires, and store
+   the result.
+--------------------------------*/
+
+const keychainService = "omnipub"
+
+var errNoStoredToken = errors.New("no stored token")
+
+// tokenStore keeps login tokens by account.
+type tokenStore interface {
+	get(account string) (string, error) // errNoStoredToken if absent
+	set(account, token string) error
+	String() string
+}
+
+func newTokenStore(noKeychain bool) tokenStore {
+	if noKeychain {
+		dir, _ := os.UserConfigDir()
+		return tokenDir(filepath.Join(dir, "omnipub", "tokens"))
+	}
+	return osKeychain{}
+}
+
+// osKeychain is the platform secret store (keychain_unix.go,
+// keychain_windows.go).
+type osKeychain struct{}
+
+func (osKeychain) get(account string) (string, error) { return keychainGet(account) }
+func (osKeychain) set(account, token string) error    { return keychainSet(account, token) }
+func (osKeychain) String() string                     { return "keychain" }
+
+// tokenDir keeps one 0600 file per account.
+type tokenDir string
+
+func (d tokenDir) get(account string) (string, error) {
+	raw, err := os.ReadFile(filepath.Join(string(d), account))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", errNoStoredToken
+	}
+	if err != nil {
+		return "", err
+	}
+	warnCredentialsMode(filepath.Join(string(d), account))
+	return strings.TrimSpace(string(raw)), nil
+}
+
+func (d tokenDir) set(account, token string) error {
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return err
+	}
+	path := filepath.Join(string(d), account)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(token+"\n"), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d tokenDir) String() string { return string(d) }
+
+// loginToken is a stored entry. Only the device flow fills in more than
+// AccessToken.
+type loginToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	TokenURL     string    `json:"token_url,omitempty"`
+	ClientID     string    `json:"client_id,omitempty"`
+	Expiry       time.Time `json:"expiry,omitzero"`
+}
+
+// parseLoginToken reads an entry: JSON from the device flow, or a bare
+// token.
+func parseLoginToken(raw string) loginToken {
+	var lt loginToken
+	if strings.HasPrefix(raw, "{") && json.Unmarshal([]byte(raw), &lt) == nil && lt.AccessToken != "" {
+		return lt
+	}
+	return loginToken{AccessToken: raw}
+}
+
+// encode is what goes into the store; a bare token when there is nothing
+// to refresh with.
+func (lt loginToken) encode() string {
+	if lt.RefreshToken == "" {
+		return lt.AccessToken
+	}
+	b, _ := json.Marshal(lt)
+	return string(b)
+}
+
+// refreshable reports whether lt can and should be refreshed within
+// margin of now.
+func (lt loginToken) refreshable(margin time.Duration) bool {
+	return lt.RefreshToken != "" && lt.TokenURL != "" && !lt.Expiry.IsZero() && time.Until(lt.Expiry) < margin
+}
+
+// loginRefreshMargin is how long before expiry a token is refreshed.
+const loginRefreshMargin = time.Minute
+
+// storedKey reads a token stored by `transform login`, refreshing it
+// when it has a refresh token.
+type storedKey struct {
+	store   tokenStore
+	account string
+	mu      sync.Mutex // one refresh at a time: refresh tokens may rotate
+}
+
+func newStoredKey(store tokenStore, account string) *storedKey {
+	return &storedKey{store: store, account: account}
+}
+
+func (k *storedKey) Key(ctx context.Context) (string, error) {
+	lt, err := k.current(ctx)
+	if err != nil {
+		return "", err
+	}
+	return lt.AccessToken, nil
+}
+
+// current reads the entry and refreshes it if it is about to expire.
+func (k *storedKey) current(ctx context.Context) (loginToken, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	raw, err := k.store.get(k.account)
+	if errors.Is(err, errNoStoredToken) {
+		return loginToken{}, fmt.Errorf("%s: no token for profile %q (run `transform login`)", k.store, k.account)
+	}
+	if err != nil {
+		return loginToken{}, fmt.Errorf("%s: %w", k.store, err)
+	}
+	lt := parseLoginToken(raw)
+	if !lt.refreshable(loginRefreshMargin) {
+		return lt, nil
+	}
+	fresh, err := refreshLogin(ctx, lt)
+	if err != nil {
+		if time.Now().Before(lt.Expiry) {
+			log.Printf("Refreshing stored token %s: %v", k.account, err)
+			return lt, nil // still good for a little while
+		}
+		return loginToken{}, fmt.Errorf("refreshing stored token %s: %w (run `transform login` again)", k.account, err)
+	}
+	if err := k.store.set(k.account, fresh.encode()); err != nil {
+		log.Printf("Error storing the refreshed token in %s: %v", k.store, err)
+	}
+	return fresh, nil
+}
+
+// Renew refreshes the token shortly before it expires, for as long as
+// the entry has a refresh token.
+func (k *storedKey) Renew(ctx context.Context, onKey func(string)) {
+	lt, err := k.current(ctx)
+	for err == nil && lt.RefreshToken != "" && !lt.Expiry.IsZero() {
+		select {
+		case <-time.After(max(time.Until(lt.Expiry)-loginRefreshMargin, 5*time.Second)):
+		case <-ctx.Done():
+			return
+		}
+		var fresh loginToken
+		if fresh, err = k.current(ctx); err == nil && fresh.AccessToken != lt.AccessToken {
+			onKey(fresh.AccessToken)
+		}
+		lt = fresh
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func (k *storedKey) String() string { return "stored token " + k.account }
+
+// hasStoredToken reports whether `transform login` stored a token for
+// profile.
+func hasStoredToken(store tokenStore, profile string) bool {
+	_, err := store.get(loginAccount(profile))
+	return err == nil
+}
+
+// loginAccount names the stored entry for a profile.
+func loginAccount(profile string) string {
+	if profile == "" {
+		return "default"
+	}
+	return profile
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// keychainSet stores token under account, replacing any previous one.
+func keychainSet(account, token string) error {
+	ctx := context.Background()
+	var cmd *exec.Cmd
+	var stdin string
+	if runtime.GOOS == "darwin" {
+		// interactive mode keeps the token off the command line
+		cmd = exec.CommandContext(ctx, "security", "-i")
+		stdin = fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+			securityQuote(keychainService), securityQuote(account), securityQuote(token))
+	} else {
+		cmd = exec.CommandContext(ctx, "secret-tool", "store", "--label=Omnipub ("+account+")",
+			"service", keychainService, "account", account)
+		stdin = token
+	}
+	if _, err := runPlugin(ctx, cmd, []byte(stdin)); err != nil {
+		return fmt.Errorf("%s: %w", cmd.Path, err)
+	}
+	return nil
+}
+
+// keychainGet returns the token stored under account, or errNoStoredToken.
+func keychainGet(account string) (string, error) {
+	ctx := context.Background()
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.CommandContext(ctx, "security", "find-generic-password", "-s", keychainService, "-a", account, "-w")
+	} else {
+		cmd = exec.CommandContext(ctx, "secret-tool", "lookup", "service", keychainService, "account", account)
+	}
+	out, err := runPlugin(ctx, cmd, nil)
+	// secret-tool exits 1 when nothing matches, security 44; any other
+	// exit (a locked keyring, no D-Bus session, a denied prompt) is an
+	// error, reported with what the tool said
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == keychainNotFound() || (err == nil && len(out) == 0) {
+		return "", errNoStoredToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", cmd.Path, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keychainNotFound is the exit status of the lookup tool when no entry
+// matches.
+func keychainNotFound() int {
+	if runtime.GOOS == "darwin" {
+		return 44 // errSecItemNotFound
+	}
+	return 1
+}
+
+// securityQuote quotes s for a `security -i` command line.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
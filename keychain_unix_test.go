@@ -0,0 +1,50 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestKeychainGetExitStatus(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("stands in for secret-tool")
+	}
+	for _, tc := range []struct {
+		name, script string
+		token        string
+		err          string // "" for errNoStoredToken when token is empty too
+	}{
+		{"found", "echo tok-123", "tok-123", ""},
+		{"not found", "exit 1", "", ""},
+		{"empty", "exit 0", "", ""},
+		{"locked", "echo 'Cannot autolaunch D-Bus without X11 $DISPLAY' >&2; exit 2", "", "Cannot autolaunch D-Bus"},
+		{"killed", "kill -9 $$", "", "signal: killed"},
+	} {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "secret-tool"), []byte("#!/bin/sh\n"+tc.script+"\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+		token, err := keychainGet("default")
+		switch {
+		case tc.token != "":
+			if token != tc.token || err != nil {
+				t.Errorf("%s: got %q, %v; want %q", tc.name, token, err, tc.token)
+			}
+		case tc.err == "":
+			if !errors.Is(err, errNoStoredToken) {
+				t.Errorf("%s: got %q, %v; want errNoStoredToken", tc.name, token, err)
+			}
+		default:
+			if err == nil || errors.Is(err, errNoStoredToken) || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: got %v; want an error containing %q", tc.name, err, tc.err)
+			}
+		}
+	}
+}
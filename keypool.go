@@ -0,0 +1,200 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   API key pool
+
+   A key list (a -key-file with
+   one key per line, or env-list:
+   with comma-separated keys) holds
+   several keys; other sources hold
+   exactly one secret, commas and
+   all. Requests rotate over
+   them round-robin to spread
+   per-key rate limits; a key that
+   gets 429 is benched for a while
+   (Retry-After, else 5 s doubling
+   per consecutive 429, at most
+   5 min). When every key is benched
+   workers wait for the first one to
+   come back.
+--------------------------------*/
+
+const (
+	benchBase = 5 * time.Second
+	benchMax  = 5 * time.Minute
+)
+
+type poolKey struct {
+	pool *keyPool
+	key  string
+	n    int // 1-based position, for logs
+
+	requests, throttled int
+	consecutive         int // 429s in a row
+	benchedUntil        time.Time
+}
+
+// label identifies a key in logs without revealing any of it: a short
+// hash, to match against the output of sha256sum.
+func (k *poolKey) label() string {
+	sum := sha256.Sum256([]byte(k.key))
+	return fmt.Sprintf("key %d (%x)", k.n, sum[:4])
+}
+
+type keyPool struct {
+	mu   sync.Mutex
+	keys []*poolKey
+	next int
+}
+
+// keyLister is a key source whose value is a list of keys.
+type keyLister interface {
+	KeySource
+	splitKeys(value string) []string
+}
+
+// splitKeys returns the keys in a value src returned. Only key lists
+// are split: a basic-auth password or cookie may well contain a comma.
+func splitKeys(src KeySource, value string) []string {
+	if l, ok := src.(keyLister); ok {
+		return l.splitKeys(value)
+	}
+	if value = strings.TrimSpace(value); value == "" {
+		return nil
+	}
+	return []string{value}
+}
+
+// splitOn splits value at any of seps and drops empty keys.
+func splitOn(value, seps string) []string {
+	var keys []string
+	for _, k := range strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(seps, r) }) {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+func newKeyPool(keys []string) *keyPool {
+	p := &keyPool{}
+	for i, k := range keys {
+		p.keys = append(p.keys, &poolKey{pool: p, key: k, n: i + 1})
+	}
+	return p
+}
+
+// pick returns the next key that is not benched, waiting if all are.
+func (p *keyPool) pick() *poolKey {
+	for {
+		p.mu.Lock()
+		now := time.Now()
+		var soonest *poolKey
+		for range p.keys {
+			k := p.keys[p.next]
+			p.next = (p.next + 1) % len(p.keys)
+			if !now.Before(k.benchedUntil) {
+				k.requests++
+				p.mu.Unlock()
+				return k
+			}
+			if soonest == nil || k.benchedUntil.Before(soonest.benchedUntil) {
+				soonest = k
+			}
+		}
+		wait := soonest.benchedUntil.Sub(now)
+		p.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// report records the response status the key got.
+func (k *poolKey) report(status int, retryAfter time.Duration) {
+	p := k.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if status != http.StatusTooManyRequests {
+		if status != 0 {
+			k.consecutive = 0
+		}
+		return
+	}
+	k.throttled++
+	k.consecutive++
+	if len(p.keys) == 1 {
+		return // nothing to rotate to; the retry delay does the waiting
+	}
+	bench := retryAfter
+	if bench <= 0 {
+		bench = benchBase << min(k.consecutive-1, 6)
+	}
+	bench = min(bench, benchMax)
+	k.benchedUntil = time.Now().Add(bench)
+	log.Printf("%s benched for %s after 429 (%d of %d requests throttled)", k.label(), bench, k.throttled, k.requests)
+}
+
+// values returns the keys themselves.
+func (p *keyPool) values() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	vals := make([]string, len(p.keys))
+	for i, k := range p.keys {
+		vals[i] = k.key
+	}
+	return vals
+}
+
+// has reports whether key is still in the pool.
+func (p *keyPool) has(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range p.keys {
+		if k.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// same reports whether the pool holds exactly keys.
+func (p *keyPool) same(keys []string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(keys) != len(p.keys) {
+		return false
+	}
+	for i, k := range keys {
+		if p.keys[i].key != k {
+			return false
+		}
+	}
+	return true
+}
+
+// dump writes per-key request and 429 counts.
+func (p *keyPool) dump(w io.Writer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, k := range p.keys {
+		rate := 0.0
+		if k.requests > 0 {
+			rate = 100 * float64(k.throttled) / float64(k.requests)
+		}
+		fmt.Fprintf(w, "  %s: %d requests, %d throttled (%.1f%%)", k.label(), k.requests, k.throttled, rate)
+		if until := time.Until(k.benchedUntil); until > 0 {
+			fmt.Fprintf(w, ", benched for %s", until.Round(time.Second))
+		}
+		fmt.Fprintln(w)
+	}
+}
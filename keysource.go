@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+/* -------------------------------
+   API key sources
+
+   -key-source picks one of
+     env:NAME    (default, -key-env)
+     env-list:NAME
+                 comma-separated keys
+     file:PATH   e.g. a mounted
+                 secret (-key-file);
+                 one key per line
+     cmd:COMMAND shell command that
+                 prints the key, e.g.
+                 "pass show omnipub"
+                 (-key-cmd)
+     vault:PATH  vault.go
+                 (-vault-path)
+     aws-sm:ID   aws.go
+     ssm:NAME    aws.go
+     keychain:PROFILE
+                 token stored by
+                 `transform login`
+
+   Surrounding whitespace is trimmed
+   from whatever the source returns.
+--------------------------------*/
+
+// KeySource yields the Omnipub API key.
+type KeySource interface {
+	Key(ctx context.Context) (string, error)
+	String() string // describes the source, never the key
+}
+
+// renewingKeySource hands out keys that expire; Renew keeps them valid
+// until ctx is done and reports replacement keys through onKey.
+type renewingKeySource interface {
+	KeySource
+	Renew(ctx context.Context, onKey func(string))
+}
+
+// NewKeySource parses a -key-source spec: env:NAME, env-list:NAME, file:PATH,
+// cmd:COMMAND, aws-sm:SECRET[#field] or ssm:PARAMETER. Vault and
+// keychain specs are handled by the caller, which has the -vault-* and
+// -no-keychain settings.
+func NewKeySource(spec string) (KeySource, error) {
+	scheme, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("key-source %q: want scheme:value", spec)
+	}
+	switch scheme {
+	case "env":
+		return envKey(arg), nil
+	case "env-list":
+		return envKeyList(arg), nil
+	case "file":
+		return fileKey(arg), nil
+	case "cmd":
+		return cmdKey(arg), nil
+	case "aws-sm":
+		return newAWSKey("secretsmanager", arg)
+	case "ssm":
+		return newAWSKey("ssm", arg)
+	}
+	return nil, fmt.Errorf("key-source %q: unknown scheme %q (want env, env-list, file, cmd, vault, aws-sm, ssm or keychain)", spec, scheme)
+}
+
+type envKey string
+
+func (e envKey) Key(context.Context) (string, error) {
+	key := strings.TrimSpace(os.Getenv(string(e)))
+	if key == "" {
+		return "", fmt.Errorf("env %q not set", string(e))
+	}
+	return key, nil
+}
+
+func (e envKey) String() string { return "env " + string(e) }
+
+// envKeyList is an environment variable holding comma-separated keys.
+type envKeyList string
+
+func (e envKeyList) Key(ctx context.Context) (string, error) {
+	return envKey(e).Key(ctx)
+}
+
+func (e envKeyList) String() string { return "env " + string(e) }
+
+func (envKeyList) splitKeys(value string) []string { return splitOn(value, ",\n") }
+
+type fileKey string
+
+func (f fileKey) Key(context.Context) (string, error) {
+	raw, err := os.ReadFile(string(f))
+	if err != nil {
+		return "", fmt.Errorf("key file: %w", err)
+	}
+	key := strings.TrimSpace(string(raw))
+	if key == "" {
+		return "", fmt.Errorf("key file %s is empty", string(f))
+	}
+	return key, nil
+}
+
+func (f fileKey) String() string { return "file " + string(f) }
+
+func (fileKey) splitKeys(value string) []string { return splitOn(value, "\r\n") }
+
+type cmdKey string
+
+func (c cmdKey) Key(ctx context.Context) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", string(c))
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", string(c))
+	}
+	out, err := runPlugin(ctx, cmd, nil)
+	if err != nil {
+		return "", fmt.Errorf("key command: %w", err)
+	}
+	key := strings.TrimSpace(string(out))
+	if key == "" {
+		return "", errors.New("key command printed nothing")
+	}
+	return key, nil
+}
+
+func (c cmdKey) String() string { return "key command" }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+/* -------------------------------
+   Lenient decoding (-lenient)
+
+   Exports from hand-rolled CMS
+   scripts are often "almost JSON".
+   -lenient rewrites such input
+   into JSON before the usual
+   decoding:
+
+   trailing commas   dropped
+   NaN, Infinity     null
+   unquoted keys     quoted
+   'single quotes'   "double"
+   comments          dropped
+   UTF-8 BOM         dropped
+
+   Each kind of fix is logged and
+   listed in the file's -report
+   notes. Anything else still fails
+   as an input error.
+--------------------------------*/
+
+// lenientJSON rewrites raw into strict JSON. It returns raw itself, and no
+// fixes, when nothing needed fixing.
+func lenientJSON(raw []byte) ([]byte, []string) {
+	l := &lenientScanner{in: raw, fixes: make(map[string]int)}
+	if bytes.HasPrefix(raw, []byte("\xEF\xBB\xBF")) {
+		l.pos = 3
+		l.fix("UTF-8 BOM")
+	}
+	l.scan()
+	if len(l.order) == 0 {
+		return raw, nil
+	}
+	notes := make([]string, len(l.order))
+	for i, kind := range l.order {
+		notes[i] = kind
+		if n := l.fixes[kind]; n > 1 {
+			notes[i] = fmt.Sprintf("%s (%d)", kind, n)
+		}
+	}
+	return l.out.Bytes(), notes
+}
+
+// skipLeadingComments returns raw without a BOM and the space and comments
+// before its first value, for sniffInput to look at.
+func skipLeadingComments(raw []byte) []byte {
+	l := &lenientScanner{in: raw, fixes: make(map[string]int)}
+	if bytes.HasPrefix(raw, []byte("\xEF\xBB\xBF")) {
+		l.pos = 3
+	}
+	l.peek()
+	return raw[l.pos:]
+}
+
+type lenientScanner struct {
+	in    []byte
+	pos   int
+	out   bytes.Buffer
+	fixes map[string]int
+	order []string // kinds of fix, in order of first appearance
+}
+
+func (l *lenientScanner) fix(kind string) {
+	if l.fixes[kind] == 0 {
+		l.order = append(l.order, kind)
+	}
+	l.fixes[kind]++
+}
+
+func (l *lenientScanner) scan() {
+	for l.pos < len(l.in) {
+		c := l.in[l.pos]
+		switch {
+		case c == '"':
+			l.copyString()
+		case c == '\'':
+			l.requoteString()
+		case c == '/' && l.skipComment():
+		case c == ',':
+			l.pos++
+			if next := l.peek(); next == '}' || next == ']' {
+				l.fix("trailing comma")
+				continue
+			}
+			l.out.WriteByte(c)
+		case c == '-' && bytes.HasPrefix(l.in[l.pos+1:], []byte("Infinity")):
+			l.pos += len("-Infinity")
+			l.out.WriteString("null")
+			l.fix("NaN or Infinity → null")
+		case isIdentStart(c):
+			l.word()
+		default:
+			l.out.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+// peek returns the next byte that is not space or part of a comment,
+// copying the space over; 0 at the end.
+func (l *lenientScanner) peek() byte {
+	for l.pos < len(l.in) {
+		switch c := l.in[l.pos]; c {
+		case ' ', '\t', '\r', '\n':
+			l.out.WriteByte(c)
+			l.pos++
+		case '/':
+			if !l.skipComment() {
+				return c
+			}
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+// skipComment drops a // or /* */ comment at pos, if there is one.
+func (l *lenientScanner) skipComment() bool {
+	rest := l.in[l.pos:]
+	switch {
+	case bytes.HasPrefix(rest, []byte("//")):
+		end := bytes.IndexByte(rest, '\n')
+		if end < 0 {
+			end = len(rest)
+		}
+		l.pos += end
+	case bytes.HasPrefix(rest, []byte("/*")):
+		end := bytes.Index(rest[2:], []byte("*/"))
+		if end < 0 {
+			return false // leave it for the decoder to report
+		}
+		l.pos += 2 + end + 2
+	default:
+		return false
+	}
+	l.fix("comment")
+	return true
+}
+
+// copyString copies a double-quoted string as is.
+func (l *lenientScanner) copyString() {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.in) {
+		switch l.in[l.pos] {
+		case '\\':
+			l.pos += 2
+			continue
+		case '"':
+			l.pos++
+			l.out.Write(l.in[start:l.pos])
+			return
+		}
+		l.pos++
+	}
+	l.out.Write(l.in[start:])
+}
+
+// requoteString turns a single-quoted string into a double-quoted one.
+func (l *lenientScanner) requoteString() {
+	end := l.pos + 1
+	for end < len(l.in) && l.in[end] != '\'' {
+		if l.in[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(l.in) {
+		l.out.Write(l.in[l.pos:]) // unterminated: leave it for the decoder
+		l.pos = len(l.in)
+		return
+	}
+	body := string(l.in[l.pos+1 : end])
+	body = strings.ReplaceAll(body, `\'`, `'`)
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c == '\\' && i+1 < len(body):
+			b.WriteByte(c)
+			b.WriteByte(body[i+1])
+			i++
+		case c == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	l.out.WriteByte('"')
+	l.out.WriteString(b.String())
+	l.out.WriteByte('"')
+	l.pos = end + 1
+	l.fix("single-quoted string")
+}
+
+// word handles a bare identifier: a literal, NaN or Infinity, or an
+// unquoted object key.
+func (l *lenientScanner) word() {
+	start := l.pos
+	for l.pos < len(l.in) && isIdentPart(l.in[l.pos]) {
+		l.pos++
+	}
+	w := string(l.in[start:l.pos])
+	switch w {
+	case "true", "false", "null":
+		l.out.WriteString(w)
+		return
+	case "NaN", "Infinity":
+		l.out.WriteString("null")
+		l.fix("NaN or Infinity → null")
+		return
+	}
+	i := l.pos
+	for i < len(l.in) && (l.in[i] == ' ' || l.in[i] == '\t') {
+		i++
+	}
+	if i < len(l.in) && l.in[i] == ':' {
+		fmt.Fprintf(&l.out, "%q", w)
+		l.fix("unquoted key")
+		return
+	}
+	l.out.WriteString(w) // leave it for the decoder to report
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c|0x20 >= 'a' && c|0x20 <= 'z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Broken-link audit (-link-check)
+
+   Checks every outbound http(s)
+   link in the content with a HEAD
+   (GET where HEAD is refused) and
+   treats 404, 410 and unknown hosts
+   as dead. Anything else – timeouts,
+   5xx, 403 – is left alone: the
+   link may well work for readers.
+
+   report   – log dead links only
+   annotate – mark them: a
+              data-broken-link
+              attribute and a
+              "[broken link]" note
+   archive  – point them at the
+              closest Wayback Machine
+              snapshot, if any
+   strip    – drop the anchor, keep
+              its text
+
+   Each URL is checked once per run.
+
+   -source-archive checks each
+   article's own link the same way
+   and, when it is dead, cites the
+   Wayback snapshot closest to the
+   publish date instead.
+--------------------------------*/
+
+const (
+	linkCheckTimeout = 10 * time.Second
+	linkCheckConns   = 16 // across all workers
+)
+
+type deadLink struct {
+	url     string
+	reason  string // "http 404", "no such host"
+	archive string // the snapshot it now points to, archive mode
+}
+
+type linkStatus struct {
+	dead string // reason, "" = alive or unknown
+	wait chan struct{}
+
+	looked  bool   // archive mode: snapshot looked up
+	archive string // the closest snapshot, if any
+}
+
+type LinkChecker struct {
+	mode       string
+	archiveAPI string
+	client     *http.Client
+	sem        chan struct{}
+
+	mu       sync.Mutex
+	checked  map[string]*linkStatus
+	found    int // dead links seen in articles
+	articles int // articles with at least one
+	archived int
+
+	deadSources     int // dead article links, -source-archive
+	archivedSources int // of which replaced by a snapshot
+}
+
+func NewLinkChecker(mode, archiveAPI string) (*LinkChecker, error) {
+	switch mode {
+	case "report", "annotate", "archive", "strip":
+	default:
+		return nil, fmt.Errorf("link-check %q: want off, report, annotate, archive or strip", mode)
+	}
+	c := newLinkProber(archiveAPI)
+	c.mode = mode
+	return c, nil
+}
+
+// newLinkProber returns a checker for -source-archive alone, without a
+// content mode.
+func newLinkProber(archiveAPI string) *LinkChecker {
+	return &LinkChecker{
+		archiveAPI: archiveAPI,
+		client:     &http.Client{Timeout: linkCheckTimeout},
+		sem:        make(chan struct{}, linkCheckConns),
+		checked:    make(map[string]*linkStatus),
+	}
+}
+
+// Apply checks the links of one article and rewrites the dead ones as
+// the mode says; they are listed in doc.deadLinks.
+func (c *LinkChecker) Apply(doc *renderDoc, toks []htmlToken) []htmlToken {
+	// start all of the article's checks, then wait for them
+	status := make(map[string]*linkStatus)
+	for _, t := range toks {
+		href, ok := t.attr("href")
+		if t.isTag("a") && ok && checkable(href) {
+			href = strings.TrimSpace(href)
+			if status[href] == nil {
+				status[href] = c.status(href)
+			}
+		}
+	}
+	for _, s := range status {
+		<-s.wait
+	}
+
+	out := make([]htmlToken, 0, len(toks))
+	dropEnd := map[int]bool{} // end tags of stripped anchors
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if dropEnd[i] {
+			continue
+		}
+		href, _ := t.attr("href")
+		s := status[strings.TrimSpace(href)]
+		if !t.isTag("a") || s == nil || s.dead == "" {
+			out = append(out, t)
+			continue
+		}
+		dl := deadLink{url: strings.TrimSpace(href), reason: s.dead}
+		switch c.mode {
+		case "report":
+			out = append(out, t)
+		case "annotate":
+			t.setAttr("data-broken-link", s.dead)
+			out = append(out, t)
+			if end := elementEnd(toks, i); end != i {
+				out = append(out, toks[i+1:end+1]...)
+				i = end
+			}
+			out = append(out, htmlToken{kind: htmlText, data: " [broken link]"})
+		case "archive":
+			if snap := c.snapshot(dl.url, "", s); snap != "" {
+				t.setAttr("href", snap)
+				dl.archive = snap
+			}
+			out = append(out, t)
+		case "strip":
+			if end := elementEnd(toks, i); end != i {
+				dropEnd[end] = true
+			}
+		}
+		doc.deadLinks = append(doc.deadLinks, dl)
+	}
+
+	if n := len(doc.deadLinks); n > 0 {
+		c.mu.Lock()
+		c.found += n
+		c.articles++
+		for _, dl := range doc.deadLinks {
+			if dl.archive != "" {
+				c.archived++
+			}
+		}
+		c.mu.Unlock()
+	}
+	return out
+}
+
+func checkable(href string) bool {
+	href = strings.ToLower(strings.TrimSpace(href))
+	return strings.HasPrefix(href, "http://") || strings.HasPrefix(href, "https://")
+}
+
+// status returns the (possibly still running) check of u, starting it if
+// this is the first time u is seen.
+func (c *LinkChecker) status(u string) *linkStatus {
+	c.mu.Lock()
+	s, ok := c.checked[u]
+	if !ok {
+		s = &linkStatus{wait: make(chan struct{})}
+		c.checked[u] = s
+	}
+	c.mu.Unlock()
+	if !ok {
+		go func() {
+			defer close(s.wait)
+			c.sem <- struct{}{}
+			defer func() { <-c.sem }()
+			s.dead = c.check(u)
+		}()
+	}
+	return s
+}
+
+// check returns why u is dead, or "" if it is not (known to be).
+func (c *LinkChecker) check(u string) string {
+	status, err := c.request(http.MethodHead, u)
+	switch status {
+	case http.StatusMethodNotAllowed, http.StatusForbidden, http.StatusNotImplemented:
+		status, err = c.request(http.MethodGet, u)
+	}
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return "no such host"
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return fmt.Sprintf("http %d", status)
+	}
+	return ""
+}
+
+func (c *LinkChecker) request(method, u string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), linkCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "transform-to-omnipub/"+toolVersion()+" (link check)")
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// archivedSource checks the article's link and, if it is dead, returns
+// the snapshot closest to the publish date ("" if there is none) and why
+// the link is dead.
+func (c *LinkChecker) archivedSource(a *Article) (snap, dead string) {
+	if !checkable(a.Link) {
+		return "", ""
+	}
+	link := strings.TrimSpace(a.Link)
+	s := c.status(link)
+	<-s.wait
+	if s.dead == "" {
+		return "", ""
+	}
+	var ts string
+	if d, ok := parseArticleDate(a.PublishDate); ok {
+		ts = d.UTC().Format("20060102")
+	}
+	snap = c.snapshot(link, ts, s)
+
+	c.mu.Lock()
+	c.deadSources++
+	if snap != "" {
+		c.archivedSources++
+	}
+	c.mu.Unlock()
+	return snap, s.dead
+}
+
+// snapshot returns the archived copy of u closest to ts (YYYYMMDD, "" =
+// now), asking the Wayback Machine availability API once per URL.
+func (c *LinkChecker) snapshot(u, ts string, s *linkStatus) string {
+	c.mu.Lock()
+	looked, snap := s.looked, s.archive
+	c.mu.Unlock()
+	if looked {
+		return snap
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), linkCheckTimeout)
+	defer cancel()
+	q := url.Values{"url": {u}}
+	if ts != "" {
+		q.Set("timestamp", ts)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.archiveAPI+"?"+q.Encode(), nil)
+	if err == nil {
+		if resp, err := c.client.Do(req); err == nil {
+			var out struct {
+				ArchivedSnapshots struct {
+					Closest struct {
+						Available bool   `json:"available"`
+						URL       string `json:"url"`
+					} `json:"closest"`
+				} `json:"archived_snapshots"`
+			}
+			if resp.StatusCode == http.StatusOK && json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&out) == nil {
+				if cl := out.ArchivedSnapshots.Closest; cl.Available && cl.URL != "" {
+					snap = strings.Replace(cl.URL, "http://web.archive.org/", "https://web.archive.org/", 1)
+				}
+			}
+			resp.Body.Close()
+		}
+	}
+
+	c.mu.Lock()
+	s.looked, s.archive = true, snap
+	c.mu.Unlock()
+	return snap
+}
+
+// report writes the run's dead-link totals and starts counting afresh;
+// URLs are checked again in the next run.
+func (c *LinkChecker) report(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.found > 0 {
+		fmt.Fprintf(w, "Dead links: %d in %d articles", c.found, c.articles)
+		switch c.mode {
+		case "annotate":
+			fmt.Fprint(w, ", annotated")
+		case "archive":
+			fmt.Fprintf(w, ", %d pointed at archived copies", c.archived)
+		case "strip":
+			fmt.Fprint(w, ", anchors removed")
+		}
+		fmt.Fprintln(w)
+	}
+	if c.deadSources > 0 {
+		fmt.Fprintf(w, "Dead sources: %d, %d cited as archived copies\n", c.deadSources, c.archivedSources)
+	}
+	c.found, c.articles, c.archived = 0, 0, 0
+	c.deadSources, c.archivedSources = 0, 0
+	c.checked = make(map[string]*linkStatus)
+}
+
+// logDeadLinks lists an article's dead links under its input file.
+func logDeadLinks(file string, links []deadLink) {
+	for _, l := range links {
+		if l.archive != "" {
+			log.Printf("DEAD  %s: %s (%s) → %s", file, l.url, l.reason, l.archive)
+		} else {
+			log.Printf("DEAD  %s: %s (%s)", file, l.url, l.reason)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+)
+
+/* -------------------------------
+   Reading collections back
+   (-export, -verify)
+
+   Instead of uploading, -export
+   writes the items of each
+   -collection to a file, one JSON
+   object per line, and -verify
+   checks that every file in the
+   -manifest is among the items
+   listed. -verify-out writes the
+   missing files for -retry (with
+   -reupload, as the manifest has
+   them), which uploads them again.
+
+   Both walk GET {api}/collections/
+   {id}/items page by page. With
+   -list-state the position is
+   saved after every page, so an
+   interrupted listing continues
+   where it stopped; a finished one
+   clears it.
+--------------------------------*/
+
+// listingState is what -list-state keeps between runs.
+type listingState struct {
+	Mode       string       `json:"mode"`       // export or verify
+	Collection int          `json:"collection"` // being listed; 0 = none
+	Position   listPosition `json:"position"`
+	Exported   int          `json:"exported,omitempty"` // items written so far
+	Found      []string     `json:"found,omitempty"`    // -verify: files seen so far
+}
+
+func loadListingState(path, mode string) (listingState, error) {
+	var b []byte
+	var err error
+	if isRemoteState(path) {
+		b, err = getRemoteState(path)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	var st listingState
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	} else if err != nil {
+		return st, fmt.Errorf("list state: %w", err)
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, fmt.Errorf("list state %s: %w", path, err)
+	}
+	if st.Collection != 0 && st.Mode != mode {
+		return st, fmt.Errorf("list state %s holds an unfinished -%s; finish it or remove the state", path, st.Mode)
+	}
+	return st, nil
+}
+
+func saveListingState(path string, st listingState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if isRemoteState(path) {
+		return putRemoteState(path, b)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// clearListingState records that no listing is in progress.
+func clearListingState(path string) error {
+	if isRemoteState(path) {
+		return putRemoteState(path, []byte("{}"))
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// listCollections calls page with the items of each collection, page by
+// page, starting where st left off, and saves st to statePath (if set)
+// after each page. It returns an exit code.
+func (t *Transformer) listCollections(ctx context.Context, collections []int, st *listingState, statePath string, page func(id int, items []map[string]any) error) int {
+	start := 0
+	if st.Collection != 0 {
+		start = slices.Index(collections, st.Collection)
+		if start < 0 {
+			log.Printf("list state %s is for collection %d, which is not in -collection", statePath, st.Collection)
+			return exitSetup
+		}
+		log.Printf("Resuming the listing of collection %d after %d items", st.Collection, st.Position.Seen)
+	}
+	for _, id := range collections[start:] {
+		var p *pager
+		var err error
+		if id == st.Collection {
+			p, err = t.resumePager(st.Position)
+		} else {
+			p, err = t.newPager(fmt.Sprintf("/collections/%d/items", id), nil)
+		}
+		if err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		for !p.done() {
+			if ctx.Err() != nil {
+				log.Println("Interrupted – the listing resumes from here with the same -list-state")
+				return exitAborted
+			}
+			items, err := p.next(ctx)
+			if err != nil {
+				log.Printf("Listing collection %d: %v", id, err)
+				if statePath != "" {
+					log.Println("The listing resumes from the last page with the same -list-state")
+				}
+				return exitAborted
+			}
+			if err := page(id, items); err != nil {
+				log.Print(err)
+				return exitAborted
+			}
+			st.Collection, st.Position = id, p.position()
+			if statePath == "" {
+				continue
+			}
+			if err := saveListingState(statePath, *st); err != nil {
+				log.Printf("Error saving list state: %v", err)
+				return exitAborted
+			}
+		}
+	}
+	if statePath != "" {
+		if err := clearListingState(statePath); err != nil {
+			log.Printf("Error clearing list state: %v", err)
+		}
+	}
+	return exitOK
+}
+
+// exportItems writes the items of collections to out as JSON lines.
+func (t *Transformer) exportItems(ctx context.Context, collections []int, out, statePath string) int {
+	st := listingState{Mode: "export"}
+	if statePath != "" {
+		var err error
+		if st, err = loadListingState(statePath, "export"); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		st.Mode = "export"
+	}
+	// a resumed export appends to what the interrupted one wrote
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if st.Collection != 0 {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(out, flags, 0o644)
+	if err != nil {
+		log.Print(err)
+		return exitSetup
+	}
+	defer f.Close()
+
+	code := t.listCollections(ctx, collections, &st, statePath, func(id int, items []map[string]any) error {
+		w := bufio.NewWriter(f)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		for _, item := range items {
+			if _, ok := item["collection_id"]; !ok {
+				item["collection_id"] = id
+			}
+			if err := enc.Encode(item); err != nil {
+				return err
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		// on disk before the state moves past the page
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		st.Exported += len(items)
+		return nil
+	})
+	if code == exitOK {
+		log.Printf("Exported %d items to %s", st.Exported, out)
+	}
+	return code
+}
+
+// verifyItems checks that every file in manifest with an item URL is
+// listed in one of collections, and writes the missing ones to out.
+func (t *Transformer) verifyItems(ctx context.Context, collections []int, manifest *Manifest, out, statePath string) int {
+	st := listingState{Mode: "verify"}
+	if statePath != "" {
+		var err error
+		if st, err = loadListingState(statePath, "verify"); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		st.Mode = "verify"
+	}
+
+	// files by item URL and by the URL's last segment, which is usually
+	// the item ID
+	byURL := make(map[string][]string)
+	byID := make(map[string][]string)
+	var want []string
+	unknown := 0
+	manifest.mu.Lock()
+	for _, e := range manifest.latest {
+		if len(e.URLs) == 0 {
+			unknown++
+			continue
+		}
+		want = append(want, e.Path)
+		for _, u := range e.URLs {
+			byURL[u] = append(byURL[u], e.Path)
+			byID[path.Base(u)] = append(byID[path.Base(u)], e.Path)
+		}
+	}
+	manifest.mu.Unlock()
+	slices.Sort(want)
+	found := make(map[string]bool)
+	for _, p := range st.Found {
+		found[p] = true
+	}
+
+	code := t.listCollections(ctx, collections, &st, statePath, func(_ int, items []map[string]any) error {
+		for _, item := range items {
+			// the URL when the item has one; the ID only matches otherwise
+			var matches []string
+			if u, _ := item["url"].(string); u != "" {
+				matches = byURL[u]
+			} else {
+				switch id := item["id"].(type) {
+				case string:
+					matches = byID[id]
+				case float64:
+					matches = byID[strconv.FormatFloat(id, 'f', -1, 64)]
+				}
+			}
+			for _, p := range matches {
+				if !found[p] {
+					found[p] = true
+					st.Found = append(st.Found, p)
+				}
+			}
+		}
+		return nil
+	})
+	if code != exitOK {
+		return code
+	}
+
+	var missing []string
+	for _, p := range want {
+		if !found[p] {
+			missing = append(missing, p)
+			log.Printf("MISSING %s → %s", p, manifest.latest[p].URLs[0])
+		}
+	}
+	fmt.Printf("Verified %d files: %d listed, %d missing", len(want), len(want)-len(missing), len(missing))
+	if unknown > 0 {
+		fmt.Printf(" (%d more have no item URL to check)", unknown)
+	}
+	fmt.Println()
+	if out != "" && len(missing) > 0 {
+		if err := saveFilesToFile(out, missing); err != nil {
+			log.Printf("Error writing -verify-out: %v", err)
+		} else {
+			fmt.Printf("Missing files saved to %s – upload them again with -retry %s -reupload\n", out, out)
+		}
+	}
+	if len(missing) > 0 {
+		return exitFailures
+	}
+	return exitOK
+}
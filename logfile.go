@@ -0,0 +1,160 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Log file (-log-file)
+
+   -log-file run.log sends the log
+   there instead of stderr. The file
+   is rotated when it would grow
+   past -log-max-size or is older
+   than -log-rotate:
+
+   run.log                      current
+   run-20240502T020000.000.log  rotated
+
+   -log-keep rotated files are kept,
+   the oldest are deleted.
+--------------------------------*/
+
+const logStamp = "20060102T150405.000"
+
+type RotatingLog struct {
+	path    string
+	maxSize int64         // 0 = no size limit
+	every   time.Duration // 0 = no time limit
+	keep    int           // rotated files kept, 0 = all
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+func NewRotatingLog(path string, maxSize int64, every time.Duration, keep int) (*RotatingLog, error) {
+	if keep < 0 {
+		return nil, fmt.Errorf("log-keep %d: want 0 or more", keep)
+	}
+	l := &RotatingLog{path: path, maxSize: maxSize, every: every, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open appends to the log file, counting its age from its first write.
+func (l *RotatingLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size, l.opened = f, st.Size(), time.Now()
+	if st.Size() > 0 {
+		l.opened = firstLogTime(l.path, st.ModTime())
+	}
+	return nil
+}
+
+// firstLogTime reads the timestamp of a log file's first line, or returns
+// fallback.
+func firstLogTime(path string, fallback time.Time) time.Time {
+	const layout = "2006/01/02 15:04:05" // log.LstdFlags
+	f, err := os.Open(path)
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+	b := make([]byte, len(layout))
+	if _, err := io.ReadFull(f, b); err != nil {
+		return fallback
+	}
+	if t, err := time.ParseInLocation(layout, string(b), time.Local); err == nil {
+		return t
+	}
+	return fallback
+}
+
+func (l *RotatingLog) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	due := l.every > 0 && time.Since(l.opened) >= l.every
+	if l.size > 0 && (due || (l.maxSize > 0 && l.size+int64(len(b)) > l.maxSize)) {
+		if err := l.rotate(); err != nil {
+			// keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "log-file: rotating %s: %v\n", l.path, err)
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file after the time it was rotated and starts
+// a new one.
+func (l *RotatingLog) rotate() error {
+	ext := filepath.Ext(l.path)
+	base := strings.TrimSuffix(l.path, ext) + "-" + time.Now().Format(logStamp)
+	dst := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(dst); os.IsNotExist(err) {
+			break
+		}
+		dst = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path, dst); err != nil {
+		l.open()
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	l.prune()
+	return nil
+}
+
+// prune deletes the oldest rotated files beyond keep.
+func (l *RotatingLog) prune() {
+	if l.keep == 0 {
+		return
+	}
+	dir, name := filepath.Split(l.path)
+	ext := filepath.Ext(name)
+	prefix := strings.TrimSuffix(name, ext) + "-"
+	entries, err := os.ReadDir(cmp.Or(dir, "."))
+	if err != nil {
+		return
+	}
+	var old []string
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !strings.HasSuffix(rest, ext) || len(rest) < len(logStamp) {
+			continue
+		}
+		if _, err := time.Parse(logStamp, rest[:len(logStamp)]); err == nil {
+			old = append(old, e.Name())
+		}
+	}
+	slices.Sort(old) // the stamp sorts by time
+	for _, f := range old[:max(0, len(old)-l.keep)] {
+		os.Remove(filepath.Join(dir, f))
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   transform login
+
+   Gets a token into the OS keychain
+   (keychain.go, or a file with
+   -no-keychain) so analysts never
+   handle raw keys:
+
+   - paste:  prompts for the token
+             with echo off
+   - device: OAuth 2.0 device
+             authorization grant
+             (RFC 8628), when
+             -device-url is given;
+             a refresh token is
+             kept and used
+
+   Later runs with the same -profile
+   (or none) read it from the
+   keychain when no other key source
+   is configured.
+--------------------------------*/
+
+const loginUsage = `usage:
+  transform login [-profile NAME] [-no-keychain]
+  transform login [-profile NAME] [-no-keychain] -device-url URL -token-url URL [-client-id ID] [-scope S]`
+
+// loginMain implements "transform login"; it returns the exit code.
+func loginMain(args []string) int {
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	profile := fs.String("profile", os.Getenv("OMNIPUB_PROFILE"), "Profile to store the token for")
+	deviceURL := fs.String("device-url", "", "OAuth device authorization endpoint; enables the device flow")
+	tokenURL := fs.String("token-url", "", "OAuth token endpoint for the device flow")
+	clientID := fs.String("client-id", "transform-to-omnipub", "OAuth client ID for the device flow")
+	scope := fs.String("scope", "", "OAuth scope to request in the device flow")
+	noKeychain := fs.Bool("no-keychain", os.Getenv("OMNIPUB_NO_KEYCHAIN") != "", "Store the token in an owner-only file instead of the OS keychain")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 || (*deviceURL == "") != (*tokenURL == "") {
+		fmt.Fprintln(os.Stderr, loginUsage)
+		return exitSetup
+	}
+
+	var token loginToken
+	var err error
+	if *deviceURL != "" {
+		token, err = deviceLogin(context.Background(), *deviceURL, *tokenURL, *clientID, *scope)
+	} else {
+		token.AccessToken, err = readSecret("Paste your Omnipub API token: ")
+	}
+	if err == nil && token.AccessToken == "" {
+		err = errors.New("empty token")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "login: %v\n", err)
+		return exitSetup
+	}
+
+	account := loginAccount(*profile)
+	store := newTokenStore(*noKeychain)
+	if err := store.set(account, token.encode()); err != nil {
+		fmt.Fprintf(os.Stderr, "login: storing token in %s: %v\n", store, err)
+		if !*noKeychain {
+			fmt.Fprintln(os.Stderr, "(no keychain on this machine? use -no-keychain)")
+		}
+		return exitSetup
+	}
+	fmt.Fprintf(os.Stderr, "Token stored in %s for profile %q.\n", store, account)
+	if token.RefreshToken != "" {
+		fmt.Fprintln(os.Stderr, "Runs refresh it with the stored refresh token when it expires.")
+	}
+	return exitOK
+}
+
+// readSecret prompts on stderr and reads one line from stdin, with terminal
+// echo off where stty is available.
+func readSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if st, err := os.Stdin.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 && runtime.GOOS != "windows" {
+		stty := func(arg string) {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = os.Stdin
+			_ = cmd.Run()
+		}
+		stty("-echo")
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
+}
+
+type deviceAuth struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+	Description  string `json:"error_description"`
+}
+
+// loginToken turns a successful answer into a stored entry.
+func (tr tokenResponse) loginToken(tokenURL, clientID string) loginToken {
+	lt := loginToken{AccessToken: tr.AccessToken, RefreshToken: tr.RefreshToken, TokenURL: tokenURL, ClientID: clientID}
+	if tr.ExpiresIn > 0 {
+		lt.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second).Round(time.Second)
+	}
+	return lt
+}
+
+// deviceLogin runs the device authorization grant and returns the tokens
+// once the user has approved it in a browser.
+func deviceLogin(ctx context.Context, deviceURL, tokenURL, clientID, scope string) (loginToken, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	form := url.Values{"client_id": {clientID}}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	var da deviceAuth
+	if status, err := postForm(ctx, client, deviceURL, form, &da); err != nil {
+		return loginToken{}, err
+	} else if status != http.StatusOK || da.DeviceCode == "" {
+		return loginToken{}, fmt.Errorf("device authorization: http %d", status)
+	}
+
+	if da.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "Open %s\nand confirm the code %s.\n", da.VerificationURIComplete, da.UserCode)
+	} else {
+		fmt.Fprintf(os.Stderr, "Open %s\nand enter the code %s.\n", da.VerificationURI, da.UserCode)
+	}
+	interval := time.Duration(max(da.Interval, 5)) * time.Second
+	deadline := time.Now().Add(time.Duration(max(da.ExpiresIn, 60)) * time.Second)
+
+	poll := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {da.DeviceCode},
+		"client_id":   {clientID},
+	}
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		var tr tokenResponse
+		status, err := postForm(ctx, client, tokenURL, poll, &tr)
+		if err != nil {
+			return loginToken{}, err
+		}
+		switch {
+		case status == http.StatusOK && tr.AccessToken != "":
+			return tr.loginToken(tokenURL, clientID), nil
+		case tr.Error == "authorization_pending":
+		case tr.Error == "slow_down":
+			interval += 5 * time.Second
+		case tr.Error != "":
+			return loginToken{}, fmt.Errorf("device login: %s %s", tr.Error, tr.Description)
+		default:
+			return loginToken{}, fmt.Errorf("token endpoint: http %d", status)
+		}
+	}
+	return loginToken{}, errors.New("device login: code expired before it was confirmed")
+}
+
+// refreshLogin trades lt's refresh token for a new access token
+// (RFC 6749 section 6). The refresh token is kept unless the server
+// rotates it.
+func refreshLogin(ctx context.Context, lt loginToken) (loginToken, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {lt.RefreshToken},
+		"client_id":     {lt.ClientID},
+	}
+	var tr tokenResponse
+	status, err := postForm(ctx, client, lt.TokenURL, form, &tr)
+	switch {
+	case err != nil:
+		return loginToken{}, err
+	case status == http.StatusOK && tr.AccessToken != "":
+	case tr.Error != "":
+		return loginToken{}, fmt.Errorf("token refresh: %s %s", tr.Error, tr.Description)
+	default:
+		return loginToken{}, fmt.Errorf("token endpoint: http %d", status)
+	}
+	if tr.RefreshToken == "" {
+		tr.RefreshToken = lt.RefreshToken
+	}
+	return tr.loginToken(lt.TokenURL, lt.ClientID), nil
+}
+
+// postForm POSTs form to u and decodes the JSON answer into out, whatever
+// the status.
+func postForm(ctx context.Context, client *http.Client, u string, form url.Values, out any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("%s: http %d: %w", req.URL.Redacted(), resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
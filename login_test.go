@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStoredKeyRefresh(t *testing.T) {
+	var forms []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.Form.Encode())
+		if r.Form.Get("refresh_token") != "r1" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "a2", "refresh_token": "r2", "expires_in": 3600})
+	}))
+	defer srv.Close()
+
+	store := tokenDir(t.TempDir())
+	expired := loginToken{AccessToken: "a1", RefreshToken: "r1", TokenURL: srv.URL, ClientID: "cli", Expiry: time.Now().Add(-time.Second)}
+	if err := store.set("default", expired.encode()); err != nil {
+		t.Fatal(err)
+	}
+	k := newStoredKey(store, "default")
+	key, err := k.Key(context.Background())
+	if err != nil || key != "a2" {
+		t.Fatalf("Key = %q, %v; want the refreshed a2", key, err)
+	}
+	if want := "client_id=cli&grant_type=refresh_token&refresh_token=r1"; len(forms) != 1 || forms[0] != want {
+		t.Errorf("token requests %q, want [%q]", forms, want)
+	}
+	// the rotated refresh token is stored; a fresh token is not refreshed
+	raw, _ := store.get("default")
+	if lt := parseLoginToken(raw); lt.AccessToken != "a2" || lt.RefreshToken != "r2" || time.Until(lt.Expiry) < 59*time.Minute {
+		t.Errorf("stored %+v", lt)
+	}
+	if key, err := k.Key(context.Background()); key != "a2" || err != nil || len(forms) != 1 {
+		t.Errorf("second Key = %q, %v after %d requests", key, err, len(forms))
+	}
+
+	// a rejected refresh of an expired token says to log in again
+	expired.RefreshToken = "gone"
+	store.set("default", expired.encode())
+	if _, err := k.Key(context.Background()); err == nil {
+		t.Error("Key with a rejected refresh token: want an error")
+	}
+}
+
+func TestParseLoginToken(t *testing.T) {
+	for raw, want := range map[string]loginToken{
+		"plain-token":          {AccessToken: "plain-token"},
+		`{"access_token":"a"}`: {AccessToken: "a"},
+		`{"not":"ours"}`:       {AccessToken: `{"not":"ours"}`},
+		`{"access_token":"a","refresh_token":"r"}`: {AccessToken: "a", RefreshToken: "r"},
+	} {
+		if got := parseLoginToken(raw); got != want {
+			t.Errorf("parseLoginToken(%q) = %+v, want %+v", raw, got, want)
+		}
+	}
+	if got := (loginToken{AccessToken: "a", Expiry: time.Now()}).encode(); got != "a" {
+		t.Errorf("a token without a refresh token encodes as %q", got)
+	}
+}
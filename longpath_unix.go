@@ -0,0 +1,6 @@
+//go:build !windows
+
+package main
+
+// nativePath is p with the platform's separators.
+func nativePath(p string) string { return p }
@@ -0,0 +1,20 @@
+package main
+
+import "path/filepath"
+
+// maxRelPath is the longest relative path Windows opens without long-path
+// support (MAX_PATH less room for an 8.3 file name).
+const maxRelPath = 248
+
+// nativePath is p with backslashes. Long relative paths are made
+// absolute: the os package opens absolute paths of any length, relative
+// ones only up to MAX_PATH.
+func nativePath(p string) string {
+	p = filepath.FromSlash(p)
+	if len(p) >= maxRelPath && !filepath.IsAbs(p) {
+		if abs, err := filepath.Abs(p); err == nil {
+			return abs
+		}
+	}
+	return p
+}
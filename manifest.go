@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Success manifest (-manifest)
+
+   One JSON line per file uploaded
+   successfully, appended across
+   runs:
+
+   {"ts":…,"run_id":…,"path":…,
+    "sha256":…,"size":…,"urls":[…]}
+
+   plus the API bases used and,
+   with -near-dup, the article's
+   simhash.
+
+   Before a run, files whose latest
+   entry has the same size and
+   content hash are skipped, so
+   re-running a whole directory only
+   uploads what is new or changed.
+   -reupload turns the skipping off
+   (successes are still recorded).
+
+   A multi-item file (input plugin,
+   EPUB …) with failed items gets a
+   "partial" entry listing the items
+   that made it, by hash. The file
+   is not skipped, but as long as
+   its content is the same, the next
+   run sends only the other items,
+   so retries do not duplicate them.
+
+   An s3:// or gs:// manifest is
+   downloaded to a temporary file at
+   startup and uploaded whenever new
+   entries are recorded, at most
+   every manifestSyncEvery, and
+   once more on exit.
+--------------------------------*/
+
+const manifestSyncEvery = 30 * time.Second
+
+type manifestEntry struct {
+	Time   time.Time `json:"ts"`
+	RunID  string    `json:"run_id"`
+	Path   string    `json:"path"` // absolute
+	SHA256 string    `json:"sha256"`
+	Size   int64     `json:"size"`
+	URLs   []string  `json:"urls,omitempty"` // published items, if the API said
+
+	Endpoints []string `json:"endpoints,omitempty"` // API bases that took the uploads
+	Simhash   string   `json:"simhash,omitempty"`   // -near-dup fingerprint, hex
+
+	Partial bool     `json:"partial,omitempty"` // some items failed
+	Items   []string `json:"items,omitempty"`   // with Partial: the items uploaded (itemSum)
+}
+
+type Manifest struct {
+	mu     sync.Mutex
+	f      *os.File
+	latest map[string]manifestEntry // by absolute path
+
+	remote   remoteObject // set for an s3:// or gs:// manifest kept in f
+	dirty    bool         // f has entries remote lacks
+	stopSync func()
+}
+
+// OpenManifest loads the entries recorded so far and opens path for
+// appending.
+func OpenManifest(path string) (*Manifest, error) {
+	if isRemoteState(path) {
+		return openRemoteManifest(path)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return loadManifest(path, f)
+}
+
+// openRemoteManifest mirrors an object-store manifest in a temporary file.
+func openRemoteManifest(path string) (*Manifest, error) {
+	obj, err := remoteState(path)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	b, err := obj.Get(ctx)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "omnipub-manifest-*.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(b); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	m, err := loadManifest(path, f)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	m.remote = obj
+	m.stopSync = every(manifestSyncEvery, func() {
+		if err := m.sync(); err != nil {
+			log.Printf("Error saving manifest: %v", err)
+		}
+	})
+	log.Printf("Manifest %s: %d files recorded", obj, len(m.latest))
+	return m, nil
+}
+
+func loadManifest(path string, f *os.File) (*Manifest, error) {
+	m := &Manifest{f: f, latest: make(map[string]manifestEntry)}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	bad := 0
+	for sc.Scan() {
+		var e manifestEntry
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil || e.Path == "" {
+			bad++
+			continue
+		}
+		m.latest[e.Path] = e
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("manifest %s: %w", path, err)
+	}
+	if bad > 0 {
+		log.Printf("Warning: manifest %s: ignored %d unreadable lines", path, bad)
+	}
+	return m, nil
+}
+
+// Unchanged splits off files already uploaded with the same content.
+func (m *Manifest) Unchanged(files []string) (keep []string, skipped []skippedFile) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		prev, ok := m.latest[abs]
+		if err != nil || !ok || prev.Partial {
+			keep = append(keep, f)
+			continue
+		}
+		// the size settles most changes without reading the file
+		if st, err := os.Stat(f); err != nil || st.Size() != prev.Size {
+			keep = append(keep, f)
+			continue
+		}
+		if sum, _, err := hashFile(f); err != nil || sum != prev.SHA256 {
+			keep = append(keep, f)
+			continue
+		}
+		skipped = append(skipped, skippedFile{f, "unchanged", "uploaded unchanged in run " + prev.RunID})
+	}
+	return keep, skipped
+}
+
+// Record appends a successful upload of file, hashed before it was sent.
+func (m *Manifest) Record(runID, file, sum string, size int64, res *uploadResult) error {
+	return m.record(runID, file, sum, size, res, false)
+}
+
+// RecordPartial appends the items of file that were uploaded when others
+// failed.
+func (m *Manifest) RecordPartial(runID, file, sum string, size int64, res *uploadResult) error {
+	return m.record(runID, file, sum, size, res, true)
+}
+
+func (m *Manifest) record(runID, file, sum string, size int64, res *uploadResult, partial bool) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	e := manifestEntry{
+		Time: time.Now().UTC(), RunID: runID, Path: abs, SHA256: sum, Size: size,
+		URLs: res.URLs(), Endpoints: res.Endpoints(), Simhash: res.Fingerprint(),
+		Partial: partial,
+	}
+	if partial {
+		e.Items = res.Items()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// the items an earlier partial entry has were not sent again
+	if prev, ok := m.latest[abs]; ok && prev.Partial && prev.SHA256 == sum {
+		for _, u := range prev.URLs {
+			if !slices.Contains(e.URLs, u) {
+				e.URLs = append(e.URLs, u)
+			}
+		}
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := m.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	m.latest[abs] = e
+	m.dirty = true
+	return nil
+}
+
+// uploadedItems returns the items of file already uploaded by runs that
+// left it partial, if its content is still sum; nil if there are none.
+func (m *Manifest) uploadedItems(file, sum string) map[string]bool {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev, ok := m.latest[abs]
+	if !ok || !prev.Partial || prev.SHA256 != sum || len(prev.Items) == 0 {
+		return nil
+	}
+	items := make(map[string]bool, len(prev.Items))
+	for _, s := range prev.Items {
+		items[s] = true
+	}
+	return items
+}
+
+type uploadedItemsKey struct{}
+
+// withUploadedItems tells processFile which items of a multi-item file
+// not to send again.
+func withUploadedItems(ctx context.Context, items map[string]bool) context.Context {
+	return context.WithValue(ctx, uploadedItemsKey{}, items)
+}
+
+func uploadedItemsFrom(ctx context.Context) map[string]bool {
+	items, _ := ctx.Value(uploadedItemsKey{}).(map[string]bool)
+	return items
+}
+
+// itemSum identifies an item of a multi-item file across runs: the hash
+// of the article as decoded.
+func itemSum(a *Article) string {
+	sum := articleSum(a)
+	return hex.EncodeToString(sum[:16])
+}
+
+// sync uploads a remote manifest if entries were recorded since the last
+// upload.
+func (m *Manifest) sync() error {
+	m.mu.Lock()
+	if m.remote == nil || !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	b, err := os.ReadFile(m.f.Name())
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	m.dirty = false
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if err := m.remote.Put(ctx, b); err != nil {
+		m.mu.Lock()
+		m.dirty = true
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Close uploads a remote manifest a last time.
+func (m *Manifest) Close() error {
+	if m.remote == nil {
+		return m.f.Close()
+	}
+	m.stopSync()
+	err := m.sync()
+	m.f.Close()
+	os.Remove(m.f.Name())
+	return err
+}
+
+// hashFile returns the SHA-256 and size of a file's content.
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestManifestPartialItems(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.jsonl")
+	file := filepath.Join(dir, "feed.xml")
+	if err := os.WriteFile(file, []byte("<rss>…</rss>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sum, size, err := hashFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := &Article{Title: "a"}, &Article{Title: "b"}
+
+	m, err := OpenManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a made it, b failed
+	res := &uploadResult{}
+	res.add("http://api", "http://api/a", "1")
+	res.addItem(itemSum(a))
+	if err := m.RecordPartial("run1", file, sum, size, res); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+
+	m, err = OpenManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if keep, _ := m.Unchanged([]string{file}); len(keep) != 1 {
+		t.Errorf("partial file skipped as unchanged")
+	}
+	items := m.uploadedItems(file, sum)
+	if !items[itemSum(a)] || items[itemSum(b)] {
+		t.Errorf("uploadedItems = %v, want only a", items)
+	}
+	if items := m.uploadedItems(file, "sum2"); items != nil {
+		t.Errorf("uploadedItems of changed file = %v, want none", items)
+	}
+
+	// the retry sends b only; the entry keeps a's URL
+	res = &uploadResult{}
+	res.add("http://api", "http://api/b", "2")
+	if err := m.Record("run2", file, sum, size, res); err != nil {
+		t.Fatal(err)
+	}
+	if keep, _ := m.Unchanged([]string{file}); len(keep) != 0 {
+		t.Errorf("uploaded file not skipped as unchanged")
+	}
+	abs, _ := filepath.Abs(file)
+	if urls := m.latest[abs].URLs; !slices.Equal(urls, []string{"http://api/b", "http://api/a"}) {
+		t.Errorf("URLs = %v", urls)
+	}
+	if items := m.uploadedItems(file, sum); items != nil {
+		t.Errorf("uploadedItems after success = %v, want none", items)
+	}
+}
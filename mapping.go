@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   Field mapping (-map-expr)
+
+   Maps arbitrary source JSON onto
+   the Article model using jq-style
+   path expressions:
+
+     title=.post.headline
+     content=.body.sections[0].html
+     link=.canonical // .permalink
+--------------------------------*/
+
+// pathStep is a single selector in a path: either an object key or an
+// array index (negative indexes count from the end).
+type pathStep struct {
+	key   string
+	index int
+	isIdx bool
+}
+
+// pathExpr is a list of alternatives separated by "//"; the first one
+// that resolves to a non-null value wins.
+type pathExpr struct {
+	src  string
+	alts [][]pathStep
+}
+
+type fieldMapping struct {
+	field string
+	expr  *pathExpr
+}
+
+// Mapper applies compiled -map-expr rules to a decoded JSON document.
+type Mapper struct {
+	rules []fieldMapping
+}
+
+// articleFields lists the Article fields addressable by -map-expr,
+// keyed by their JSON names.
+var articleFields = map[string]func(a *Article) *string{
+	"title":          func(a *Article) *string { return &a.Title },
+	"content":        func(a *Article) *string { return &a.Content },
+	"excerpt":        func(a *Article) *string { return &a.Excerpt },
+	"link":           func(a *Article) *string { return &a.Link },
+	"published_date": func(a *Article) *string { return &a.PublishDate },
+	"updated_date":   func(a *Article) *string { return &a.UpdatedDate },
+	"author":         func(a *Article) *string { return &a.Author },
+	"image":          func(a *Article) *string { return &a.Image },
+}
+
+// NewMapper compiles "field=expr" rules.
+func NewMapper(specs []string) (*Mapper, error) {
+	m := &Mapper{}
+	for _, spec := range specs {
+		field, src, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("map-expr %q: want field=expr", spec)
+		}
+		field = strings.TrimSpace(field)
+		if _, known := articleFields[field]; !known {
+			return nil, fmt.Errorf("map-expr %q: unknown field %q", spec, field)
+		}
+		expr, err := parsePathExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("map-expr %q: %w", spec, err)
+		}
+		m.rules = append(m.rules, fieldMapping{field: field, expr: expr})
+	}
+	return m, nil
+}
+
+// Decode builds an Article from raw JSON. Top-level string fields that
+// match the Article schema, and the collection and tag fields, are taken as
+// defaults; mapped fields override them. Unlike plain decoding, non-string
+// values under schema keys are tolerated since the source shape is
+// arbitrary.
+func (m *Mapper) Decode(raw []byte) (Article, error) {
+	var a Article
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return a, err
+	}
+	if obj, ok := doc.(map[string]any); ok {
+		for name, field := range articleFields {
+			if s, ok := obj[name].(string); ok {
+				*field(&a) = s
+			}
+		}
+		a.Collections = jsonCollections(obj["collections"])
+		a.CollectionID = refFromJSON(obj["collection_id"])
+		a.Collection = refFromJSON(obj["collection"])
+		a.Tags = termsFromJSON(obj["tags"])
+		a.Categories = termsFromJSON(obj["categories"])
+	}
+	return a, m.Apply(doc, &a)
+}
+
+// Apply overwrites the mapped fields of a with values extracted from doc.
+// Fields without a rule keep whatever the default decoding produced.
+func (m *Mapper) Apply(doc any, a *Article) error {
+	for _, r := range m.rules {
+		v, err := r.expr.eval(doc)
+		if err != nil {
+			return fmt.Errorf("map-expr %s=%s: %w", r.field, r.expr.src, err)
+		}
+		*articleFields[r.field](a) = stringify(v)
+	}
+	return nil
+}
+
+func (p *pathExpr) eval(doc any) (any, error) {
+	var lastErr error
+	for _, steps := range p.alts {
+		v, err := walkPath(doc, steps)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if v != nil {
+			return v, nil
+		}
+	}
+	if lastErr != nil && len(p.alts) == 1 {
+		return nil, lastErr
+	}
+	return nil, nil
+}
+
+func walkPath(v any, steps []pathStep) (any, error) {
+	for _, s := range steps {
+		switch cur := v.(type) {
+		case nil:
+			return nil, nil
+		case map[string]any:
+			if s.isIdx {
+				return nil, fmt.Errorf("cannot index object with number")
+			}
+			v = cur[s.key]
+		case []any:
+			if !s.isIdx {
+				return nil, fmt.Errorf("cannot index array with %q", s.key)
+			}
+			i := s.index
+			if i < 0 {
+				i += len(cur)
+			}
+			if i < 0 || i >= len(cur) {
+				v = nil
+			} else {
+				v = cur[i]
+			}
+		default:
+			return nil, fmt.Errorf("cannot index %T", cur)
+		}
+	}
+	return v, nil
+}
+
+// stringify renders a JSON value as an Article field: strings verbatim,
+// scalars via their JSON text, and arrays of strings joined by newlines.
+func stringify(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []any:
+		parts := make([]string, 0, len(x))
+		for _, e := range x {
+			s, ok := e.(string)
+			if !ok {
+				b, _ := json.Marshal(x)
+				return string(b)
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, "\n")
+	default:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+}
+
+// parsePathExpr parses ".a.b[0]", `.["odd key"]`, ".a // .b" and ".".
+func parsePathExpr(src string) (*pathExpr, error) {
+	p := &pathExpr{src: strings.TrimSpace(src)}
+	for _, alt := range strings.Split(p.src, "//") {
+		steps, err := parsePath(strings.TrimSpace(alt))
+		if err != nil {
+			return nil, err
+		}
+		p.alts = append(p.alts, steps)
+	}
+	return p, nil
+}
+
+func parsePath(s string) ([]pathStep, error) {
+	if s == "" || s[0] != '.' {
+		return nil, fmt.Errorf("path %q must start with '.'", s)
+	}
+	var steps []pathStep
+	i := 0
+	for i < len(s) {
+		switch s[i] {
+		case '.':
+			i++
+			if i >= len(s) || s[i] == '[' {
+				continue
+			}
+			if s[i] == '"' {
+				key, n, err := readQuoted(s[i:])
+				if err != nil {
+					return nil, err
+				}
+				steps = append(steps, pathStep{key: key})
+				i += n
+				continue
+			}
+			j := i
+			for j < len(s) && s[j] != '.' && s[j] != '[' {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("path %q: empty key", s)
+			}
+			steps = append(steps, pathStep{key: s[i:j]})
+			i = j
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("path %q: unclosed '['", s)
+			}
+			inner := strings.TrimSpace(s[i+1 : i+end])
+			if strings.HasPrefix(inner, `"`) {
+				key, err := strconv.Unquote(inner)
+				if err != nil {
+					return nil, fmt.Errorf("path %q: %v", s, err)
+				}
+				steps = append(steps, pathStep{key: key})
+			} else {
+				n, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("path %q: bad index %q", s, inner)
+				}
+				steps = append(steps, pathStep{index: n, isIdx: true})
+			}
+			i += end + 1
+		default:
+			return nil, fmt.Errorf("path %q: unexpected %q", s, s[i])
+		}
+	}
+	return steps, nil
+}
+
+func readQuoted(s string) (string, int, error) {
+	for j := 1; j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == '"' {
+			key, err := strconv.Unquote(s[:j+1])
+			return key, j + 1, err
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string in %q", s)
+}
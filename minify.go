@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+)
+
+/* -------------------------------
+   HTML minification (-minify)
+
+   Conservative: drops comments
+   (except IE conditionals), collapses
+   whitespace runs in text to a single
+   space, and removes whitespace-only
+   text next to block-level tags.
+   Content of pre, textarea, script
+   and style is left alone.
+--------------------------------*/
+
+var preserveWSElems = map[string]bool{"pre": true, "textarea": true, "script": true, "style": true}
+
+var blockElems = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true, "dd": true,
+	"details": true, "div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "head": true, "header": true, "hr": true, "html": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "section": true, "summary": true,
+	"table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"tr": true, "ul": true, "br": true, "caption": true, "colgroup": true, "col": true,
+	"meta": true, "link": true, "title": true, "iframe": true, "svg": true,
+}
+
+func minifyHTML(s string) string {
+	toks := tokenizeHTML(s)
+	out := make([]htmlToken, 0, len(toks))
+	preserve := 0
+	for i, t := range toks {
+		switch t.kind {
+		case htmlComment:
+			if strings.HasPrefix(t.data, "[if") || strings.HasPrefix(t.data, "<![endif]") {
+				out = append(out, t)
+			}
+			continue
+		case htmlStartTag:
+			if preserveWSElems[t.data] {
+				preserve++
+			}
+		case htmlEndTag:
+			if preserveWSElems[t.data] && preserve > 0 {
+				preserve--
+			}
+		case htmlText:
+			if preserve > 0 {
+				break
+			}
+			text := collapseSpace(t.data)
+			if strings.TrimSpace(text) == "" && (isBlockBoundary(toks, i-1) || isBlockBoundary(toks, i+1)) {
+				continue
+			}
+			if text != t.data {
+				t = htmlToken{kind: htmlText, data: text}
+			}
+		}
+		out = append(out, t)
+	}
+	return strings.TrimSpace(renderHTML(out))
+}
+
+func isBlockBoundary(toks []htmlToken, i int) bool {
+	if i < 0 || i >= len(toks) {
+		return true
+	}
+	t := toks[i]
+	switch t.kind {
+	case htmlStartTag, htmlEndTag, htmlSelfClosingTag:
+		return blockElems[t.data]
+	case htmlComment, htmlDoctype:
+		return true
+	}
+	return false
+}
+
+func collapseSpace(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\n' || r == '\t' || r == '\r' || r == '\f' {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
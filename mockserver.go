@@ -0,0 +1,455 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/* -------------------------------
+   Mock Omnipub server
+   (transform mock-server)
+
+   Speaks the upload endpoints of
+   every API version this build
+   knows (POST /v2/omnipub,
+   /v3/items), GET /versions,
+   GET /vN/collections/ID and its
+   paged /items listing, so a
+   run can be rehearsed or tested
+   end to end without a real
+   tenant.
+
+   Uploads are checked like the API
+   would: multipart, a metadata
+   object with a title, HTML
+   content, numeric collections.
+   -latency, -error-rate and
+   -error-status make it slow or
+   flaky; -store DIR keeps what
+   arrived, one JSON file per item
+   plus its attachments.
+--------------------------------*/
+
+const mockUsage = `usage:
+  transform mock-server [-addr HOST:PORT] [-latency D|MIN-MAX] [-error-rate F] [-error-status CODES]
+                        [-store DIR] [-key KEY] [-collection-limit N]`
+
+type mockServer struct {
+	latencyMin, latencyMax time.Duration
+	errorRate              float64
+	errorStatus            []int
+	store                  string
+	key                    string
+	collectionLimit        int
+	base                   string // http://addr, for item URLs
+
+	seq      atomic.Int64
+	mu       sync.Mutex
+	counts   map[int]int       // items per collection
+	etags    map[string]string // If-None-Match seen → item ID
+	statuses map[int]int       // responses by status
+	items    map[string][]byte // item ID → HTML, for GET /items/ID
+	listed   []mockListed      // accepted items in order, for the listing
+}
+
+// mockListed is an item as GET /vN/collections/ID/items lists it.
+type mockListed struct {
+	ID          string    `json:"id"`
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Collections []int     `json:"collections,omitempty"`
+	Received    time.Time `json:"received"`
+}
+
+// mockPageSize is the default page size of the items listing.
+const mockPageSize = 50
+
+// mockItem is what -store writes for each accepted upload.
+type mockItem struct {
+	ID          string         `json:"id"`
+	Version     string         `json:"api_version"`
+	Received    time.Time      `json:"received"`
+	RunID       string         `json:"run_id,omitempty"`
+	RequestID   string         `json:"request_id,omitempty"`
+	Metadata    map[string]any `json:"metadata"`
+	Collections []int          `json:"collections,omitempty"`
+	HTML        string         `json:"html"`
+	Attachments []string       `json:"attachments,omitempty"` // file names under ID/
+}
+
+// mockMain implements "transform mock-server"; it returns the exit code.
+func mockMain(args []string) int {
+	fs := flag.NewFlagSet("mock-server", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "Listen on this address")
+	latency := fs.String("latency", "0", "Delay each upload by this long, or by a random time in MIN-MAX, e.g. 20ms-200ms")
+	errorRate := fs.Float64("error-rate", 0, "Fail this fraction of uploads, 0 to 1")
+	errorStatus := fs.String("error-status", "503", "Comma-separated statuses failed uploads get, picked at random; 429 comes with Retry-After")
+	store := fs.String("store", "", "Save each accepted item in this directory")
+	key := fs.String("key", "", "Answer 401 to requests that do not carry this API key")
+	collectionLimit := fs.Int("collection-limit", 0, "Item limit reported for every collection (0 = none)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, mockUsage)
+		return exitSetup
+	}
+
+	m := &mockServer{
+		errorRate: *errorRate, store: *store, key: *key, collectionLimit: *collectionLimit,
+		counts: make(map[int]int), etags: make(map[string]string),
+		statuses: make(map[int]int), items: make(map[string][]byte),
+	}
+	var err error
+	if m.latencyMin, m.latencyMax, err = parseLatency(*latency); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	if m.errorRate < 0 || m.errorRate > 1 {
+		fmt.Fprintln(os.Stderr, "error-rate: want a fraction between 0 and 1")
+		return exitSetup
+	}
+	for _, s := range strings.Split(*errorStatus, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || code < 400 || code > 599 {
+			fmt.Fprintf(os.Stderr, "error-status %q: want 4xx or 5xx codes\n", s)
+			return exitSetup
+		}
+		m.errorStatus = append(m.errorStatus, code)
+	}
+	if m.store != "" {
+		if err := os.MkdirAll(m.store, 0o755); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitSetup
+		}
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitSetup
+	}
+	m.base = "http://" + ln.Addr().String()
+	srv := &http.Server{Handler: m, ReadHeaderTimeout: 10 * time.Second}
+	log.Printf("Mock Omnipub listening on %s (try -api %s/v2)", m.base, m.base)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Print(err)
+		return exitSetup
+	}
+	m.report(os.Stderr)
+	return exitOK
+}
+
+// parseLatency reads "0", "50ms" or "20ms-200ms".
+func parseLatency(s string) (lo, hi time.Duration, err error) {
+	a, b, ranged := strings.Cut(s, "-")
+	if lo, err = time.ParseDuration(strings.TrimSpace(a)); err != nil {
+		return 0, 0, fmt.Errorf("latency %q: %w", s, err)
+	}
+	hi = lo
+	if ranged {
+		if hi, err = time.ParseDuration(strings.TrimSpace(b)); err != nil {
+			return 0, 0, fmt.Errorf("latency %q: %w", s, err)
+		}
+	}
+	if lo < 0 || hi < lo {
+		return 0, 0, fmt.Errorf("latency %q: want MIN-MAX with MIN <= MAX", s)
+	}
+	return lo, hi, nil
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := m.route(w, r)
+	m.mu.Lock()
+	m.statuses[status]++
+	m.mu.Unlock()
+	log.Printf("%s %s → %d %s", r.Method, r.URL.Path, status, r.Header.Get("X-Request-ID"))
+}
+
+// route answers r and returns the status it sent.
+func (m *mockServer) route(w http.ResponseWriter, r *http.Request) int {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if r.Method == http.MethodGet && strings.HasPrefix(path, "/items/") {
+		m.mu.Lock()
+		html, ok := m.items[strings.TrimPrefix(path, "/items/")]
+		m.mu.Unlock()
+		if !ok {
+			return mockError(w, http.StatusNotFound, "no such item")
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(html)
+		return http.StatusOK
+	}
+	if !m.authorized(r) {
+		return mockError(w, http.StatusUnauthorized, "missing or wrong API key")
+	}
+
+	version, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && (path == "/versions" || rest == "versions"):
+		return mockJSON(w, http.StatusOK, map[string]any{"versions": knownVersions})
+	case r.Method == http.MethodGet && strings.HasPrefix(rest, "collections/") && strings.HasSuffix(rest, "/items"):
+		id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rest, "collections/"), "/items"))
+		if err != nil {
+			return mockError(w, http.StatusNotFound, "no such collection")
+		}
+		return m.listItems(w, r, id)
+	case r.Method == http.MethodGet && strings.HasPrefix(rest, "collections/"):
+		id, err := strconv.Atoi(strings.TrimPrefix(rest, "collections/"))
+		if err != nil {
+			return mockError(w, http.StatusNotFound, "no such collection")
+		}
+		m.mu.Lock()
+		doc := map[string]any{"id": id, "item_count": m.counts[id]}
+		m.mu.Unlock()
+		if m.collectionLimit > 0 {
+			doc["item_limit"] = m.collectionLimit
+		}
+		return mockJSON(w, http.StatusOK, doc)
+	}
+	api := apiDialects[version]
+	if api == nil || "/"+rest != api.uploadPath {
+		return mockError(w, http.StatusNotFound, "no such endpoint")
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return mockError(w, http.StatusMethodNotAllowed, "uploads are POSTed")
+	}
+	return m.upload(w, r, api)
+}
+
+// listItems answers one page of a collection's items: ?cursor= is where
+// the page starts, ?limit= its size; next_cursor points at the next one.
+func (m *mockServer) listItems(w http.ResponseWriter, r *http.Request, id int) int {
+	q := r.URL.Query()
+	from, _ := strconv.Atoi(q.Get("cursor"))
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = mockPageSize
+	}
+	m.mu.Lock()
+	in := []mockListed{}
+	for _, it := range m.listed {
+		if slices.Contains(it.Collections, id) {
+			in = append(in, it)
+		}
+	}
+	m.mu.Unlock()
+	from = min(max(from, 0), len(in))
+	to := min(from+limit, len(in))
+	doc := map[string]any{"items": in[from:to]}
+	if to < len(in) {
+		doc["next_cursor"] = strconv.Itoa(to)
+	}
+	return mockJSON(w, http.StatusOK, doc)
+}
+
+func (m *mockServer) authorized(r *http.Request) bool {
+	if m.key == "" {
+		return true
+	}
+	basic := base64.StdEncoding.EncodeToString([]byte(m.key))
+	for _, vals := range r.Header {
+		for _, v := range vals {
+			if strings.Contains(v, m.key) || strings.Contains(v, basic) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (m *mockServer) upload(w http.ResponseWriter, r *http.Request, api *apiDialect) int {
+	if d := m.latencyMin; m.latencyMax > d {
+		d += rand.N(m.latencyMax - d)
+		time.Sleep(d)
+	} else if d > 0 {
+		time.Sleep(d)
+	}
+	if m.errorRate > 0 && rand.Float64() < m.errorRate {
+		status := m.errorStatus[rand.N(len(m.errorStatus))]
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		return mockError(w, status, "injected failure (-error-rate)")
+	}
+
+	item, files, err := parseMockUpload(r, api)
+	if err != nil {
+		return mockError(w, http.StatusBadRequest, err.Error())
+	}
+	etag := r.Header.Get("If-None-Match")
+	m.mu.Lock()
+	if id, ok := m.etags[etag]; ok && etag != "" {
+		m.mu.Unlock()
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		log.Printf("    unchanged: item %s", id)
+		return http.StatusNotModified
+	}
+	item.ID = strconv.FormatInt(m.seq.Add(1), 10)
+	if etag != "" {
+		m.etags[etag] = item.ID
+	}
+	for _, c := range item.Collections {
+		m.counts[c]++
+	}
+	m.items[item.ID] = []byte(item.HTML)
+	title, _ := item.Metadata["title"].(string)
+	m.listed = append(m.listed, mockListed{item.ID, m.base + "/items/" + item.ID, title, item.Collections, item.Received})
+	m.mu.Unlock()
+
+	item.RunID, item.RequestID = r.Header.Get("X-Run-ID"), r.Header.Get("X-Request-ID")
+	if m.store != "" {
+		if err := m.save(item, files); err != nil {
+			log.Printf("Error storing item %s: %v", item.ID, err)
+			return mockError(w, http.StatusInternalServerError, "could not store the item")
+		}
+	}
+	return mockJSON(w, http.StatusCreated, map[string]any{"id": item.ID, "url": m.base + "/items/" + item.ID})
+}
+
+// parseMockUpload checks an upload the way the API does.
+func parseMockUpload(r *http.Request, api *apiDialect) (mockItem, map[string][]byte, error) {
+	item := mockItem{Version: api.version, Received: time.Now().UTC()}
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mt != "multipart/form-data" {
+		return item, nil, errors.New("want a multipart/form-data body")
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return item, nil, fmt.Errorf("multipart: %v", err)
+	}
+	form := r.MultipartForm
+	defer form.RemoveAll()
+
+	item.HTML = firstValue(form.Value[api.htmlField])
+	if strings.TrimSpace(item.HTML) == "" {
+		return item, nil, fmt.Errorf("%s: missing or empty", api.htmlField)
+	}
+	meta := firstValue(form.Value[api.metadataField])
+	if err := json.Unmarshal([]byte(meta), &item.Metadata); err != nil || item.Metadata == nil {
+		return item, nil, fmt.Errorf("%s: want a JSON object", api.metadataField)
+	}
+	if title, _ := item.Metadata["title"].(string); strings.TrimSpace(title) == "" {
+		return item, nil, fmt.Errorf("%s: title missing", api.metadataField)
+	}
+	for _, v := range form.Value[api.collectionField] {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return item, nil, fmt.Errorf("%s %q: want a positive number", api.collectionField, v)
+		}
+		item.Collections = append(item.Collections, id)
+	}
+
+	files := make(map[string][]byte)
+	for field, headers := range form.File {
+		if field != api.attachmentField && (api.coverField == "" || field != api.coverField) {
+			return item, nil, fmt.Errorf("file field %q: want %s", field, api.attachmentField)
+		}
+		for _, h := range headers {
+			f, err := h.Open()
+			if err != nil {
+				return item, nil, err
+			}
+			b, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return item, nil, err
+			}
+			name := filepath.Base(h.Filename)
+			if field == api.coverField {
+				name = "cover-" + name
+			}
+			files[name] = b
+			item.Attachments = append(item.Attachments, name)
+		}
+	}
+	return item, files, nil
+}
+
+func firstValue(vals []string) string {
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+func (m *mockServer) save(item mockItem, files map[string][]byte) error {
+	if len(files) > 0 {
+		dir := filepath.Join(m.store, item.ID)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		for name, b := range files {
+			if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	f, err := os.Create(filepath.Join(m.store, item.ID+".json"))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetEscapeHTML(false) // keep the stored HTML readable
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(item); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// report prints the responses sent, by status.
+func (m *mockServer) report(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	byStatus := make(map[string]int, len(m.statuses))
+	for code, n := range m.statuses {
+		byStatus[strconv.Itoa(code)] = n
+	}
+	fmt.Fprintf(w, "Items stored: %d\n", m.seq.Load())
+	if len(byStatus) > 0 {
+		fmt.Fprintf(w, "Responses: %s\n", kindBreakdown(byStatus))
+	}
+}
+
+func mockJSON(w http.ResponseWriter, status int, v any) int {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+	return status
+}
+
+func mockError(w http.ResponseWriter, status int, msg string) int {
+	return mockJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+/* -------------------------------
+   Near duplicates (-near-dup)
+
+   Each article's title and cleaned
+   content get a 64-bit simhash over
+   three-word shingles. Two articles
+   are near duplicates when their
+   fingerprints agree on at least
+   -near-dup-threshold of the bits
+   (default 0.9, ≤ 6 bits apart).
+
+   Articles are compared with those
+   uploaded earlier in the process
+   and, with -manifest, with those
+   recorded there (the manifest
+   keeps each file's simhash).
+
+   flag – upload, with metadata
+          near_duplicate_of naming
+          the earlier item
+   skip – do not upload
+
+   Articles under nearDupMinWords
+   words are not fingerprinted.
+--------------------------------*/
+
+const nearDupMinWords = 20
+
+type fingerprintEntry struct {
+	fp   uint64
+	file string // absolute
+	url  string // the item, when known
+}
+
+type NearDup struct {
+	skip    bool
+	maxDist int // bits
+
+	mu      sync.Mutex
+	entries []fingerprintEntry
+}
+
+func NewNearDup(mode string, threshold float64) (*NearDup, error) {
+	switch mode {
+	case "flag", "skip":
+	default:
+		return nil, fmt.Errorf("near-dup %q: want off, flag or skip", mode)
+	}
+	if threshold <= 0 || threshold > 1 {
+		return nil, fmt.Errorf("near-dup-threshold %v: want a similarity in (0, 1]", threshold)
+	}
+	return &NearDup{skip: mode == "skip", maxDist: int((1 - threshold) * 64)}, nil
+}
+
+// seed adds the fingerprints recorded in a manifest.
+func (n *NearDup) seed(m *Manifest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, e := range m.latest {
+		if fp, err := strconv.ParseUint(e.Simhash, 16, 64); err == nil && e.Simhash != "" {
+			var url string
+			if len(e.URLs) > 0 {
+				url = e.URLs[0]
+			}
+			n.entries = append(n.entries, fingerprintEntry{fp, e.Path, url})
+		}
+	}
+}
+
+// simhash fingerprints text; ok is false for texts too short to judge.
+func simhash(text string) (fp uint64, ok bool) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) < nearDupMinWords {
+		return 0, false
+	}
+	var weights [64]int
+	for i := 0; i+3 <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(words[i] + " " + words[i+1] + " " + words[i+2]))
+		s := h.Sum64()
+		for b := range weights {
+			if s&(1<<b) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+	for b, w := range weights {
+		if w > 0 {
+			fp |= 1 << b
+		}
+	}
+	return fp, true
+}
+
+// Check looks fp up and, unless it duplicates an earlier article, adds it
+// for file. It returns the closest earlier article within the threshold
+// and how similar it is.
+func (n *NearDup) Check(fp uint64, file string) (match *fingerprintEntry, similarity float64) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	best := n.maxDist + 1
+	for i := range n.entries {
+		e := &n.entries[i]
+		if e.file == abs {
+			continue // an earlier version of the same file
+		}
+		if d := bits.OnesCount64(fp ^ e.fp); d < best {
+			best, match = d, e
+		}
+	}
+	if match != nil {
+		m := *match
+		similarity = 1 - float64(best)/64
+		if !n.skip {
+			n.entries = append(n.entries, fingerprintEntry{fp: fp, file: abs})
+		}
+		return &m, similarity
+	}
+	n.entries = append(n.entries, fingerprintEntry{fp: fp, file: abs})
+	return nil, 0
+}
+
+// release forgets file's fingerprint after its upload failed.
+func (n *NearDup) release(file string) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := len(n.entries) - 1; i >= 0; i-- {
+		if n.entries[i].file == abs {
+			n.entries = append(n.entries[:i], n.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// itemURL records the item an uploaded file became, for later matches.
+func (n *NearDup) itemURL(file, url string) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := len(n.entries) - 1; i >= 0; i-- {
+		if n.entries[i].file == abs {
+			n.entries[i].url = url
+			return
+		}
+	}
+}
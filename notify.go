@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   Completion notifications
+   (-notify-url)
+
+   After every batch (completed or
+   aborted) a summary is POSTed to
+   each URL. Slack incoming webhooks
+   (hooks.slack.com) get a plain
+   {"text": …} message; anything
+   else gets the full JSON summary,
+   which also carries "text" for
+   chat tools that only read that.
+--------------------------------*/
+
+type runSummary struct {
+	Text         string                  `json:"text"`
+	RunID        string                  `json:"run_id"`
+	Status       string                  `json:"status"` // completed or aborted
+	Host         string                  `json:"host,omitempty"`
+	Started      time.Time               `json:"started_at"`
+	Finished     time.Time               `json:"finished_at"`
+	DurationSec  float64                 `json:"duration_sec"`
+	Total        int                     `json:"total"`
+	Success      uint64                  `json:"success"`
+	Failure      uint64                  `json:"failure"`
+	Skipped      uint64                  `json:"skipped"`
+	AbortReason  string                  `json:"abort_reason,omitempty"`
+	NotStarted   int                     `json:"not_started,omitempty"`
+	Retries      int64                   `json:"retries"`
+	ByClass      map[failureClass]int    `json:"failures_by_class,omitempty"`
+	ByKind       map[string]int          `json:"failures_by_kind,omitempty"`
+	FailureFiles map[failureClass]string `json:"failure_files,omitempty"`
+	UpFront      []skippedFile           `json:"skipped_up_front,omitempty"`
+	EmptyContent []string                `json:"empty_content,omitempty"`
+	Duplicates   []duplicateAlias        `json:"duplicates,omitempty"`
+	RateLimit    *rateSummary            `json:"rate_limit,omitempty"`
+	Connections  *connSummary            `json:"connections,omitempty"`
+}
+
+func newRunSummary(p *Progress) runSummary {
+	s := p.Snapshot()
+	sum := runSummary{
+		RunID:       s.RunID,
+		Status:      "completed",
+		Started:     p.start,
+		Finished:    p.start.Add(s.Elapsed),
+		DurationSec: s.Elapsed.Seconds(),
+		Total:       s.Total,
+		Success:     s.Success,
+		Failure:     s.Failure,
+		Skipped:     s.Skipped,
+		Retries:     s.Retries,
+		ByKind:      s.ByKind,
+		ByClass:     s.ByClass,
+	}
+	if p.aborted != nil {
+		sum.Status = "aborted"
+		sum.AbortReason = p.aborted.Error()
+		sum.NotStarted = s.Remaining
+	}
+	sum.Host, _ = os.Hostname()
+	if len(p.failureFiles) > 0 {
+		sum.FailureFiles = p.failureFiles
+	}
+	sum.UpFront = p.skippedUpFront
+	sum.EmptyContent = p.emptyFiles()
+	sum.Duplicates = p.duplicates()
+	sum.RateLimit = s.RateLimit
+	sum.Connections = s.Connections
+	sum.Text = sum.text()
+	return sum
+}
+
+func (s runSummary) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Omnipub upload %s %s", s.RunID, s.Status)
+	if s.AbortReason != "" {
+		fmt.Fprintf(&b, " (%s)", s.AbortReason)
+	}
+	if s.Host != "" {
+		fmt.Fprintf(&b, " on %s", s.Host)
+	}
+	fmt.Fprintf(&b, " after %s: %d/%d succeeded, %d failed, %d skipped",
+		time.Duration(s.DurationSec*float64(time.Second)).Round(time.Second), s.Success, s.Total, s.Failure, s.Skipped)
+	if s.NotStarted > 0 {
+		fmt.Fprintf(&b, ", %d not started", s.NotStarted)
+	}
+	if len(s.UpFront) > 0 {
+		var up strings.Builder
+		reportSkipped(&up, s.UpFront)
+		b.WriteString("\n" + strings.TrimSuffix(up.String(), "\n"))
+	}
+	if len(s.EmptyContent) > 0 {
+		fmt.Fprintf(&b, "\nEmpty content, not uploaded: %d files", len(s.EmptyContent))
+	}
+	if len(s.Duplicates) > 0 {
+		fmt.Fprintf(&b, "\nDuplicates, not uploaded: %d files", len(s.Duplicates))
+	}
+	if s.RateLimit != nil {
+		b.WriteString("\n" + s.RateLimit.text())
+	}
+	if len(s.ByClass) > 0 {
+		fmt.Fprintf(&b, "\nFailures: %d retryable, %d permanent, %d input",
+			s.ByClass[classRetryable], s.ByClass[classPermanent], s.ByClass[classInput])
+	}
+	if len(s.ByKind) > 0 {
+		b.WriteString("\nBy kind: " + kindBreakdown(s.ByKind))
+	}
+	for _, c := range failureClasses {
+		if path, ok := s.FailureFiles[c]; ok {
+			fmt.Fprintf(&b, "\nFailed paths (%s): %s", c, path)
+		}
+	}
+	return b.String()
+}
+
+// Notifier posts run summaries to webhooks.
+type Notifier struct {
+	urls   []string
+	client *http.Client
+}
+
+func NewNotifier(urls []string) (*Notifier, error) {
+	for _, u := range urls {
+		pu, err := url.Parse(u)
+		if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+			return nil, fmt.Errorf("notify-url %q: want an http(s) URL", u)
+		}
+	}
+	return &Notifier{urls: urls, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Send delivers s to every URL; failures are collected, not fatal.
+func (n *Notifier) Send(ctx context.Context, s runSummary) error {
+	var errs []string
+	for _, u := range n.urls {
+		var payload any = s
+		if isSlackWebhook(u) {
+			payload = map[string]string{"text": s.Text}
+		}
+		body, _ := json.Marshal(payload)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := n.client.Do(req)
+		if err != nil {
+			// *url.Error repeats the full URL; report only the cause.
+			var ue *url.Error
+			if errors.As(err, &ue) {
+				err = ue.Err
+			}
+			errs = append(errs, fmt.Sprintf("%s: %v", redactURL(u), err))
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			errs = append(errs, fmt.Sprintf("%s: http %d", redactURL(u), resp.StatusCode))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("notify: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func isSlackWebhook(u string) bool {
+	pu, err := url.Parse(u)
+	return err == nil && pu.Host == "hooks.slack.com"
+}
+
+// redactURL drops the path and query, which for webhooks is the secret.
+func redactURL(u string) string {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return pu.Scheme + "://" + pu.Host + "/…"
+}
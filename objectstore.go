@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   State in an object store
+
+   -manifest, -schedule-state and
+   -list-state take s3://bucket/key or
+   gs://bucket/key as well as a
+   path, so a Job rescheduled onto
+   another node picks up where the
+   previous pod stopped.
+
+   S3 signs with the AWS key-source
+   credentials (env, ECS task role,
+   instance role); AWS_ENDPOINT_URL
+   points it at MinIO and friends.
+   GCS takes GOOGLE_OAUTH_ACCESS_TOKEN
+   or the metadata server's token
+   (GKE workload identity);
+   STORAGE_EMULATOR_HOST points it
+   at an emulator.
+
+   Writes are conditional on the
+   version last read: a second
+   writer fails instead of silently
+   overwriting the first.
+--------------------------------*/
+
+var errObjectChanged = errors.New("changed by another writer since it was read")
+
+// remoteObject is one object holding a state file.
+type remoteObject interface {
+	// Get returns os.ErrNotExist when there is no object yet.
+	Get(ctx context.Context) ([]byte, error)
+	Put(ctx context.Context, b []byte) error
+	String() string
+}
+
+// isRemoteState reports whether path names an object rather than a file.
+func isRemoteState(path string) bool {
+	return strings.HasPrefix(path, "s3://") || strings.HasPrefix(path, "gs://")
+}
+
+var remoteStates struct {
+	sync.Mutex
+	objs map[string]remoteObject
+}
+
+// remoteState returns the object for raw, the same one on every call so
+// that conditional writes follow the version it last read or wrote.
+func remoteState(raw string) (remoteObject, error) {
+	remoteStates.Lock()
+	defer remoteStates.Unlock()
+	if obj, ok := remoteStates.objs[raw]; ok {
+		return obj, nil
+	}
+	obj, err := openRemoteObject(raw)
+	if err != nil {
+		return nil, err
+	}
+	if remoteStates.objs == nil {
+		remoteStates.objs = make(map[string]remoteObject)
+	}
+	remoteStates.objs[raw] = obj
+	return obj, nil
+}
+
+// getRemoteState and putRemoteState read and write a small state object.
+func getRemoteState(raw string) ([]byte, error) {
+	obj, err := remoteState(raw)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return obj.Get(ctx)
+}
+
+func putRemoteState(raw string, b []byte) error {
+	obj, err := remoteState(raw)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return obj.Put(ctx, b)
+}
+
+func openRemoteObject(raw string) (remoteObject, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("%s: want %s://bucket/key", raw, u.Scheme)
+	}
+	client := &http.Client{Timeout: time.Minute}
+	switch u.Scheme {
+	case "s3":
+		return &s3Object{bucket: u.Host, key: key, aws: &awsKey{service: "s3", id: u.Host, client: client}}, nil
+	case "gs":
+		return &gcsObject{bucket: u.Host, key: key, client: client}, nil
+	}
+	return nil, fmt.Errorf("%s: want s3:// or gs://", raw)
+}
+
+/* ---------- S3 ---------- */
+
+type s3Object struct {
+	bucket, key string
+	aws         *awsKey
+	etag        string // as last read or written; "" if there was no object
+}
+
+func (o *s3Object) String() string { return "s3://" + o.bucket + "/" + o.key }
+
+func (o *s3Object) url(region string) string {
+	path := "/" + strings.ReplaceAll(url.PathEscape(o.key), "%2F", "/")
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/" + o.bucket + path
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com%s", o.bucket, region, path)
+}
+
+func (o *s3Object) do(ctx context.Context, method string, body []byte, h http.Header) (*http.Response, error) {
+	creds, err := o.aws.credentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	region, err := o.aws.region(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, o.url(region), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, v := range h {
+		req.Header[name] = v
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	signV4(req, body, creds, region, "s3", time.Now())
+	return o.aws.client.Do(req)
+}
+
+func (o *s3Object) Get(ctx context.Context) ([]byte, error) {
+	resp, err := o.do(ctx, http.MethodGet, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		o.etag = ""
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: GET: http %d", o, resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o, err)
+	}
+	o.etag = resp.Header.Get("ETag")
+	return b, nil
+}
+
+func (o *s3Object) Put(ctx context.Context, b []byte) error {
+	h := http.Header{}
+	if o.etag != "" {
+		h.Set("If-Match", o.etag)
+	} else {
+		h.Set("If-None-Match", "*")
+	}
+	resp, err := o.do(ctx, http.MethodPut, b, h)
+	if err != nil {
+		return fmt.Errorf("%s: %w", o, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPreconditionFailed || resp.StatusCode == http.StatusConflict:
+		return fmt.Errorf("%s: %w", o, errObjectChanged)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s: PUT: http %d", o, resp.StatusCode)
+	}
+	o.etag = resp.Header.Get("ETag")
+	return nil
+}
+
+/* ---------- GCS ---------- */
+
+const gcsMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+type gcsObject struct {
+	bucket, key string
+	client      *http.Client
+	generation  string // as last read or written; "0" if there was no object
+
+	token   string
+	expires time.Time
+}
+
+func (o *gcsObject) String() string { return "gs://" + o.bucket + "/" + o.key }
+
+func (o *gcsObject) base() string {
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return strings.TrimSuffix(host, "/")
+	}
+	return "https://storage.googleapis.com"
+}
+
+func (o *gcsObject) accessToken(ctx context.Context) (string, error) {
+	if t := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); t != "" {
+		return t, nil
+	}
+	if os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+		return "", nil
+	}
+	if o.token != "" && time.Until(o.expires) > time.Minute {
+		return o.token, nil
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, gcsMetadataToken, nil)
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%s: no GOOGLE_OAUTH_ACCESS_TOKEN and no metadata server: %w", o, err)
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: metadata token: http %d", o, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("%s: metadata token: %w", o, err)
+	}
+	o.token, o.expires = tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second)
+	return o.token, nil
+}
+
+func (o *gcsObject) do(ctx context.Context, method, u string, body []byte) (*http.Response, error) {
+	token, err := o.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	return o.client.Do(req)
+}
+
+func (o *gcsObject) Get(ctx context.Context) ([]byte, error) {
+	u := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?alt=media", o.base(), url.PathEscape(o.bucket), url.PathEscape(o.key))
+	resp, err := o.do(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		o.generation = "0"
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: GET: http %d", o, resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", o, err)
+	}
+	o.generation = resp.Header.Get("X-Goog-Generation")
+	return b, nil
+}
+
+func (o *gcsObject) Put(ctx context.Context, b []byte) error {
+	q := url.Values{"uploadType": {"media"}, "name": {o.key}}
+	if o.generation != "" {
+		q.Set("ifGenerationMatch", o.generation)
+	}
+	u := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", o.base(), url.PathEscape(o.bucket), q.Encode())
+	resp, err := o.do(ctx, http.MethodPost, u, b)
+	if err != nil {
+		return fmt.Errorf("%s: %w", o, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("%s: %w", o, errObjectChanged)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: upload: http %d", o, resp.StatusCode)
+	}
+	var meta struct {
+		Generation string `json:"generation"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err == nil && meta.Generation != "" {
+		o.generation = meta.Generation
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Open Graph enrichment
+   (-og-enrich)
+
+   Fetches each article's link and
+   fills fields the input left empty:
+
+   excerpt ← og:description
+             (description)
+   image   ← og:image
+   author  ← article:author
+             (author), unless it is a
+             profile URL
+
+   Requests to one host are spaced
+   by -og-rate, with at most
+   ogConns at a time overall. Pages
+   are fetched once per process;
+   -og-cache keeps them across runs
+   for ogCacheTTL.
+--------------------------------*/
+
+const (
+	ogConns     = 4
+	ogReadBytes = 512 << 10
+	ogCacheTTL  = 30 * 24 * time.Hour
+)
+
+// ogInfo is what a page says about itself.
+type ogInfo struct {
+	Description string    `json:"description,omitempty"`
+	Image       string    `json:"image,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Fetched     time.Time `json:"fetched"`
+}
+
+type ogEntry struct {
+	info ogInfo
+	err  error
+	wait chan struct{}
+}
+
+type OpenGraph struct {
+	client   *http.Client
+	interval time.Duration // between requests to one host
+	sem      chan struct{}
+	cache    string // -og-cache file
+
+	mu      sync.Mutex
+	pages   map[string]*ogEntry
+	next    map[string]time.Time // per host: earliest next request
+	dirty   bool                 // pages fetched since the cache was saved
+	fetched int
+	cached  int
+	filled  int // articles that got at least one field
+}
+
+func NewOpenGraph(perSec float64, cache string) (*OpenGraph, error) {
+	if perSec <= 0 {
+		return nil, fmt.Errorf("og-rate %v: must be positive", perSec)
+	}
+	g := &OpenGraph{
+		client:   &http.Client{Timeout: 15 * time.Second},
+		interval: time.Duration(float64(time.Second) / perSec),
+		sem:      make(chan struct{}, ogConns),
+		cache:    cache,
+		pages:    make(map[string]*ogEntry),
+		next:     make(map[string]time.Time),
+	}
+	if cache == "" {
+		return g, nil
+	}
+	b, err := os.ReadFile(cache)
+	if errors.Is(err, fs.ErrNotExist) {
+		return g, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved map[string]ogInfo
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("og-cache %s: %w", cache, err)
+	}
+	for u, info := range saved {
+		if time.Since(info.Fetched) < ogCacheTTL {
+			e := &ogEntry{info: info, wait: make(chan struct{})}
+			close(e.wait)
+			g.pages[u] = e
+		}
+	}
+	return g, nil
+}
+
+// Enrich fills a's empty excerpt, image and author from its link's page.
+// It returns the names of the fields it filled.
+func (g *OpenGraph) Enrich(ctx context.Context, a *Article) ([]string, error) {
+	if !checkable(a.Link) || (a.Excerpt != "" && a.Image != "" && a.Author != "") {
+		return nil, nil
+	}
+	info, err := g.page(ctx, strings.TrimSpace(a.Link))
+	if err != nil {
+		return nil, err
+	}
+	var filled []string
+	for _, f := range []struct {
+		name string
+		dst  *string
+		src  string
+	}{
+		{"excerpt", &a.Excerpt, info.Description},
+		{"image", &a.Image, info.Image},
+		{"author", &a.Author, info.Author},
+	} {
+		if *f.dst == "" && f.src != "" {
+			*f.dst = f.src
+			filled = append(filled, f.name)
+		}
+	}
+	if len(filled) > 0 {
+		g.mu.Lock()
+		g.filled++
+		g.mu.Unlock()
+	}
+	return filled, nil
+}
+
+// page returns the Open Graph info of u, fetching it on first use.
+func (g *OpenGraph) page(ctx context.Context, u string) (ogInfo, error) {
+	g.mu.Lock()
+	e, ok := g.pages[u]
+	if ok {
+		g.cached++
+	} else {
+		e = &ogEntry{wait: make(chan struct{})}
+		g.pages[u] = e
+	}
+	g.mu.Unlock()
+	if ok {
+		<-e.wait
+		return e.info, e.err
+	}
+
+	e.info, e.err = g.fetch(ctx, u)
+	g.mu.Lock()
+	if e.err != nil {
+		// a later article with the same link tries again
+		delete(g.pages, u)
+	} else {
+		g.fetched++
+		g.dirty = true
+	}
+	g.mu.Unlock()
+	close(e.wait)
+	return e.info, e.err
+}
+
+// throttle waits for host's next request slot.
+func (g *OpenGraph) throttle(ctx context.Context, host string) error {
+	g.mu.Lock()
+	at := time.Now()
+	if next := g.next[host]; next.After(at) {
+		at = next
+	}
+	g.next[host] = at.Add(g.interval)
+	g.mu.Unlock()
+	select {
+	case <-time.After(time.Until(at)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *OpenGraph) fetch(ctx context.Context, u string) (ogInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return ogInfo{}, err
+	}
+	req.Header.Set("User-Agent", "transform-to-omnipub/"+toolVersion()+" (open graph)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	if err := g.throttle(ctx, req.URL.Host); err != nil {
+		return ogInfo{}, err
+	}
+	g.sem <- struct{}{}
+	defer func() { <-g.sem }()
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return ogInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ogInfo{}, fmt.Errorf("http %d", resp.StatusCode)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, ogReadBytes))
+	if err != nil {
+		return ogInfo{}, err
+	}
+	return parseOpenGraph(string(page), resp.Request.URL), nil
+}
+
+// parseOpenGraph reads a page's og:/article: <meta> tags, falling back to
+// the plain description and author ones.
+func parseOpenGraph(page string, base *url.URL) ogInfo {
+	meta := pageMeta(tokenizeHTML(page))
+	info := ogInfo{Fetched: time.Now().UTC()}
+	info.Description = cmp.Or(meta["og:description"], meta["description"])
+	if img := cmp.Or(meta["og:image:secure_url"], meta["og:image"], meta["og:image:url"]); img != "" {
+		if u, err := base.Parse(img); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			info.Image = u.String()
+		}
+	}
+	for _, author := range []string{meta["article:author"], meta["author"]} {
+		if author != "" && !checkable(author) {
+			info.Author = author
+			break
+		}
+	}
+	return info
+}
+
+// pageMeta maps the lower-cased property or name of each <meta> tag in
+// the page's head to its content; the first non-empty one wins.
+func pageMeta(toks []htmlToken) map[string]string {
+	meta := map[string]string{}
+	for _, t := range toks {
+		if t.isTag("body") {
+			break
+		}
+		if !t.isTag("meta") {
+			continue
+		}
+		key, _ := t.attr("property")
+		if key == "" {
+			key, _ = t.attr("name")
+		}
+		content, _ := t.attr("content")
+		key, content = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(content)
+		if _, seen := meta[key]; !seen && content != "" {
+			meta[key] = content
+		}
+	}
+	return meta
+}
+
+// report writes the run's enrichment totals, saves -og-cache and starts
+// counting afresh.
+func (g *OpenGraph) report(w io.Writer) {
+	g.mu.Lock()
+	fetched, cached, filled := g.fetched, g.cached, g.filled
+	g.fetched, g.cached, g.filled = 0, 0, 0
+	g.mu.Unlock()
+	if fetched+cached > 0 {
+		fmt.Fprintf(w, "Open Graph: %d pages fetched, %d from cache, %d articles enriched\n", fetched, cached, filled)
+	}
+	if err := g.save(); err != nil {
+		fmt.Fprintf(w, "og-cache: %v\n", err)
+	}
+}
+
+// save writes the fetched pages to -og-cache, if set.
+func (g *OpenGraph) save() error {
+	g.mu.Lock()
+	if g.cache == "" || !g.dirty {
+		g.mu.Unlock()
+		return nil
+	}
+	saved := make(map[string]ogInfo, len(g.pages))
+	for u, e := range g.pages {
+		select {
+		case <-e.wait:
+			if e.err == nil {
+				saved[u] = e.info
+			}
+		default: // still being fetched
+		}
+	}
+	g.dirty = false
+	g.mu.Unlock()
+
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(g.cache), "."+filepath.Base(g.cache)+".tmp")
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.cache)
+}
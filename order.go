@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Upload order (-order)
+
+   publish-date decodes every file
+   before the run and dispatches
+   them oldest first, so Omnipub's
+   "recently added" views follow
+   the original publication order.
+   Files with several items sort by
+   their earliest item, and their
+   items are uploaded oldest first.
+   Files without a readable date go
+   last, in listing order.
+
+   -shuffle randomizes the order
+   instead, from -seed (a random
+   seed, logged, if not given), so
+   items that share an expensive
+   server-side path are not sent
+   back to back and a run can be
+   repeated exactly.
+
+   -priority FILE lists paths or
+   globs (one per line, # for
+   comments) to upload before
+   everything else, in the order
+   listed, so a run cut short is
+   missing the long tail rather
+   than the featured content.
+
+   -start-at/-count cut a slice out
+   of the sorted listing before any
+   other filter, so the same
+   indexes name the same files on
+   every run.
+
+   Workers still overlap, so the
+   order is approximate unless
+   -order-serial gives every
+   collection one lane: a file
+   waits for the files listed
+   before it that go to the same
+   collection, while files for
+   other collections go on in
+   parallel. Lanes follow the
+   collections the decoded file
+   names, -collection otherwise;
+   plugins moving articles
+   elsewhere are not seen.
+--------------------------------*/
+
+// articleDateLayouts are the published_date shapes seen in exports.
+var articleDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC850,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"01/02/2006",
+	"2006/01/02",
+}
+
+// parseArticleDate reads a published_date; ok is false if no layout fits.
+func parseArticleDate(s string) (t time.Time, ok bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return t, false
+	}
+	for _, layout := range articleDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return t, false
+}
+
+// sortByPublishDate orders files by the earliest publish date of their
+// articles, decoding them in parallel.
+func (t *Transformer) sortByPublishDate(ctx context.Context, files []string) []string {
+	dates := make([]time.Time, len(files))
+	known := make([]bool, len(files))
+	idx := make(chan int)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				arts, err := t.decodeAhead(ctx, files[i])
+				if err != nil {
+					continue // reported when the upload tries it
+				}
+				for _, a := range arts {
+					if d, ok := parseArticleDate(a.PublishDate); ok && (!known[i] || d.Before(dates[i])) {
+						dates[i], known[i] = d, true
+					}
+				}
+			}
+		}()
+	}
+	for i := range files {
+		idx <- i
+	}
+	close(idx)
+	wg.Wait()
+
+	order := make([]int, len(files))
+	undated := 0
+	for i := range order {
+		order[i] = i
+		if !known[i] {
+			undated++
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		i, j := order[a], order[b]
+		if known[i] != known[j] {
+			return known[i]
+		}
+		return known[i] && dates[i].Before(dates[j])
+	})
+	sorted := make([]string, len(files))
+	for k, i := range order {
+		sorted[k] = files[i]
+	}
+	if undated > 0 {
+		log.Printf("%d of %d files have no readable published_date and go last", undated, len(files))
+	}
+	return sorted
+}
+
+// sortArticles orders a multi-item file's articles oldest first; undated
+// ones keep their place after the dated ones.
+func sortArticles(arts []Article) {
+	sort.SliceStable(arts, func(a, b int) bool {
+		da, oka := parseArticleDate(arts[a].PublishDate)
+		db, okb := parseArticleDate(arts[b].PublishDate)
+		if oka != okb {
+			return oka
+		}
+		return oka && da.Before(db)
+	})
+}
+
+// prioritize moves files matching an entry of list to the front, ranked
+// by the first entry they match; the rest keep their order. An entry
+// matches a file by path, or as a glob against its path or name.
+func prioritize(files, list []string) []string {
+	type entry struct{ abs, pattern string }
+	var entries []entry
+	for _, l := range list {
+		if l = strings.TrimSpace(l); l == "" || l[0] == '#' {
+			continue
+		}
+		abs, _ := filepath.Abs(l)
+		entries = append(entries, entry{abs, filepath.Clean(l)})
+	}
+	rank := make([]int, len(files))
+	matched := 0
+	for i, f := range files {
+		rank[i] = len(entries)
+		abs, _ := filepath.Abs(f)
+		for r, e := range entries {
+			if ok, _ := filepath.Match(e.pattern, filepath.Clean(f)); ok || abs == e.abs {
+				rank[i] = r
+				break
+			}
+			if ok, _ := filepath.Match(e.pattern, filepath.Base(f)); ok {
+				rank[i] = r
+				break
+			}
+		}
+		if rank[i] < len(entries) {
+			matched++
+		}
+	}
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return rank[order[a]] < rank[order[b]] })
+	out := make([]string, len(files))
+	for k, i := range order {
+		out[k] = files[i]
+	}
+	log.Printf("%d of %d files are on the priority list and go first", matched, len(files))
+	return out
+}
+
+// shuffleFiles permutes files in place, reproducibly for a given seed.
+func shuffleFiles(files []string, seed uint64) {
+	r := rand.New(rand.NewPCG(seed, seed))
+	r.Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+}
+
+// sliceFiles sorts files and keeps count of them from index start
+// (count 0 = to the end).
+func sliceFiles(files []string, start, count int) []string {
+	total := len(files)
+	sort.Strings(files)
+	files = files[min(start, total):]
+	if count > 0 && count < len(files) {
+		files = files[:count]
+	}
+	if len(files) == 0 {
+		log.Printf("-start-at %d is past the end of the %d-file list", start, total)
+	} else {
+		log.Printf("Processing files %d–%d of %d (sorted by path)", start, start+len(files)-1, total)
+	}
+	return files
+}
+
+// orderLanes keeps uploads to a collection in dispatch order.
+// dispatched numbers files as they are queued; a worker then calls
+// enter with the file's collections, which returns once every earlier
+// file sharing a collection has left.
+type orderLanes struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	seqs  map[string][]int64 // dispatch numbers by file, oldest first
+	next  int64              // numbers handed out
+	keys  map[int64][]int    // collections entered but not yet lined up
+	low   int64              // numbers below this are lined up
+	lanes map[int][]int64    // unfinished numbers per collection, in order
+}
+
+func newOrderLanes() *orderLanes {
+	l := &orderLanes{seqs: make(map[string][]int64), keys: make(map[int64][]int), lanes: make(map[int][]int64)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// feed numbers the files from in and passes them on to out, closing out
+// when in is closed.
+func (l *orderLanes) feed(in <-chan string, out chan<- string) {
+	for f := range in {
+		l.mu.Lock()
+		l.seqs[f] = append(l.seqs[f], l.next)
+		l.next++
+		l.mu.Unlock()
+		out <- f
+	}
+	close(out)
+}
+
+// enter blocks until file, going to collections, heads each of their
+// lanes, and returns the func that lets the next file in. Earlier files
+// are held by workers already, as the pool takes jobs in order, so the
+// oldest unfinished one can always go.
+func (l *orderLanes) enter(file string, collections []int) (leave func()) {
+	if len(collections) == 0 {
+		collections = []int{0} // the server's default
+	}
+	collections = slices.Compact(slices.Sorted(slices.Values(collections)))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq := l.seqs[file][0]
+	if l.seqs[file] = l.seqs[file][1:]; len(l.seqs[file]) == 0 {
+		delete(l.seqs, file)
+	}
+	l.keys[seq] = collections
+	// line files up in dispatch order once all before them have entered
+	for {
+		c, ok := l.keys[l.low]
+		if !ok {
+			break
+		}
+		for _, id := range c {
+			l.lanes[id] = append(l.lanes[id], l.low)
+		}
+		delete(l.keys, l.low)
+		l.low++
+	}
+	l.cond.Broadcast()
+	for !l.heads(seq, collections) {
+		l.cond.Wait()
+	}
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for _, id := range collections {
+			if l.lanes[id] = l.lanes[id][1:]; len(l.lanes[id]) == 0 {
+				delete(l.lanes, id)
+			}
+		}
+		l.cond.Broadcast()
+	}
+}
+
+func (l *orderLanes) heads(seq int64, collections []int) bool {
+	if seq >= l.low {
+		return false
+	}
+	for _, id := range collections {
+		if l.lanes[id][0] != seq {
+			return false
+		}
+	}
+	return true
+}
+
+// fileCollections returns the collections file's articles go to, as
+// far as decoding tells; def when it cannot be decoded.
+func (t *Transformer) fileCollections(ctx context.Context, file string, def []int) []int {
+	arts, err := t.decodeAhead(ctx, file)
+	if err != nil {
+		return def
+	}
+	var out []int
+	for i := range arts {
+		ids, err := t.articleCollections(ctx, &arts[i], def)
+		if err != nil {
+			ids = def // the upload reports it
+		}
+		for _, id := range ids {
+			if !slices.Contains(out, id) {
+				out = append(out, id)
+			}
+		}
+	}
+	if len(out) == 0 {
+		return def
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOrderLanes(t *testing.T) {
+	// f0…f39 alternate between collections 1 and 2; every tenth goes to both
+	var files []string
+	want := make(map[string][]int)
+	for i := range 40 {
+		f := fmt.Sprintf("f%d", i)
+		files = append(files, f)
+		switch {
+		case i%10 == 9:
+			want[f] = []int{2, 1}
+		case i%2 == 0:
+			want[f] = []int{1}
+		default:
+			want[f] = []int{2}
+		}
+	}
+
+	lanes := newOrderLanes()
+	in, jobs := make(chan string), make(chan string, 8)
+	go lanes.feed(in, jobs)
+	go func() {
+		for _, f := range files {
+			in <- f
+		}
+		close(in)
+	}()
+
+	var mu sync.Mutex
+	got := make(map[int][]string)
+	var running, most atomic.Int32
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				leave := lanes.enter(f, want[f])
+				n := running.Add(1)
+				for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+				}
+				mu.Lock()
+				for _, id := range want[f] {
+					got[id] = append(got[id], f)
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				running.Add(-1)
+				leave()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, id := range []int{1, 2} {
+		var order []string
+		for _, f := range files {
+			if slices.Contains(want[f], id) {
+				order = append(order, f)
+			}
+		}
+		if !slices.Equal(got[id], order) {
+			t.Errorf("collection %d got %v, want %v", id, got[id], order)
+		}
+	}
+	if most.Load() < 2 {
+		t.Error("the two collections never uploaded in parallel")
+	}
+	if len(lanes.seqs) != 0 || len(lanes.keys) != 0 || len(lanes.lanes) != 0 {
+		t.Errorf("lanes left behind: %v %v %v", lanes.seqs, lanes.keys, lanes.lanes)
+	}
+}
+
+func TestDecodeAheadOnce(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.json")
+	if err := os.WriteFile(file, []byte(`{"title": "A", "content": "x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tr := &Transformer{encoding: "auto", invalidUTF8: "replace"}
+	ctx := context.Background()
+
+	ahead, err := tr.decodeAhead(ctx, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the upload gets the same articles, not a second decoding
+	arts, err := tr.decodeFile(ctx, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arts) != 1 || &arts[0] != &ahead[0] {
+		t.Errorf("decodeFile decoded %s again", file)
+	}
+	if again, _ := tr.decodeFile(ctx, file); len(again) != 1 || &again[0] == &ahead[0] {
+		t.Errorf("decoded articles handed out twice")
+	}
+
+	// a file changed since is decoded afresh
+	if _, err := tr.decodeAhead(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, []byte(`{"title": "B", "content": "x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if arts, err := tr.decodeFile(ctx, file); err != nil || arts[0].Title != "B" {
+		t.Errorf("changed file: %v, %v", arts, err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+/* -------------------------------
+   Reading lists from the API
+
+   List endpoints may page their
+   results. listAll follows
+   whichever style the answer uses:
+   a next link ("next",
+   links.next), a cursor
+   ("next_cursor", also under
+   "meta"), or "has_more" with an
+   offset.
+
+   A next link must stay on the
+   API's scheme and host: every
+   page is fetched with the API
+   key.
+--------------------------------*/
+
+// maxListPages bounds a listing whose next link never runs out.
+const maxListPages = 1000
+
+// listKeys are the fields that may hold a page's items.
+var listKeys = []string{"collections", "data", "items", "results"}
+
+// getJSON GETs u with the API key and decodes the JSON answer.
+func (t *Transformer) getJSON(ctx context.Context, u string) (any, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = t.headers.Clone()
+	t.auth(req, t.keyPool().pick().key)
+	req.Header.Set("Accept", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("GET %s: http %d", req.URL.Path, resp.StatusCode)
+	}
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("GET %s: %w", req.URL.Path, err)
+	}
+	return doc, nil
+}
+
+// listAll calls each for every object of the list at path (under the API
+// base), page by page.
+func (t *Transformer) listAll(ctx context.Context, path string, query url.Values, each func(map[string]any)) error {
+	p, err := t.newPager(path, query)
+	if err != nil {
+		return err
+	}
+	for !p.done() {
+		items, err := p.next(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range items {
+			each(m)
+		}
+	}
+	return nil
+}
+
+// listPosition is where a paged listing stands, saved so an interrupted
+// listing can resume: the next page to fetch and the items before it.
+type listPosition struct {
+	Next string `json:"next"` // "" once the list is done
+	Seen int    `json:"seen"` // for has_more/offset paging
+}
+
+// pager fetches a paged list one page at a time.
+type pager struct {
+	t     *Transformer
+	pos   listPosition
+	pages int
+}
+
+func (t *Transformer) newPager(path string, query url.Values) (*pager, error) {
+	u, err := url.Parse(t.apiBase + path)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+	return &pager{t: t, pos: listPosition{Next: u.String()}}, nil
+}
+
+// resumePager continues a listing at pos, which must be on the API host.
+func (t *Transformer) resumePager(pos listPosition) (*pager, error) {
+	base, err := url.Parse(t.apiBase)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(pos.Next)
+	if err != nil || u.Scheme != base.Scheme || u.Host != base.Host {
+		return nil, fmt.Errorf("saved list position %q is not on the API host %s://%s", pos.Next, base.Scheme, base.Host)
+	}
+	return &pager{t: t, pos: pos}, nil
+}
+
+func (p *pager) done() bool { return p.pos.Next == "" }
+
+// position is where the listing resumes after the pages fetched so far.
+func (p *pager) position() listPosition { return p.pos }
+
+// next fetches the next page and returns its objects.
+func (p *pager) next(ctx context.Context) ([]map[string]any, error) {
+	u, err := url.Parse(p.pos.Next)
+	if err != nil {
+		return nil, err
+	}
+	if p.pages++; p.pages > maxListPages {
+		return nil, fmt.Errorf("GET %s: more than %d pages", u.Path, maxListPages)
+	}
+	doc, err := p.t.getJSON(ctx, u.String())
+	if err != nil {
+		return nil, err
+	}
+	items, obj := pageItems(doc)
+	var objs []map[string]any
+	for _, e := range items {
+		if m, ok := e.(map[string]any); ok {
+			objs = append(objs, m)
+		}
+	}
+	p.pos.Seen += len(items)
+	p.pos.Next = ""
+	if obj == nil || len(items) == 0 {
+		return objs, nil
+	}
+
+	meta, _ := obj["meta"].(map[string]any)
+	links, _ := obj["links"].(map[string]any)
+	switch {
+	case firstString(obj["next"], links["next"]) != "":
+		next, err := u.Parse(firstString(obj["next"], links["next"]))
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: next page: %w", u.Path, err)
+		}
+		if next.Scheme != u.Scheme || next.Host != u.Host {
+			return nil, fmt.Errorf("GET %s: next page %s://%s is not on the API host %s://%s", u.Path, next.Scheme, next.Host, u.Scheme, u.Host)
+		}
+		u = next
+	case firstString(obj["next_cursor"], meta["next_cursor"]) != "":
+		q := u.Query()
+		q.Set("cursor", firstString(obj["next_cursor"], meta["next_cursor"]))
+		u.RawQuery = q.Encode()
+	case obj["has_more"] == true:
+		q := u.Query()
+		q.Set("offset", strconv.Itoa(p.pos.Seen))
+		u.RawQuery = q.Encode()
+	default:
+		return objs, nil
+	}
+	p.pos.Next = u.String()
+	return objs, nil
+}
+
+// pageItems returns the items of one page, and the page object when the
+// list is wrapped in one (it may say where the next page is).
+func pageItems(doc any) ([]any, map[string]any) {
+	if list, ok := doc.([]any); ok {
+		return list, nil
+	}
+	obj, _ := doc.(map[string]any)
+	for _, k := range listKeys {
+		if l, ok := obj[k].([]any); ok {
+			return l, obj
+		}
+	}
+	return nil, obj
+}
+
+// firstString returns the first of vs that is a non-empty string.
+func firstString(vs ...any) string {
+	for _, v := range vs {
+		if s, ok := v.(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
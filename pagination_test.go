@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// pagedServer lists the items 1..n three at a time in the paging style
+// of ?style=, like the API's list endpoints.
+func pagedServer(t *testing.T, n int) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		from, _ := strconv.Atoi(q.Get("cursor") + q.Get("offset") + q.Get("page"))
+		var items []map[string]any
+		for i := from; i < min(from+3, n); i++ {
+			items = append(items, map[string]any{"id": i + 1})
+		}
+		doc := map[string]any{"items": items}
+		if more := from+3 < n; more {
+			next := strconv.Itoa(from + 3)
+			switch q.Get("style") {
+			case "next":
+				doc["links"] = map[string]any{"next": "?style=next&page=" + next}
+			case "cursor":
+				doc["meta"] = map[string]any{"next_cursor": next}
+			case "has_more":
+				doc["has_more"] = true
+			case "foreign":
+				doc["next"] = "http://elsewhere.example/v2/items?page=" + next
+			}
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testTransformer(t *testing.T, api string) *Transformer {
+	t.Setenv("TEST_OMNIPUB_KEY", "k")
+	tr, err := NewTransformer(api, envKey("TEST_OMNIPUB_KEY"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func listIDs(tr *Transformer, style string) ([]int, error) {
+	var ids []int
+	err := tr.listAll(context.Background(), "/items", map[string][]string{"style": {style}}, func(m map[string]any) {
+		ids = append(ids, int(m["id"].(float64)))
+	})
+	return ids, err
+}
+
+func TestListAll(t *testing.T) {
+	srv := pagedServer(t, 8)
+	tr := testTransformer(t, srv.URL+"/v2")
+	want := []int{1, 2, 3, 4, 5, 6, 7, 8}
+	for _, style := range []string{"next", "cursor", "has_more"} {
+		ids, err := listIDs(tr, style)
+		if err != nil {
+			t.Errorf("%s: %v", style, err)
+		} else if !slices.Equal(ids, want) {
+			t.Errorf("%s: listed %v, want %v", style, ids, want)
+		}
+	}
+}
+
+func TestListAllStaysOnHost(t *testing.T) {
+	srv := pagedServer(t, 8)
+	tr := testTransformer(t, srv.URL+"/v2")
+	_, err := listIDs(tr, "foreign")
+	if err == nil || !strings.Contains(err.Error(), "elsewhere.example") {
+		t.Errorf("next link to another host: err = %v", err)
+	}
+	if _, err := tr.resumePager(listPosition{Next: "http://elsewhere.example/v2/items"}); err == nil {
+		t.Error("resumePager accepted a position on another host")
+	}
+}
+
+func TestPagerResume(t *testing.T) {
+	srv := pagedServer(t, 8)
+	tr := testTransformer(t, srv.URL+"/v2")
+	for _, style := range []string{"next", "cursor", "has_more"} {
+		p, err := tr.newPager("/items", map[string][]string{"style": {style}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := p.next(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// a later run picks up from the saved position
+		p, err = tr.resumePager(p.position())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []int
+		for !p.done() {
+			items, err := p.next(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range items {
+				ids = append(ids, int(m["id"].(float64)))
+			}
+		}
+		if want := []int{4, 5, 6, 7, 8}; !slices.Equal(ids, want) {
+			t.Errorf("%s: resumed with %v, want %v", style, ids, want)
+		}
+	}
+}
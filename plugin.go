@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+/* -------------------------------
+   Transform plugins (-transform-wasm)
+
+   A plugin is a WASI command module.
+   It receives one Article as JSON on
+   stdin and writes the transformed
+   Article as JSON to stdout; writing
+   `null` skips the article.
+
+   Modules run under an external WASI
+   runtime (wasmtime by default) with
+   no preopened directories and no
+   network, so they can only see the
+   article they are handed.
+--------------------------------*/
+
+// TransformHook rewrites an article after decoding. Returning a nil
+// article means "skip this item".
+type TransformHook interface {
+	Transform(ctx context.Context, a *Article) (*Article, error)
+}
+
+type wasmHook struct {
+	runtime string
+	module  string
+}
+
+// NewWasmHook checks that module looks like a WASM binary and that the
+// runtime is on PATH.
+func NewWasmHook(runtime, module string) (TransformHook, error) {
+	f, err := os.Open(module)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, []byte("\x00asm")) {
+		return nil, fmt.Errorf("%s: not a WASM module", module)
+	}
+	path, err := exec.LookPath(runtime)
+	if err != nil {
+		return nil, fmt.Errorf("wasm runtime: %w", err)
+	}
+	return &wasmHook{runtime: path, module: module}, nil
+}
+
+func (h *wasmHook) Transform(ctx context.Context, a *Article) (*Article, error) {
+	in, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	out, err := runPlugin(ctx, exec.CommandContext(ctx, h.runtime, "run", h.module), in)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", h.module, err)
+	}
+	var res *Article
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("plugin %s: bad output: %w", h.module, err)
+	}
+	return res, nil
+}
+
+/* -------------------------------
+   Input plugins (-input-plugin)
+
+   An input plugin is any executable.
+   For each input file it is run as
+
+     <plugin> <path>
+
+   with the file contents on stdin,
+   and must print one Article JSON
+   object per line (NDJSON) on
+   stdout. A non-zero exit fails
+   the file.
+--------------------------------*/
+
+// InputPlugin decodes input files through an external executable.
+type InputPlugin struct {
+	path   string
+	strict bool // -strict applies to the plugin's output too
+}
+
+// NewInputPlugin resolves the plugin executable.
+func NewInputPlugin(name string) (*InputPlugin, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("input plugin: %w", err)
+	}
+	return &InputPlugin{path: path}, nil
+}
+
+// Decode runs the plugin on one file and parses its NDJSON output.
+func (p *InputPlugin) Decode(ctx context.Context, file string, raw []byte) ([]Article, error) {
+	out, err := runPlugin(ctx, exec.CommandContext(ctx, p.path, file), raw)
+	if err != nil {
+		return nil, fmt.Errorf("input plugin: %w", err)
+	}
+	var arts []Article
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 0, 64<<10), 64<<20)
+	for line := 1; sc.Scan(); line++ {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		a, err := decodeArticle(b, p.strict)
+		if err != nil {
+			return nil, fmt.Errorf("input plugin: output line %d: %w", line, err)
+		}
+		arts = append(arts, a)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("input plugin: %w", err)
+	}
+	if len(arts) == 0 {
+		return nil, fmt.Errorf("input plugin: no articles in output")
+	}
+	return arts, nil
+}
+
+// runPlugin feeds stdin to cmd and returns its stdout. A non-zero exit
+// is reported together with the tail of stderr.
+func runPlugin(ctx context.Context, cmd *exec.Cmd, stdin []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 512 {
+			msg = "…" + msg[len(msg)-512:]
+		}
+		if msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
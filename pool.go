@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+/* -------------------------------
+   Worker pool
+
+   Fixed job queue, resizable set of
+   workers, and a pause gate checked
+   before each job is taken. Once the
+   run's context is cancelled the
+   gate stays open, so paused workers
+   drain the queue and Wait returns.
+--------------------------------*/
+
+type workerPool struct {
+	jobs chan string
+	work func(file string)
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	gate    *sync.Cond
+	paused  bool
+	stopped bool // ctx cancelled: the gate no longer holds workers
+	want    int  // target worker count
+	running int
+}
+
+func newWorkerPool(ctx context.Context, jobs chan string, workers int, work func(string)) *workerPool {
+	p := &workerPool{jobs: jobs, work: work}
+	p.gate = sync.NewCond(&p.mu)
+	context.AfterFunc(ctx, func() {
+		p.mu.Lock()
+		p.stopped = true
+		p.mu.Unlock()
+		p.gate.Broadcast()
+	})
+	p.Resize(workers)
+	return p
+}
+
+func (p *workerPool) worker() {
+	defer p.wg.Done()
+	for {
+		p.mu.Lock()
+		for p.paused && !p.stopped && p.running <= p.want {
+			p.gate.Wait()
+		}
+		if p.running > p.want {
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+
+		f, ok := <-p.jobs
+		if !ok {
+			p.mu.Lock()
+			p.running--
+			p.mu.Unlock()
+			return
+		}
+		p.work(f)
+	}
+}
+
+// Resize sets the number of workers; surplus workers exit after their
+// current job.
+func (p *workerPool) Resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.want = n
+	for p.running < p.want {
+		p.running++
+		p.wg.Add(1)
+		go p.worker()
+	}
+	p.gate.Broadcast()
+}
+
+// SetPaused stops or restarts handing out jobs; in-flight jobs finish.
+func (p *workerPool) SetPaused(paused bool) {
+	p.mu.Lock()
+	p.paused = paused
+	p.mu.Unlock()
+	p.gate.Broadcast()
+}
+
+type poolState struct {
+	Paused  bool `json:"paused"`
+	Workers int  `json:"workers"`
+	Running int  `json:"running"`
+}
+
+func (p *workerPool) State() poolState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return poolState{Paused: p.paused, Workers: p.want, Running: p.running}
+}
+
+// Wait blocks until the job channel is closed and drained.
+func (p *workerPool) Wait() { p.wg.Wait() }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolCancelWhilePaused(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs := make(chan string, 3)
+	var ran atomic.Int32
+	// paused before any worker can block on the queue
+	p := newWorkerPool(ctx, jobs, 0, func(string) {
+		if ctx.Err() == nil {
+			ran.Add(1)
+		}
+	})
+	p.SetPaused(true)
+	p.Resize(2)
+	for _, f := range []string{"a", "b", "c"} {
+		jobs <- f
+	}
+	close(jobs)
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after cancelling a paused pool")
+	}
+	if n := ran.Load(); n != 0 {
+		t.Errorf("%d jobs ran while paused", n)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+/* -------------------------------
+   Credentials profiles (-profile)
+
+   ~/.config/omnipub/credentials
+   (OMNIPUB_CREDENTIALS_FILE to
+   move it), INI style:
+
+   [acme]
+   api_key    = …
+   api        = https://…/api/v2
+   collection = 42
+
+   [globex]
+   key_source = vault:secret/data/globex
+   auth       = header:X-Api-Key
+
+   A profile only applies when
+   chosen with -profile or
+   OMNIPUB_PROFILE; flags given on
+   the command line still win.
+--------------------------------*/
+
+var errNoProfile = errors.New("not found")
+
+var profileFields = map[string]bool{
+	"api_key": true, "key_source": true, "api": true, "collection": true, "auth": true,
+}
+
+func defaultCredentialsFile() string {
+	if p := os.Getenv("OMNIPUB_CREDENTIALS_FILE"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "omnipub", "credentials")
+}
+
+// loadProfile reads section name from the credentials file at path.
+func loadProfile(path, name string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("profile %q: %w", name, err)
+	}
+	defer f.Close()
+
+	var (
+		section string
+		found   bool
+		vals    = make(map[string]string)
+	)
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || section == name
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want key = value", path, n)
+		}
+		k = strings.TrimSpace(k)
+		if !profileFields[k] {
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", path, n, k)
+		}
+		if section == name {
+			vals[k] = strings.TrimSpace(v)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("profile %q %w in %s", name, errNoProfile, path)
+	}
+	if vals["api_key"] != "" && vals["key_source"] != "" {
+		return nil, fmt.Errorf("profile %q: set api_key or key_source, not both", name)
+	}
+	return vals, nil
+}
+
+// warnCredentialsMode flags a credentials file other users can read.
+func warnCredentialsMode(path string) {
+	if st, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && st.Mode().Perm()&0o077 != 0 {
+		log.Printf("Warning: %s is readable by other users (mode %v); chmod 600 it", path, st.Mode().Perm())
+	}
+}
+
+// profileKey reads api_key from a profile; re-reading the file on every
+// call lets 401 recovery pick up an edited key.
+type profileKey struct {
+	path, name string
+}
+
+func (p profileKey) Key(context.Context) (string, error) {
+	vals, err := loadProfile(p.path, p.name)
+	if err != nil {
+		return "", err
+	}
+	if vals["api_key"] == "" {
+		return "", fmt.Errorf("profile %q has no api_key", p.name)
+	}
+	return vals["api_key"], nil
+}
+
+func (p profileKey) String() string { return "profile " + p.name }
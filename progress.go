@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* -------------------------------
+   Run progress
+
+   Shared by the workers; snapshots
+   feed the SIGUSR1 status dump.
+--------------------------------*/
+
+const (
+	recentErrorsKept = 20
+	rateWindow       = 256 // completions used for the current-rate estimate
+)
+
+type recentError struct {
+	File string    `json:"file"`
+	Err  string    `json:"error"`
+	At   time.Time `json:"at"`
+}
+
+type Progress struct {
+	runID   string
+	start   time.Time
+	total   int
+	budget  *retryBudget
+	rates   *rateStats
+	conns   *connStats
+	aborted error // why the run stopped early, nil if it ran to the end
+
+	failureFiles   map[failureClass]string // -save-failures output, set at the end
+	skippedUpFront []skippedFile           // left out at discovery, not counted in total
+
+	ok, fail, skipped atomic.Uint64
+
+	mu       sync.Mutex
+	inFlight map[string]time.Time
+	byKind   map[string]int // failures grouped by failureKind
+	byClass  map[failureClass]int
+	recent   []recentError
+	empty    []string // files with an article skipped for empty content
+	dups     []duplicateAlias
+	finished []time.Time // ring of recent completion times
+	next     int
+}
+
+func NewProgress(total int) *Progress {
+	return &Progress{
+		start:    time.Now(),
+		total:    total,
+		inFlight: make(map[string]time.Time),
+		byKind:   make(map[string]int),
+		byClass:  make(map[failureClass]int),
+
+		failureFiles: make(map[failureClass]string),
+	}
+}
+
+// begin marks file as in flight.
+func (p *Progress) begin(file string) {
+	p.mu.Lock()
+	p.inFlight[file] = time.Now()
+	p.mu.Unlock()
+}
+
+// finish records the outcome of file.
+func (p *Progress) finish(file string, err error, skipped bool) {
+	switch {
+	case skipped:
+		p.skipped.Add(1)
+	case err != nil:
+		p.fail.Add(1)
+	default:
+		p.ok.Add(1)
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.inFlight, file)
+	if errors.Is(err, errEmptyContent) {
+		p.empty = append(p.empty, file)
+	}
+	var dup *duplicateError
+	if errors.As(err, &dup) {
+		p.dups = append(p.dups, duplicateAlias{File: file, Of: dup.of})
+	}
+	if err != nil && !skipped {
+		p.byKind[failureKind(err)]++
+		p.byClass[classify(err)]++
+		p.recent = append(p.recent, recentError{File: file, Err: err.Error(), At: now})
+		if len(p.recent) > recentErrorsKept {
+			p.recent = p.recent[1:]
+		}
+	}
+	if len(p.finished) < rateWindow {
+		p.finished = append(p.finished, now)
+	} else {
+		p.finished[p.next] = now
+		p.next = (p.next + 1) % rateWindow
+	}
+}
+
+type inFlightItem struct {
+	File     string        `json:"file"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// ProgressSnapshot is a point-in-time view of the run.
+type ProgressSnapshot struct {
+	RunID       string               `json:"run_id"`
+	Elapsed     time.Duration        `json:"elapsed_ns"`
+	Total       int                  `json:"total"`
+	Done        int                  `json:"done"`
+	Remaining   int                  `json:"remaining"`
+	Success     uint64               `json:"success"`
+	Failure     uint64               `json:"failure"`
+	Skipped     uint64               `json:"skipped"`
+	Retries     int64                `json:"retries"`
+	UpFront     int                  `json:"skipped_up_front"`
+	Empty       int                  `json:"empty_content"`
+	Duplicates  int                  `json:"duplicates"`
+	ByKind      map[string]int       `json:"failures_by_kind"`
+	ByClass     map[failureClass]int `json:"failures_by_class"`
+	RatePerSec  float64              `json:"rate_per_sec"`  // since start
+	RecentRate  float64              `json:"recent_rate"`   // over the last completions
+	InFlight    []inFlightItem       `json:"in_flight"`     // longest-running first
+	RecentError []recentError        `json:"recent_errors"` // oldest first
+	RateLimit   *rateSummary         `json:"rate_limit,omitempty"`
+	Connections *connSummary         `json:"connections,omitempty"`
+}
+
+func (p *Progress) Snapshot() ProgressSnapshot {
+	now := time.Now()
+	s := ProgressSnapshot{
+		RunID:   p.runID,
+		Elapsed: now.Sub(p.start),
+		Total:   p.total,
+		Success: p.ok.Load(),
+		Failure: p.fail.Load(),
+		Skipped: p.skipped.Load(),
+		Retries: p.budget.Used(),
+		UpFront: len(p.skippedUpFront),
+
+		RateLimit:   p.rates.summary(),
+		Connections: p.conns.summary(),
+	}
+	s.Done = int(s.Success + s.Failure + s.Skipped)
+	s.Remaining = s.Total - s.Done
+	if secs := s.Elapsed.Seconds(); secs > 0 {
+		s.RatePerSec = float64(s.Done) / secs
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for f, t := range p.inFlight {
+		s.InFlight = append(s.InFlight, inFlightItem{File: f, Duration: now.Sub(t)})
+	}
+	sort.Slice(s.InFlight, func(i, j int) bool { return s.InFlight[i].Duration > s.InFlight[j].Duration })
+	s.RecentError = append([]recentError(nil), p.recent...)
+	s.Empty = len(p.empty)
+	s.Duplicates = len(p.dups)
+	s.ByKind = make(map[string]int, len(p.byKind))
+	for k, n := range p.byKind {
+		s.ByKind[k] = n
+	}
+	s.ByClass = make(map[failureClass]int, len(p.byClass))
+	for c, n := range p.byClass {
+		s.ByClass[c] = n
+	}
+	if n := len(p.finished); n > 1 {
+		oldest := p.finished[p.next%n]
+		if span := now.Sub(oldest).Seconds(); span > 0 {
+			s.RecentRate = float64(n) / span
+		}
+	}
+	return s
+}
+
+// eta estimates the time left from the recent rate, "–" when unknown.
+func (s ProgressSnapshot) eta() string {
+	if s.RecentRate <= 0 || s.Remaining <= 0 {
+		return "–"
+	}
+	left := time.Duration(float64(s.Remaining) / s.RecentRate * float64(time.Second))
+	return left.Round(time.Second).String()
+}
+
+// failureKind buckets an upload error for summaries: "http 429",
+// "timeout", "json-decode", "yaml-decode", "xml-decode", "network" or
+// "other".
+func failureKind(err error) string {
+	var syntax *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var netErr net.Error
+	var he *httpError
+	var ye *yamlError
+	var xe *xml.SyntaxError
+	switch {
+	case errors.As(err, &he):
+		return fmt.Sprintf("http %d", he.status)
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return "timeout"
+	case errors.As(err, &syntax) || errors.As(err, &typeErr):
+		return "json-decode"
+	case errors.As(err, &ye):
+		return "yaml-decode"
+	case errors.As(err, &xe):
+		return "xml-decode"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return "other"
+}
+
+// kindBreakdown lists failure counts, most common first:
+// "300× http 429, 47× http 413, 12× timeout".
+func kindBreakdown(byKind map[string]int) string {
+	kinds := make([]string, 0, len(byKind))
+	for k := range byKind {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if byKind[kinds[i]] != byKind[kinds[j]] {
+			return byKind[kinds[i]] > byKind[kinds[j]]
+		}
+		return kinds[i] < kinds[j]
+	})
+	parts := make([]string, len(kinds))
+	for i, k := range kinds {
+		parts[i] = fmt.Sprintf("%d× %s", byKind[k], k)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reportFailures breaks the failures down by kind and by class.
+func (p *Progress) reportFailures(w io.Writer) {
+	s := p.Snapshot()
+	if len(s.ByKind) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Failures: %s\n", kindBreakdown(s.ByKind))
+	fmt.Fprintf(w, "  by class: %d retryable, %d permanent, %d input\n",
+		s.ByClass[classRetryable], s.ByClass[classPermanent], s.ByClass[classInput])
+}
+
+// dump writes a human-readable status report.
+func (p *Progress) dump(w io.Writer) {
+	s := p.Snapshot()
+	fmt.Fprintf(w, "=== run %s: status after %s ===\n", s.RunID, s.Elapsed.Round(time.Second))
+	fmt.Fprintf(w, "done %d/%d (remaining %d)  success %d  failure %d  skipped %d  retries %d\n",
+		s.Done, s.Total, s.Remaining, s.Success, s.Failure, s.Skipped, s.Retries)
+	reportSkipped(w, p.skippedUpFront)
+	p.reportEmpty(w)
+	p.reportDuplicates(w)
+	if len(s.ByClass) > 0 {
+		fmt.Fprintf(w, "failures: %d retryable  %d permanent  %d input\n",
+			s.ByClass[classRetryable], s.ByClass[classPermanent], s.ByClass[classInput])
+		fmt.Fprintf(w, "by kind: %s\n", kindBreakdown(s.ByKind))
+	}
+	fmt.Fprintf(w, "throughput %.1f/s overall, %.1f/s recent\n", s.RatePerSec, s.RecentRate)
+	if s.RateLimit != nil {
+		fmt.Fprintln(w, s.RateLimit.text())
+	}
+	if s.Connections != nil {
+		fmt.Fprintln(w, s.Connections.text())
+	}
+	fmt.Fprintf(w, "in flight (%d):\n", len(s.InFlight))
+	for _, it := range s.InFlight {
+		fmt.Fprintf(w, "  %8s  %s\n", it.Duration.Round(time.Millisecond), it.File)
+	}
+	fmt.Fprintf(w, "recent errors (%d):\n", len(s.RecentError))
+	for _, e := range s.RecentError {
+		fmt.Fprintf(w, "  %s  %s → %s\n", e.At.Format(time.TimeOnly), e.File, e.Err)
+	}
+}
+
+// emptyFiles returns the files skipped for empty content so far.
+func (p *Progress) emptyFiles() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.empty...)
+}
+
+// reportEmpty lists the files skipped for empty content.
+func (p *Progress) reportEmpty(w io.Writer) {
+	files := p.emptyFiles()
+	if len(files) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Empty content, not uploaded (%d):\n", len(files))
+	for _, f := range files {
+		fmt.Fprintf(w, "  %s\n", f)
+	}
+}
+
+// duplicates returns the files skipped as duplicates so far.
+func (p *Progress) duplicates() []duplicateAlias {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]duplicateAlias(nil), p.dups...)
+}
+
+// reportDuplicates lists the files skipped as duplicates, by the file
+// that was uploaded.
+func (p *Progress) reportDuplicates(w io.Writer) {
+	dups := p.duplicates()
+	if len(dups) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Duplicates, not uploaded (%d):\n", len(dups))
+	for _, d := range dups {
+		fmt.Fprintf(w, "  %s = %s\n", d.File, d.Of)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Quarantine (-quarantine-dir)
+
+   Input files that cannot be
+   decoded are copied aside with a
+   <name>.error.txt next to each,
+   so bad data goes to the content
+   team instead of the retry loop.
+   The originals are left in place.
+--------------------------------*/
+
+type Quarantine struct {
+	dir string
+	mu  sync.Mutex // serializes name picking
+}
+
+func NewQuarantine(dir string) (*Quarantine, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("quarantine dir: %w", err)
+	}
+	return &Quarantine{dir: dir}, nil
+}
+
+// Add copies file into the quarantine with a report of why it failed and
+// returns the copy's path.
+func (q *Quarantine) Add(file string, cause error, runID string) (string, error) {
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	q.mu.Lock()
+	dst := q.freeName(filepath.Base(file))
+	err = os.WriteFile(dst, raw, 0o644)
+	q.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	var report strings.Builder
+	fmt.Fprintf(&report, "source:  %s\n", file)
+	fmt.Fprintf(&report, "time:    %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&report, "run:     %s\n", runID)
+	fmt.Fprintf(&report, "error:   %v\n", cause)
+	var syntax *json.SyntaxError
+	var notJSON *notJSONError
+	switch {
+	case errors.As(cause, &notJSON) && notJSON.mime != "":
+		fmt.Fprintf(&report, "content: %s\n", notJSON.mime)
+	case errors.As(cause, &syntax):
+		// Offset counts the offending byte itself
+		line, col := position(raw, syntax.Offset-1)
+		fmt.Fprintf(&report, "at:      line %d, column %d\n", line, col)
+	}
+	return dst, os.WriteFile(dst+".error.txt", []byte(report.String()), 0o644)
+}
+
+// freeName picks name in the quarantine dir, adding -2, -3 … when a file
+// of that name is already there.
+func (q *Quarantine) freeName(name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	dst := filepath.Join(q.dir, name)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(dst); errors.Is(err, os.ErrNotExist) {
+			return dst
+		}
+		dst = filepath.Join(q.dir, fmt.Sprintf("%s-%d%s", stem, i, ext))
+	}
+}
+
+// position converts a byte offset into 1-based line and column numbers.
+func position(raw []byte, off int64) (line, col int) {
+	off = min(max(off, 0), int64(len(raw)))
+	before := raw[:off]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = int(off) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
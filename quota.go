@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+/* -------------------------------
+   Collection capacity check
+   (-quota-check)
+
+   Before a run into -collection,
+   GET {api}/collections/{id} and
+   compare the item count plus the
+   files about to be uploaded with
+   the collection's limit, so a run
+   that cannot fit warns or stops
+   up front instead of failing on
+   4xx errors most of the way in.
+
+   Field names vary between API
+   versions; the common ones are
+   tried, at the top level or under
+   "collection" / "data". A server
+   without the endpoint or a limit
+   skips the check.
+--------------------------------*/
+
+var (
+	collectionCountKeys = []string{"item_count", "items_count", "count", "num_items"}
+	collectionLimitKeys = []string{"item_limit", "max_items", "items_limit", "quota", "limit"}
+)
+
+type collectionCapacity struct {
+	count, limit int // limit 0 = unknown or unlimited
+}
+
+// collectionCapacity asks the API how full collection id is.
+func (t *Transformer) collectionCapacity(ctx context.Context, id int) (collectionCapacity, error) {
+	res, err := t.getJSON(ctx, fmt.Sprintf("%s/collections/%d", t.apiBase, id))
+	if err != nil {
+		return collectionCapacity{}, err
+	}
+	doc, _ := res.(map[string]any)
+	var c collectionCapacity
+	for _, obj := range []any{doc, doc["collection"], doc["data"]} {
+		m, ok := obj.(map[string]any)
+		if !ok {
+			continue
+		}
+		if n, ok := firstInt(m, collectionCountKeys); ok && c.count == 0 {
+			c.count = n
+		}
+		if n, ok := firstInt(m, collectionLimitKeys); ok && c.limit == 0 {
+			c.limit = n
+		}
+	}
+	return c, nil
+}
+
+// firstInt returns the first of keys in m holding a number.
+func firstInt(m map[string]any, keys []string) (int, bool) {
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case float64:
+			return int(v), true
+		case string:
+			if n, err := strconv.Atoi(v); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// checkCapacity reports whether uploading n more items into collection
+// id fits; mode is warn or abort. It only says no in abort mode.
+func (t *Transformer) checkCapacity(ctx context.Context, id, n int, mode string) bool {
+	c, err := t.collectionCapacity(ctx, id)
+	if err != nil {
+		log.Printf("Capacity check for collection %d skipped: %v", id, err)
+		return true
+	}
+	if c.limit <= 0 {
+		log.Printf("Collection %d reports no item limit; capacity check skipped", id)
+		return true
+	}
+	free := c.limit - c.count
+	if n <= free {
+		log.Printf("Collection %d: %d/%d items, room for %d – this run adds up to %d", id, c.count, c.limit, free, n)
+		return true
+	}
+	log.Printf("Warning: collection %d: %d/%d items, room for %d, but this run would add %d", id, c.count, c.limit, max(free, 0), n)
+	return mode != "abort"
+}
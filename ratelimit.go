@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Rate-limit telemetry
+
+   Every upload response's
+   X-RateLimit-Limit / -Remaining /
+   -Reset (or the unprefixed
+   RateLimit-* draft headers) is
+   tracked over the run, along with
+   the 429s and the time workers sat
+   waiting them out. The summary
+   turns that into the worker count
+   that would have stayed under the
+   limit: limit per window × time
+   per upload.
+--------------------------------*/
+
+type rateStats struct {
+	workers int           // the batch's worker count, for comparison
+	backoff time.Duration // -backoff, part of each worker's cycle
+
+	mu           sync.Mutex
+	timed        int           // responses other than 429
+	latency      time.Duration // summed over timed responses
+	sampled      int           // responses carrying rate-limit headers
+	remainingSum float64
+	limit        int           // latest X-RateLimit-Limit
+	window       time.Duration // longest reset seen ≈ the window length
+	throttled    int           // 429 answers
+	waited       time.Duration // spent waiting out 429s
+}
+
+func newRateStats(workers int, backoff time.Duration) *rateStats {
+	return &rateStats{workers: workers, backoff: backoff}
+}
+
+// rateHeader reads X-RateLimit-name, falling back to RateLimit-name.
+func rateHeader(h http.Header, name string) (float64, bool) {
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		if v, err := strconv.ParseFloat(h.Get(prefix+name), 64); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// observe records one response. A nil rateStats records nothing.
+func (r *rateStats) observe(h http.Header, status int, latency time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if status == http.StatusTooManyRequests {
+		r.throttled++
+	} else {
+		r.timed++
+		r.latency += latency
+	}
+	if limit, ok := rateHeader(h, "Limit"); ok {
+		r.limit = int(limit)
+	}
+	if remaining, ok := rateHeader(h, "Remaining"); ok {
+		r.sampled++
+		r.remainingSum += remaining
+	}
+	if reset, ok := rateHeader(h, "Reset"); ok {
+		if reset > 1e9 { // a Unix time rather than seconds to go
+			reset -= float64(time.Now().Unix())
+		}
+		if d := time.Duration(reset * float64(time.Second)); d > r.window && d <= 24*time.Hour {
+			r.window = d
+		}
+	}
+}
+
+// throttled reports whether err is a 429 answer.
+func throttled(err error) bool {
+	var he *httpError
+	return errors.As(err, &he) && he.status == http.StatusTooManyRequests
+}
+
+// wait records time a worker spent held back by rate limiting.
+func (r *rateStats) wait(d time.Duration) {
+	if r == nil || d <= 0 {
+		return
+	}
+	r.mu.Lock()
+	r.waited += d
+	r.mu.Unlock()
+}
+
+// rateSummary is the telemetry as reported.
+type rateSummary struct {
+	Throttled        int     `json:"throttled"`
+	ThrottledSec     float64 `json:"throttled_sec"`
+	Limit            int     `json:"limit,omitempty"`
+	WindowSec        float64 `json:"window_sec,omitempty"`
+	AvgRemaining     float64 `json:"avg_remaining,omitempty"`
+	AvgUploadSec     float64 `json:"avg_upload_sec,omitempty"`
+	Workers          int     `json:"workers"`
+	SuggestedWorkers int     `json:"suggested_workers,omitempty"` // 0 = not enough data
+}
+
+// summary returns nil if the run saw neither rate-limit headers nor 429s.
+func (r *rateStats) summary() *rateSummary {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sampled == 0 && r.throttled == 0 {
+		return nil
+	}
+	s := &rateSummary{
+		Throttled:    r.throttled,
+		ThrottledSec: r.waited.Seconds(),
+		Limit:        r.limit,
+		WindowSec:    r.window.Seconds(),
+		Workers:      r.workers,
+	}
+	if r.sampled > 0 {
+		s.AvgRemaining = r.remainingSum / float64(r.sampled)
+	}
+	if r.timed > 0 {
+		s.AvgUploadSec = r.latency.Seconds() / float64(r.timed)
+	}
+	// each worker makes one request per (upload + backoff); the limit
+	// allows limit/window requests per second
+	cycle := s.AvgUploadSec + r.backoff.Seconds()
+	if r.limit > 0 && r.window > 0 && cycle > 0 {
+		perSec := float64(r.limit) / r.window.Seconds()
+		s.SuggestedWorkers = max(1, int(math.Floor(perSec*cycle)))
+	}
+	return s
+}
+
+func (s *rateSummary) text() string {
+	line := fmt.Sprintf("Rate limiting: %d × 429, %s of worker time spent throttled", s.Throttled,
+		time.Duration(s.ThrottledSec*float64(time.Second)).Round(time.Second))
+	if s.Limit > 0 {
+		line += fmt.Sprintf("; limit %d", s.Limit)
+		if s.WindowSec > 0 {
+			line += fmt.Sprintf(" per %s", time.Duration(s.WindowSec*float64(time.Second)).Round(time.Second))
+		}
+		line += fmt.Sprintf(", %.0f remaining on average (%.0f%%)", s.AvgRemaining, 100*s.AvgRemaining/float64(s.Limit))
+	}
+	switch {
+	case s.SuggestedWorkers > 0:
+		line += fmt.Sprintf("\n  At %.2fs per upload, -workers %d would stay under the limit (this run used %d)",
+			s.AvgUploadSec, s.SuggestedWorkers, s.Workers)
+	case s.Throttled > 0:
+		line += "\n  No X-RateLimit-Limit/-Reset headers to size -workers from"
+	}
+	return line
+}
+
+// report writes the telemetry, if there is any.
+func (r *rateStats) report(w io.Writer) {
+	if s := r.summary(); s != nil {
+		fmt.Fprintln(w, s.text())
+	}
+}
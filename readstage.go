@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+/* -------------------------------
+   Read stage
+
+   Each input is read from disk once
+   per upload. Its SHA-256 is taken
+   in a goroutine while the bytes
+   decode, and -manifest, -checksums
+   and -transform-cache all use that
+   one hash, so they add no second
+   pass over the corpus.
+
+   The byte count is checked against
+   the size at open: a file still
+   being written fails instead of
+   going out half-read.
+
+   Files decoded ahead of their
+   upload (-order publish-date,
+   -order-serial lanes) keep their
+   articles, keyed by path and hash,
+   until the upload takes them, so
+   input plugins and the rest of the
+   decoding run once per file.
+--------------------------------*/
+
+type inputBytes struct {
+	path string
+	raw  []byte
+
+	hashed chan struct{}
+	sum    [32]byte
+}
+
+func readInput(path string) (*inputBytes, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, st.Size()+bytes.MinRead))
+	if _, err := buf.ReadFrom(f); err != nil {
+		return nil, err
+	}
+	if int64(buf.Len()) != st.Size() {
+		return nil, fmt.Errorf("changed while reading: %d bytes read, %d at open", buf.Len(), st.Size())
+	}
+	in := &inputBytes{path: path, raw: buf.Bytes(), hashed: make(chan struct{})}
+	go func() {
+		in.sum = sha256.Sum256(in.raw)
+		close(in.hashed)
+	}()
+	return in, nil
+}
+
+// Sum waits for the hash of the bytes read.
+func (in *inputBytes) Sum() [32]byte {
+	<-in.hashed
+	return in.sum
+}
+
+func (in *inputBytes) hexSum() string {
+	sum := in.Sum()
+	return hex.EncodeToString(sum[:])
+}
+
+func (in *inputBytes) size() int64 { return int64(len(in.raw)) }
+
+type inputBytesKey struct{}
+
+func withInputBytes(ctx context.Context, in *inputBytes) context.Context {
+	return context.WithValue(ctx, inputBytesKey{}, in)
+}
+
+// decodedInput holds a file's articles decoded ahead of its upload.
+type decodedInput struct {
+	sum   [32]byte // of the bytes decoded
+	arts  []Article
+	notes []string // input fixes, for the upload's report
+}
+
+// decodeAhead decodes file before its upload and keeps the articles for
+// it. They are returned for reading only: the upload changes them.
+func (t *Transformer) decodeAhead(ctx context.Context, file string) ([]Article, error) {
+	in, err := t.readInput(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	res := &uploadResult{}
+	arts, err := t.decodeFile(withUploadResult(withInputBytes(ctx, in), res), file)
+	if err != nil {
+		return nil, err // decoded again, and reported, by the upload
+	}
+	t.decoded.Store(file, &decodedInput{sum: in.Sum(), arts: arts, notes: res.Notes()})
+	return arts, nil
+}
+
+// takeDecoded returns the articles decodeAhead kept for in, once, and
+// passes its notes on to the upload's result.
+func (t *Transformer) takeDecoded(ctx context.Context, in *inputBytes) ([]Article, bool) {
+	v, ok := t.decoded.LoadAndDelete(in.path)
+	if !ok {
+		return nil, false
+	}
+	d := v.(*decodedInput)
+	if d.sum != in.Sum() {
+		return nil, false // changed since
+	}
+	for _, n := range d.notes {
+		uploadResultFrom(ctx).note(n)
+	}
+	return d.arts, true
+}
+
+// readInput returns the bytes of file already read for this upload, or
+// reads them.
+func (t *Transformer) readInput(ctx context.Context, file string) (*inputBytes, error) {
+	if in, ok := ctx.Value(inputBytesKey{}).(*inputBytes); ok && in.path == file {
+		return in, nil
+	}
+	return readInput(file)
+}
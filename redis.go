@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Shared work queue in Redis
+   (-redis URL)
+
+   For one migration spread over a
+   fleet: a single
+   `-redis URL -redis-push` lists
+   the files as usual and pushes
+   them onto KEY:pending. Every
+   `-redis URL` instance then moves
+   paths from there to its own
+   KEY:processing:ID list, uploads
+   them and records the outcome:
+
+   KEY:stats     hash  uploaded,
+                       failed, skipped
+   KEY:failed    list  failed paths
+   KEY:alive:ID  TTL'd while running
+
+   Paths left in the processing
+   list of an instance whose alive
+   key expired – it crashed or was
+   evicted – go back to pending
+   when another instance runs dry.
+   An instance exits once pending
+   is empty.
+
+   Needs Redis 6.2+ (BLMOVE).
+--------------------------------*/
+
+const (
+	redisAliveTTL  = 30 * time.Second
+	redisBlockWait = 2 * time.Second
+)
+
+var errRedisNil = errors.New("redis: nil")
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn is one RESP connection; do is safe for concurrent use. After
+// a read or write error the connection is dropped, since a reply may be
+// half read, and the next command dials again.
+type redisConn struct {
+	url  *url.URL
+	mu   sync.Mutex
+	conn net.Conn // nil until redialled
+	r    *bufio.Reader
+}
+
+// dialRedis connects to redis://[user:password@]host[:port][/db], or
+// rediss:// for TLS.
+func dialRedis(ctx context.Context, raw string) (*redisConn, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("%q: want redis:// or rediss://", raw)
+	}
+	c := &redisConn{url: u}
+	if err := c.dial(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// dial opens the connection and authenticates; c.mu is held or c is
+// not shared yet.
+func (c *redisConn) dial(ctx context.Context) error {
+	u := c.url
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "rediss" {
+		conn, err = (&tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: u.Hostname()}}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn, c.r = conn, bufio.NewReader(conn)
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		args := []string{"AUTH", pass}
+		if name := u.User.Username(); name != "" {
+			args = []string{"AUTH", name, pass}
+		}
+		if _, err := c.roundTrip(0, args...); err != nil {
+			c.drop()
+			return err
+		}
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" && db != "0" {
+		if _, err := c.roundTrip(0, "SELECT", db); err != nil {
+			c.drop()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisConn) drop() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.r = nil, nil
+	}
+}
+
+// do sends one command and reads its reply; block extends the deadline
+// for blocking commands.
+func (c *redisConn) do(block time.Duration, args ...string) (any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := c.dial(ctx)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+	v, err := c.roundTrip(block, args...)
+	if err != nil && !isRedisReply(err) {
+		c.drop()
+	}
+	return v, err
+}
+
+// isRedisReply reports whether err is an answer from the server, which
+// leaves the connection usable, rather than a failure to talk to it.
+func isRedisReply(err error) bool {
+	var re redisError
+	return errors.Is(err, errRedisNil) || errors.As(err, &re)
+}
+
+func (c *redisConn) roundTrip(block time.Duration, args ...string) (any, error) {
+	c.conn.SetDeadline(time.Now().Add(10*time.Second + block))
+	w := bufio.NewWriter(c.conn)
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read reads one reply. Nil and error elements of an array are kept as
+// nil and redisError values so the rest of the array is still read.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		items := make([]any, n)
+		for i := range items {
+			v, err := c.read()
+			var re redisError
+			switch {
+			case errors.As(err, &re):
+				items[i] = re
+			case err != nil && !errors.Is(err, errRedisNil):
+				return nil, err
+			default:
+				items[i] = v
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+func (c *redisConn) int(args ...string) (int64, error) {
+	v, err := c.do(0, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("redis %s: unexpected reply %v", args[0], v)
+	}
+	return n, nil
+}
+
+func (c *redisConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drop()
+	return nil
+}
+
+// RedisQueue is a workQueue shared by every instance using the same key.
+type RedisQueue struct {
+	url, key, id string
+
+	conn  *redisConn // Done, heartbeat, recovery; Feed dials its own
+	count int
+
+	stopAlive func()
+}
+
+func NewRedisQueue(ctx context.Context, rawURL, key string) (*RedisQueue, error) {
+	conn, err := dialRedis(ctx, rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	q := &RedisQueue{url: rawURL, key: key, id: redisInstanceID(), conn: conn}
+	if _, err := conn.do(0, "PING"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	return q, nil
+}
+
+// redisInstanceID names this instance's processing list and alive key:
+// host and PID for people, plus a random suffix, since containers on
+// different nodes often share both.
+func redisInstanceID() string {
+	host, _ := os.Hostname()
+	var b [4]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b[:]))
+}
+
+func (q *RedisQueue) pendingKey() string    { return q.key + ":pending" }
+func (q *RedisQueue) processingKey() string { return q.key + ":processing:" + q.id }
+func (q *RedisQueue) aliveKey(id string) string {
+	return q.key + ":alive:" + id
+}
+
+// Push appends files to the shared queue.
+func (q *RedisQueue) Push(files []string) error {
+	const chunk = 1000
+	for len(files) > 0 {
+		n := min(chunk, len(files))
+		args := append([]string{"RPUSH", q.pendingKey()}, files[:n]...)
+		if _, err := q.conn.do(0, args...); err != nil {
+			return err
+		}
+		files = files[n:]
+	}
+	return nil
+}
+
+// Start announces this instance and takes stock of the queue.
+func (q *RedisQueue) Start() (int, error) {
+	if err := q.alive(); err != nil {
+		return 0, err
+	}
+	q.stopAlive = every(redisAliveTTL/3, func() {
+		if err := q.alive(); err != nil {
+			log.Printf("Error refreshing redis heartbeat: %v", err)
+		}
+	})
+	q.recover()
+	n, err := q.conn.int("LLEN", q.pendingKey())
+	q.count = int(n)
+	return q.count, err
+}
+
+func (q *RedisQueue) alive() error {
+	_, err := q.conn.do(0, "SET", q.aliveKey(q.id), "1", "PX", strconv.FormatInt(redisAliveTTL.Milliseconds(), 10))
+	return err
+}
+
+// Len is the shared queue's length when this instance started; other
+// instances take from it too.
+func (q *RedisQueue) Len() int { return q.count }
+
+func (q *RedisQueue) Feed(ctx context.Context, jobs chan<- string) {
+	defer close(jobs)
+	conn, err := dialRedis(ctx, q.url)
+	if err != nil {
+		log.Printf("Error reading redis queue: %v", err)
+		return
+	}
+	defer conn.Close()
+	wait := strconv.FormatFloat(redisBlockWait.Seconds(), 'f', -1, 64)
+	for ctx.Err() == nil {
+		v, err := conn.do(redisBlockWait, "BLMOVE", q.pendingKey(), q.processingKey(), "LEFT", "RIGHT", wait)
+		if errors.Is(err, errRedisNil) {
+			// dry: take back the work of dead instances, else stop
+			if q.recover() == 0 {
+				if n, err := q.conn.int("LLEN", q.pendingKey()); err == nil && n == 0 {
+					return
+				}
+			}
+			continue
+		}
+		if err != nil {
+			// the connection is dropped and redialled on the next try; a
+			// path moved by a lost reply sits in our processing list,
+			// which Close hands back
+			log.Printf("Error reading redis queue, retrying: %v", err)
+			select {
+			case <-time.After(redisBlockWait):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		file, _ := v.(string)
+		select {
+		case jobs <- file:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Done records the outcome of file and drops it from this instance's
+// processing list.
+func (q *RedisQueue) Done(file string, err error) {
+	status := "uploaded"
+	switch {
+	case errors.Is(err, errSkipped):
+		status = "skipped"
+	case err != nil:
+		status = "failed"
+		if _, rerr := q.conn.do(0, "RPUSH", q.key+":failed", file); rerr != nil {
+			log.Printf("Error recording %s in redis: %v", file, rerr)
+		}
+	}
+	if _, rerr := q.conn.do(0, "HINCRBY", q.key+":stats", status, "1"); rerr != nil {
+		log.Printf("Error recording %s in redis: %v", file, rerr)
+	}
+	if _, rerr := q.conn.do(0, "LREM", q.processingKey(), "1", file); rerr != nil {
+		log.Printf("Error recording %s in redis: %v", file, rerr)
+	}
+}
+
+// Close hands paths taken but not worked on – after an abort – back to
+// the shared queue.
+func (q *RedisQueue) Close() {
+	if q.stopAlive != nil {
+		q.stopAlive()
+	}
+	if n := q.requeue(q.processingKey()); n > 0 {
+		log.Printf("Returned %d unstarted files to %s", n, q.pendingKey())
+	}
+	q.conn.do(0, "DEL", q.aliveKey(q.id))
+}
+
+// recover requeues the processing lists of instances no longer alive.
+func (q *RedisQueue) recover() int {
+	total := 0
+	cursor := "0"
+	prefix := q.key + ":processing:"
+	for {
+		v, err := q.conn.do(0, "SCAN", cursor, "MATCH", prefix+"*", "COUNT", "100")
+		if err != nil {
+			log.Printf("Error scanning redis queue: %v", err)
+			return total
+		}
+		reply, _ := v.([]any)
+		if len(reply) != 2 {
+			return total
+		}
+		cursor, _ = reply[0].(string)
+		keys, _ := reply[1].([]any)
+		for _, k := range keys {
+			key, _ := k.(string)
+			id := strings.TrimPrefix(key, prefix)
+			if id == q.id {
+				continue
+			}
+			if alive, err := q.conn.int("EXISTS", q.aliveKey(id)); err != nil || alive > 0 {
+				continue
+			}
+			if n := q.requeue(key); n > 0 {
+				log.Printf("Requeued %d files left by instance %s", n, id)
+				total += n
+			}
+		}
+		if cursor == "0" {
+			return total
+		}
+	}
+}
+
+// requeue moves the paths in list back to the front of pending.
+func (q *RedisQueue) requeue(list string) int {
+	n := 0
+	for {
+		_, err := q.conn.do(0, "LMOVE", list, q.pendingKey(), "RIGHT", "LEFT")
+		if err != nil {
+			if !errors.Is(err, errRedisNil) {
+				log.Printf("Error requeueing %s: %v", list, err)
+			}
+			return n
+		}
+		n++
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis answers each command on a connection with the next of
+// replies (raw RESP), after recording it. A reply ending in "!" is sent
+// without it and the connection closed, as if the server went away.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	replies  []string
+	commands []string // "CMD arg …", across connections
+	conns    int
+}
+
+func newFakeRedis(t *testing.T, replies ...string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, replies: replies}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) url() string { return "redis://" + f.ln.Addr().String() }
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, strings.Join(args, " "))
+		var reply string
+		if len(f.replies) > 0 {
+			reply, f.replies = f.replies[0], f.replies[1:]
+		}
+		f.mu.Unlock()
+		if cut, ok := strings.CutSuffix(reply, "!"); ok {
+			io.WriteString(conn, cut)
+			return
+		}
+		io.WriteString(conn, reply)
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+func TestRedisReplies(t *testing.T) {
+	for _, tc := range []struct {
+		reply string
+		want  any
+		err   error
+	}{
+		{"+OK\r\n", "OK", nil},
+		{":42\r\n", int64(42), nil},
+		{"$5\r\nhello\r\n", "hello", nil},
+		{"$0\r\n\r\n", "", nil},
+		{"$7\r\na\r\nb\r\nc\r\n", "a\r\nb\r\nc", nil},
+		{"$-1\r\n", nil, errRedisNil},
+		{"*-1\r\n", nil, errRedisNil},
+		{"-ERR wrong type\r\n", nil, redisError("ERR wrong type")},
+		{"*0\r\n", []any{}, nil},
+		{"*2\r\n$1\r\n0\r\n*2\r\n$3\r\nk:a\r\n$3\r\nk:b\r\n", []any{"0", []any{"k:a", "k:b"}}, nil},
+		// nil and error elements do not cut the array short
+		{"*3\r\n$-1\r\n-ERR no\r\n:1\r\n", []any{nil, redisError("ERR no"), int64(1)}, nil},
+	} {
+		// each reply is followed by a PONG: the stream must stay in step
+		f := newFakeRedis(t, tc.reply, "+PONG\r\n")
+		c, err := dialRedis(context.Background(), f.url())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.do(0, "TEST")
+		if !errors.Is(err, tc.err) || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: got %#v, %v; want %#v, %v", tc.reply, got, err, tc.want, tc.err)
+		}
+		if pong, err := c.do(0, "PING"); pong != "PONG" || err != nil {
+			t.Errorf("%q: next reply %#v, %v; want PONG", tc.reply, pong, err)
+		}
+		c.Close()
+	}
+}
+
+func TestRedisRedialsAfterError(t *testing.T) {
+	// the first connection dies mid-reply; the second one works
+	f := newFakeRedis(t, "+OK\r\n", "+PONG\r\n", "*2\r\n$3\r\nfoo\r\n!", "+OK\r\n", ":3\r\n")
+	u := "redis://:secret@" + f.ln.Addr().String()
+	c, err := dialRedis(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.do(0, "PING"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.do(0, "LRANGE", "k", "0", "-1"); err == nil {
+		t.Fatal("truncated reply: want an error")
+	}
+	// a fresh connection, authenticated again, answers the next command
+	n, err := c.int("LLEN", "k")
+	if err != nil || n != 3 {
+		t.Errorf("after redial: LLEN = %d, %v; want 3", n, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conns != 2 {
+		t.Errorf("%d connections, want 2", f.conns)
+	}
+	want := []string{"AUTH secret", "PING", "LRANGE k 0 -1", "AUTH secret", "LLEN k"}
+	if !reflect.DeepEqual(f.commands, want) {
+		t.Errorf("commands %q, want %q", f.commands, want)
+	}
+}
+
+func TestRedisInstanceID(t *testing.T) {
+	a, b := redisInstanceID(), redisInstanceID()
+	if a == b {
+		t.Errorf("two instance IDs are both %q", a)
+	}
+	if !strings.Contains(a, "-"+strconv.Itoa(os.Getpid())+"-") {
+		t.Errorf("instance ID %q lacks the PID", a)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Run report (-report)
+
+   One row per file of the run:
+   path, status (uploaded, skipped
+   or failed), the last HTTP status,
+   upload attempts, duration, item
+   IDs and URLs, failure class and
+   the error or skip reason.
+
+   -report-format json (default)
+   writes {"run_id", "files": […]};
+   csv writes a header and a row per
+   file, for spreadsheets; html a
+   self-contained page (see
+   reporthtml.go). The file is
+   rewritten after each run.
+--------------------------------*/
+
+var reportFormats = map[string]bool{"json": true, "csv": true, "html": true}
+
+type reportRow struct {
+	Path       string       `json:"path"`
+	Status     string       `json:"status"`
+	HTTPStatus int          `json:"http_status,omitempty"`
+	Attempts   int          `json:"attempts"`
+	Duration   float64      `json:"duration_sec"`
+	ItemIDs    []string     `json:"item_ids,omitempty"`
+	ItemURLs   []string     `json:"item_urls,omitempty"`
+	Class      failureClass `json:"class,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Quarantine string       `json:"quarantined,omitempty"` // copy in -quarantine-dir
+	Notes      []string     `json:"notes,omitempty"`       // input fixes, e.g. by -lenient
+}
+
+type RunReport struct {
+	path, format string
+
+	mu   sync.Mutex
+	rows []reportRow
+}
+
+func NewRunReport(path, format string) (*RunReport, error) {
+	if !reportFormats[format] {
+		return nil, fmt.Errorf("report-format %q: want json, csv or html", format)
+	}
+	return &RunReport{path: path, format: format}, nil
+}
+
+// add records the outcome of file; a nil report records nothing.
+func (r *RunReport) add(file string, res *uploadResult, err error, took time.Duration, quarantined string) {
+	if r == nil {
+		return
+	}
+	row := reportRow{Path: file, Status: "uploaded", Duration: took.Round(time.Millisecond).Seconds(), Quarantine: quarantined}
+	row.HTTPStatus, row.Attempts = res.Status()
+	row.ItemIDs, row.ItemURLs = res.IDs(), res.URLs()
+	row.Notes = res.Notes()
+	switch {
+	case err == nil:
+	case errors.Is(err, errSkipped):
+		row.Status, row.Error = "skipped", err.Error()
+	default:
+		row.Status, row.Class, row.Error = "failed", classify(err), err.Error()
+	}
+	r.mu.Lock()
+	r.rows = append(r.rows, row)
+	r.mu.Unlock()
+}
+
+// write saves the run's rows, sorted by path, and starts afresh.
+func (r *RunReport) write(runID string) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	rows := r.rows
+	r.rows = nil
+	r.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Path < rows[j].Path })
+
+	var b bytes.Buffer
+	switch r.format {
+	case "csv":
+		w := csv.NewWriter(&b)
+		w.Write([]string{"path", "status", "http_status", "attempts", "duration_sec", "item_id", "item_url", "class", "error", "quarantined", "notes"})
+		for _, row := range rows {
+			status := ""
+			if row.HTTPStatus != 0 {
+				status = strconv.Itoa(row.HTTPStatus)
+			}
+			w.Write([]string{
+				row.Path, row.Status, status, strconv.Itoa(row.Attempts),
+				strconv.FormatFloat(row.Duration, 'f', 3, 64),
+				strings.Join(row.ItemIDs, " "), strings.Join(row.ItemURLs, " "),
+				string(row.Class), row.Error, row.Quarantine, strings.Join(row.Notes, "; "),
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	case "html":
+		if err := writeHTMLReport(&b, runID, rows, filepath.Dir(r.path)); err != nil {
+			return err
+		}
+	default:
+		if rows == nil {
+			rows = []reportRow{}
+		}
+		doc := struct {
+			RunID string      `json:"run_id"`
+			Files []reportRow `json:"files"`
+		}{runID, rows}
+		out, _ := json.MarshalIndent(doc, "", "  ")
+		b.Write(append(out, '\n'))
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"cmp"
+	"html/template"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+)
+
+/* -------------------------------
+   HTML run report
+
+   -report-format html writes one
+   page with no outside assets, to
+   attach to a ticket:
+
+   - totals and a bar of uploaded,
+     skipped and failed files
+   - failures by class, and the
+     most common errors
+   - a histogram of upload times
+     and the slowest uploads
+   - every failure, linked to its
+     copy in -quarantine-dir
+
+   Links are relative to the
+   report's directory.
+--------------------------------*/
+
+const (
+	reportSlowest   = 20 // uploads listed as slowest
+	reportTopErrors = 10 // distinct errors listed
+)
+
+// durationBuckets are the histogram's upper bounds in seconds.
+var durationBuckets = []float64{0.5, 1, 2, 5, 10, 30}
+
+type reportBar struct {
+	Label string
+	Count int
+	Pct   float64 // of the chart's largest (or total) count
+	Class string  // CSS class
+}
+
+type reportPage struct {
+	RunID     string
+	Generated string
+	Total     int
+	Status    []reportBar
+	Attempts  int
+	Seconds   float64
+	Classes   []reportBar
+	Errors    []reportBar
+	Durations []reportBar
+	Slowest   []reportRow
+	Failures  []reportFailure
+}
+
+type reportFailure struct {
+	reportRow
+	Link string // to the quarantined copy
+}
+
+func writeHTMLReport(w io.Writer, runID string, rows []reportRow, dir string) error {
+	page := reportPage{RunID: runID, Generated: time.Now().Format(time.RFC1123), Total: len(rows)}
+
+	byStatus := map[string]int{}
+	byClass := map[failureClass]int{}
+	byError := map[string]int{}
+	buckets := make([]int, len(durationBuckets)+1)
+	var uploads []reportRow
+	for _, r := range rows {
+		byStatus[r.Status]++
+		page.Attempts += r.Attempts
+		page.Seconds += r.Duration
+		if r.Status == "failed" {
+			byClass[r.Class]++
+			byError[errorSummary(r.Error)]++
+			f := reportFailure{reportRow: r}
+			if r.Quarantine != "" {
+				f.Link = relativeLink(dir, r.Quarantine)
+			}
+			page.Failures = append(page.Failures, f)
+		}
+		if r.Attempts > 0 {
+			uploads = append(uploads, r)
+			i, _ := slices.BinarySearch(durationBuckets, r.Duration)
+			buckets[i]++
+		}
+	}
+
+	for _, s := range []string{"uploaded", "skipped", "failed"} {
+		page.Status = append(page.Status, reportBar{Label: s, Count: byStatus[s], Class: s})
+	}
+	scaleBars(page.Status, len(rows))
+	for _, c := range failureClasses {
+		if byClass[c] > 0 {
+			page.Classes = append(page.Classes, reportBar{Label: string(c), Count: byClass[c], Class: "failed"})
+		}
+	}
+	scaleBars(page.Classes, 0)
+	for msg, n := range byError {
+		page.Errors = append(page.Errors, reportBar{Label: msg, Count: n, Class: "failed"})
+	}
+	slices.SortFunc(page.Errors, func(a, b reportBar) int {
+		return cmp.Or(b.Count-a.Count, cmp.Compare(a.Label, b.Label))
+	})
+	page.Errors = page.Errors[:min(len(page.Errors), reportTopErrors)]
+	scaleBars(page.Errors, 0)
+	if len(uploads) > 0 {
+		lower := 0.0
+		for i, n := range buckets {
+			label := "> " + formatSeconds(lower)
+			if i < len(durationBuckets) {
+				label = formatSeconds(lower) + "–" + formatSeconds(durationBuckets[i])
+				lower = durationBuckets[i]
+			}
+			page.Durations = append(page.Durations, reportBar{Label: label, Count: n, Class: "uploaded"})
+		}
+		scaleBars(page.Durations, 0)
+	}
+
+	slices.SortFunc(uploads, func(a, b reportRow) int { return cmp.Compare(b.Duration, a.Duration) })
+	page.Slowest = uploads[:min(len(uploads), reportSlowest)]
+	return reportTemplate.Execute(w, page)
+}
+
+// scaleBars sets each bar's width as a share of total, or of the largest
+// count when total is 0.
+func scaleBars(bars []reportBar, total int) {
+	if total == 0 {
+		for _, b := range bars {
+			total = max(total, b.Count)
+		}
+	}
+	for i := range bars {
+		if total > 0 {
+			bars[i].Pct = 100 * float64(bars[i].Count) / float64(total)
+		}
+	}
+}
+
+// errorSummary shortens an error for grouping: the request ID and the
+// response body are dropped.
+func errorSummary(msg string) string {
+	if len(msg) > 8 && msg[:5] == "http " {
+		return msg[:8]
+	}
+	if len(msg) > 80 {
+		return truncateUTF8(msg, 80) + "…"
+	}
+	return msg
+}
+
+// relativeLink returns the path of target relative to dir, slash-separated.
+func relativeLink(dir, target string) string {
+	absDir, err1 := filepath.Abs(dir)
+	absTarget, err2 := filepath.Abs(target)
+	if err1 != nil || err2 != nil {
+		return filepath.ToSlash(target)
+	}
+	if rel, err := filepath.Rel(absDir, absTarget); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(absTarget)
+}
+
+func formatSeconds(s float64) string {
+	return (time.Duration(s * float64(time.Second))).String()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": func(f float64) string { return strconv.FormatFloat(f, 'f', 1, 64) + "%" },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Omnipub upload {{.RunID}}</title>
+<style>
+body { font: 14px/1.4 system-ui, sans-serif; margin: 2em auto; max-width: 60em; color: #222; }
+h1 { font-size: 1.5em; } h2 { font-size: 1.15em; margin-top: 2em; border-bottom: 1px solid #ddd; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .25em .5em; border-bottom: 1px solid #eee; vertical-align: top; }
+td.n, th.n { text-align: right; white-space: nowrap; }
+.stack { display: flex; height: 1.6em; border-radius: 3px; overflow: hidden; background: #eee; }
+.bar { height: 1em; min-width: 1px; border-radius: 2px; }
+.uploaded { background: #3a9d5d; } .skipped { background: #c9a227; } .failed { background: #c8443a; }
+.chart td:first-child { width: 30%; } .chart td:last-child { width: 55%; }
+.muted { color: #777; } code { font-size: .9em; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>Omnipub upload {{.RunID}}</h1>
+<p class="muted">Generated {{.Generated}}. {{.Total}} files, {{.Attempts}} upload attempts, {{printf "%.1f" .Seconds}} s spent on files.</p>
+
+<div class="stack">{{range .Status}}{{if .Count}}<div class="{{.Class}}" style="width: {{pct .Pct}}" title="{{.Label}}: {{.Count}}"></div>{{end}}{{end}}</div>
+<table class="chart">{{range .Status}}
+<tr><td>{{.Label}}</td><td class="n">{{.Count}}</td><td class="n">{{pct .Pct}}</td></tr>{{end}}
+</table>
+
+{{if .Classes}}<h2>Failures by class</h2>
+<table class="chart">{{range .Classes}}
+<tr><td>{{.Label}}</td><td class="n">{{.Count}}</td><td><div class="bar {{.Class}}" style="width: {{pct .Pct}}"></div></td></tr>{{end}}
+</table>
+<h2>Most common errors</h2>
+<table class="chart">{{range .Errors}}
+<tr><td><code>{{.Label}}</code></td><td class="n">{{.Count}}</td><td><div class="bar {{.Class}}" style="width: {{pct .Pct}}"></div></td></tr>{{end}}
+</table>{{end}}
+
+{{if .Durations}}<h2>Upload times</h2>
+<table class="chart">{{range .Durations}}
+<tr><td>{{.Label}}</td><td class="n">{{.Count}}</td><td><div class="bar {{.Class}}" style="width: {{pct .Pct}}"></div></td></tr>{{end}}
+</table>
+<h2>Slowest uploads</h2>
+<table>
+<tr><th>File</th><th>Status</th><th class="n">Attempts</th><th class="n">Time</th><th>Item</th></tr>{{range .Slowest}}
+<tr><td><code>{{.Path}}</code></td><td>{{.Status}}</td><td class="n">{{.Attempts}}</td><td class="n">{{printf "%.2f" .Duration}} s</td><td>{{range .ItemURLs}}<a href="{{.}}">{{.}}</a> {{else}}{{range .ItemIDs}}{{.}} {{end}}{{end}}</td></tr>{{end}}
+</table>{{end}}
+
+{{if .Failures}}<h2>Failures ({{len .Failures}})</h2>
+<table>
+<tr><th>File</th><th>Class</th><th>Error</th><th>Quarantined</th></tr>{{range .Failures}}
+<tr><td><code>{{.Path}}</code></td><td>{{.Class}}</td><td><code>{{.Error}}</code></td><td>{{if .Link}}<a href="{{.Link}}">copy</a> · <a href="{{.Link}}.error.txt">report</a>{{end}}</td></tr>{{end}}
+</table>{{end}}
+</body>
+</html>
+`))
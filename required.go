@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* -------------------------------
+   Required fields (-require)
+
+   -require "title,link,content=placeholder"
+
+   Each field may name what happens
+   when it is empty (default
+   -require-action):
+
+   skip        – do not upload it
+   fail        – count it as an
+                 input failure
+   abort       – fail it and stop
+                 the run
+   placeholder – upload with a
+                 stand-in: title,
+                 content and author
+                 have one built in;
+                 "placeholder:TEXT"
+                 sets any field's
+
+   Checked after plugins, enrichment
+   and normalization, so a field they
+   fill counts as present.
+--------------------------------*/
+
+var defaultPlaceholders = map[string]string{
+	"title":   "Untitled",
+	"content": "<p>No content available.</p>",
+	"author":  "Unknown",
+}
+
+type requiredField struct {
+	field       string
+	action      string
+	placeholder string
+}
+
+type Requirements struct {
+	fields []requiredField
+}
+
+// abortRunError stops the whole run; it wraps the reason.
+type abortRunError struct{ err error }
+
+func (e *abortRunError) Error() string { return e.err.Error() }
+func (e *abortRunError) Unwrap() error { return e.err }
+
+func NewRequirements(spec, defaultAction string) (*Requirements, error) {
+	r := &Requirements{}
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		field, action, ok := strings.Cut(item, "=")
+		field = strings.TrimSpace(field)
+		if !ok {
+			action = defaultAction
+		}
+		if _, known := articleFields[field]; !known {
+			return nil, fmt.Errorf("require %q: unknown field %q", item, field)
+		}
+		rf := requiredField{field: field, action: strings.TrimSpace(action)}
+		if text, ok := strings.CutPrefix(rf.action, "placeholder:"); ok {
+			rf.action, rf.placeholder = "placeholder", text
+		} else if rf.action == "placeholder" {
+			rf.placeholder = defaultPlaceholders[field]
+		}
+		switch rf.action {
+		case "skip", "fail", "abort":
+		case "placeholder":
+			if rf.placeholder == "" {
+				return nil, fmt.Errorf("require %q: %s has no built-in placeholder; use %s=placeholder:TEXT", item, field, field)
+			}
+		default:
+			return nil, fmt.Errorf("require %q: want skip, fail, abort or placeholder[:TEXT]", item)
+		}
+		r.fields = append(r.fields, rf)
+	}
+	return r, nil
+}
+
+// Check fills placeholders into a, or returns the error the first missing
+// field's action calls for.
+func (r *Requirements) Check(a *Article) error {
+	for _, rf := range r.fields {
+		p := articleFields[rf.field](a)
+		if strings.TrimSpace(*p) != "" {
+			continue
+		}
+		missing := fmt.Errorf("missing required %s", rf.field)
+		switch rf.action {
+		case "skip":
+			return fmt.Errorf("%w: %w", errSkipped, missing)
+		case "fail":
+			return &inputError{missing}
+		case "abort":
+			return &inputError{&abortRunError{missing}}
+		case "placeholder":
+			*p = rf.placeholder
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/* -------------------------------
+   Retries (-max-retries,
+   -retry-budget)
+
+   Transient network errors (time-
+   outs, refused or reset
+   connections, a response cut
+   short), 429 and 5xx are
+   retryable and retried with exponential backoff
+   (or the server's Retry-After).
+   Certificate errors, bad URLs and
+   redirect refusals fail the same
+   way every time, so they are
+   permanent.
+   Every retry draws on a run-wide
+   budget; once it is spent the run
+   aborts, because at that point the
+   API itself is the problem and
+   retrying every file just
+   multiplies the run time.
+--------------------------------*/
+
+const (
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+)
+
+var errRetryBudget = errors.New("retry budget exhausted")
+
+// httpError is a non-2xx upload response.
+type httpError struct {
+	status    int
+	body      string
+	requestID string
+}
+
+func (e *httpError) Error() string {
+	return fmt.Sprintf("http %d %s (request %s)", e.status, e.body, e.requestID)
+}
+
+// Failure classes decide where a failed file goes: retryable ones are
+// worth another run, permanent ones (4xx) will fail the same way again,
+// and input errors are data problems for the content team.
+type failureClass string
+
+const (
+	classRetryable failureClass = "retryable"
+	classPermanent failureClass = "permanent"
+	classInput     failureClass = "input"
+)
+
+var failureClasses = []failureClass{classRetryable, classPermanent, classInput}
+
+// inputError marks a file that could not be decoded into articles.
+type inputError struct{ err error }
+
+func (e *inputError) Error() string { return e.err.Error() }
+func (e *inputError) Unwrap() error { return e.err }
+
+func classify(err error) failureClass {
+	var ie *inputError
+	switch {
+	case errors.As(err, &ie):
+		return classInput
+	case retryable(err):
+		return classRetryable
+	}
+	return classPermanent
+}
+
+// classPath is where failures of class c are saved: the retryable ones in
+// path itself (what -retry should pick up), the others next to it, e.g.
+// failed.permanent.txt.
+func classPath(path string, c failureClass) string {
+	if c == classRetryable {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + string(c) + ext
+}
+
+// retryable reports whether another attempt could succeed.
+func retryable(err error) bool {
+	var he *httpError
+	if errors.As(err, &he) {
+		return he.status == http.StatusTooManyRequests || he.status >= 500
+	}
+	var certErr *tls.CertificateVerificationError
+	var unknownCA x509.UnknownAuthorityError
+	var badHost x509.HostnameError
+	var badCert x509.CertificateInvalidError
+	if errors.As(err, &certErr) || errors.As(err, &unknownCA) || errors.As(err, &badHost) || errors.As(err, &badCert) {
+		return false
+	}
+	// *url.Error is a net.Error too, whatever its cause: only these are
+	// transient
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout(),
+		errors.As(err, &opErr),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, io.EOF), // the server closed the connection first
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED):
+		return true
+	}
+	return false
+}
+
+// unauthorized reports a 401, i.e. the API key was not accepted.
+func unauthorized(err error) bool {
+	var he *httpError
+	return errors.As(err, &he) && he.status == http.StatusUnauthorized
+}
+
+// retryDelay is the wait before retry number attempt+1: exponential with
+// jitter, or the server's hint when it gave one.
+func retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, retryMaxDelay)
+	}
+	d := min(retryBaseDelay<<attempt, retryMaxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header (seconds or HTTP date).
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// retryBudget caps the number of retries in one run.
+type retryBudget struct {
+	limit     int64 // 0 = unlimited
+	used      atomic.Int64
+	once      sync.Once
+	exhausted func()
+}
+
+// budgetSpec is a parsed -retry-budget: a fixed count, a share of the
+// run's files, or neither (unlimited).
+type budgetSpec struct {
+	count   int64
+	percent float64
+}
+
+// parseRetryBudget accepts a count ("200"), a share of the files ("10%")
+// or "off".
+func parseRetryBudget(spec string) (budgetSpec, error) {
+	bad := fmt.Errorf("retry-budget %q: want N, N%% or off", spec)
+	switch {
+	case spec == "off":
+		return budgetSpec{}, nil
+	case strings.HasSuffix(spec, "%"):
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(spec, "%"), 64)
+		if err != nil || pct <= 0 {
+			return budgetSpec{}, bad
+		}
+		return budgetSpec{percent: pct}, nil
+	}
+	n, err := strconv.ParseInt(spec, 10, 64)
+	if err != nil || n < 1 {
+		return budgetSpec{}, bad
+	}
+	return budgetSpec{count: n}, nil
+}
+
+// newRetryBudget sizes spec for a run of n files. exhausted is called once,
+// when the first retry over the limit is refused.
+func newRetryBudget(spec budgetSpec, n int, exhausted func()) *retryBudget {
+	b := &retryBudget{limit: spec.count, exhausted: exhausted}
+	if spec.percent > 0 {
+		b.limit = max(int64(float64(n)*spec.percent/100), 1)
+	}
+	return b
+}
+
+// take claims one retry; false means the budget is spent.
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	for {
+		n := b.used.Load()
+		if b.limit > 0 && n >= b.limit {
+			b.once.Do(b.exhausted)
+			return false
+		}
+		if b.used.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// Used is the number of retries performed so far.
+func (b *retryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryable(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://api.example.com/v2/items", Err: err}
+	}
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"503", &httpError{status: 503}, true},
+		{"429", &httpError{status: 429}, true},
+		{"404", &httpError{status: 404}, false},
+		{"wrapped 502", fmt.Errorf("item 2: %w", &httpError{status: 502}), true},
+		{"reset", urlErr(&net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"bare reset", fmt.Errorf("upload: %w", syscall.ECONNRESET), true},
+		{"unexpected EOF", urlErr(io.ErrUnexpectedEOF), true},
+		{"server closed", urlErr(io.EOF), true},
+		{"dns", urlErr(&net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "api.example.com"}}), true},
+		{"unknown authority", urlErr(x509.UnknownAuthorityError{}), false},
+		{"hostname", urlErr(x509.HostnameError{Host: "api.example.com", Certificate: &x509.Certificate{}}), false},
+		{"expired", urlErr(x509.CertificateInvalidError{Reason: x509.Expired}), false},
+		{"scheme", urlErr(errors.New("unsupported protocol scheme \"ftp\"")), false},
+		{"redirect", urlErr(errors.New("stopped after 10 redirects")), false},
+		{"plain", errors.New("decode: unexpected end of JSON input"), false},
+	} {
+		if got := retryable(tc.err); got != tc.want {
+			t.Errorf("%s: retryable(%v) = %v, want %v", tc.name, tc.err, got, tc.want)
+		}
+	}
+}
+
+// TestRetryableClient checks the errors http.Client really returns.
+func TestRetryableClient(t *testing.T) {
+	tlsSrv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	tlsSrv.Config.ErrorLog = log.New(io.Discard, "", 0) // the handshakes fail on purpose
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { time.Sleep(300 * time.Millisecond) }))
+	defer slow.Close()
+	redirect := httptest.NewServer(http.RedirectHandler("/again", http.StatusFound))
+	defer redirect.Close()
+	closed := httptest.NewServer(nil)
+	closedURL := closed.URL
+	closed.Close()
+	wrongHost := tlsSrv.Client()
+	wrongHost.Transport.(*http.Transport).TLSClientConfig.ServerName = "omnipub.test" // not in the test certificate
+
+	for _, tc := range []struct {
+		name   string
+		client *http.Client
+		url    string
+		want   bool
+	}{
+		{"unknown authority", http.DefaultClient, tlsSrv.URL, false},
+		{"wrong host", wrongHost, tlsSrv.URL, false},
+		{"scheme", http.DefaultClient, "ftp://example.com/", false},
+		{"redirect policy", &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return errors.New("no redirects") }}, redirect.URL, false},
+		{"refused", http.DefaultClient, closedURL, true},
+		{"timeout", &http.Client{Timeout: 50 * time.Millisecond}, slow.URL, true},
+	} {
+		resp, err := tc.client.Get(tc.url)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("%s: no error", tc.name)
+			continue
+		}
+		if got := retryable(err); got != tc.want {
+			t.Errorf("%s: retryable(%v) = %v, want %v", tc.name, err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+/* -------------------------------
+   Interactive review
+   (-interactive)
+
+   For small curated batches: each
+   article is rendered to a preview
+   file and the run waits for
+   approve / skip / edit / quit on
+   the terminal. Edit opens the
+   rendered HTML in $VISUAL or
+   $EDITOR and uploads what is
+   saved. Quit skips everything
+   that is left. Uploads run one at
+   a time.
+--------------------------------*/
+
+const (
+	previewBegin = "<!-- omnipub:begin -->"
+	previewEnd   = "<!-- omnipub:end -->"
+)
+
+type Reviewer struct {
+	mu   sync.Mutex // one prompt at a time
+	in   *bufio.Reader
+	dir  string
+	quit bool
+}
+
+func NewReviewer() (*Reviewer, error) {
+	dir, err := os.MkdirTemp("", "omnipub-review-")
+	if err != nil {
+		return nil, err
+	}
+	return &Reviewer{in: bufio.NewReader(os.Stdin), dir: dir}, nil
+}
+
+// Close removes the preview files.
+func (r *Reviewer) Close() error { return os.RemoveAll(r.dir) }
+
+// Review shows htmlContent for art and returns the HTML to upload, or an
+// errSkipped error if the reviewer turned it down.
+func (r *Reviewer) Review(file string, art *Article, htmlContent string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.quit {
+		return "", fmt.Errorf("%w: review ended", errSkipped)
+	}
+
+	f, err := os.CreateTemp(r.dir, "preview-*.html")
+	if err != nil {
+		return "", err
+	}
+	preview := f.Name()
+	defer os.Remove(preview)
+	_, err = fmt.Fprintf(f, "<!doctype html>\n<meta charset=\"utf-8\">\n<title>%s</title>\n%s\n%s\n%s\n",
+		html.EscapeString(art.Title), previewBegin, htmlContent, previewEnd)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(os.Stderr, "\n── %s\n   %q\n   preview: file://%s\n", file, art.Title, preview)
+	for {
+		fmt.Fprint(os.Stderr, "[a]pprove, [s]kip, [e]dit, [q]uit? ")
+		line, err := r.in.ReadString('\n')
+		if err != nil && line == "" {
+			// no more answers: treat like quit
+			r.quit = true
+			return "", fmt.Errorf("%w: review ended", errSkipped)
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "a", "approve", "y", "yes":
+			return htmlContent, nil
+		case "s", "skip", "n", "no":
+			return "", fmt.Errorf("%w: rejected in review", errSkipped)
+		case "q", "quit":
+			r.quit = true
+			return "", fmt.Errorf("%w: review ended", errSkipped)
+		case "e", "edit":
+			if err := runEditor(preview); err != nil {
+				fmt.Fprintf(os.Stderr, "editor: %v\n", err)
+				continue
+			}
+			raw, err := os.ReadFile(preview)
+			if err != nil {
+				return "", err
+			}
+			htmlContent = previewContent(string(raw))
+			fmt.Fprintln(os.Stderr, "   edited; approve to upload the new version")
+		}
+	}
+}
+
+// previewContent extracts the article HTML from an edited preview file.
+func previewContent(doc string) string {
+	if _, rest, ok := strings.Cut(doc, previewBegin); ok {
+		if body, _, ok := strings.Cut(rest, previewEnd); ok {
+			return strings.TrimSpace(body)
+		}
+	}
+	return strings.TrimSpace(doc)
+}
+
+// runEditor opens path in $VISUAL, $EDITOR or a platform default and waits.
+func runEditor(path string) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	var cmd *exec.Cmd
+	switch {
+	case editor != "" && runtime.GOOS == "windows":
+		cmd = exec.Command("cmd", "/C", editor+` "`+path+`"`)
+	case editor != "":
+		cmd = exec.Command("sh", "-c", editor+` "$1"`, "editor", path)
+	case runtime.GOOS == "windows":
+		cmd = exec.Command("notepad", path)
+	default:
+		cmd = exec.Command("vi", path)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   One upload batch
+
+   runBatch pushes a file list
+   through the worker pool. The
+   runControl outlives batches so
+   the control server and status
+   signal keep working across
+   scheduled runs.
+--------------------------------*/
+
+type batchOptions struct {
+	workers      int
+	backoff      time.Duration
+	collections  []int // -collection, unless an article names its own
+	saveFailures string
+	retryBudget  budgetSpec
+	quarantine   *Quarantine // optional home for undecodable input files
+	manifest     *Manifest   // optional record of successful uploads
+	opener       *browserOpener
+	report       *RunReport // optional -report
+	dashboard    bool       // -tui
+	quiet        bool       // -quiet
+	reupload     bool       // -reupload: send items a partial manifest entry has too
+	heartbeat    time.Duration
+	queue        workQueue // optional -spool-dir or -redis queue
+	orderSerial  bool      // -order-serial: one upload at a time per collection
+
+	skippedUpFront []skippedFile // this batch's discovery skips, for the report
+}
+
+// workQueue feeds a batch from outside memory. Feed closes jobs when the
+// queue is exhausted or ctx is done; Done is called for every file that
+// was worked on, Close once the pool has stopped.
+type workQueue interface {
+	Len() int
+	Feed(ctx context.Context, jobs chan<- string)
+	Done(file string, err error)
+	Close()
+}
+
+// runControl is the handle the control server and SIGUSR1 use to reach
+// the current batch. Pause state and worker count persist across batches.
+type runControl struct {
+	mu       sync.Mutex
+	progress *Progress   // current or most recent batch
+	pool     *workerPool // nil between batches
+	workers  int         // overrides batchOptions.workers once set
+	paused   bool
+}
+
+func (c *runControl) attach(p *Progress, pool *workerPool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.progress, c.pool = p, pool
+	if c.paused {
+		pool.SetPaused(true)
+	}
+}
+
+func (c *runControl) detach() {
+	c.mu.Lock()
+	c.pool = nil
+	c.mu.Unlock()
+}
+
+func (c *runControl) SetPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = paused
+	if c.pool != nil {
+		c.pool.SetPaused(paused)
+	}
+}
+
+func (c *runControl) Resize(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers = n
+	if c.pool != nil {
+		c.pool.Resize(n)
+	}
+}
+
+func (c *runControl) status() controlStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var st controlStatus
+	if c.progress != nil {
+		st.ProgressSnapshot = c.progress.Snapshot()
+	}
+	if c.pool != nil {
+		st.poolState = c.pool.State()
+	} else {
+		st.poolState = poolState{Paused: c.paused, Workers: c.workers}
+	}
+	return st
+}
+
+func (c *runControl) dump(w io.Writer) {
+	c.mu.Lock()
+	p := c.progress
+	c.mu.Unlock()
+	if p == nil {
+		fmt.Fprintln(w, "=== status: idle, no batch has run yet ===")
+		return
+	}
+	p.dump(w)
+}
+
+var errInterrupted = errors.New("interrupted")
+
+// newRunID returns a sortable, practically unique batch ID such as
+// 20240502T020000-3f9a1c.
+func newRunID() string {
+	var b [3]byte
+	_, _ = rand.Read(b[:])
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(b[:])
+}
+
+func (t *Transformer) runBatch(ctx context.Context, files []string, opts batchOptions, ctl *runControl) *Progress {
+	if opts.quiet {
+		defer quietLog()()
+	}
+	// with a spool, files is written out and dropped; nil files resumes
+	// the spooled batch
+	total := len(files)
+	var jobs chan string
+	if opts.queue != nil {
+		if sp, ok := opts.queue.(*Spool); ok && files != nil {
+			if err := sp.Write(files); err != nil {
+				// not log.Fatal: the caller's deferred closes (-manifest) must run
+				p := NewProgress(total)
+				p.aborted = fmt.Errorf("spool: %w", err)
+				return p
+			}
+			files = nil
+		}
+		total = opts.queue.Len()
+		jobs = make(chan string, 4*opts.workers)
+	} else {
+		jobs = make(chan string, total)
+	}
+	progress := NewProgress(total)
+
+	// every request carries X-Run-ID and a per-run X-Request-ID
+	t.runID = newRunID()
+	t.runAt = time.Now()
+	t.reqSeq.Store(0)
+	progress.runID = t.runID
+	progress.skippedUpFront = opts.skippedUpFront
+	if t.dedupe != nil {
+		t.dedupe.reset()
+	}
+	log.Printf("Run ID %s", t.runID)
+
+	// the run aborts on interrupt (ctx) or when the retry budget runs out
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	var budget *retryBudget
+	budget = newRetryBudget(opts.retryBudget, total, func() {
+		log.Printf("Retry budget of %d exhausted – aborting run", budget.limit)
+		abort(errRetryBudget)
+	})
+	t.budget, progress.budget = budget, budget
+
+	// To store failures if save-failures is specified
+	failures := make(map[failureClass][]string)
+	var failuresMutex sync.Mutex
+
+	workers := opts.workers
+	ctl.mu.Lock()
+	if ctl.workers > 0 {
+		workers = ctl.workers
+	}
+	ctl.mu.Unlock()
+	t.rates = newRateStats(workers, opts.backoff)
+	progress.rates = t.rates
+	t.conns = newConnStats(workers)
+	progress.conns = t.conns
+
+	// with -order-serial, files pass through lanes between the queue
+	// and the pool
+	var lanes *orderLanes
+	poolJobs := jobs
+	if opts.orderSerial {
+		lanes = newOrderLanes()
+		poolJobs = make(chan string, cap(jobs))
+	}
+
+	// Cancelling ctx stops new uploads but lets in-flight ones finish.
+	uploadCtx := context.WithoutCancel(ctx)
+	pool := newWorkerPool(ctx, poolJobs, workers, func(f string) {
+		// After an abort the queue is drained without uploading.
+		if ctx.Err() != nil {
+			if lanes != nil {
+				lanes.enter(f, nil)()
+			}
+			return
+		}
+
+		// read once: decoding, the lanes, the manifest, -checksums and the
+		// transform cache share these bytes and their hash, so a file
+		// rewritten mid-upload is not recorded with content that never
+		// went out
+		fctx := uploadCtx
+		in, rerr := readInput(f)
+		if rerr == nil {
+			fctx = withInputBytes(uploadCtx, in)
+			if opts.manifest != nil && !opts.reupload {
+				if items := opts.manifest.uploadedItems(f, in.hexSum()); items != nil {
+					fctx = withUploadedItems(fctx, items)
+				}
+			}
+		}
+		if lanes != nil {
+			defer lanes.enter(f, t.fileCollections(fctx, f, opts.collections))()
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		// If backoff is specified, sleep for a short duration to avoid rate limiting
+		if opts.backoff > 0 {
+			time.Sleep(opts.backoff)
+		}
+
+		progress.begin(f)
+		res := &uploadResult{}
+		started := time.Now()
+		process := t.processFile
+		if t.cache != nil {
+			process = t.processCached
+		}
+		err := process(withUploadResult(fctx, res), f, opts.collections)
+		progress.finish(f, err, errors.Is(err, errSkipped))
+		took := time.Since(started)
+		t.fileMetric(res, err)
+		var quarantined string
+		urls := res.URLs()
+		if err == nil {
+			if t.nearDup != nil && len(urls) > 0 {
+				t.nearDup.itemURL(f, urls[0])
+			}
+			for _, u := range urls {
+				log.Printf("OK    %s → %s", f, u)
+				opts.opener.open(u)
+			}
+		}
+		// a 304 means the server has this content: record it too
+		if (err == nil || errors.Is(err, errNotModified)) && opts.manifest != nil && rerr == nil {
+			if merr := opts.manifest.Record(progress.runID, f, in.hexSum(), in.size(), res); merr != nil {
+				log.Printf("Error recording %s in manifest: %v", f, merr)
+			}
+		} else if err != nil && opts.manifest != nil && rerr == nil && len(res.Items()) > 0 {
+			if merr := opts.manifest.RecordPartial(progress.runID, f, in.hexSum(), in.size(), res); merr != nil {
+				log.Printf("Error recording %s in manifest: %v", f, merr)
+			}
+		}
+		var stop *abortRunError
+		if errors.As(err, &stop) {
+			log.Printf("Required field missing in %s – aborting run", f)
+			abort(fmt.Errorf("%s: %w", f, stop))
+		}
+		if errors.Is(err, errSkipped) {
+			log.Printf("SKIP  %s → %v", f, err)
+		} else if err != nil {
+			class := classify(err)
+			log.Printf("FAIL  %s [%s] → %v", f, class, err)
+			if class == classInput && opts.quarantine != nil {
+				if dst, qerr := opts.quarantine.Add(f, err, progress.runID); qerr != nil {
+					log.Printf("Error quarantining %s: %v", f, qerr)
+				} else {
+					log.Printf("QUARANTINED %s → %s", f, dst)
+					quarantined = dst
+				}
+			}
+
+			// Store failure if requested
+			if opts.saveFailures != "" {
+				failuresMutex.Lock()
+				failures[class] = append(failures[class], f)
+				failuresMutex.Unlock()
+			}
+		}
+		opts.report.add(f, res, err, took, quarantined)
+		if opts.queue != nil {
+			opts.queue.Done(f, err)
+		}
+	})
+	ctl.attach(progress, pool)
+	defer ctl.detach()
+	stopDashboard := func() {}
+	if opts.dashboard {
+		stopDashboard = startDashboard(ctl)
+	}
+
+	// enqueue work
+	if lanes != nil {
+		go lanes.feed(jobs, poolJobs)
+	}
+	if opts.queue != nil {
+		go opts.queue.Feed(ctx, jobs)
+	} else {
+		for _, f := range files {
+			jobs <- f
+		}
+		close(jobs)
+	}
+	stopGauges := t.queueGauges(progress)
+	stopHeartbeat := progress.heartbeat(opts.heartbeat)
+	pool.Wait()
+	stopGauges()
+	stopHeartbeat()
+	stopDashboard()
+	if opts.queue != nil {
+		opts.queue.Close()
+	}
+	if keys := t.keyPool(); len(keys.keys) > 1 {
+		log.Println("API key usage:")
+		keys.dump(log.Writer())
+	}
+	if ctx.Err() != nil {
+		progress.aborted = context.Cause(ctx)
+		if errors.Is(progress.aborted, context.Canceled) {
+			progress.aborted = errInterrupted
+		}
+	}
+
+	// Save failures to file if requested, one file per class
+	for _, class := range failureClasses {
+		if opts.saveFailures == "" || len(failures[class]) == 0 {
+			continue
+		}
+		path := classPath(opts.saveFailures, class)
+		err := saveFilesToFile(path, failures[class])
+		if err != nil {
+			log.Printf("Error saving failures file: %v", err)
+		} else {
+			log.Printf("Saved %d %s failed paths to %s", len(failures[class]), class, path)
+			progress.failureFiles[class] = path
+		}
+	}
+	if err := opts.report.write(progress.runID); err != nil {
+		log.Printf("Error writing run report: %v", err)
+	}
+	return progress
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   Scheduler (-schedule)
+
+   Standard 5-field cron expressions
+   (minute hour day-of-month month
+   day-of-week) with *, lists,
+   ranges and steps, plus @hourly,
+   @daily, @weekly and @monthly.
+   As in cron, a day matches when
+   both day fields do – unless
+   neither starts with *, and then
+   either may. So a step over the
+   whole range, like every other
+   day of the month, still needs
+   the weekday to match too.
+   Expressions that can never
+   match, like 0 0 31 2 *, are
+   rejected.
+--------------------------------*/
+
+type cronField struct {
+	set [60]bool
+	any bool // written as * or */N
+}
+
+type CronSchedule struct {
+	minute, hour, dom, month, dow cronField
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+func ParseCron(expr string) (*CronSchedule, error) {
+	if s, ok := cronShortcuts[strings.TrimSpace(expr)]; ok {
+		expr = s
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: want 5 fields", expr)
+	}
+	c := &CronSchedule{}
+	specs := []struct {
+		f      *cronField
+		lo, hi int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}}
+	for i, sp := range specs {
+		if err := parseCronField(fields[i], sp.lo, sp.hi, sp.f); err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", expr, err)
+		}
+	}
+	if c.dow.set[7] {
+		c.dow.set[0] = true // 7 is Sunday too
+	}
+	if (c.dom.any || c.dow.any) && !c.domPossible() {
+		return nil, fmt.Errorf("schedule %q: no month has those days, so it never runs", expr)
+	}
+	return c, nil
+}
+
+// domPossible reports whether some selected month has a selected day of
+// the month; February counts 29 days.
+func (c *CronSchedule) domPossible() bool {
+	days := [13]int{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+	for m := 1; m <= 12; m++ {
+		for d := 1; d <= days[m] && c.month.set[m]; d++ {
+			if c.dom.set[d] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseCronField(s string, lo, hi int, f *cronField) error {
+	f.any = strings.HasPrefix(s, "*") // *, */N: the other day field must match too
+	for _, part := range strings.Split(s, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return fmt.Errorf("bad step in %q", part)
+			}
+			step = n
+		}
+		from, to := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = strconv.Atoi(a); err != nil {
+				return fmt.Errorf("bad value %q", part)
+			}
+			to = from
+			if isRange {
+				if to, err = strconv.Atoi(b); err != nil {
+					return fmt.Errorf("bad range %q", part)
+				}
+			} else if hasStep {
+				to = hi
+			}
+		}
+		if from < lo || to > hi || from > to {
+			return fmt.Errorf("%q out of range %d-%d", part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			f.set[v] = true
+		}
+	}
+	return nil
+}
+
+// Next returns the first minute strictly after t that matches, or the
+// zero time if none does within 28 years (a full weekday cycle).
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(28, 0, 0); t.Before(limit); t = t.Add(time.Minute) {
+		if !c.month.set[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if !c.hour.set[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()).Add(-time.Minute)
+			continue
+		}
+		if c.minute.set[t.Minute()] {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom.set[t.Day()], c.dow.set[int(t.Weekday())]
+	if c.dom.any || c.dow.any {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+/* ---------- incremental selection ---------- */
+
+// parseSince accepts an RFC 3339 timestamp or a duration back from now.
+func parseSince(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("modified-since %q: want RFC 3339 time or duration like 24h", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// modifiedSince keeps files whose mtime is at or after since.
+func modifiedSince(files []string, since time.Time) []string {
+	var out []string
+	for _, f := range files {
+		if st, err := os.Stat(f); err == nil && !st.ModTime().Before(since) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// loadScheduleState reads the start time of the last completed scheduled
+// run, written by saveScheduleState.
+func loadScheduleState(path string) (time.Time, error) {
+	var b []byte
+	var err error
+	if isRemoteState(path) {
+		b, err = getRemoteState(path)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, strings.TrimSpace(string(b)))
+}
+
+func saveScheduleState(path string, t time.Time) error {
+	if isRemoteState(path) {
+		return putRemoteState(path, []byte(t.Format(time.RFC3339Nano)+"\n"))
+	}
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, []byte(t.Format(time.RFC3339Nano)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
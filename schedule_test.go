@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"0 0 31 2 *",
+		"0 0 30,31 2 *",
+		"0 0 31 4,6,9,11 *",
+		"* * *",
+		"60 * * * *",
+		"0 0 5-1 * *",
+		"*/0 * * * *",
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q): want error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC) // a Wednesday
+	for _, tc := range []struct {
+		expr string
+		want []string // the next few runs
+	}{
+		{"@daily", []string{"2025-01-02 00:00", "2025-01-03 00:00"}},
+		{"*/20 13 * * *", []string{"2025-01-01 13:00", "2025-01-01 13:20", "2025-01-01 13:40"}},
+		// feasible only in leap years
+		{"0 0 29 2 *", []string{"2028-02-29 00:00"}},
+		// both days restricted: either matches
+		{"0 0 3 * 5", []string{"2025-01-03 00:00", "2025-01-10 00:00", "2025-01-17 00:00"}},
+		// a day field written */N is not OR-ed: odd days that are Mondays
+		{"0 0 */2 * 1", []string{"2025-01-13 00:00", "2025-01-27 00:00", "2025-02-03 00:00"}},
+		{"0 0 */1 * 1", []string{"2025-01-06 00:00"}},
+		{"0 0 * * */3", []string{"2025-01-04 00:00", "2025-01-05 00:00", "2025-01-08 00:00"}},
+		// Feb 29 on a Sunday, years away
+		{"0 0 29 2 */7", []string{"2032-02-29 00:00"}},
+		// 7 is Sunday
+		{"30 6 * * 7", []string{"2025-01-05 06:30"}},
+	} {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Errorf("ParseCron(%q): %v", tc.expr, err)
+			continue
+		}
+		var got []string
+		for at := from; len(got) < len(tc.want); {
+			at = c.Next(at)
+			got = append(got, at.Format("2006-01-02 15:04"))
+		}
+		if strings.Join(got, ", ") != strings.Join(tc.want, ", ") {
+			t.Errorf("%q: next runs %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
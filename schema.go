@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   Input schema versions
+
+   An input's "schema_version" picks
+   its decoder; without one it is
+   read as version 1, the flat
+   Article. Each decoder produces
+   the current Article, so older
+   shapes keep working while
+   producers move on.
+
+   1 – flat: content, excerpt, link,
+       published_date, updated_date
+   2 – grouped: body {html, excerpt},
+       url, dates {published,
+       updated}, author {name,
+       email}
+
+   A new shape is one more entry in
+   articleSchemas and articleShapes.
+--------------------------------*/
+
+var articleSchemas = map[int]func(b []byte, strict bool) (Article, error){
+	1: decodeArticleV1,
+	2: decodeArticleV2,
+}
+
+// articleShapes are the types each schema version decodes into, for
+// inputs that are typed before they become JSON (yaml.go).
+var articleShapes = map[int]reflect.Type{
+	1: reflect.TypeFor[Article](),
+	2: reflect.TypeFor[articleV2](),
+}
+
+// decodeArticle parses one JSON Article of any known schema version. In
+// strict mode keys outside the schema, and anything after the object, fail
+// the decode.
+func decodeArticle(b []byte, strict bool) (Article, error) {
+	var peek struct {
+		Version json.RawMessage `json:"schema_version"`
+	}
+	// trailing data is left for the decoder to report
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&peek); err != nil {
+		return Article{}, err
+	}
+	version := 1
+	if len(peek.Version) > 0 && string(peek.Version) != "null" {
+		v, err := strconv.Atoi(strings.Trim(string(peek.Version), `"`))
+		if err != nil {
+			return Article{}, fmt.Errorf("schema_version %s: want a number", peek.Version)
+		}
+		version = v
+	}
+	decode, ok := articleSchemas[version]
+	if !ok {
+		return Article{}, fmt.Errorf("schema_version %d: this build reads versions 1 to %d", version, len(articleSchemas))
+	}
+	a, err := decode(b, strict)
+	a.SchemaVersion = version
+	return a, err
+}
+
+// decodeStrictly is json.Unmarshal, failing unknown keys and trailing data
+// when strict.
+func decodeStrictly(b []byte, v any, strict bool) error {
+	if !strict {
+		return json.Unmarshal(b, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("strict: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("strict: data after the article object")
+	}
+	return nil
+}
+
+func decodeArticleV1(b []byte, strict bool) (Article, error) {
+	var a Article
+	err := decodeStrictly(b, &a, strict)
+	return a, err
+}
+
+// articleV2 is schema version 2.
+type articleV2 struct {
+	SchemaVersion int    `json:"schema_version"`
+	Title         string `json:"title"`
+	Body          struct {
+		HTML    string `json:"html"`
+		Excerpt string `json:"excerpt"`
+	} `json:"body"`
+	URL   string `json:"url"`
+	Dates struct {
+		Published string `json:"published"`
+		Updated   string `json:"updated"`
+	} `json:"dates"`
+	Author struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"author"`
+	Image      string   `json:"image"`
+	Tags       termList `json:"tags"`
+	Categories termList `json:"categories"`
+
+	Collections  []int         `json:"collections"`
+	CollectionID collectionRef `json:"collection_id"`
+	Collection   collectionRef `json:"collection"`
+}
+
+func decodeArticleV2(b []byte, strict bool) (Article, error) {
+	var v articleV2
+	if err := decodeStrictly(b, &v, strict); err != nil {
+		return Article{}, err
+	}
+	author := strings.TrimSpace(v.Author.Name)
+	if email := strings.TrimSpace(v.Author.Email); email != "" {
+		if author == "" {
+			author = email
+		} else {
+			author += " <" + email + ">"
+		}
+	}
+	return Article{
+		Title:        v.Title,
+		Content:      v.Body.HTML,
+		Excerpt:      v.Body.Excerpt,
+		Link:         v.URL,
+		PublishDate:  v.Dates.Published,
+		UpdatedDate:  v.Dates.Updated,
+		Author:       author,
+		Image:        v.Image,
+		Tags:         v.Tags,
+		Categories:   v.Categories,
+		Collections:  v.Collections,
+		CollectionID: v.CollectionID,
+		Collection:   v.Collection,
+	}, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* -------------------------------
+   Simple CSS selectors
+
+   Compound selectors only (no
+   combinators): tag, .class, #id,
+   [attr], [attr=value], [attr*=value],
+   and any combination of them
+   (div.share[data-role=x]). Comma
+   separates alternatives.
+--------------------------------*/
+
+type attrCond struct {
+	key, val string
+	op       byte // 0 = present, '=' exact, '*' substring, '^' prefix, '$' suffix
+}
+
+type simpleSelector struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrCond
+}
+
+type Selector []simpleSelector
+
+func ParseSelector(src string) (Selector, error) {
+	var sel Selector
+	for _, part := range strings.Split(src, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.ContainsAny(part, " >+~") && !strings.Contains(part, "[") {
+			return nil, fmt.Errorf("selector %q: combinators are not supported", part)
+		}
+		s, err := parseSimpleSelector(part)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, s)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("empty selector %q", src)
+	}
+	return sel, nil
+}
+
+func parseSimpleSelector(s string) (simpleSelector, error) {
+	var out simpleSelector
+	ident := func(i int) int {
+		j := i
+		for j < len(s) && (isIdentByte(s[j]) || s[j] == '-') {
+			j++
+		}
+		return j
+	}
+	i := ident(0)
+	out.tag = strings.ToLower(s[:i])
+	if out.tag == "*" {
+		out.tag = ""
+	}
+	for i < len(s) {
+		switch s[i] {
+		case '.', '#':
+			j := ident(i + 1)
+			if j == i+1 {
+				return out, fmt.Errorf("selector %q: empty name at %d", s, i)
+			}
+			if s[i] == '.' {
+				out.classes = append(out.classes, s[i+1:j])
+			} else {
+				out.id = s[i+1 : j]
+			}
+			i = j
+		case '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return out, fmt.Errorf("selector %q: unclosed '['", s)
+			}
+			body := s[i+1 : i+end]
+			var c attrCond
+			if k := strings.IndexByte(body, '='); k >= 0 {
+				c.key, c.val, c.op = body[:k], body[k+1:], '='
+				if k > 0 && strings.IndexByte("*^$", body[k-1]) >= 0 {
+					c.key, c.op = body[:k-1], body[k-1]
+				}
+				c.val = strings.Trim(strings.TrimSpace(c.val), `"'`)
+			} else {
+				c.key = body
+			}
+			c.key = strings.ToLower(strings.TrimSpace(c.key))
+			out.attrs = append(out.attrs, c)
+			i += end + 1
+		default:
+			return out, fmt.Errorf("selector %q: unexpected %q", s, s[i])
+		}
+	}
+	return out, nil
+}
+
+// Match reports whether the start tag t matches any alternative.
+func (sel Selector) Match(t *htmlToken) bool {
+	if t.kind != htmlStartTag && t.kind != htmlSelfClosingTag {
+		return false
+	}
+	for _, s := range sel {
+		if s.match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *simpleSelector) match(t *htmlToken) bool {
+	if s.tag != "" && s.tag != t.data {
+		return false
+	}
+	if s.id != "" {
+		if id, _ := t.attr("id"); id != s.id {
+			return false
+		}
+	}
+	if len(s.classes) > 0 {
+		class, _ := t.attr("class")
+		have := strings.Fields(class)
+		for _, want := range s.classes {
+			found := false
+			for _, h := range have {
+				if h == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	for _, c := range s.attrs {
+		v, ok := t.attr(c.key)
+		if !ok {
+			return false
+		}
+		switch c.op {
+		case '=':
+			ok = v == c.val
+		case '*':
+			ok = strings.Contains(v, c.val)
+		case '^':
+			ok = strings.HasPrefix(v, c.val)
+		case '$':
+			ok = strings.HasSuffix(v, c.val)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/* -------------------------------
+   Non-JSON input
+
+   Input files are sniffed before
+   decoding, so an image, a saved
+   HTML page or an empty file named
+   .json fails as an input error
+   that says what it is, rather than
+   as "invalid character …".
+
+   Input plugins decode formats of
+   their own and are not sniffed,
+   nor are YAML files (yaml.go) or
+   saved pages (htmlpage.go).
+--------------------------------*/
+
+// notJSONError is an input file that is not JSON at all.
+type notJSONError struct {
+	kind string // e.g. "an image"
+	mime string // as sniffed, "" for empty files
+}
+
+func (e *notJSONError) Error() string {
+	if e.mime == "" {
+		return "not JSON: " + e.kind
+	}
+	return fmt.Sprintf("not JSON: looks like %s (%s)", e.kind, e.mime)
+}
+
+// sniffInput returns a *notJSONError for raw input that cannot be JSON.
+func sniffInput(raw []byte) error {
+	switch {
+	case len(raw) == 0:
+		return &notJSONError{kind: "an empty file"}
+	case len(bytes.TrimSpace(raw)) == 0:
+		return &notJSONError{kind: "a file of only whitespace"}
+	}
+	sniffed := http.DetectContentType(raw)
+	mime, _, _ := strings.Cut(sniffed, ";")
+	var kind string
+	switch major, _, _ := strings.Cut(mime, "/"); {
+	case major == "image":
+		kind = "an image"
+	case major == "audio" || major == "video":
+		kind = "a media file"
+	case major == "font":
+		kind = "a font"
+	case mime == "text/html":
+		kind = "an HTML page"
+	case mime == "text/xml":
+		kind = "an XML document"
+	case mime == "application/pdf" || mime == "application/postscript":
+		kind = "a document (" + strings.TrimPrefix(mime, "application/") + ")"
+	case mime == "application/zip" || mime == "application/x-gzip" || mime == "application/x-rar-compressed":
+		kind = "an archive"
+	case mime == "application/octet-stream":
+		if _, utf16 := looksUTF16(raw); utf16 {
+			return nil
+		}
+		kind = "binary data"
+	case strings.HasPrefix(mime, "text/plain"):
+		if !strings.Contains(sniffed, "utf-8") {
+			return nil // UTF-16, left to toUTF8
+		}
+		if c := bytes.TrimLeft(bytes.TrimPrefix(raw, []byte("\xEF\xBB\xBF")), " \t\r\n"); len(c) == 0 || c[0] == '{' || c[0] == '[' {
+			return nil
+		}
+		kind = "plain text"
+	default:
+		return nil
+	}
+	return &notJSONError{kind: kind, mime: mime}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Disk-spilled work queue
+   (-spool-dir DIR)
+
+   The batch's file list is written
+   to DIR/queue before uploading
+   starts – in directory mode
+   straight from the directory
+   listing – and the workers read
+   it back a few lines at a time,
+   so the pending queue costs
+   disk, not memory, however many
+   millions of files it holds.
+
+   DIR/cursor holds the offset of
+   the oldest file not yet done,
+   DIR/done the offsets of files
+   finished past it. A run that
+   stops early leaves them behind;
+   the next start with the same
+   -spool-dir resumes at the
+   cursor, skipping what is done,
+   instead of listing files again.
+   A completed batch removes them.
+--------------------------------*/
+
+const (
+	spoolSaveEvery = time.Second
+	spoolDirChunk  = 4096 // directory entries read at a time
+)
+
+type Spool struct {
+	dir string
+
+	mu       sync.Mutex
+	count    int                // files in the batch still to do at its start
+	next     int64              // offset after the last line handed out
+	eof      bool               // every line has been handed out
+	inFlight map[string][]int64 // handed-out offsets by file, oldest first
+	done     map[int64]bool     // offsets finished past the cursor
+	doneLog  *os.File           // DIR/done, appended to by Done
+	cursor   int64              // as last saved
+	saved    time.Time
+}
+
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spool: %w", err)
+	}
+	return &Spool{dir: dir}, nil
+}
+
+func (s *Spool) queuePath() string  { return filepath.Join(s.dir, "queue") }
+func (s *Spool) cursorPath() string { return filepath.Join(s.dir, "cursor") }
+func (s *Spool) donePath() string   { return filepath.Join(s.dir, "done") }
+
+// Pending counts the files left in an unfinished batch from an earlier
+// run; 0 if there is none.
+func (s *Spool) Pending() (int, error) {
+	f, err := os.Open(s.queuePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	cursor, err := s.loadCursor()
+	if err != nil {
+		return 0, err
+	}
+	done, err := s.loadDone(cursor)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(cursor, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n := 0
+	off := cursor
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if line != "\n" && line != "" && !done[off] {
+			n++
+		}
+		off += int64(len(line))
+		if err == io.EOF {
+			break
+		}
+	}
+	s.reset(cursor, n)
+	s.done = done
+	return n, nil
+}
+
+func (s *Spool) loadCursor() (int64, error) {
+	b, err := os.ReadFile(s.cursorPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	cursor, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil || cursor < 0 {
+		return 0, fmt.Errorf("spool: bad cursor %q in %s", strings.TrimSpace(string(b)), s.cursorPath())
+	}
+	return cursor, nil
+}
+
+// loadDone reads the offsets past cursor that were finished.
+func (s *Spool) loadDone(cursor int64) (map[int64]bool, error) {
+	done := make(map[int64]bool)
+	b, err := os.ReadFile(s.donePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return done, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		// a torn last line from a crash is ignored; that file is sent again
+		if off, err := strconv.ParseInt(line, 10, 64); err == nil && off >= cursor {
+			done[off] = true
+		}
+	}
+	return done, nil
+}
+
+// Write replaces the queue with files, starting a new batch.
+func (s *Spool) Write(files []string) error {
+	w, err := s.Create()
+	if err != nil {
+		return err
+	}
+	if err := w.Add(files...); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Commit()
+}
+
+// spoolWriter writes a new queue; it replaces the old one on Commit.
+type spoolWriter struct {
+	s *Spool
+	f *os.File
+	w *bufio.Writer
+	n int
+}
+
+// Create starts writing a new batch, so files can be added as they are
+// listed.
+func (s *Spool) Create() (*spoolWriter, error) {
+	f, err := os.Create(s.queuePath() + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &spoolWriter{s: s, f: f, w: bufio.NewWriter(f)}, nil
+}
+
+func (w *spoolWriter) Add(files ...string) error {
+	for _, file := range files {
+		w.w.WriteString(file)
+		if err := w.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	w.n += len(files)
+	return nil
+}
+
+// Len is the number of files added so far.
+func (w *spoolWriter) Len() int { return w.n }
+
+// Abort drops the new queue; the old one, if any, stays.
+func (w *spoolWriter) Abort() {
+	w.f.Close()
+	os.Remove(w.f.Name())
+}
+
+// Commit makes the new queue the spool's batch.
+func (w *spoolWriter) Commit() error {
+	s, f := w.s, w.f
+	if err := w.w.Flush(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		w.Abort()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	// the cursor and done offsets go first: stale ones must never point
+	// into the new queue
+	if err := s.saveCursor(0); err != nil {
+		return err
+	}
+	s.closeDoneLog()
+	if err := os.Remove(s.donePath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(f.Name(), s.queuePath()); err != nil {
+		return err
+	}
+	s.reset(0, w.n)
+	return nil
+}
+
+// WriteDir starts a new batch with the files in dir matching any of
+// patterns, reading the directory a chunk at a time so the listing never
+// sits in memory whole. filter sees each chunk and returns the files to
+// queue and those it skipped. Files are queued in directory order.
+func (s *Spool) WriteDir(dir string, patterns []string, filter func([]string) ([]string, []skippedFile)) (int, []skippedFile, error) {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return 0, nil, fmt.Errorf("-glob %q: %w", p, err)
+		}
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer d.Close()
+	w, err := s.Create()
+	if err != nil {
+		return 0, nil, err
+	}
+	var skipped []skippedFile
+	for {
+		ents, rerr := d.ReadDir(spoolDirChunk)
+		var chunk []string
+		for _, e := range ents {
+			if e.IsDir() {
+				continue
+			}
+			for _, p := range patterns {
+				if ok, _ := filepath.Match(p, e.Name()); ok {
+					chunk = append(chunk, filepath.Join(dir, e.Name()))
+					break
+				}
+			}
+		}
+		keep, sk := filter(chunk)
+		skipped = append(skipped, sk...)
+		if err := w.Add(keep...); err != nil {
+			w.Abort()
+			return 0, nil, err
+		}
+		if rerr == io.EOF {
+			break
+		} else if rerr != nil {
+			w.Abort()
+			return 0, nil, rerr
+		}
+	}
+	return w.Len(), skipped, w.Commit()
+}
+
+// Clear removes the queue without running it.
+func (s *Spool) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove()
+}
+
+func (s *Spool) reset(cursor int64, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count, s.next, s.cursor, s.eof = count, cursor, cursor, false
+	s.inFlight = make(map[string][]int64)
+	s.done = make(map[int64]bool)
+}
+
+// Len is the number of files in the current batch.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Feed sends the queued files to jobs until the queue is exhausted or ctx
+// is done, then closes jobs.
+func (s *Spool) Feed(ctx context.Context, jobs chan<- string) {
+	defer close(jobs)
+	f, err := os.Open(s.queuePath())
+	if err != nil {
+		log.Printf("Error reading spool: %v", err)
+		return
+	}
+	defer f.Close()
+	s.mu.Lock()
+	off := s.next
+	s.mu.Unlock()
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		log.Printf("Error reading spool: %v", err)
+		return
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			if err != io.EOF {
+				log.Printf("Error reading spool: %v", err)
+				return
+			}
+			s.mu.Lock()
+			s.eof = true
+			s.mu.Unlock()
+			return
+		}
+		at := off
+		off += int64(len(line))
+		file := strings.TrimSuffix(line, "\n")
+		if file == "" {
+			continue
+		}
+		s.mu.Lock()
+		if s.done[at] {
+			s.next = off // finished by the run that left the queue
+			s.mu.Unlock()
+			continue
+		}
+		s.inFlight[file] = append(s.inFlight[file], at)
+		s.next = off
+		s.mu.Unlock()
+		select {
+		case jobs <- file:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Done marks the oldest handed-out copy of file as finished, whatever
+// the outcome, records it in DIR/done and saves the cursor now and then.
+func (s *Spool) Done(file string, _ error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offs := s.inFlight[file]
+	if len(offs) == 0 {
+		return
+	}
+	if len(offs) > 1 {
+		s.inFlight[file] = offs[1:]
+	} else {
+		delete(s.inFlight, file)
+	}
+	s.done[offs[0]] = true
+	s.appendDone(offs[0])
+	if time.Since(s.saved) < spoolSaveEvery {
+		return
+	}
+	s.checkpoint()
+}
+
+// lowWater is the offset before which every file is done. Files handed
+// out but never finished – drained after an abort – hold it back.
+func (s *Spool) lowWater() int64 {
+	low := s.next
+	for _, offs := range s.inFlight {
+		if offs[0] < low {
+			low = offs[0]
+		}
+	}
+	return low
+}
+
+func (s *Spool) checkpoint() {
+	s.saved = time.Now()
+	low := s.lowWater()
+	if low == s.cursor {
+		return
+	}
+	if err := s.saveCursor(low); err != nil {
+		log.Printf("Error saving spool cursor: %v", err)
+		return
+	}
+	s.cursor = low
+	// offsets below the cursor are covered by it
+	for off := range s.done {
+		if off < low {
+			delete(s.done, off)
+		}
+	}
+	if err := s.saveDone(); err != nil {
+		log.Printf("Error saving spool: %v", err)
+	}
+}
+
+// appendDone adds off to DIR/done.
+func (s *Spool) appendDone(off int64) {
+	if s.doneLog == nil {
+		f, err := os.OpenFile(s.donePath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Printf("Error saving spool: %v", err)
+			return
+		}
+		s.doneLog = f
+	}
+	if _, err := s.doneLog.WriteString(strconv.FormatInt(off, 10) + "\n"); err != nil {
+		log.Printf("Error saving spool: %v", err)
+	}
+}
+
+// saveDone rewrites DIR/done with the offsets still past the cursor.
+func (s *Spool) saveDone() error {
+	s.closeDoneLog()
+	var b strings.Builder
+	for off := range s.done {
+		b.WriteString(strconv.FormatInt(off, 10) + "\n")
+	}
+	tmp := s.donePath() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.donePath())
+}
+
+func (s *Spool) closeDoneLog() {
+	if s.doneLog != nil {
+		s.doneLog.Close()
+		s.doneLog = nil
+	}
+}
+
+func (s *Spool) saveCursor(off int64) error {
+	tmp := s.cursorPath() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(off, 10)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.cursorPath())
+}
+
+// Close saves the cursor of a batch that stopped early, or removes the
+// queue of one that finished.
+func (s *Spool) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.eof && len(s.inFlight) == 0 {
+		s.remove()
+		return
+	}
+	s.checkpoint()
+	s.closeDoneLog()
+	log.Printf("Spooled queue kept in %s: the next run with -spool-dir %s resumes it", s.dir, s.dir)
+}
+
+func (s *Spool) remove() {
+	s.closeDoneLog()
+	for _, p := range []string{s.queuePath(), s.cursorPath(), s.donePath()} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Error removing spool: %v", err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSpoolResumeSkipsDone(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write([]string{"a", "b", "c", "d", "e"}); err != nil {
+		t.Fatal(err)
+	}
+	jobs := make(chan string, 5)
+	s.Feed(context.Background(), jobs)
+	for f := range jobs {
+		// a is still in flight when the run stops; c and e finished
+		if f == "c" || f == "e" {
+			s.Done(f, nil)
+		}
+	}
+	s.Close()
+
+	s, err = OpenSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := s.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("Pending() = %d, want 3", n)
+	}
+	jobs = make(chan string, 5)
+	s.Feed(context.Background(), jobs)
+	var got []string
+	for f := range jobs {
+		got = append(got, f)
+		s.Done(f, nil)
+	}
+	if want := []string{"a", "b", "d"}; !slices.Equal(got, want) {
+		t.Errorf("resumed %v, want %v", got, want)
+	}
+	s.Close()
+	if n, err := s.Pending(); err != nil || n != 0 {
+		t.Errorf("after the batch: Pending() = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   DogStatsD metrics (-statsd)
+
+   -statsd host:port sends metrics
+   over UDP, tagged run_id:<ID>
+   plus -statsd-tags:
+
+   omnipub.files          count  status, class, collection
+   omnipub.request        timing status, collection (ms)
+   omnipub.retries        count
+   omnipub.queue.depth    gauge  files not started yet
+   omnipub.queue.in_flight gauge
+
+   Gauges go out every
+   statsdInterval. Sends never
+   block; a missing agent only
+   loses metrics.
+--------------------------------*/
+
+const statsdInterval = 10 * time.Second
+
+type StatsD struct {
+	conn   net.Conn
+	prefix string
+	tags   []string // added to every metric
+}
+
+func NewStatsD(addr, prefix, tags string) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %w", err)
+	}
+	s := &StatsD{conn: conn, prefix: prefix}
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			s.tags = append(s.tags, t)
+		}
+	}
+	return s, nil
+}
+
+func (s *StatsD) send(name, value, kind string, tags []string) {
+	if s == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(s.prefix + name + ":" + value + "|" + kind)
+	all := append(append([]string(nil), s.tags...), tags...)
+	if len(all) > 0 {
+		b.WriteString("|#" + strings.Join(all, ","))
+	}
+	_, _ = s.conn.Write([]byte(b.String()))
+}
+
+func (s *StatsD) count(name string, n int, tags ...string) {
+	s.send(name, strconv.Itoa(n), "c", tags)
+}
+
+func (s *StatsD) timing(name string, d time.Duration, tags ...string) {
+	s.send(name, strconv.FormatInt(d.Milliseconds(), 10), "ms", tags)
+}
+
+func (s *StatsD) gauge(name string, v int, tags ...string) {
+	s.send(name, strconv.Itoa(v), "g", tags)
+}
+
+// collectionTags tags a metric with each collection ID.
+func collectionTags(tags []string, ids []int) []string {
+	for _, id := range ids {
+		tags = append(tags, "collection:"+strconv.Itoa(id))
+	}
+	return tags
+}
+
+type metricCollectionsKey struct{}
+
+// withMetricCollections tags ctx with the collections of one post, for the
+// request timing.
+func withMetricCollections(ctx context.Context, ids []int) context.Context {
+	return context.WithValue(ctx, metricCollectionsKey{}, ids)
+}
+
+func metricCollections(ctx context.Context) []int {
+	ids, _ := ctx.Value(metricCollectionsKey{}).([]int)
+	return ids
+}
+
+// statusTag is a response's status code, or "error" without one.
+func statusTag(resp *http.Response) string {
+	if resp == nil {
+		return "error"
+	}
+	return strconv.Itoa(resp.StatusCode)
+}
+
+// fileMetric counts one file's outcome.
+func (t *Transformer) fileMetric(res *uploadResult, err error) {
+	if t.statsd == nil {
+		return
+	}
+	tags := []string{"run_id:" + t.runID}
+	switch {
+	case err == nil:
+		tags = append(tags, "status:uploaded")
+	case errors.Is(err, errSkipped):
+		tags = append(tags, "status:skipped")
+	default:
+		tags = append(tags, "status:failed", "class:"+string(classify(err)))
+	}
+	t.statsd.count("files", 1, collectionTags(tags, res.Collections())...)
+}
+
+// queueGauges reports the queue every statsdInterval until stopped.
+func (t *Transformer) queueGauges(p *Progress) (stop func()) {
+	if t.statsd == nil {
+		return func() {}
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	tag := "run_id:" + t.runID
+	report := func() {
+		s := p.Snapshot()
+		t.statsd.gauge("queue.depth", s.Remaining-len(s.InFlight), tag)
+		t.statsd.gauge("queue.in_flight", len(s.InFlight), tag)
+	}
+	go func() {
+		defer close(exited)
+		tick := time.NewTicker(statsdInterval)
+		defer tick.Stop()
+		for {
+			report()
+			select {
+			case <-done:
+				report()
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
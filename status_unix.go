@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyStatusSignal dumps the current batch's progress to stderr on every SIGUSR1.
+func notifyStatusSignal(ctl *runControl) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			ctl.dump(os.Stderr)
+		}
+	}()
+}
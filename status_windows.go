@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// notifyStatusSignal is a no-op: Windows has no SIGUSR1.
+func notifyStatusSignal(ctl *runControl) {}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/* -------------------------------
+   Inline SVG policy (-svg)
+
+   sanitize – keep inline <svg> but
+              drop <script>,
+              <foreignObject>, event
+              handlers, script URLs and
+              href-animating <set>/
+              <animate> (default)
+   strip    – remove inline <svg>
+   keep     – pass through untouched
+--------------------------------*/
+
+func svgPass(mode string) (contentPass, error) {
+	switch mode {
+	case "sanitize":
+		return sanitizeSVG, nil
+	case "strip":
+		return stripSVG, nil
+	case "keep":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("svg %q: want sanitize, strip or keep", mode)
+}
+
+// svgDropElems are removed from inside <svg> together with their content.
+var svgDropElems = map[string]bool{"script": true, "foreignobject": true, "iframe": true, "handler": true}
+
+func sanitizeSVG(_ *renderDoc, toks []htmlToken) []htmlToken {
+	out := make([]htmlToken, 0, len(toks))
+	depth := 0
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.isTag("svg") {
+			if t.kind == htmlStartTag {
+				depth++
+			}
+		} else if t.kind == htmlEndTag && t.data == "svg" && depth > 0 {
+			depth--
+		}
+		if depth == 0 && !t.isTag("svg") {
+			out = append(out, t)
+			continue
+		}
+
+		if t.kind == htmlStartTag || t.kind == htmlSelfClosingTag {
+			if svgDropElems[t.data] || animatesHref(&t) {
+				i = svgElementEnd(toks, i)
+				continue
+			}
+			defangTag(&t)
+			t.removeAttrs(func(a htmlAttr) bool {
+				return a.key == "style" && strings.Contains(strings.ToLower(a.val), "url(javascript")
+			})
+		}
+		if t.kind == htmlEndTag && svgDropElems[t.data] {
+			continue // stray closer of a dropped element
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+func stripSVG(_ *renderDoc, toks []htmlToken) []htmlToken {
+	out := make([]htmlToken, 0, len(toks))
+	for i := 0; i < len(toks); i++ {
+		if toks[i].isTag("svg") {
+			i = svgElementEnd(toks, i)
+			continue
+		}
+		out = append(out, toks[i])
+	}
+	return out
+}
+
+// svgElementEnd is elementEnd without HTML void-element rules, since SVG
+// reuses names like <image> freely.
+func svgElementEnd(toks []htmlToken, i int) int {
+	if toks[i].kind == htmlSelfClosingTag {
+		return i
+	}
+	return elementEnd(toks, i)
+}
+
+// animatesHref reports <set>/<animate> elements that rewrite a link target,
+// a known way to smuggle javascript: URLs past attribute filters.
+func animatesHref(t *htmlToken) bool {
+	if !t.isTag("set", "animate") {
+		return false
+	}
+	name, _ := t.attr("attributename")
+	name = strings.ToLower(strings.TrimSpace(name))
+	return name == "href" || name == "xlink:href"
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/* -------------------------------
+   Tags and taxonomy (-taxonomy)
+
+   An article's "tags" and
+   "categories" (arrays, or one
+   comma-separated string) go into
+   metadata together as "tags".
+
+   -taxonomy maps them onto the
+   target vocabulary first:
+
+   [rename]
+   Tech, gadgets = Technology
+   Politik       = Politics
+   [drop]
+   uncategorized
+   *                      # all others
+   [defaults]
+   news/**         = News
+   archive/2012/** = Archive, Legacy
+
+   rename – several source terms
+            onto one merges them
+   drop   – "*" drops every term
+            rename does not cover
+   defaults – for articles left
+            without tags, by the
+            first pattern matching
+            the path below -dir (as
+            -exclude matches it)
+
+   Terms match case-insensitively.
+--------------------------------*/
+
+// termList is a list of tags or categories. Inputs give either a JSON
+// array or a single comma-separated string.
+type termList []string
+
+func (l *termList) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*l = termsFromJSON(v)
+	return nil
+}
+
+// termsFromJSON reads strings, comma-separated strings, and objects with a
+// "name"; anything else is ignored.
+func termsFromJSON(v any) termList {
+	var out termList
+	var add func(v any)
+	add = func(v any) {
+		switch v := v.(type) {
+		case string:
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					out = append(out, s)
+				}
+			}
+		case []any:
+			for _, e := range v {
+				add(e)
+			}
+		case map[string]any:
+			if name, ok := v["name"].(string); ok {
+				add(name)
+			}
+		}
+	}
+	add(v)
+	return out
+}
+
+// terms returns the article's tags and categories, without repeats.
+func (a *Article) terms() []string {
+	return uniqueTerms(append(append([]string(nil), a.Tags...), a.Categories...))
+}
+
+// uniqueTerms drops case-insensitive repeats, keeping the first spelling.
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	var out []string
+	for _, t := range terms {
+		if k := strings.ToLower(t); !seen[k] {
+			seen[k] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+type termDefault struct {
+	pattern string
+	terms   []string
+}
+
+type Taxonomy struct {
+	dir      string
+	rename   map[string]string // by lower-case source term
+	drop     map[string]bool
+	dropRest bool // "*" in [drop]
+	defaults []termDefault
+
+	mu                       sync.Mutex
+	renamed, dropped, filled int
+}
+
+func LoadTaxonomy(file, dir string) (*Taxonomy, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("taxonomy: %w", err)
+	}
+	defer f.Close()
+
+	tx := &Taxonomy{dir: dir, rename: make(map[string]string), drop: make(map[string]bool)}
+	section := ""
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section != "rename" && section != "drop" && section != "defaults" {
+				return nil, fmt.Errorf("%s:%d: unknown section [%s]", file, n, section)
+			}
+			continue
+		}
+		switch section {
+		case "rename":
+			from, to, ok := strings.Cut(line, "=")
+			to = strings.TrimSpace(to)
+			if !ok || to == "" || len(termsFromJSON(from)) == 0 {
+				return nil, fmt.Errorf("%s:%d: want source, … = target", file, n)
+			}
+			for _, t := range termsFromJSON(from) {
+				tx.rename[strings.ToLower(t)] = to
+			}
+		case "drop":
+			if line == "*" {
+				tx.dropRest = true
+			} else {
+				tx.drop[strings.ToLower(line)] = true
+			}
+		case "defaults":
+			pat, terms, ok := strings.Cut(line, "=")
+			pat = filepath.ToSlash(strings.TrimSpace(pat))
+			if !ok || pat == "" || len(termsFromJSON(terms)) == 0 {
+				return nil, fmt.Errorf("%s:%d: want pattern = term, …", file, n)
+			}
+			if _, err := path.Match(strings.ReplaceAll(pat, "**", "*"), ""); err != nil {
+				return nil, fmt.Errorf("%s:%d: %q: %w", file, n, pat, err)
+			}
+			tx.defaults = append(tx.defaults, termDefault{pat, termsFromJSON(terms)})
+		default:
+			return nil, fmt.Errorf("%s:%d: outside a [rename], [drop] or [defaults] section", file, n)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Map returns the target terms for the source terms of file.
+func (tx *Taxonomy) Map(file string, terms []string) []string {
+	var out []string
+	renamed, dropped := 0, 0
+	for _, t := range terms {
+		k := strings.ToLower(t)
+		switch to, ok := tx.rename[k]; {
+		case ok:
+			out = append(out, to)
+			renamed++
+		case tx.drop[k] || tx.dropRest:
+			dropped++
+		default:
+			out = append(out, t)
+		}
+	}
+	out = uniqueTerms(out)
+	filled := 0
+	if len(out) == 0 {
+		if d := tx.defaultFor(file); d != nil {
+			out = d
+			filled = 1
+		}
+	}
+
+	tx.mu.Lock()
+	tx.renamed += renamed
+	tx.dropped += dropped
+	tx.filled += filled
+	tx.mu.Unlock()
+	return out
+}
+
+func (tx *Taxonomy) defaultFor(file string) []string {
+	rel := file
+	if r, err := filepath.Rel(tx.dir, file); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	rel = filepath.ToSlash(rel)
+	for _, d := range tx.defaults {
+		target := rel
+		if !strings.Contains(d.pattern, "/") {
+			target = path.Base(rel)
+		}
+		if globMatch(d.pattern, target) {
+			return d.terms
+		}
+	}
+	return nil
+}
+
+// report writes the run's mapping totals and starts counting afresh.
+func (tx *Taxonomy) report(w io.Writer) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.renamed+tx.dropped+tx.filled > 0 {
+		fmt.Fprintf(w, "Taxonomy: %d terms renamed, %d dropped, %d articles given default tags\n", tx.renamed, tx.dropped, tx.filled)
+	}
+	tx.renamed, tx.dropped, tx.filled = 0, 0, 0
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   node_exporter textfile
+   (-textfile)
+
+   After every run the last run's
+   numbers are written in the
+   Prometheus text format for
+   node_exporter's textfile
+   collector:
+
+   omnipub_last_run_timestamp_seconds
+   omnipub_last_run_duration_seconds
+   omnipub_last_run_files{status}
+   omnipub_last_run_failures{class}
+   omnipub_last_run_retries
+   omnipub_last_run_aborted
+
+   -textfile-labels job=nightly
+   adds labels to every series, so
+   several jobs can share one
+   collector directory. The file
+   is replaced atomically.
+--------------------------------*/
+
+var (
+	promLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	labelEscaper  = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+type Textfile struct {
+	path   string
+	labels []string // name="value", escaped
+}
+
+func NewTextfile(path, labels string) (*Textfile, error) {
+	t := &Textfile{path: path}
+	for _, kv := range strings.Split(labels, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(kv, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !promLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("textfile-labels %q: want name=value pairs", kv)
+		}
+		if name == "status" || name == "class" {
+			return nil, fmt.Errorf("textfile-labels %q: %s is used by the metrics", kv, name)
+		}
+		t.labels = append(t.labels, name+`="`+labelEscaper.Replace(strings.TrimSpace(value))+`"`)
+	}
+	return t, nil
+}
+
+// series renders a metric name with the -textfile-labels plus extra ones.
+func (t *Textfile) series(name string, extra ...string) string {
+	labels := append(append([]string(nil), t.labels...), extra...)
+	if len(labels) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(labels, ",") + "}"
+}
+
+// Write records the run of p.
+func (t *Textfile) Write(p *Progress) error {
+	s := p.Snapshot()
+	var b strings.Builder
+	gauge := func(name, help string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	gauge("omnipub_last_run_timestamp_seconds", "Unix time the last run finished.")
+	fmt.Fprintf(&b, "%s %d\n", t.series("omnipub_last_run_timestamp_seconds"), time.Now().Unix())
+	gauge("omnipub_last_run_duration_seconds", "Duration of the last run.")
+	fmt.Fprintf(&b, "%s %.3f\n", t.series("omnipub_last_run_duration_seconds"), s.Elapsed.Seconds())
+	gauge("omnipub_last_run_files", "Files of the last run by outcome.")
+	for _, st := range []struct {
+		status string
+		n      uint64
+	}{{"uploaded", s.Success}, {"failed", s.Failure}, {"skipped", s.Skipped}} {
+		fmt.Fprintf(&b, "%s %d\n", t.series("omnipub_last_run_files", `status="`+st.status+`"`), st.n)
+	}
+	gauge("omnipub_last_run_failures", "Failed files of the last run by failure class.")
+	for _, c := range failureClasses {
+		fmt.Fprintf(&b, "%s %d\n", t.series("omnipub_last_run_failures", `class="`+string(c)+`"`), s.ByClass[c])
+	}
+	gauge("omnipub_last_run_retries", "Retries spent in the last run.")
+	fmt.Fprintf(&b, "%s %d\n", t.series("omnipub_last_run_retries"), s.Retries)
+	gauge("omnipub_last_run_aborted", "1 if the last run was aborted.")
+	aborted := 0
+	if p.aborted != nil {
+		aborted = 1
+	}
+	fmt.Fprintf(&b, "%s %d\n", t.series("omnipub_last_run_aborted"), aborted)
+
+	// node_exporter must never read a half-written file
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.path)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   Thumbnails (-thumbnail)
+
+   Picks the article's representative
+   image: an og:image (or
+   twitter:image) <meta> in the
+   content, else the article's
+   image field, else the first <img> not
+   declared smaller than
+   -thumbnail-min-size pixels either
+   way (icons, tracking pixels).
+
+   url   – record it as thumbnail_url
+           in metadata
+   cover – also download it and send
+           it as the item's cover
+           (API v3 and later)
+--------------------------------*/
+
+const maxCoverBytes = 10 << 20
+
+type Thumbnails struct {
+	cover   bool
+	minSize int
+	client  *http.Client
+}
+
+func NewThumbnails(mode string, minSize int) (*Thumbnails, error) {
+	switch mode {
+	case "url", "cover":
+	default:
+		return nil, fmt.Errorf("thumbnail %q: want off, url or cover", mode)
+	}
+	return &Thumbnails{
+		cover:   mode == "cover",
+		minSize: minSize,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Apply records the article's thumbnail in doc.thumbnail; the content is
+// left as it is.
+func (th *Thumbnails) Apply(doc *renderDoc, toks []htmlToken) []htmlToken {
+	var first string
+	for _, t := range toks {
+		switch {
+		case t.isTag("meta"):
+			prop, _ := t.attr("property")
+			if prop == "" {
+				prop, _ = t.attr("name")
+			}
+			content, _ := t.attr("content")
+			if p := strings.ToLower(prop); p != "og:image" && p != "twitter:image" {
+				continue
+			}
+			if u := th.imageURL(doc, content); u != "" {
+				doc.thumbnail = u
+				return toks
+			}
+		case t.isTag("img") && first == "" && doc.article.Image == "":
+			if th.small(t) {
+				continue
+			}
+			src, _ := t.attr("src")
+			first = th.imageURL(doc, src)
+		}
+	}
+	doc.thumbnail = cmp.Or(th.imageURL(doc, doc.article.Image), first)
+	return toks
+}
+
+// small reports whether img declares a width or height below the minimum.
+func (th *Thumbnails) small(img htmlToken) bool {
+	for _, name := range []string{"width", "height"} {
+		v, _ := img.attr(name)
+		if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(v), "px")); err == nil && n < th.minSize {
+			return true
+		}
+	}
+	return false
+}
+
+// imageURL makes src absolute against the article's link. Data URIs and
+// images extracted as attachments have no URL and give "".
+func (th *Thumbnails) imageURL(doc *renderDoc, src string) string {
+	src = strings.TrimSpace(src)
+	if src == "" || strings.HasPrefix(strings.ToLower(src), "data:") {
+		return ""
+	}
+	for _, a := range doc.attachments {
+		if a.name == src {
+			return ""
+		}
+	}
+	u, err := url.Parse(src)
+	if err != nil {
+		return ""
+	}
+	if !u.IsAbs() {
+		base, err := url.Parse(strings.TrimSpace(doc.article.Link))
+		if err != nil || !base.IsAbs() {
+			return ""
+		}
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+// fetchCover downloads the thumbnail for upload as the item's cover.
+func (th *Thumbnails) fetchCover(ctx context.Context, u string) (attachment, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return attachment{}, err
+	}
+	req.Header.Set("User-Agent", "transform-to-omnipub/"+toolVersion()+" (thumbnail)")
+	resp, err := th.client.Do(req)
+	if err != nil {
+		return attachment{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return attachment{}, fmt.Errorf("http %d", resp.StatusCode)
+	}
+	ctype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(ctype, "image/") {
+		return attachment{}, fmt.Errorf("not an image (%s)", cmp.Or(ctype, "no content type"))
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCoverBytes+1))
+	if err != nil {
+		return attachment{}, err
+	}
+	if len(data) > maxCoverBytes {
+		return attachment{}, fmt.Errorf("larger than %d MiB", maxCoverBytes>>20)
+	}
+	name := path.Base(resp.Request.URL.Path)
+	if name == "/" || name == "." || path.Ext(name) == "" {
+		name = "cover" + extensionFor(ctype)
+	}
+	return attachment{name: name, contentType: ctype, data: data}, nil
+}
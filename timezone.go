@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/* -------------------------------
+   Naive dates (-assume-tz)
+
+   Dates without zone information
+   ("2019-03-01 14:30") are read in
+   -assume-tz and sent with their
+   offset (2019-03-01T14:30:00+01:00),
+   so the server does not pick a
+   zone of its own.
+
+   -tz-map FILE overrides it per
+   source, first match wins:
+
+   host:example.de = Europe/Berlin
+   feeds/us/**     = America/New_York
+
+   host: patterns match the link's
+   host (and its subdomains); others
+   the path below -dir, as -exclude
+   matches it. Date-only values and
+   dates with a zone are left alone.
+--------------------------------*/
+
+// naiveDateLayouts are the articleDateLayouts without a zone but with a
+// time of day.
+var naiveDateLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+type tzRule struct {
+	pattern string
+	host    bool
+	loc     *time.Location
+}
+
+type Timezones struct {
+	dir   string
+	def   *time.Location // nil: leave unmatched dates naive
+	rules []tzRule
+}
+
+func NewTimezones(assume, mapFile, dir string) (*Timezones, error) {
+	tz := &Timezones{dir: dir}
+	if assume != "" {
+		loc, err := time.LoadLocation(assume)
+		if err != nil {
+			return nil, fmt.Errorf("assume-tz: %w", err)
+		}
+		tz.def = loc
+	}
+	if mapFile == "" {
+		return tz, nil
+	}
+	f, err := os.Open(mapFile)
+	if err != nil {
+		return nil, fmt.Errorf("tz-map: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		pat, zone, ok := strings.Cut(line, "=")
+		pat, zone = strings.TrimSpace(pat), strings.TrimSpace(zone)
+		if !ok || pat == "" || zone == "" {
+			return nil, fmt.Errorf("%s:%d: want pattern = zone", mapFile, n)
+		}
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", mapFile, n, err)
+		}
+		r := tzRule{loc: loc}
+		if host, ok := strings.CutPrefix(pat, "host:"); ok {
+			r.pattern, r.host = strings.ToLower(strings.TrimSpace(host)), true
+		} else {
+			r.pattern = filepath.ToSlash(pat)
+			if _, err := path.Match(strings.ReplaceAll(r.pattern, "**", "*"), ""); err != nil {
+				return nil, fmt.Errorf("%s:%d: %q: %w", mapFile, n, pat, err)
+			}
+		}
+		tz.rules = append(tz.rules, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return tz, nil
+}
+
+// location returns the zone for naive dates of file's article a.
+func (tz *Timezones) location(file string, a *Article) *time.Location {
+	rel := file
+	if r, err := filepath.Rel(tz.dir, file); err == nil && !strings.HasPrefix(r, "..") {
+		rel = r
+	}
+	rel = filepath.ToSlash(rel)
+	var host string
+	if u, err := url.Parse(strings.TrimSpace(a.Link)); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	for _, r := range tz.rules {
+		if r.host {
+			if host != "" && (host == r.pattern || strings.HasSuffix(host, "."+r.pattern)) {
+				return r.loc
+			}
+			continue
+		}
+		target := rel
+		if !strings.Contains(r.pattern, "/") {
+			target = path.Base(rel)
+		}
+		if globMatch(r.pattern, target) {
+			return r.loc
+		}
+	}
+	return tz.def
+}
+
+// Apply gives a's naive dates the zone that applies to file.
+func (tz *Timezones) Apply(file string, a *Article) {
+	loc := tz.location(file, a)
+	if loc == nil {
+		return
+	}
+	a.PublishDate = localizeDate(a.PublishDate, loc)
+	a.UpdatedDate = localizeDate(a.UpdatedDate, loc)
+}
+
+// localizeDate rewrites a naive date as RFC 3339 in loc; other values are
+// returned unchanged.
+func localizeDate(s string, loc *time.Location) string {
+	for _, layout := range naiveDateLayouts {
+		if t, err := time.ParseInLocation(layout, strings.TrimSpace(s), loc); err == nil {
+			return t.Format(time.RFC3339Nano)
+		}
+	}
+	return s
+}
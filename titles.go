@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/* -------------------------------
+   Titles (-title-max, -title-from)
+
+   Titles are trimmed and runs of
+   whitespace collapsed. Titles over
+   -title-max characters (default:
+   the limit the API publishes, if
+   any) are cut at a word end, with
+   "…".
+
+   -title-from content gives
+   articles without a title one from
+   their content: the first h1–h3,
+   else the first sentence, cut to
+   generatedTitleMax characters.
+   Script and style text is not
+   considered. This runs before
+   -require, so a generated title
+   counts as present.
+--------------------------------*/
+
+const generatedTitleMax = 80
+
+type TitlePolicy struct {
+	max      int // characters, 0 = no limit
+	generate bool
+}
+
+// Apply fixes a's title; note describes a change, for the log.
+func (p *TitlePolicy) Apply(a *Article) (note string) {
+	title := strings.Join(strings.Fields(a.Title), " ")
+	if title == "" && p.generate {
+		if title = titleFromContent(a.Content); title != "" {
+			note = "generated"
+		}
+	}
+	if p.max > 0 && utf8.RuneCountInString(title) > p.max {
+		title = cutTitle(title, p.max)
+		note = strings.TrimPrefix(note+", cut to fit", ", ")
+	}
+	a.Title = title
+	return note
+}
+
+// titleFromContent takes the first h1–h3 of content, else its first
+// sentence.
+func titleFromContent(content string) string {
+	var toks []htmlToken
+	all := tokenizeHTML(content)
+	for i := 0; i < len(all); i++ {
+		if t := all[i]; t.kind == htmlStartTag && (t.data == "script" || t.data == "style") {
+			i = elementEnd(all, i)
+			continue
+		}
+		toks = append(toks, all[i])
+	}
+	for i, t := range toks {
+		if t.kind == htmlStartTag && (t.data == "h1" || t.data == "h2" || t.data == "h3") {
+			if h := strings.Join(strings.Fields(textContent(toks[i:elementEnd(toks, i)])), " "); h != "" {
+				return cutTitle(h, generatedTitleMax)
+			}
+		}
+	}
+	text := strings.Join(strings.Fields(textContent(toks)), " ")
+	if text == "" {
+		return ""
+	}
+	if i := strings.IndexAny(text, ".!?"); i > 0 {
+		text = text[:i]
+	}
+	return cutTitle(text, generatedTitleMax)
+}
+
+// cutTitle shortens s to at most n characters, at a word end if one falls
+// in the second half, and marks the cut with "…".
+func cutTitle(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	head := string(runes[:n-1])
+	if i := strings.LastIndexByte(head, ' '); i >= len(head)/2 {
+		head = head[:i]
+	}
+	return strings.TrimRight(head, " ,;:-–") + "…"
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+)
+
+/* -------------------------------
+   Table of contents (-toc-min-headings)
+
+   Gives every h2–h6 in the content
+   an anchor id (existing ids are
+   kept) and, when an article has at
+   least N headings, renders a nested
+   list of links above the body.
+--------------------------------*/
+
+type tocEntry struct {
+	level int
+	id    string
+	text  string
+}
+
+type TOC struct {
+	minHeadings int
+}
+
+// Apply assigns heading ids and records the headings on doc.
+func (c *TOC) Apply(doc *renderDoc, toks []htmlToken) []htmlToken {
+	used := map[string]bool{}
+	for i := range toks {
+		if t := &toks[i]; t.kind == htmlStartTag || t.kind == htmlSelfClosingTag {
+			if id, ok := t.attr("id"); ok {
+				used[id] = true
+			}
+		}
+	}
+	for i := range toks {
+		t := &toks[i]
+		level := headingLevel(t)
+		if level < 2 || t.kind != htmlStartTag {
+			continue
+		}
+		end := elementEnd(toks, i)
+		text := strings.Join(strings.Fields(textContent(toks[i+1:end])), " ")
+		if text == "" {
+			continue
+		}
+		id, ok := t.attr("id")
+		if !ok || id == "" {
+			id = uniqueSlug(text, used)
+			t.setAttr("id", id)
+		}
+		doc.toc = append(doc.toc, tocEntry{level: level, id: id, text: text})
+	}
+	return toks
+}
+
+// render returns the TOC markup, or "" below the heading threshold.
+// tocWithin keeps the entries whose heading is still in content.
+func tocWithin(entries []tocEntry, content string) []tocEntry {
+	var kept []tocEntry
+	for _, e := range entries {
+		if strings.Contains(content, `id="`+e.id+`"`) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func (c *TOC) render(entries []tocEntry) string {
+	if len(entries) < c.minHeadings || len(entries) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<nav class="toc"><h2>Contents</h2>` + "\n")
+	base := entries[0].level
+	for _, e := range entries {
+		if e.level < base {
+			base = e.level
+		}
+	}
+	depth := 0
+	for i, e := range entries {
+		want := e.level - base + 1
+		if i > 0 && want <= depth {
+			b.WriteString("</li>")
+		}
+		for depth < want {
+			b.WriteString("<ul>")
+			depth++
+			if depth < want {
+				b.WriteString("<li>")
+			}
+		}
+		for depth > want {
+			b.WriteString("</ul></li>")
+			depth--
+		}
+		fmt.Fprintf(&b, `<li><a href="#%s">%s</a>`, html.EscapeString(e.id), html.EscapeString(e.text))
+	}
+	b.WriteString("</li>")
+	for ; depth > 1; depth-- {
+		b.WriteString("</ul></li>")
+	}
+	b.WriteString("</ul>\n</nav>\n")
+	return b.String()
+}
+
+// headingLevel returns 1–6 for <h1>…<h6>, 0 otherwise.
+func headingLevel(t *htmlToken) int {
+	if (t.kind == htmlStartTag || t.kind == htmlEndTag || t.kind == htmlSelfClosingTag) &&
+		len(t.data) == 2 && t.data[0] == 'h' && t.data[1] >= '1' && t.data[1] <= '6' {
+		return int(t.data[1] - '0')
+	}
+	return 0
+}
+
+func uniqueSlug(text string, used map[string]bool) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		slug = "section"
+	}
+	id := slug
+	for n := 2; used[id]; n++ {
+		id = fmt.Sprintf("%s-%d", slug, n)
+	}
+	used[id] = true
+	return id
+}
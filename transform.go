@@ -3,8 +3,12 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html"
@@ -12,11 +16,16 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -25,12 +34,22 @@ import (
 --------------------------------*/
 
 type Article struct {
-	Title       string `json:"title"`
-	Content     string `json:"content"`
-	Excerpt     string `json:"excerpt"`
-	Link        string `json:"link"`
-	PublishDate string `json:"published_date"`
-	UpdatedDate string `json:"updated_date"`
+	Title       string   `json:"title"`
+	Content     string   `json:"content"`
+	Excerpt     string   `json:"excerpt"`
+	Link        string   `json:"link"`
+	PublishDate string   `json:"published_date"`
+	UpdatedDate string   `json:"updated_date"`
+	Author      string   `json:"author,omitempty"`
+	Image       string   `json:"image,omitempty"`       // representative image URL
+	Collections []int    `json:"collections,omitempty"` // overrides -collection
+	Tags        termList `json:"tags,omitempty"`
+	Categories  termList `json:"categories,omitempty"` // sent as tags too
+
+	SchemaVersion int `json:"schema_version,omitempty"` // of the input, see schema.go
+
+	CollectionID collectionRef `json:"collection_id,omitzero"` // used when Collections is empty
+	Collection   collectionRef `json:"collection,omitzero"`    // a collection name, failing that
 }
 
 /* -------------------------------
@@ -41,18 +60,124 @@ type Article struct {
 
 type Transformer struct {
 	apiBase string
+	api     *apiDialect // upload endpoint and field names of the API version
 	client  *http.Client
-	headers http.Header
+	keys    KeySource
+	keyMu   sync.RWMutex // guards pool, which is replaced when keys are renewed
+	pool    *keyPool
+	headers http.Header // sent with every upload
+	auth    authScheme  // attaches the API key
+	signer  *Signer     // optional HMAC request signing
+
+	refreshMu     sync.Mutex      // one key re-read at a time
+	lastRefresh   time.Time       // of a re-read that returned the same key
+	mapper        *Mapper         // optional -map-expr field mapping
+	xmlMapper     *XMLMapper      // optional -xpath rules for .xml files
+	filter        *Filter         // optional -filter expression
+	matches       []*FieldMatch   // -match-title, -match-link
+	byDate        bool            // -order publish-date
+	allowEmpty    bool            // upload articles with empty content anyway
+	dedupe        *runDedupe      // nil with -allow-duplicates
+	statsd        *StatsD         // optional -statsd
+	cache         *TransformCache // optional -transform-cache
+	verbose       int             // -v = 1, -vv = 2
+	review        *Reviewer       // optional -interactive approval
+	conditional   bool            // send If-None-Match with a content hash
+	crossPost     bool            // one request for all collections
+	canonical     *Canonicalizer  // optional -canonical-links
+	sourceArchive *LinkChecker    // optional -source-archive
+	thumbs        *Thumbnails     // optional -thumbnail
+	a11y          *Accessibility  // optional -a11y
+	og            *OpenGraph      // optional -og-enrich
+	authors       *AuthorMap      // optional -author-map
+	taxonomy      *Taxonomy       // optional -taxonomy
+	nearDup       *NearDup        // optional -near-dup
+	truncate      *Truncation     // optional -truncate
+	require       *Requirements   // optional -require
+	timezones     *Timezones      // optional -assume-tz/-tz-map
+	dates         *DatePolicy     // optional -invalid-date/-future-date
+	titles        *TitlePolicy    // optional -title-max/-title-from
+	fieldLimits   map[string]int  // field lengths the API publishes
+	collMu        sync.Mutex
+	collByName    map[string]int // collection IDs by lower-case name, 0 = none
+	hooks         []TransformHook
+
+	inputPlugin  *InputPlugin // optional -input-plugin decoder
+	decoded      sync.Map     // path → *decodedInput, decoded before the upload
+	checksums    *Checksums   // optional -checksums
+	strict       bool         // reject keys outside the Article schema
+	lenient      bool         // fix almost-JSON before decoding
+	epub         string       // -epub: chapters or book
+	archivePages string       // -archive-pages: articles or all
+	encoding     string       // -input-encoding
+	invalidUTF8  string       // -invalid-utf8
+	nfc          bool         // compose text to Unicode NFC
+	fixMojibake  bool         // repair UTF-8 mis-decoded as Windows-1252
+
+	normalizeEntities bool          // undo double-encoded entities
+	asciiPunct        bool          // smart quotes/dashes → ASCII
+	passes            []contentPass // rewriting passes over article content
+	minify            bool          // minify the rendered HTML before upload
+	toc               *TOC          // optional table of contents
+	audit             *AuditLog     // optional -audit-log
+
+	maxRetries int          // per-upload retries of retryable failures
+	budget     *retryBudget // run-wide cap on retries
+	failover   *failover    // optional secondary API base
+	rates      *rateStats   // rate-limit telemetry of the current batch
+	conns      *connStats   // connection reuse of the current batch
+
+	runID  string        // X-Run-ID of the current batch
+	runAt  time.Time     // when the current batch started
+	batch  string        // -batch-id, else the run ID goes into metadata
+	reqSeq atomic.Uint64 // numbers X-Request-IDs within the run
+}
+
+// contentPass rewrites tokenized article content; side outputs go to doc.
+type contentPass func(doc *renderDoc, toks []htmlToken) []htmlToken
+
+// renderDoc carries per-article state through rendering and upload.
+type renderDoc struct {
+	article        *Article
+	attachments    []attachment
+	toc            []tocEntry
+	empty          bool // no text or media left after cleanup
+	deadLinks      []deadLink
+	content        string // the cleaned article HTML
+	archivedSource string // Wayback snapshot cited for a dead article link
+	thumbnail      string // representative image URL, -thumbnail
+	a11y           []a11yIssue
+}
+
+// attachment is a file sent alongside html_content in the multipart body.
+type attachment struct {
+	field       string // form field, "" = the API's attachment field
+	name        string
+	contentType string
+	data        []byte
 }
 
-func NewTransformer(apiBase, apiKeyEnv string, maxConns int) (*Transformer, error) {
+// errSkipped marks articles deliberately not uploaded (wrapped with the
+// reason); workers count these separately from failures.
+var errSkipped = errors.New("skipped")
+
+// errNotModified is a conditional upload the server already had (304).
+var errNotModified = fmt.Errorf("%w: unchanged on the server (304)", errSkipped)
+
+// errEmptyContent marks articles with nothing left to show after cleanup;
+// they are skipped and listed separately in the report.
+var errEmptyContent = errors.New("empty content")
+
+func NewTransformer(apiBase string, keys KeySource, maxConns int) (*Transformer, error) {
 	apiBase = strings.TrimSuffix(apiBase, "/")
-	key := os.Getenv(apiKeyEnv)
-	if key == "" {
-		return nil, fmt.Errorf("env %q not set", apiKeyEnv)
+	key, err := keys.Key(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	pool := newKeyPool(splitKeys(keys, key))
+	if len(pool.keys) == 0 {
+		return nil, fmt.Errorf("%s: no API key", keys)
 	}
-	h := make(http.Header)
-	h.Set("Authorization", "Bearer "+key)
 
 	tr := &http.Transport{
 		MaxIdleConns:        maxConns,
@@ -62,229 +187,1830 @@ func NewTransformer(apiBase, apiKeyEnv string, maxConns int) (*Transformer, erro
 	}
 
 	return &Transformer{
+		keys:    keys,
+		pool:    pool,
 		apiBase: apiBase,
-		headers: h,
+		api:     apiDialects["v2"],
+		headers: make(http.Header),
+		auth:    bearerAuth,
 		client:  &http.Client{Transport: tr, Timeout: 15 * time.Second},
 	}, nil
 }
 
+// keyPool returns the current API keys.
+func (t *Transformer) keyPool() *keyPool {
+	t.keyMu.RLock()
+	defer t.keyMu.RUnlock()
+	return t.pool
+}
+
+// setKey swaps in new API keys (a key source value, possibly holding
+// several) for subsequent requests.
+func (t *Transformer) setKey(value string) {
+	keys := splitKeys(t.keys, value)
+	if len(keys) == 0 {
+		log.Printf("Ignoring empty API key from %s", t.keys)
+		return
+	}
+	t.keyMu.Lock()
+	t.pool = newKeyPool(keys)
+	t.keyMu.Unlock()
+	for _, k := range keys {
+		t.audit.addSecret(k)
+	}
+}
+
+// keyRefreshCooldown stops a genuinely bad key from being re-read on
+// every failing request.
+const keyRefreshCooldown = 10 * time.Second
+
+// refreshKey re-reads the key after stale was rejected with 401 and
+// reports whether a retry with a different key makes sense.
+func (t *Transformer) refreshKey(ctx context.Context, stale string) bool {
+	t.refreshMu.Lock()
+	defer t.refreshMu.Unlock()
+	if !t.keyPool().has(stale) {
+		return true // another worker already swapped it
+	}
+	if time.Since(t.lastRefresh) < keyRefreshCooldown {
+		return false
+	}
+	key, err := t.keys.Key(ctx)
+	if err != nil {
+		log.Printf("Re-reading API key from %s after 401: %v", t.keys, err)
+		t.lastRefresh = time.Now()
+		return false
+	}
+	if t.keyPool().same(splitKeys(t.keys, key)) {
+		log.Printf("API key from %s is unchanged after 401", t.keys)
+		t.lastRefresh = time.Now()
+		return false
+	}
+	log.Printf("API key rejected (401); using the new key from %s", t.keys)
+	t.setKey(key)
+	return true
+}
+
 // -----------------------------------------------------------------------------
 // Helpers (≈ clean_description, parse_date, build HTML, build metadata)
 // -----------------------------------------------------------------------------
 
-func (t *Transformer) cleanHTML(s string) string {
+func (t *Transformer) cleanHTML(doc *renderDoc, s string) string {
+	if len(t.passes) > 0 {
+		toks := tokenizeHTML(s)
+		for _, pass := range t.passes {
+			toks = pass(doc, toks)
+		}
+		s = renderHTML(toks)
+	}
 	replacer := strings.NewReplacer("<script", "&lt;script", "</script>", "&lt;/script&gt;")
 	s = replacer.Replace(s)
 	return s
 }
 
-func (t *Transformer) buildHTML(a *Article) string {
-	var b strings.Builder
-	b.WriteString(fmt.Sprintf("<h1>%s</h1>\n", html.EscapeString(a.Title)))
+func (t *Transformer) buildHTML(doc *renderDoc) string {
+	content := t.cleanHTML(doc, doc.article.Content)
+	doc.content = content
+	doc.empty = emptyContent(content)
+	return t.renderPage(doc, content)
+}
+
+// renderPage lays out the item page around the cleaned content.
+func (t *Transformer) renderPage(doc *renderDoc, content string) string {
+	a := doc.article
+	b := getBuffer()
+	defer putBuffer(b)
+	b.Grow(len(content) + 1024)
+	fmt.Fprintf(b, "<h1>%s</h1>\n", html.EscapeString(a.Title))
 	if a.Excerpt != "" {
-		b.WriteString(fmt.Sprintf("<p>%s</p>\n", html.EscapeString(a.Excerpt)))
+		fmt.Fprintf(b, "<p>%s</p>\n", html.EscapeString(a.Excerpt))
+	}
+	if t.toc != nil {
+		b.WriteString(t.toc.render(doc.toc))
 	}
 	b.WriteString("<div>\n")
-	b.WriteString(t.cleanHTML(a.Content))
+	b.WriteString(content)
 	b.WriteString("\n</div>\n")
 	b.WriteString("<h3>Metadata</h3>\n")
-	b.WriteString(fmt.Sprintf(`<p>Source Url: <a href="%s">%s</a></p>`, a.Link, a.Link))
-	b.WriteString(fmt.Sprintf(`<p>Published Date: %s</p>`, a.PublishDate))
-	b.WriteString(fmt.Sprintf(`<p>Updated Date: %s</p>`, a.UpdatedDate))
+	if doc.archivedSource != "" {
+		fmt.Fprintf(b, `<p>Source Url: <a href="%s">%s</a> (archived copy; the original %s is no longer available)</p>`,
+			doc.archivedSource, doc.archivedSource, a.Link)
+	} else {
+		fmt.Fprintf(b, `<p>Source Url: <a href="%s">%s</a></p>`, a.Link, a.Link)
+	}
+	fmt.Fprintf(b, `<p>Published Date: %s</p>`, a.PublishDate)
+	fmt.Fprintf(b, `<p>Updated Date: %s</p>`, a.UpdatedDate)
+	if a.Author != "" {
+		fmt.Fprintf(b, `<p>Author: %s</p>`, html.EscapeString(a.Author))
+	}
 	return b.String()
 }
 
 func (t *Transformer) buildMetadata(a *Article) map[string]any {
-	return map[string]any{
+	meta := map[string]any{
 		"title":         a.Title,
-		"creation_date": a.PublishDate,
-		"source_url":    a.Link,
+		t.api.dateKey:   a.PublishDate,
+		t.api.sourceKey: a.Link,
+	}
+	if a.Author != "" {
+		meta["author"] = a.Author
+	}
+	if a.Image != "" {
+		meta["thumbnail_url"] = a.Image
+	}
+	if terms := a.terms(); len(terms) > 0 {
+		meta["tags"] = terms
 	}
+	return meta
+}
+
+// batchTags returns metadata stamped with the batch ID, run start and tool
+// version, so a run's items can be found again in Omnipub.
+func (t *Transformer) batchTags(metadata map[string]any) map[string]any {
+	tagged := make(map[string]any, len(metadata)+3)
+	for k, v := range metadata {
+		tagged[k] = v
+	}
+	tagged["batch_id"] = cmp.Or(t.batch, t.runID)
+	tagged["run_at"] = t.runAt.UTC().Format(time.RFC3339)
+	tagged["tool_version"] = toolVersion()
+	return tagged
 }
 
 // -----------------------------------------------------------------------------
 // POSTing (≈ post_item)
 // -----------------------------------------------------------------------------
 
-func (t *Transformer) postItem(ctx context.Context, htmlContent string, metadata map[string]any, collectionID *int) error {
-	// build multipart body
-	var body bytes.Buffer
-	mp := multipart.NewWriter(&body)
+func (t *Transformer) postItem(ctx context.Context, htmlContent string, metadata map[string]any, collectionIDs []int, attachments []attachment) error {
+	// build multipart body; the buffer goes back to the pool once the
+	// upload, retries and failover are done with it
+	body := getBuffer()
+	defer putBuffer(body)
+	mp := multipart.NewWriter(body)
 
-	_ = mp.WriteField("html_content", htmlContent)
+	_ = mp.WriteField(t.api.htmlField, htmlContent)
+	// the batch tags differ on every run, so they stay out of the ETag
 	metaBytes, _ := json.Marshal(metadata)
-	_ = mp.WriteField("metadata", string(metaBytes))
-	if collectionID != nil {
-		_ = mp.WriteField("collection_id", fmt.Sprintf("%d", *collectionID))
+	tagged, _ := json.Marshal(t.batchTags(metadata))
+	_ = mp.WriteField(t.api.metadataField, string(tagged))
+	for _, id := range collectionIDs {
+		_ = mp.WriteField(t.api.collectionField, strconv.Itoa(id))
+	}
+	for _, a := range attachments {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, cmp.Or(a.field, t.api.attachmentField), a.name))
+		h.Set("Content-Type", a.contentType)
+		part, err := mp.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(a.data); err != nil {
+			return err
+		}
 	}
 	mp.Close()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.apiBase+"/omnipub", &body)
+	post := cachedPost{ContentType: mp.FormDataContentType(), Collections: collectionIDs, Body: body.Bytes()}
+	if t.conditional {
+		post.ETag = contentETag(htmlContent, metaBytes, collectionIDs, attachments)
+	}
+	post.Title, _ = metadata["title"].(string)
+	err := t.sendPost(ctx, post)
+	if err != nil && !errors.Is(err, errNotModified) {
+		// only what is still outstanding is sent again from the cache
+		postRecorderFrom(ctx).add(post)
+	}
+	return err
+}
+
+// sendPost uploads a built request body, failing over if configured.
+func (t *Transformer) sendPost(ctx context.Context, p cachedPost) error {
+	uploadResultFrom(ctx).setCollections(p.Collections)
+	ctx = withMetricCollections(ctx, p.Collections)
+	base := t.uploadBase()
+	// failing over is only safe if the primary never saw the request
+	var sent atomic.Bool
+	uctx := ctx
+	if base == t.apiBase && t.failover != nil {
+		uctx = traceSent(ctx, &sent)
+	}
+	err := t.upload(uctx, base, p.Body, p.ContentType, p.Title, p.ETag)
+	if base == t.apiBase && t.failover.report(err, sent.Load()) {
+		log.Printf("FAILOVER %s → %s after %v", inputFile(ctx), t.failover.secondary, err)
+		err = t.upload(ctx, t.failover.secondary, p.Body, p.ContentType, p.Title, p.ETag)
+	}
+	return err
+}
+
+// upload sends one item to the API at base, retrying as configured.
+func (t *Transformer) upload(ctx context.Context, base string, payload []byte, contentType, title, etag string) error {
+	refreshed := false
+	for attempt := 0; ; {
+		picked := time.Now()
+		key := t.keyPool().pick()
+		t.rates.wait(time.Since(picked)) // all keys benched after 429s
+		retryAfter, err := t.send(ctx, base, key, payload, contentType, title, etag)
+		// a 401 mid-run usually means the key was rotated: re-read it
+		// once and try again, outside the retry count and budget
+		if unauthorized(err) && !refreshed && t.refreshKey(ctx, key.key) {
+			refreshed = true
+			continue
+		}
+		if err == nil || attempt >= t.maxRetries || !retryable(err) || !t.budget.take() {
+			return err
+		}
+		delay := retryDelay(attempt, retryAfter)
+		if throttled(err) {
+			t.rates.wait(delay)
+		}
+		log.Printf("RETRY %s in %s → %v", inputFile(ctx), delay, err)
+		t.statsd.count("retries", 1, "run_id:"+t.runID)
+		time.Sleep(delay)
+		attempt++
+	}
+}
+
+// send makes one upload attempt to base with key. retryAfter is the server's
+// Retry-After hint, if any. A non-empty etag is sent as If-None-Match, and
+// a 304 answer comes back as errNotModified.
+func (t *Transformer) send(ctx context.Context, base string, key *poolKey, payload []byte, contentType, title, etag string) (retryAfter time.Duration, err error) {
+	reqID := fmt.Sprintf("%s-%06d", t.runID, t.reqSeq.Add(1))
+	rec := auditRecord{RunID: t.runID, RequestID: reqID, Title: title}
+	if t.audit != nil {
+		sum := sha256.Sum256(payload)
+		rec.RequestSHA = hex.EncodeToString(sum[:])
+	}
+
+	req, err := http.NewRequestWithContext(t.conns.trace(ctx), http.MethodPost, base+t.api.uploadPath, bytes.NewReader(payload))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	req.Header = t.headers.Clone()
-	req.Header.Set("Content-Type", mp.FormDataContentType())
+	t.auth(req, key.key)
+	if t.signer != nil {
+		t.signer.Sign(req, payload, time.Now())
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Run-ID", t.runID)
+	req.Header.Set("X-Request-ID", reqID)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
 
+	t.debugRequest(req, key.key, reqID)
+	start := time.Now()
 	resp, err := t.client.Do(req)
+	t.statsd.timing("request", time.Since(start), collectionTags([]string{"run_id:" + t.runID, "status:" + statusTag(resp)}, metricCollections(ctx))...)
 	if err != nil {
-		return err
+		t.debugf(1, "HTTP  POST %s → %v in %s (request %s)", req.URL, err, time.Since(start).Round(time.Millisecond), reqID)
+		uploadResultFrom(ctx).attempt(0)
+		t.audit.request(ctx, rec, start, 0, "", err)
+		return 0, fmt.Errorf("%w (request %s)", err, reqID)
 	}
 	defer resp.Body.Close()
+	t.debugf(1, "HTTP  POST %s → %d in %s (request %s)", req.URL, resp.StatusCode, time.Since(start).Round(time.Millisecond), reqID)
+	retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	t.rates.observe(resp.Header, resp.StatusCode, time.Since(start))
+	key.report(resp.StatusCode, retryAfter)
+	uploadResultFrom(ctx).attempt(resp.StatusCode)
 
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return nil
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		t.debugBody(reqID, raw)
+		uploadResultFrom(ctx).add(base, itemURL(raw), itemID(raw))
+		t.audit.request(ctx, rec, start, resp.StatusCode, string(raw), nil)
+		return 0, nil
+	}
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		t.audit.request(ctx, rec, start, resp.StatusCode, "", nil)
+		return 0, errNotModified
 	}
 	slurp, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
-	return fmt.Errorf("http %d %s", resp.StatusCode, strings.TrimSpace(string(slurp)))
+	t.debugBody(reqID, slurp)
+	err = &httpError{status: resp.StatusCode, body: strings.TrimSpace(string(slurp)), requestID: reqID}
+	t.audit.request(ctx, rec, start, resp.StatusCode, "", err)
+	return retryAfter, err
+}
+
+// contentETag hashes what an upload carries – not the multipart body,
+// whose boundary is random – into a strong ETag for If-None-Match.
+func contentETag(htmlContent string, metadata []byte, collectionIDs []int, attachments []attachment) string {
+	h := sha256.New()
+	field := func(b []byte) {
+		fmt.Fprintf(h, "%d:", len(b))
+		h.Write(b)
+	}
+	field([]byte(htmlContent))
+	field(metadata)
+	for _, id := range collectionIDs {
+		field([]byte(strconv.Itoa(id)))
+	}
+	for _, a := range attachments {
+		field([]byte(a.name))
+		field([]byte(a.contentType))
+		field(a.data)
+	}
+	return `"sha256-` + hex.EncodeToString(h.Sum(nil)) + `"`
 }
 
 /* ---------- worker-friendly wrapper ---------- */
 
-func (t *Transformer) processFile(ctx context.Context, file string, collectionID *int) error {
-	f, err := os.Open(file)
+type inputFileKey struct{}
+
+// withInputFile tags ctx with the file being processed, for logs and
+// audit records further down.
+func withInputFile(ctx context.Context, file string) context.Context {
+	return context.WithValue(ctx, inputFileKey{}, file)
+}
+
+func inputFile(ctx context.Context) string {
+	f, _ := ctx.Value(inputFileKey{}).(string)
+	return f
+}
+
+func (t *Transformer) processFile(ctx context.Context, file string, collections []int) error {
+	arts, err := t.decodeFile(ctx, file)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	ctx = withInputFile(ctx, file)
+	if t.byDate {
+		sortArticles(arts)
+	}
+	if len(arts) == 1 {
+		return t.publish(ctx, &arts[0], collections)
+	}
 
-	var art Article
-	if err := json.NewDecoder(f).Decode(&art); err != nil {
-		return err
+	// multi-article inputs (input plugins): the file only counts as
+	// uploaded if every item made it; skipped items don't count against it.
+	// Items uploaded are noted for -manifest, and those an earlier run
+	// uploaded are not sent again.
+	res, uploaded := uploadResultFrom(ctx), uploadedItemsFrom(ctx)
+	var failed, skipped, again int
+	var firstErr error
+	for i := range arts {
+		sum := itemSum(&arts[i]) // before plugins change it
+		if uploaded[sum] {
+			res.addItem(sum)
+			again++
+			continue
+		}
+		err := t.publish(ctx, &arts[i], collections)
+		switch {
+		case errors.Is(err, errSkipped):
+			skipped++
+		case err != nil:
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("item %d: %w", i, err)
+			}
+		default:
+			res.addItem(sum)
+		}
+	}
+	if again > 0 {
+		log.Printf("ITEMS %s: %d of %d items were uploaded by an earlier run and are not sent again", file, again, len(arts))
 	}
+	if failed > 0 {
+		return fmt.Errorf("%d/%d items failed, first: %w", failed, len(arts), firstErr)
+	}
+	if skipped == len(arts) {
+		return fmt.Errorf("%w: all %d items", errSkipped, skipped)
+	}
+	return nil
+}
 
-	return t.postItem(ctx, t.buildHTML(&art), t.buildMetadata(&art), collectionID)
+// decodeFile turns one input file into articles: through the input
+// plugin if configured, otherwise as a single JSON Article. Decoding
+// failures are returned as *inputError.
+func (t *Transformer) decodeFile(ctx context.Context, file string) ([]Article, error) {
+	in, err := t.readInput(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	arts, ok := t.takeDecoded(ctx, in)
+	if !ok {
+		arts, err = t.decodeBytes(ctx, file, in.raw)
+	}
+	// the hash runs alongside decoding; a mismatch explains any decode error
+	if t.checksums != nil {
+		if cerr := t.checksums.Verify(file, in.Sum(), len(in.raw)); cerr != nil {
+			return nil, &inputError{cerr}
+		}
+	}
+	return arts, err
 }
 
-/* ============================================================================
-   MAIN – Worker‑pool that saturates the API
-============================================================================ */
+func (t *Transformer) decodeBytes(ctx context.Context, file string, raw []byte) ([]Article, error) {
+	var err error
+	var arts []Article
+	if t.inputPlugin != nil {
+		if arts, err = t.inputPlugin.Decode(ctx, file, raw); err != nil {
+			return nil, &inputError{err}
+		}
+	} else if isEPUBFile(file) {
+		if arts, err = decodeEPUB(raw, t.epub); err != nil {
+			return nil, &inputError{err}
+		}
+	} else if isWebArchiveFile(file) {
+		if arts, err = decodeWebArchive(raw, file, t.archivePages); err != nil {
+			return nil, &inputError{err}
+		}
+	} else {
+		xmlIn := t.xmlMapper != nil && isXMLFile(file)
+		if !isYAMLFile(file) && !isHTMLFile(file) && !xmlIn {
+			sniffed := raw
+			if t.lenient {
+				sniffed = skipLeadingComments(raw) // a comment reads as plain text
+			}
+			if err := sniffInput(sniffed); err != nil {
+				return nil, &inputError{err}
+			}
+		}
+		bom := t.lenient && bytes.HasPrefix(raw, []byte("\xEF\xBB\xBF"))
+		if raw, err = toUTF8(raw, t.encoding); err != nil {
+			return nil, &inputError{err}
+		}
+		if t.encoding == "auto" || t.encoding == "utf-8" {
+			fixed, n, err := repairUTF8(string(raw), t.invalidUTF8)
+			if err != nil {
+				return nil, &inputError{err}
+			}
+			if n > 0 {
+				log.Printf("UTF8  %s: %d invalid bytes or control characters fixed (%s)", file, n, t.invalidUTF8)
+				raw = []byte(fixed)
+			}
+		}
+		if isYAMLFile(file) {
+			if raw, err = yamlToJSON(raw); err != nil {
+				return nil, &inputError{err}
+			}
+		} else if t.lenient && !xmlIn && !isHTMLFile(file) {
+			var fixes []string
+			raw, fixes = lenientJSON(raw)
+			if bom {
+				fixes = append([]string{"UTF-8 BOM"}, fixes...)
+			}
+			if len(fixes) > 0 {
+				log.Printf("JSON  %s: lenient: %s", file, strings.Join(fixes, ", "))
+				for _, f := range fixes {
+					uploadResultFrom(ctx).note("lenient: " + f)
+				}
+			}
+		}
+		if xmlIn {
+			if arts, err = t.xmlMapper.Decode(raw); err != nil {
+				return nil, &inputError{err}
+			}
+		} else if isHTMLFile(file) {
+			art, _, err := decodeHTMLPage(raw, "", savedImages(filepath.Dir(file)))
+			if err != nil {
+				return nil, &inputError{err}
+			}
+			arts = []Article{art}
+		} else {
+			var art Article
+			if t.mapper != nil {
+				art, err = t.mapper.Decode(raw)
+			} else {
+				art, err = decodeArticle(raw, t.strict)
+			}
+			if err != nil {
+				return nil, &inputError{err}
+			}
+			arts = []Article{art}
+		}
+	}
 
-func main() {
-	dir := flag.String("dir", ".", "Directory with .json files")
-	retryFile := flag.String("retry", "", "File with list of failed files to retry")
-	api := flag.String("api", "https://cashmere.io/api/v2", "Omnipub API base")
-	collection := flag.Int("collection", 0, "Optional collection_id")
-	workers := flag.Int("workers", 10, "Concurrent workers (≈ open TCP conns)")
-	backoff := flag.Int("backoff", 0, "Backoff interval in milliseconds between retries (0 = no backoff)")
-	maxConns := flag.Int("max-conns", 256, "Max connections per host (sets Transport)")
-	apiKeyEnv := flag.String("key-env", "OMNIPUB_API_KEY", "Env var with API key")
-	saveFailures := flag.String("save-failures", "", "Save paths of failed files to this file")
-	flag.Parse()
+	if t.nfc || t.fixMojibake || t.normalizeEntities || t.asciiPunct {
+		for i := range arts {
+			t.normalizeText(&arts[i])
+		}
+	}
+	return arts, nil
+}
 
-	transformer, err := NewTransformer(*api, *apiKeyEnv, *maxConns)
-	if err != nil {
-		log.Fatal(err)
+// checkUTF8 repairs the article's fields as -invalid-utf8 says, after
+// hooks and enrichment may have changed them.
+func (t *Transformer) checkUTF8(file string, a *Article) error {
+	var fixed []string
+	for name, field := range articleFields {
+		p := field(a)
+		s, n, err := repairUTF8(*p, t.invalidUTF8)
+		if err != nil {
+			return &inputError{fmt.Errorf("%s: %w", name, err)}
+		}
+		if n > 0 {
+			*p = s
+			fixed = append(fixed, name)
+		}
 	}
+	if len(fixed) > 0 {
+		slices.Sort(fixed)
+		log.Printf("UTF8  %s: invalid bytes or control characters fixed in %s", file, strings.Join(fixed, ", "))
+	}
+	return nil
+}
 
-	var files []string
+// normalizeText applies the optional text clean-ups to an article.
+func (t *Transformer) normalizeText(a *Article) {
+	for _, field := range articleFields {
+		p := field(a)
+		if t.fixMojibake {
+			*p = fixMojibake(*p)
+		}
+		if t.nfc {
+			*p = composeNFC(*p)
+		}
+	}
+	if t.normalizeEntities {
+		a.Title = unescapeText(a.Title)
+		a.Excerpt = unescapeText(a.Excerpt)
+		a.Content = normalizeHTMLEntities(a.Content)
+	}
+	if t.asciiPunct {
+		a.Title = asciiPunctuation(a.Title)
+		a.Excerpt = asciiPunctuation(a.Excerpt)
+		a.Content = asciiPunctuation(a.Content)
+	}
+}
 
-	// Handle retry file if specified
-	if *retryFile != "" {
-		files, err = readFileList(*retryFile)
+// publish runs the per-article stages (plugins, filter) and uploads.
+func (t *Transformer) publish(ctx context.Context, art *Article, collections []int) error {
+	var sum [32]byte // of the article as decoded
+	if t.dedupe != nil {
+		sum = articleSum(art)
+	}
+	for _, h := range t.hooks {
+		out, err := h.Transform(ctx, art)
 		if err != nil {
-			log.Fatalf("Error reading retry file: %v", err)
+			return err
 		}
-	} else {
-		// Regular directory mode
-		files, err = filepath.Glob(filepath.Join(*dir, "*.json"))
+		if out == nil {
+			return fmt.Errorf("%w: dropped by plugin", errSkipped)
+		}
+		*art = *out
+	}
+
+	if t.filter != nil {
+		keep, err := t.filter.Match(art)
 		if err != nil {
-			log.Fatal(err)
+			return err
+		}
+		if !keep {
+			return fmt.Errorf("%w: filter %q", errSkipped, t.filter.src)
+		}
+	}
+	for _, m := range t.matches {
+		if !m.Match(art) {
+			return fmt.Errorf("%w: %s", errSkipped, m)
 		}
 	}
 
-	if len(files) == 0 {
-		log.Println("No files to process – nothing to upload.")
-		return
+	collections, err := t.articleCollections(ctx, art, collections)
+	if err != nil {
+		return err
+	}
+	if t.og != nil {
+		if filled, err := t.og.Enrich(ctx, art); err != nil {
+			log.Printf("Open Graph of %s: %s: %v", inputFile(ctx), art.Link, err)
+		} else if len(filled) > 0 {
+			log.Printf("OG  %s: filled %s", inputFile(ctx), strings.Join(filled, ", "))
+		}
+	}
+	if t.authors != nil {
+		art.Author = t.authors.Normalize(art.Author)
+	}
+	if t.taxonomy != nil {
+		art.Tags, art.Categories = t.taxonomy.Map(inputFile(ctx), art.terms()), nil
+	}
+	if t.timezones != nil {
+		t.timezones.Apply(inputFile(ctx), art)
+	}
+	dropDate := false
+	if t.dates != nil {
+		var note string
+		if dropDate, note, err = t.dates.Apply(art, t.runAt); err != nil {
+			return err
+		} else if note != "" {
+			log.Printf("DATE  %s: %s", inputFile(ctx), note)
+		}
+	}
+	if t.titles != nil {
+		if note := t.titles.Apply(art); note != "" {
+			log.Printf("TITLE %s: %s: %q", inputFile(ctx), note, art.Title)
+		}
+	}
+	if t.require != nil {
+		if err := t.require.Check(art); err != nil {
+			return err
+		}
 	}
-	log.Printf("Uploading %d files with %d workers …", len(files), *workers)
-
-	// --- concurrency primitives
-	jobs := make(chan string, len(files))
-	var ok, fail uint64
-	var wg sync.WaitGroup
-	ctx := context.Background()
-
-	// To store failures if save-failures is specified
-	var failures []string
-	var failuresMutex sync.Mutex
-
-	// spawn workers
-	for w := 0; w < *workers; w++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for f := range jobs {
-				// If backoff is specified, sleep for a short duration to avoid rate limiting
-				if *backoff > 0 {
-					time.Sleep(time.Duration(*backoff) * time.Millisecond)
-				}
 
-				if err := transformer.processFile(ctx, f, func() *int {
-					if *collection > 0 {
-						return collection
-					}
-					return nil
-				}()); err != nil {
-					atomic.AddUint64(&fail, 1)
-					log.Printf("FAIL  %s → %v", f, err)
-
-					// Store failure if requested
-					if *saveFailures != "" {
-						failuresMutex.Lock()
-						failures = append(failures, f)
-						failuresMutex.Unlock()
+	doc := &renderDoc{article: art}
+	if t.sourceArchive != nil {
+		var dead string
+		if doc.archivedSource, dead = t.sourceArchive.archivedSource(art); doc.archivedSource != "" {
+			log.Printf("ARCHIVED %s: source %s (%s) → %s", inputFile(ctx), art.Link, dead, doc.archivedSource)
+		} else if dead != "" {
+			log.Printf("DEAD  %s: source %s (%s), no archived copy", inputFile(ctx), art.Link, dead)
+		}
+	}
+	if err := t.checkUTF8(inputFile(ctx), art); err != nil {
+		return err
+	}
+	htmlContent := t.buildHTML(doc)
+	logDeadLinks(inputFile(ctx), doc.deadLinks)
+	if t.a11y != nil {
+		t.a11y.record(inputFile(ctx), doc.a11y)
+	}
+	if doc.empty && !t.allowEmpty {
+		return fmt.Errorf("%w: %w", errSkipped, errEmptyContent)
+	}
+	reviewed := false
+	if t.review != nil {
+		built := htmlContent
+		var err error
+		if htmlContent, err = t.review.Review(inputFile(ctx), art, htmlContent); err != nil {
+			return err
+		}
+		reviewed = htmlContent != built
+	}
+	if t.minify {
+		htmlContent = minifyHTML(htmlContent)
+	}
+	page := func([]int) string { return htmlContent }
+	if t.truncate != nil && !reviewed { // an edited page goes out as edited
+		page = func(ids []int) string {
+			r := t.truncate.rule(ids)
+			if r == nil {
+				return htmlContent
+			}
+			short, cut := r.Apply(doc.content, art)
+			if !cut {
+				return htmlContent
+			}
+			d := *doc
+			d.toc = tocWithin(doc.toc, short)
+			out := t.renderPage(&d, short)
+			if t.minify {
+				out = minifyHTML(out)
+			}
+			return out
+		}
+	}
+	metadata := t.buildMetadata(art)
+	if dropDate {
+		delete(metadata, t.api.dateKey)
+	}
+	if doc.archivedSource != "" {
+		metadata[t.api.sourceKey] = doc.archivedSource
+		metadata["source_archived"] = true
+		metadata["original_source_url"] = art.Link
+	}
+	if doc.thumbnail != "" {
+		metadata["thumbnail_url"] = doc.thumbnail
+		if t.thumbs.cover {
+			cover, err := t.thumbs.fetchCover(ctx, doc.thumbnail)
+			if err != nil {
+				log.Printf("No cover for %s: %s: %v", inputFile(ctx), doc.thumbnail, err)
+			} else {
+				cover.field = t.api.coverField
+				doc.attachments = append(doc.attachments, cover)
+			}
+		}
+	}
+	var canonURL string
+	if t.canonical != nil && art.Link != "" {
+		canonURL = t.canonical.Canonical(ctx, art.Link)
+		if first, ok := t.canonical.claim(canonURL, inputFile(ctx)); !ok {
+			return fmt.Errorf("%w: duplicate of %s (canonical URL %s)", errSkipped, first, canonURL)
+		}
+		metadata["canonical_url"] = canonURL
+	}
+	fingerprinted := false
+	if t.nearDup != nil {
+		if fp, ok := simhash(art.Title + " " + textContent(tokenizeHTML(doc.content))); ok {
+			uploadResultFrom(ctx).setFingerprint(fp)
+			if match, sim := t.nearDup.Check(fp, inputFile(ctx)); match != nil {
+				other := cmp.Or(match.url, match.file)
+				if t.nearDup.skip {
+					if canonURL != "" {
+						t.canonical.release(canonURL, inputFile(ctx))
 					}
-				} else {
-					atomic.AddUint64(&ok, 1)
+					return fmt.Errorf("%w: near duplicate of %s (%.0f%% similar)", errSkipped, other, sim*100)
 				}
+				log.Printf("NEARDUP %s ~ %s (%.0f%% similar)", inputFile(ctx), other, sim*100)
+				metadata["near_duplicate_of"] = other
 			}
-		}()
+			fingerprinted = true
+		}
 	}
-
-	// enqueue work
-	for _, f := range files {
-		jobs <- f
+	if t.dedupe != nil {
+		if first, ok := t.dedupe.claim(sum, inputFile(ctx)); !ok {
+			if canonURL != "" {
+				t.canonical.release(canonURL, inputFile(ctx))
+			}
+			if fingerprinted {
+				t.nearDup.release(inputFile(ctx))
+			}
+			return fmt.Errorf("%w: %w", errSkipped, &duplicateError{first})
+		}
 	}
-	close(jobs)
-	wg.Wait()
-
-	// Save failures to file if requested
-	if *saveFailures != "" && len(failures) > 0 {
-		err := saveFilesToFile(*saveFailures, failures)
-		if err != nil {
-			log.Printf("Error saving failures file: %v", err)
-		} else {
-			log.Printf("Saved %d failed paths to %s", len(failures), *saveFailures)
+	err = t.postToCollections(ctx, page, metadata, collections, doc.attachments)
+	if err != nil && !errors.Is(err, errSkipped) {
+		if t.dedupe != nil {
+			t.dedupe.release(sum, inputFile(ctx))
+		}
+		if canonURL != "" {
+			t.canonical.release(canonURL, inputFile(ctx))
+		}
+		if fingerprinted {
+			t.nearDup.release(inputFile(ctx))
 		}
 	}
+	return err
+}
+
+/* ============================================================================
+   MAIN – Worker‑pool that saturates the API
+============================================================================ */
 
-	fmt.Printf("Done. Success: %d  Failure: %d\n", ok, fail)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(auditMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		os.Exit(loginMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "mock-server" {
+		os.Exit(mockMain(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gen-fixtures" {
+		os.Exit(fixturesMain(os.Args[2:]))
+	}
+	os.Exit(run())
 }
 
-// readFileList reads a list of files from a text file, one path per line
-func readFileList(filePath string) ([]string, error) {
-	var files []string
+// run is the upload command; it returns the exit code (see exitcodes.go).
+func run() int {
+	// flag errors are setup errors (1), not the default 2
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flag.CommandLine.Usage = usage
 
-	file, err := os.Open(filePath)
+	dir := flag.String("dir", ".", "Directory with .json files")
+	pattern := flag.String("glob", "*.json", "File name patterns to pick up in -dir, comma-separated, e.g. \"*.json,*.yaml,*.yml\"")
+	retryFile := flag.String("retry", "", "File with list of failed files to retry (multi-item files: with -manifest, only the failed items)")
+	api := flag.String("api", "https://cashmere.io/api/v2", "Omnipub API base")
+	apiVersion := flag.String("api-version", "auto", "API version to speak: auto (ask the server), v2 or v3")
+	collection := flag.String("collection", "", "Optional collection_id; a comma-separated list posts each item into every collection, e.g. 12,15")
+	workers := flag.Int("workers", 10, "Concurrent workers (≈ open TCP conns)")
+	backoff := flag.Int("backoff", 0, "Backoff interval in milliseconds between retries (0 = no backoff)")
+	maxConns := flag.Int("max-conns", 256, "Max connections per host (sets Transport)")
+	apiKeyEnv := flag.String("key-env", "OMNIPUB_API_KEY", "Env var with API key")
+	keyFile := flag.String("key-file", "", "Read the API key from this file instead of the environment")
+	keyCmd := flag.String("key-cmd", "", `Run this shell command and use its output as the API key, e.g. "pass show omnipub"`)
+	profile := flag.String("profile", os.Getenv("OMNIPUB_PROFILE"), "Credentials profile supplying the API key, -api, -collection and -auth defaults")
+	credentialsFile := flag.String("credentials-file", defaultCredentialsFile(), "Credentials file holding the -profile sections")
+	noKeychain := flag.Bool("no-keychain", os.Getenv("OMNIPUB_NO_KEYCHAIN") != "", "Read `transform login` tokens from owner-only files instead of the OS keychain")
+	authSpec := flag.String("auth", "bearer", "How to send the API key: bearer, basic (key is user:password), header:NAME or cookie:NAME")
+	signSecret := flag.String("sign-secret", "", "Sign uploads with an HMAC using the secret from this key source, e.g. env:OMNIPUB_SIGNING_SECRET")
+	signAlg := flag.String("sign-alg", "sha256", "HMAC hash for -sign-secret: sha256, sha512 or sha1")
+	signHeader := flag.String("sign-header", "X-Signature", "Header carrying the request signature")
+	signTSHeader := flag.String("sign-timestamp-header", "X-Timestamp", "Header carrying the signed timestamp")
+	signEncoding := flag.String("sign-encoding", "hex", "Signature encoding: hex or base64")
+	keySource := flag.String("key-source", "", "Where to get the API key: env:NAME, env-list:NAME, file:PATH, cmd:COMMAND, vault:PATH, aws-sm:SECRET[#field], ssm:PARAMETER or keychain:PROFILE")
+	vaultAddr := flag.String("vault-addr", os.Getenv("VAULT_ADDR"), "Vault server address")
+	vaultAuth := flag.String("vault-auth", "token", "Vault auth method: token (VAULT_TOKEN), approle (VAULT_SECRET_ID) or kubernetes")
+	vaultRole := flag.String("vault-role", "", "Vault approle role_id or kubernetes role")
+	vaultPath := flag.String("vault-path", "", "Read the API key from this Vault secret path, e.g. secret/data/omnipub")
+	vaultField := flag.String("vault-field", "api_key", "Field of the Vault secret holding the API key")
+	statsdAddr := flag.String("statsd", "", "Send DogStatsD metrics to this host:port over UDP")
+	statsdPrefix := flag.String("statsd-prefix", "omnipub.", "Prefix of -statsd metric names")
+	statsdTags := flag.String("statsd-tags", "", "Comma-separated tags added to every -statsd metric (e.g. env:prod,service:migration)")
+	logFile := flag.String("log-file", "", "Write the log to this file instead of stderr, rotating it per -log-max-size and -log-rotate")
+	logMaxSize := flag.String("log-max-size", "100MB", "Rotate -log-file before it grows past this size (0 = no limit)")
+	logRotate := flag.Duration("log-rotate", 0, "Rotate -log-file once it is this old, e.g. 24h (0 = never)")
+	logKeep := flag.Int("log-keep", 7, "Rotated -log-file files to keep (0 = all)")
+	quiet := flag.Bool("quiet", false, "Log nothing while uploading; print only the final summary")
+	verbose := flag.Bool("v", false, "Also log every HTTP request")
+	veryVerbose := flag.Bool("vv", false, "Also log request headers (credentials redacted) and response bodies")
+	output := flag.String("output", "text", "Format of the final summary on stdout: text or json")
+	textfilePath := flag.String("textfile", "", "Write the last run's metrics here for node_exporter's textfile collector (e.g. /var/lib/node_exporter/omnipub.prom)")
+	textfileLabels := flag.String("textfile-labels", "", "Labels added to every -textfile series, e.g. job=nightly,env=prod")
+	heartbeat := flag.Duration("heartbeat", time.Minute, "Log a progress line this often when the log is not a terminal (0 = never)")
+	redisURL := flag.String("redis", "", "Share the work queue with other instances through Redis at this redis:// URL")
+	redisKey := flag.String("redis-key", "omnipub", "Prefix of the -redis keys; instances with the same prefix share a queue")
+	redisPush := flag.Bool("redis-push", false, "List the files as usual, push them onto the -redis queue and exit")
+	spoolDir := flag.String("spool-dir", "", "Keep the batch's work queue on disk here instead of in memory, and resume an unfinished one on the next start")
+	transformCache := flag.String("transform-cache", "", "Save the request bodies of failed uploads here, keyed by input hash, and resend them unchanged when the same input comes up again")
+	tui := flag.Bool("tui", false, "Show a full-screen dashboard of workers, throughput and failures while uploading (stdout must be a terminal)")
+	report := flag.String("report", "", "Write a row per file (status, HTTP status, attempts, duration, item ID) to this file after each run")
+	reportFormat := flag.String("report-format", "json", "Format of -report: json or csv")
+	saveFailures := flag.String("save-failures", "", "Save paths of retryable failures to this file, permanent and input failures next to it")
+	quarantineDir := flag.String("quarantine-dir", "", "Copy input files that fail to decode into this directory with an .error.txt report")
+	failoverAPI := flag.String("failover-api", "", "Secondary API base (same credentials) for items the -api base could not be reached for")
+	failoverAfter := flag.Int("failover-after", 3, "Send all uploads to -failover-api for a while after this many items in a row failed on -api")
+	maxRetries := flag.Int("max-retries", 0, "Retry uploads that fail with a transient network error, 429 or 5xx up to this many times")
+	retryBudget := flag.String("retry-budget", "10%", "Abort the run after this many retries in total: a count, a percentage of the files, or off")
+	schedule := flag.String("schedule", "", `Stay resident and run on this cron schedule, e.g. "0 2 * * *"; later runs only pick up files modified since the previous run`)
+	scheduleState := flag.String("schedule-state", "", "File remembering the last scheduled run so restarts stay incremental")
+	var excludes stringsFlag
+	flag.Var(&excludes, "exclude", `Skip files matching these comma-separated globs, e.g. "*.draft.json,**/tmp/**" (repeatable)`)
+	minSize := flag.String("min-size", "0", "Skip files smaller than this, e.g. 1 for zero-byte stubs (K/M/G binary, KB/MB/GB decimal)")
+	maxSize := flag.String("max-size", "0", "Skip files larger than this, e.g. 50MB (0 = no limit)")
+	settle := flag.Duration("settle", 2*time.Second, "Skip files whose size or mtime changes within this long, as still being written (0 = off)")
+	modSince := flag.String("modified-since", "", "Only upload files modified at/after this RFC 3339 time or this long ago (e.g. 24h)")
+	controlAddr := flag.String("control-addr", "", "Serve status and pause/resume/worker controls over HTTP on this address, e.g. localhost:8099")
+	controlToken := flag.String("control-token", "", "Bearer token the control server requires on every request (needed for non-loopback -control-addr)")
+	var mapExprs stringsFlag
+	flag.Var(&mapExprs, "map-expr", "Map a source path onto an Article field, e.g. title=.post.headline (repeatable)")
+	var xpaths stringsFlag
+	flag.Var(&xpaths, "xpath", "Read .xml files, taking an Article field from an XPath, e.g. title=//story/headline (repeatable)")
+	xpathItem := flag.String("xpath-item", "", "XPath of the nodes that are one article each in an .xml file; -xpath paths are relative to them")
+	var wasmPlugins stringsFlag
+	flag.Var(&wasmPlugins, "transform-wasm", "WASI module applied to each article, JSON on stdin/stdout (repeatable)")
+	wasmRuntime := flag.String("wasm-runtime", "wasmtime", "WASI runtime used to run -transform-wasm modules")
+	checksums := flag.String("checksums", "", "sha256sum-style file of input checksums; files that don't match or aren't listed fail")
+	inputEncoding := flag.String("input-encoding", "auto", "Input charset: auto, utf-8, windows-1252, latin1, utf-16le or utf-16be")
+	nfc := flag.Bool("nfc", false, "Normalize article text to Unicode NFC")
+	invalidUTF8 := flag.String("invalid-utf8", "replace", "Invalid UTF-8 in input and fields: replace (U+FFFD), transliterate (as Windows-1252) or fail")
+	fixMojibake := flag.Bool("fix-mojibake", false, "Repair UTF-8 text that was mis-decoded as Windows-1252 (CafÃ© → Café)")
+	normalizeEntities := flag.Bool("normalize-entities", false, "Fix double-encoded and numeric HTML entities in title, excerpt and content")
+	asciiPunct := flag.Bool("ascii-punctuation", false, "Replace smart quotes, dashes and ellipses with ASCII equivalents")
+	strict := flag.Bool("strict", false, "Fail input files whose JSON has keys outside the Article schema (not with -map-expr)")
+	lenient := flag.Bool("lenient", false, "Accept almost-JSON input: trailing commas, NaN, unquoted keys, single quotes, comments, a BOM")
+	epub := flag.String("epub", "chapters", "EPUB books: chapters (one article each) or book (one article)")
+	archivePages := flag.String("archive-pages", "articles", "WARC pages uploaded: articles (og:type or JSON-LD Article) or all")
+	inputPlugin := flag.String("input-plugin", "", "Executable that decodes each input file into Article NDJSON")
+	readability := flag.Bool("readability", false, "Strip navigation, share buttons, related-post blocks and empty wrappers from content")
+	var removeSelectors stringsFlag
+	flag.Var(&removeSelectors, "remove-selector", "CSS selector of content elements to remove, e.g. div.share (repeatable)")
+	embedPolicy := flag.String("embed-policy", "keep", "iframe/embed handling: keep, strip, link or allowlist")
+	embedHosts := flag.String("embed-hosts", defaultEmbedHosts, "Comma-separated hosts kept by -embed-policy allowlist")
+	svgMode := flag.String("svg", "sanitize", "Inline <svg> handling: sanitize, strip or keep")
+	dataURIMode := flag.String("data-uri", "keep", "data: image handling: keep, strip or extract")
+	dataURIMaxKB := flag.Int("data-uri-max-kb", 0, "Only apply -data-uri to images larger than this many KiB")
+	linkCheck := flag.String("link-check", "off", "Check outbound links before upload and handle dead ones (404/410/unknown host): off, report, annotate, archive or strip")
+	canonicalLinks := flag.String("canonical-links", "off", "Store each article's canonical source URL as canonical_url and skip articles whose URL was already uploaded: off, normalize or resolve (follow redirects and <link rel=canonical>)")
+	linkArchive := flag.String("link-archive", "https://archive.org/wayback/available", "Wayback Machine availability API used by -link-check archive and -source-archive")
+	thumbnail := flag.String("thumbnail", "off", "Pick a representative image (og:image or the first large <img>): off, url (thumbnail_url metadata) or cover (also upload it as the item's cover, API v3)")
+	thumbMin := flag.Int("thumbnail-min-size", 200, "Ignore <img>s declaring a width or height below this many pixels for -thumbnail")
+	sourceArchive := flag.Bool("source-archive", false, "When an article's link is dead (404/410/unknown host), cite its Wayback Machine snapshot closest to the publish date instead")
+	minify := flag.Bool("minify", false, "Minify rendered HTML (collapse whitespace, drop comments)")
+	headingBase := flag.Int("heading-base", 0, "Renumber content headings to start at this level, e.g. 2 (0 = off)")
+	headingMode := flag.String("heading-mode", "shift", "Heading renumbering: shift (keep outline) or clamp (only raise levels above base)")
+	highlight := flag.String("highlight", "off", "Syntax-highlight <pre><code class=\"language-x\"> blocks: off, classes or inline")
+	authorMap := flag.String("author-map", "", `File of "variant = name" lines normalizing article authors, e.g. "J. Smith = Jane Smith"`)
+	assumeTZ := flag.String("assume-tz", "", "IANA zone for dates without zone information (e.g. Europe/Berlin); default: leave them as given")
+	tzMap := flag.String("tz-map", "", "File of `pattern = zone` lines overriding -assume-tz per path glob or host:domain")
+	titleMax := flag.Int("title-max", 0, "Cut longer titles at a word end (0: the limit the API publishes, if any)")
+	titleFrom := flag.String("title-from", "", "Give articles without a title one from their content (content)")
+	invalidDate := flag.String("invalid-date", "keep", "Unparseable published_date: keep, drop, clamp (to the run start), updated (use updated_date) or fail")
+	futureDate := flag.String("future-date", "keep", "Future published_date: keep, drop, clamp (to the run start), updated (use updated_date) or fail")
+	require := flag.String("require", "", "Comma-separated fields articles must have, each optionally =skip, =fail, =abort or =placeholder[:TEXT]")
+	requireAction := flag.String("require-action", "skip", "What -require does with a missing field that names no action")
+	taxonomy := flag.String("taxonomy", "", "File mapping source tags/categories onto the target taxonomy ([rename], [drop], [defaults] sections)")
+	nearDup := flag.String("near-dup", "off", "Find near-duplicate articles by content fingerprint (simhash), within the run and against -manifest: off, flag or skip")
+	nearDupThreshold := flag.Float64("near-dup-threshold", 0.9, "Similarity (0-1] above which -near-dup treats two articles as duplicates")
+	truncateCfg := flag.String("truncate", "", "INI file of per-collection max_chars limits; longer content is cut and links back to the source")
+	ogEnrich := flag.Bool("og-enrich", false, "Fetch each article's link and fill an empty excerpt, image and author from its Open Graph tags")
+	ogRate := flag.Float64("og-rate", 1, "Requests per second to any one host for -og-enrich")
+	ogCache := flag.String("og-cache", "", "Keep -og-enrich results in this file across runs (30 days)")
+	a11yMode := flag.String("a11y", "off", "Check content for images without alt, empty links and skipped heading levels: off, report or fix (alt=\"\", unwrap empty links)")
+	a11yReport := flag.String("a11y-report", "", "Write each run's accessibility findings to this JSON file")
+	tocMin := flag.Int("toc-min-headings", 0, "Inject a table of contents into articles with at least this many headings (0 = off)")
+	order := flag.String("order", "", "Upload order: empty for listing order, or publish-date (oldest first)")
+	startAt := flag.Int("start-at", 0, "Skip this many files of the sorted file list (0-based index of the first file to process)")
+	count := flag.Int("count", 0, "Process at most this many files from -start-at (0 = all)")
+	shuffle := flag.Bool("shuffle", false, "Upload in a random order, reproducible with -seed")
+	seed := flag.Uint64("seed", 0, "Seed for -shuffle (0 = pick one and log it)")
+	priorityFile := flag.String("priority", "", "File listing paths or globs (one per line) to upload first, in that order")
+	orderSerial := flag.Bool("order-serial", false, "Upload one file at a time per collection so -order is followed exactly within each")
+	manifestPath := flag.String("manifest", "", "Record successful uploads (path and content hash) here and skip files already uploaded unchanged")
+	reupload := flag.Bool("reupload", false, "With -manifest, upload files even if the manifest shows them unchanged")
+	exportPath := flag.String("export", "", "Instead of uploading, write the items of each -collection to this file as JSON lines")
+	verifyItems := flag.Bool("verify", false, "Instead of uploading, check that the files in -manifest are listed in -collection")
+	verifyOut := flag.String("verify-out", "", "With -verify, write the files missing from the collections here, for -retry")
+	listState := flag.String("list-state", "", "File (or s3:// or gs:// object) keeping the -export/-verify position, so an interrupted listing resumes")
+	auditPath := flag.String("audit-log", "", "Append a hash-chained JSONL record of every upload request to this file")
+	var notifyURLs stringsFlag
+	flag.Var(&notifyURLs, "notify-url", "POST a run summary here when a run completes or is aborted; Slack incoming webhooks are detected (repeatable)")
+	crossPost := flag.Bool("cross-post", false, "Post into several collections with one request (repeated collection_id fields) instead of one POST per collection")
+	batchID := flag.String("batch-id", "", "Batch ID stamped into every item's metadata (default: the run ID), e.g. to tag a migration spanning several runs")
+	conditional := flag.Bool("conditional", false, "Send a content hash as If-None-Match so the API can answer 304 for items it already has (counted as skipped)")
+	quotaCheck := flag.String("quota-check", "warn", "Before a run into -collection, compare its item count and limit with the files to upload: warn, abort or off")
+	openItems := flag.Int("open", 0, "Open the first N uploaded items in a browser, when the API returns their URLs")
+	interactive := flag.Bool("interactive", false, "Preview each article and ask approve/skip/edit/quit on the terminal before uploading it")
+	allowDuplicates := flag.Bool("allow-duplicates", false, "Upload files whose articles are identical to an earlier file's in the same run")
+	allowEmpty := flag.Bool("allow-empty", false, "Upload articles whose content is empty after cleanup instead of skipping them")
+	matchTitle := flag.String("match-title", "", `Only upload articles whose title matches this RE2 regexp, e.g. "(?i)earnings"`)
+	matchLink := flag.String("match-link", "", `Only upload articles whose link matches this RE2 regexp, e.g. "^https://example\.com/"`)
+	filterExpr := flag.String("filter", "", `CEL-style expression selecting articles to upload, e.g. 'size(article.content) > 500'`)
+	args, err := expandResponseFiles(os.Args[1:])
 	if err != nil {
-		return nil, err
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		return exitSetup
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitSetup
+	}
+	fromEnv, err := applyEnvFlags(flag.CommandLine)
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		return exitSetup
+	}
+
+	if *retryFile != "" && flag.NArg() > 0 {
+		log.Fatal("name the files either with -retry or on the command line, not both")
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		path := strings.TrimSpace(scanner.Text())
-		if path != "" {
-			files = append(files, path)
+	if *logFile != "" {
+		maxBytes, err := parseSize(*logMaxSize)
+		if err != nil {
+			log.Fatalf("log-max-size: %v", err)
+		}
+		lf, err := NewRotatingLog(*logFile, maxBytes, *logRotate, *logKeep)
+		if err != nil {
+			log.Fatalf("log-file: %v", err)
 		}
+		log.SetOutput(lf)
+	}
+	if len(fromEnv) > 0 {
+		log.Printf("Flags from the environment: %s", strings.Join(fromEnv, ", "))
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	// profile settings fill in whatever was not given on the command line
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	tokens := newTokenStore(*noKeychain)
+	var profileVals map[string]string
+	if *profile != "" {
+		var err error
+		profileVals, err = loadProfile(*credentialsFile, *profile)
+		if errors.Is(err, errNoProfile) || errors.Is(err, os.ErrNotExist) {
+			// a profile may exist only as a `transform login` token
+			if hasStoredToken(tokens, *profile) {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		warnCredentialsMode(*credentialsFile)
+		if v := profileVals["api"]; v != "" && !set["api"] {
+			*api = v
+		}
+		if v := profileVals["auth"]; v != "" && !set["auth"] {
+			*authSpec = v
+		}
+		if v := profileVals["collection"]; v != "" && !set["collection"] {
+			*collection = v
+		}
+		log.Printf("Using profile %q (api %s)", *profile, *api)
 	}
 
-	return files, nil
+	auth, err := parseAuthScheme(*authSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// -key-file, -key-cmd and -vault-path are shorthands for -key-source
+	spec := *keySource
+	for _, short := range []struct{ scheme, val string }{{"file", *keyFile}, {"cmd", *keyCmd}, {"vault", *vaultPath}} {
+		if short.val == "" {
+			continue
+		}
+		if spec != "" {
+			log.Fatal("use only one of -key-source, -key-file, -key-cmd and -vault-path")
+		}
+		spec = short.scheme + ":" + short.val
+	}
+	keyFlagSet := spec != "" || set["key-env"]
+	if spec == "" && profileVals["key_source"] != "" && !keyFlagSet {
+		spec = profileVals["key_source"]
+	}
+	if spec == "" {
+		spec = "env:" + *apiKeyEnv
+	}
+	var keys KeySource
+	if profileVals["api_key"] != "" && !keyFlagSet {
+		keys = profileKey{path: *credentialsFile, name: *profile}
+	} else if spec == "env:"+*apiKeyEnv && !keyFlagSet && os.Getenv(*apiKeyEnv) == "" && hasStoredToken(tokens, *profile) {
+		// nothing configured: use the token from `transform login`
+		keys = newStoredKey(tokens, loginAccount(*profile))
+	} else if account, ok := strings.CutPrefix(spec, "keychain:"); ok {
+		keys = newStoredKey(tokens, account)
+	} else if path, ok := strings.CutPrefix(spec, "vault:"); ok {
+		keys, err = NewVaultKey(*vaultAddr, *vaultAuth, *vaultRole, path, *vaultField)
+	} else {
+		keys, err = NewKeySource(spec)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	transformer, err := NewTransformer(*api, keys, *maxConns)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(mapExprs) > 0 {
+		if *strict {
+			log.Fatal("-strict checks the Article schema; it cannot be combined with -map-expr, which reads arbitrary JSON")
+		}
+		if transformer.mapper, err = NewMapper(mapExprs); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(xpaths) > 0 {
+		if *inputPlugin != "" {
+			log.Fatal("-xpath reads .xml files itself; it cannot be combined with -input-plugin")
+		}
+		if transformer.xmlMapper, err = NewXMLMapper(*xpathItem, xpaths); err != nil {
+			log.Fatal(err)
+		}
+	} else if *xpathItem != "" {
+		log.Fatal("-xpath-item needs -xpath rules for the fields")
+	}
+	transformer.strict = *strict
+	transformer.lenient = *lenient
+	if !epubModes[*epub] {
+		log.Fatalf("-epub must be chapters or book, got %q", *epub)
+	}
+	transformer.epub = *epub
+	if !archivePageModes[*archivePages] {
+		log.Fatalf("-archive-pages must be articles or all, got %q", *archivePages)
+	}
+	transformer.archivePages = *archivePages
+	transformer.auth = auth
+	if *signSecret != "" {
+		src, err := NewKeySource(*signSecret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		secret, err := src.Key(context.Background())
+		if err != nil {
+			log.Fatalf("sign-secret: %v", err)
+		}
+		if transformer.signer, err = NewSigner(secret, *signAlg, *signHeader, *signTSHeader, *signEncoding); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := transformer.negotiateVersion(context.Background(), *apiVersion); err != nil {
+		log.Fatal(err)
+	}
+	if *failoverAPI != "" {
+		if *failoverAfter < 1 {
+			log.Fatal("-failover-after must be at least 1")
+		}
+		transformer.failover = newFailover(withVersion(*failoverAPI, transformer.api.version), *failoverAfter)
+	}
+	if !inputEncodings[*inputEncoding] {
+		log.Fatalf("input-encoding %q: want one of auto, utf-8, windows-1252, latin1, utf-16le, utf-16be", *inputEncoding)
+	}
+	transformer.encoding = *inputEncoding
+	if *checksums != "" {
+		if transformer.checksums, err = LoadChecksums(*checksums); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !invalidUTF8Modes[*invalidUTF8] {
+		log.Fatalf("invalid-utf8 %q: want replace, transliterate or fail", *invalidUTF8)
+	}
+	transformer.invalidUTF8 = *invalidUTF8
+	transformer.nfc = *nfc
+	transformer.fixMojibake = *fixMojibake
+	transformer.normalizeEntities = *normalizeEntities
+	transformer.asciiPunct = *asciiPunct
+	if *inputPlugin != "" {
+		if transformer.inputPlugin, err = NewInputPlugin(*inputPlugin); err != nil {
+			log.Fatal(err)
+		}
+		transformer.inputPlugin.strict = *strict
+	}
+	for _, m := range wasmPlugins {
+		h, err := NewWasmHook(*wasmRuntime, m)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transformer.hooks = append(transformer.hooks, h)
+	}
+	if *readability || len(removeSelectors) > 0 {
+		bp := &Boilerplate{heuristics: *readability}
+		for _, src := range removeSelectors {
+			sel, err := ParseSelector(src)
+			if err != nil {
+				log.Fatal(err)
+			}
+			bp.remove = append(bp.remove, sel)
+		}
+		transformer.passes = append(transformer.passes, bp.Apply)
+	}
+	if *embedPolicy != "keep" {
+		p, err := NewEmbedPolicy(*embedPolicy, *embedHosts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transformer.passes = append(transformer.passes, p.Apply)
+	}
+	if pass, err := svgPass(*svgMode); err != nil {
+		log.Fatal(err)
+	} else if pass != nil {
+		transformer.passes = append(transformer.passes, pass)
+	}
+	if *dataURIMode != "keep" {
+		p, err := NewDataURIPolicy(*dataURIMode, *dataURIMaxKB)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transformer.passes = append(transformer.passes, p.Apply)
+	}
+	if *headingBase > 0 {
+		n, err := NewHeadingNormalizer(*headingBase, *headingMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transformer.passes = append(transformer.passes, n.Apply)
+	}
+	if *highlight != "off" {
+		h, err := NewHighlighter(*highlight)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transformer.passes = append(transformer.passes, h.Apply)
+	}
+	var links *LinkChecker
+	if *linkCheck != "off" {
+		if links, err = NewLinkChecker(*linkCheck, *linkArchive); err != nil {
+			log.Fatal(err)
+		}
+		transformer.passes = append(transformer.passes, links.Apply)
+	}
+	if *thumbnail != "off" {
+		if transformer.thumbs, err = NewThumbnails(*thumbnail, *thumbMin); err != nil {
+			log.Fatal(err)
+		}
+		if transformer.thumbs.cover && transformer.api.coverField == "" {
+			log.Printf("API %s has no cover images; -thumbnail cover only records thumbnail_url", transformer.api.version)
+			transformer.thumbs.cover = false
+		}
+		transformer.passes = append(transformer.passes, transformer.thumbs.Apply)
+	}
+	if *sourceArchive {
+		if links == nil {
+			links = newLinkProber(*linkArchive)
+		}
+		transformer.sourceArchive = links
+	}
+	if *canonicalLinks != "off" {
+		if transformer.canonical, err = NewCanonicalizer(*canonicalLinks); err != nil {
+			log.Fatal(err)
+		}
+	}
+	transformer.minify = *minify
+	transformer.allowEmpty = *allowEmpty
+	if !*allowDuplicates {
+		transformer.dedupe = &runDedupe{}
+	}
+	transformer.conditional = *conditional
+	transformer.crossPost = *crossPost
+	transformer.batch = *batchID
+	if *interactive {
+		if transformer.review, err = NewReviewer(); err != nil {
+			log.Fatal(err)
+		}
+		defer transformer.review.Close()
+		*workers = 1
+	}
+	if *authorMap != "" {
+		if transformer.authors, err = LoadAuthorMap(*authorMap); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *taxonomy != "" {
+		if transformer.taxonomy, err = LoadTaxonomy(*taxonomy, *dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *assumeTZ != "" || *tzMap != "" {
+		if transformer.timezones, err = NewTimezones(*assumeTZ, *tzMap, *dir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *titleFrom != "" && *titleFrom != "content" {
+		log.Fatalf("-title-from %q: want content", *titleFrom)
+	}
+	if max := cmp.Or(*titleMax, transformer.fieldLimits["title"]); max > 0 || *titleFrom != "" {
+		if *titleMax == 0 && max > 0 {
+			log.Printf("Titles limited to %d characters, as the API publishes", max)
+		}
+		transformer.titles = &TitlePolicy{max: max, generate: *titleFrom == "content"}
+	}
+	if *invalidDate != "keep" || *futureDate != "keep" {
+		if transformer.dates, err = NewDatePolicy(*invalidDate, *futureDate); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *require != "" {
+		if transformer.require, err = NewRequirements(*require, *requireAction); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *truncateCfg != "" {
+		if transformer.truncate, err = LoadTruncation(*truncateCfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *ogEnrich {
+		if transformer.og, err = NewOpenGraph(*ogRate, *ogCache); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *a11yMode != "off" {
+		if transformer.a11y, err = NewAccessibility(*a11yMode, *a11yReport); err != nil {
+			log.Fatal(err)
+		}
+		transformer.passes = append(transformer.passes, transformer.a11y.Apply)
+	}
+	if *tocMin > 0 {
+		// runs last so it sees headings as the other passes left them
+		transformer.toc = &TOC{minHeadings: *tocMin}
+		transformer.passes = append(transformer.passes, transformer.toc.Apply)
+	}
+	if *filterExpr != "" {
+		if transformer.filter, err = NewFilter(*filterExpr); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, fm := range []struct{ field, expr string }{{"title", *matchTitle}, {"link", *matchLink}} {
+		if fm.expr == "" {
+			continue
+		}
+		m, err := NewFieldMatch(fm.field, fm.expr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		transformer.matches = append(transformer.matches, m)
+	}
+
+	var since time.Time
+	if *modSince != "" {
+		if since, err = parseSince(*modSince); err != nil {
+			log.Fatal(err)
+		}
+	}
+	minBytes, err := parseSize(*minSize)
+	if err != nil {
+		log.Fatalf("min-size: %v", err)
+	}
+	maxBytes, err := parseSize(*maxSize)
+	if err != nil {
+		log.Fatalf("max-size: %v", err)
+	}
+	discovery, err := NewDiscovery(*dir, excludes, *settle, minBytes, maxBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch *order {
+	case "":
+	case "publish-date":
+		transformer.byDate = true
+	default:
+		log.Fatalf("order %q: want publish-date", *order)
+	}
+	if *shuffle {
+		if transformer.byDate {
+			log.Fatal("use -order or -shuffle, not both")
+		}
+		if *seed == 0 {
+			*seed = uint64(time.Now().UnixNano())
+		}
+		log.Printf("Shuffling upload order with -seed %d", *seed)
+	}
+	if *startAt < 0 || *count < 0 {
+		log.Fatal("-start-at and -count must not be negative")
+	}
+	switch *quotaCheck {
+	case "warn", "abort", "off":
+	default:
+		log.Fatalf("quota-check %q: want warn, abort or off", *quotaCheck)
+	}
+	var priority []string
+	if *priorityFile != "" {
+		if priority, err = readFileList(*priorityFile); err != nil {
+			log.Fatalf("priority list: %v", err)
+		}
+	}
+	var manifest *Manifest
+	if *manifestPath != "" {
+		if manifest, err = OpenManifest(*manifestPath); err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err := manifest.Close(); err != nil {
+				log.Printf("Error saving manifest: %v", err)
+			}
+		}()
+	}
+	// opened once the flags are checked: from here on, setup errors
+	// return rather than log.Fatal so the deferred closes still run
+	if *auditPath != "" {
+		if transformer.audit, err = OpenAuditLog(*auditPath, transformer.keyPool().values()); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		defer transformer.audit.Close()
+	}
+	if *nearDup != "off" {
+		if transformer.nearDup, err = NewNearDup(*nearDup, *nearDupThreshold); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		if manifest != nil {
+			transformer.nearDup.seed(manifest)
+		}
+	}
+	// filterFiles drops files that need not be sent, one file at a time,
+	// so it can run on any part of the list.
+	filterFiles := func(files []string, since time.Time) ([]string, []skippedFile) {
+		for i, f := range files {
+			files[i] = nativePath(f)
+		}
+		if !since.IsZero() {
+			files = modifiedSince(files, since)
+		}
+		files, skipped := discovery.Filter(files)
+		if manifest != nil && !*reupload {
+			var unchanged []skippedFile
+			files, unchanged = manifest.Unchanged(files)
+			skipped = append(skipped, unchanged...)
+		}
+		return files, skipped
+	}
+	// listFiles returns errors rather than exiting: the manifest is open
+	// and its deferred Close has to run.
+	listFiles := func(since time.Time) ([]string, []skippedFile, error) {
+		var files []string
+
+		// Handle retry file if specified
+		if *retryFile != "" {
+			files, err = readFileList(*retryFile)
+			if err != nil {
+				return nil, nil, fmt.Errorf("retry file: %w", err)
+			}
+		} else if flag.NArg() > 0 {
+			// files named on the command line
+			if files, err = argFiles(flag.Args()); err != nil {
+				return nil, nil, err
+			}
+		} else {
+			// Regular directory mode
+			for _, p := range strings.Split(*pattern, ",") {
+				if p = strings.TrimSpace(p); p == "" {
+					continue
+				}
+				matched, err := filepath.Glob(filepath.Join(*dir, p))
+				if err != nil {
+					return nil, nil, fmt.Errorf("-glob %q: %w", p, err)
+				}
+				files = append(files, matched...)
+			}
+			slices.Sort(files)
+			files = slices.Compact(files)
+		}
+		if *startAt > 0 || *count > 0 {
+			files = sliceFiles(files, *startAt, *count)
+		}
+		files, skipped := filterFiles(files, since)
+		if transformer.byDate && len(files) > 0 {
+			log.Printf("Reading publish dates of %d files …", len(files))
+			files = transformer.sortByPublishDate(context.Background(), files)
+		}
+		if *shuffle {
+			shuffleFiles(files, *seed)
+		}
+		if priority != nil {
+			files = prioritize(files, priority)
+		}
+		return files, skipped, nil
+	}
+
+	opts := batchOptions{
+		workers:      *workers,
+		backoff:      time.Duration(*backoff) * time.Millisecond,
+		saveFailures: *saveFailures,
+		manifest:     manifest,
+		reupload:     *reupload,
+		orderSerial:  *orderSerial,
+	}
+	if *statsdAddr != "" {
+		if transformer.statsd, err = NewStatsD(*statsdAddr, *statsdPrefix, *statsdTags); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+	}
+	switch {
+	case *quiet && (*verbose || *veryVerbose):
+		log.Print("use either -quiet or -v/-vv")
+		return exitSetup
+	case *veryVerbose:
+		transformer.verbose = 2
+	case *verbose:
+		transformer.verbose = 1
+	}
+	opts.quiet = *quiet
+	if !outputFormats[*output] {
+		log.Printf("output %q: want text or json", *output)
+		return exitSetup
+	}
+	if *tui {
+		if stdoutIsTerminal() {
+			opts.dashboard = true
+		} else {
+			log.Println("-tui needs stdout on a terminal – showing the plain log instead")
+		}
+	}
+	var textfile *Textfile
+	if *textfilePath != "" {
+		if textfile, err = NewTextfile(*textfilePath, *textfileLabels); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+	}
+	if !opts.dashboard && !logIsTerminal() {
+		opts.heartbeat = *heartbeat
+	}
+	var redisQueue *RedisQueue
+	if *redisURL != "" {
+		if *spoolDir != "" || *schedule != "" {
+			log.Print("-redis cannot be combined with -spool-dir or -schedule")
+			return exitSetup
+		}
+		if redisQueue, err = NewRedisQueue(context.Background(), *redisURL, *redisKey); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		opts.queue = redisQueue
+	} else if *redisPush {
+		log.Print("-redis-push needs -redis")
+		return exitSetup
+	}
+	var spool *Spool
+	if *spoolDir != "" {
+		if spool, err = OpenSpool(*spoolDir); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		opts.queue = spool
+	}
+	if *transformCache != "" {
+		if transformer.cache, err = NewTransformCache(*transformCache); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+	}
+	if *report != "" {
+		if opts.report, err = NewRunReport(*report, *reportFormat); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+	}
+	if *openItems > 0 {
+		opts.opener = &browserOpener{left: *openItems}
+	}
+	transformer.maxRetries = *maxRetries
+	if *quarantineDir != "" {
+		if opts.quarantine, err = NewQuarantine(*quarantineDir); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+	}
+	if opts.retryBudget, err = parseRetryBudget(*retryBudget); err != nil {
+		log.Print(err)
+		return exitSetup
+	}
+	if opts.collections, err = parseCollections(*collection); err != nil {
+		log.Print(err)
+		return exitSetup
+	}
+	// The first interrupt lets in-flight uploads finish; a second one
+	// kills the process as usual.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Println("Interrupted – finishing in-flight uploads, press Ctrl-C again to quit now")
+	}()
+
+	if r, ok := keys.(renewingKeySource); ok {
+		go r.Renew(ctx, transformer.setKey)
+	}
+
+	if *exportPath != "" || *verifyItems {
+		switch {
+		case *exportPath != "" && *verifyItems:
+			log.Print("use either -export or -verify")
+			return exitSetup
+		case len(opts.collections) == 0:
+			log.Print("-export and -verify list the items of -collection; set it")
+			return exitSetup
+		case *verifyItems && manifest == nil:
+			log.Print("-verify checks the files in -manifest; set it")
+			return exitSetup
+		case *exportPath != "":
+			return transformer.exportItems(ctx, opts.collections, *exportPath, *listState)
+		}
+		return transformer.verifyItems(ctx, opts.collections, manifest, *verifyOut, *listState)
+	}
+
+	var notifier *Notifier
+	if len(notifyURLs) > 0 {
+		if notifier, err = NewNotifier(notifyURLs); err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+	}
+	// finish reports a batch, which may have been aborted by an interrupt,
+	// SIGTERM or the retry budget.
+	// with -output json stdout carries only the summary objects
+	out := io.Writer(os.Stdout)
+	if *output == "json" {
+		out = os.Stderr
+	}
+	// summarize prints a batch's counts, as text or JSON.
+	summarize := func(p *Progress) {
+		if *output == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(newRunSummary(p)); err != nil {
+				log.Print(err)
+			}
+			return
+		}
+		if p.aborted != nil {
+			fmt.Printf("Aborted (%v). Success: %d  Failure: %d  Skipped: %d  Not started: %d\n", p.aborted, p.ok.Load(), p.fail.Load(), p.skipped.Load(), p.Snapshot().Remaining)
+		} else {
+			fmt.Printf("Done. Success: %d  Failure: %d  Skipped: %d\n", p.ok.Load(), p.fail.Load(), p.skipped.Load())
+		}
+		p.reportFailures(os.Stdout)
+		reportSkipped(os.Stdout, p.skippedUpFront)
+		p.reportEmpty(os.Stdout)
+		p.reportDuplicates(os.Stdout)
+	}
+	// nothingToDo reports a batch that found no files.
+	nothingToDo := func(skipped []skippedFile) {
+		if *output == "json" {
+			p := NewProgress(0)
+			p.skippedUpFront = skipped
+			summarize(p)
+			return
+		}
+		reportSkipped(os.Stdout, skipped)
+	}
+	finish := func(p *Progress) {
+		summarize(p)
+		p.rates.report(out)
+		p.conns.report(out)
+		if links != nil {
+			links.report(out)
+		}
+		if transformer.a11y != nil {
+			transformer.a11y.finish(out, p.runID)
+		}
+		if transformer.og != nil {
+			transformer.og.report(out)
+		}
+		if transformer.taxonomy != nil {
+			transformer.taxonomy.report(out)
+		}
+		if textfile != nil {
+			if err := textfile.Write(p); err != nil {
+				log.Printf("Error writing -textfile: %v", err)
+			}
+		}
+		if notifier != nil {
+			nctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := notifier.Send(nctx, newRunSummary(p)); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+
+	// fits reports whether a batch of n files may start
+	fits := func(n int) bool {
+		ok := true
+		if *quotaCheck == "off" {
+			return ok
+		}
+		for _, id := range opts.collections {
+			ok = transformer.checkCapacity(ctx, id, n, *quotaCheck) && ok
+		}
+		return ok
+	}
+
+	ctl := &runControl{}
+	notifyStatusSignal(ctl)
+	if *controlAddr != "" {
+		if err := startControlServer(*controlAddr, *controlToken, ctl); err != nil {
+			log.Printf("control server: %v", err)
+			return exitSetup
+		}
+	}
+
+	// queueFiles lists a batch. With -spool-dir, a plain directory listing
+	// goes straight into the spool as it is read and files is nil; n
+	// counts the files either way.
+	queueFiles := func(since time.Time) (files []string, n int, skipped []skippedFile, err error) {
+		var patterns []string
+		for _, p := range strings.Split(*pattern, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				patterns = append(patterns, p)
+			}
+		}
+		stream := spool != nil && *retryFile == "" && flag.NArg() == 0 &&
+			*startAt == 0 && *count == 0 && !transformer.byDate && !*shuffle && priority == nil &&
+			!slices.ContainsFunc(patterns, func(p string) bool { return strings.ContainsAny(p, `/\`) })
+		if !stream {
+			files, skipped, err = listFiles(since)
+			return files, len(files), skipped, err
+		}
+		n, skipped, err = spool.WriteDir(*dir, patterns, func(files []string) ([]string, []skippedFile) {
+			return filterFiles(files, since)
+		})
+		if err == nil && n == 0 {
+			spool.Clear()
+		}
+		return nil, n, skipped, err
+	}
+
+	// resumeSpool runs the unfinished batch left in -spool-dir, if any
+	resumeSpool := func() (*Progress, error) {
+		if spool == nil {
+			return nil, nil
+		}
+		n, err := spool.Pending()
+		if err != nil {
+			return nil, fmt.Errorf("spool: %w", err)
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		log.Printf("Resuming %d queued files from %s", n, *spoolDir)
+		p := transformer.runBatch(ctx, nil, opts, ctl)
+		finish(p)
+		return p, nil
+	}
+
+	if *redisPush {
+		files, skipped, err := listFiles(since)
+		if err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		reportSkipped(out, skipped)
+		if err := redisQueue.Push(files); err != nil {
+			log.Printf("redis: %v", err)
+			return exitSetup
+		}
+		log.Printf("Queued %d files in %s:pending", len(files), *redisKey)
+		return exitOK
+	}
+	if redisQueue != nil {
+		n, err := redisQueue.Start()
+		if err != nil {
+			log.Printf("redis: %v", err)
+			return exitSetup
+		}
+		log.Printf("Taking files from %s:pending (%d queued) as %s", *redisKey, n, redisQueue.id)
+		p := transformer.runBatch(ctx, nil, opts, ctl)
+		finish(p)
+		return exitCode(p)
+	}
+
+	if *schedule != "" {
+		sched, err := ParseCron(*schedule)
+		if err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		if *scheduleState != "" {
+			if last, err := loadScheduleState(*scheduleState); err == nil {
+				since = last
+			} else if !errors.Is(err, os.ErrNotExist) {
+				log.Printf("schedule state: %v", err)
+				return exitSetup
+			}
+		}
+		p, err := resumeSpool()
+		if err != nil {
+			log.Print(err)
+			return exitSetup
+		}
+		if p != nil && ctx.Err() != nil {
+			return exitCode(p)
+		}
+		for {
+			next := sched.Next(time.Now())
+			if next.IsZero() {
+				log.Printf("-schedule %q: no run within 28 years; stopping", *schedule)
+				return exitSetup
+			}
+			log.Printf("Next run at %s", next.Format(time.RFC3339))
+			select {
+			case <-time.After(time.Until(next)):
+			case <-ctx.Done():
+				return exitOK
+			}
+
+			started := time.Now()
+			files, n, skipped, err := queueFiles(since)
+			if err != nil {
+				log.Print(err)
+				return exitSetup
+			}
+			opts.skippedUpFront = skipped
+			if n == 0 {
+				log.Println("No new or modified files – nothing to upload.")
+				nothingToDo(skipped)
+			} else if !fits(n) {
+				// since stays put: the files are still due once there is room
+				log.Println("Not starting this run: the collection is too full (-quota-check abort)")
+				if spool != nil {
+					spool.Clear()
+				}
+				continue
+			} else {
+				if !*quiet {
+					log.Printf("Uploading %d files with %d workers …", n, *workers)
+				}
+				p := transformer.runBatch(ctx, files, opts, ctl)
+				finish(p)
+				if ctx.Err() != nil {
+					return exitCode(p)
+				}
+				if p.aborted != nil {
+					// leave since alone so the next run picks the rest up
+					continue
+				}
+			}
+			since = started
+			if *scheduleState != "" {
+				if err := saveScheduleState(*scheduleState, started); err != nil {
+					log.Printf("Error saving schedule state: %v", err)
+				}
+			}
+		}
+	}
+
+	if p, err := resumeSpool(); err != nil {
+		log.Print(err)
+		return exitSetup
+	} else if p != nil {
+		return exitCode(p)
+	}
+	files, n, skipped, err := queueFiles(since)
+	if err != nil {
+		log.Print(err)
+		return exitSetup
+	}
+	opts.skippedUpFront = skipped
+	if n == 0 {
+		log.Println("No files to process – nothing to upload.")
+		nothingToDo(skipped)
+		return exitOK
+	}
+	if !fits(n) {
+		// returned rather than log.Fatal so the deferred closes still run
+		log.Println("Not starting: the collection is too full (-quota-check abort)")
+		if spool != nil {
+			spool.Clear()
+		}
+		return exitAborted
+	}
+	if !*quiet {
+		log.Printf("Uploading %d files with %d workers …", n, *workers)
+	}
+
+	p := transformer.runBatch(ctx, files, opts, ctl)
+	finish(p)
+	return exitCode(p)
+}
+
+// stringsFlag collects the values of a repeatable string flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ", ") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// readFileList reads a list of files from a text file, one path per line
+func readFileList(filePath string) ([]string, error) {
+	return readLines(filePath)
 }
 
 // saveFilesToFile saves a list of file paths to a text file
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   Transform cache
+   (-transform-cache DIR)
+
+   When a file's upload fails, the
+   request bodies that failed –
+   rendered HTML, metadata and
+   attachments, multipart-encoded –
+   are saved under the sha256 of
+   the input file. A later run that
+   meets the same input bytes sends
+   the saved bodies again instead
+   of decoding and transforming it:
+   the retry is byte-identical to
+   the original attempt. Posts that
+   went through (to some of a
+   file's collections) are not
+   saved, so they are not sent
+   twice.
+
+   Entries shrink as their posts
+   upload and are removed once all
+   have. Clear the directory after
+   changing transform flags.
+--------------------------------*/
+
+// cachedPost is one request body as sent.
+type cachedPost struct {
+	ContentType string `json:"content_type"`
+	Title       string `json:"title"`
+	ETag        string `json:"etag,omitempty"`
+	Collections []int  `json:"collections,omitempty"`
+	Body        []byte `json:"body"`
+}
+
+type cacheEntry struct {
+	File    string       `json:"file"` // the input it was made from
+	Created time.Time    `json:"created"`
+	Posts   []cachedPost `json:"posts"`
+}
+
+type TransformCache struct {
+	dir string
+}
+
+func NewTransformCache(dir string) (*TransformCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("transform-cache: %w", err)
+	}
+	return &TransformCache{dir: dir}, nil
+}
+
+func (c *TransformCache) path(sum [32]byte) string {
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// Load returns the saved posts for input bytes with sum, if any.
+func (c *TransformCache) Load(sum [32]byte) (*cacheEntry, bool) {
+	b, err := os.ReadFile(c.path(sum))
+	if err != nil {
+		return nil, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || len(e.Posts) == 0 {
+		return nil, false
+	}
+	return &e, true
+}
+
+// Store saves the posts of a failed upload, replacing an older entry.
+func (c *TransformCache) Store(sum [32]byte, file string, posts []cachedPost) error {
+	b, err := json.Marshal(cacheEntry{File: file, Created: time.Now().UTC(), Posts: posts})
+	if err != nil {
+		return err
+	}
+	dst := c.path(sum)
+	tmp := dst + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Remove drops the entry for sum after its upload succeeded.
+func (c *TransformCache) Remove(sum [32]byte) {
+	if err := os.Remove(c.path(sum)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Error removing cached requests: %v", err)
+	}
+}
+
+// postRecorder collects a file's failed posts while it is processed.
+type postRecorder struct {
+	mu    sync.Mutex
+	posts []cachedPost
+}
+
+func (r *postRecorder) add(p cachedPost) {
+	if r == nil {
+		return
+	}
+	p.Body = bytes.Clone(p.Body) // the original goes back to the buffer pool
+	r.mu.Lock()
+	r.posts = append(r.posts, p)
+	r.mu.Unlock()
+}
+
+type postRecorderKey struct{}
+
+func withPostRecorder(ctx context.Context, r *postRecorder) context.Context {
+	return context.WithValue(ctx, postRecorderKey{}, r)
+}
+
+func postRecorderFrom(ctx context.Context) *postRecorder {
+	r, _ := ctx.Value(postRecorderKey{}).(*postRecorder)
+	return r
+}
+
+// processCached uploads file through the cache: saved posts are sent
+// again as they are; otherwise the file is transformed as usual and its
+// posts are saved if it fails.
+func (t *Transformer) processCached(ctx context.Context, file string, collections []int) error {
+	in, err := t.readInput(ctx, file)
+	if err != nil {
+		return err
+	}
+	sum := in.Sum()
+	if e, ok := t.cache.Load(sum); ok {
+		log.Printf("CACHED %s: sending %d saved request(s) from %s", file, len(e.Posts), e.Created.Local().Format(time.DateTime))
+		ctx = withInputFile(ctx, file)
+		var firstErr error
+		var failed []cachedPost
+		sent := false
+		for _, p := range e.Posts {
+			err := t.sendPost(ctx, p)
+			switch {
+			case err == nil:
+				sent = true
+			case errors.Is(err, errNotModified):
+			default:
+				failed = append(failed, p)
+				firstErr = cmp.Or(firstErr, err)
+			}
+		}
+		if firstErr != nil {
+			if len(failed) < len(e.Posts) {
+				if serr := t.cache.Store(sum, e.File, failed); serr != nil {
+					log.Printf("Error caching %s: %v", file, serr)
+				}
+			}
+			return firstErr
+		}
+		t.cache.Remove(sum)
+		if !sent {
+			return errNotModified
+		}
+		return nil
+	}
+
+	rec := &postRecorder{}
+	err = t.processFile(withPostRecorder(ctx, rec), file, collections)
+	var ie *inputError
+	if err != nil && !errors.Is(err, errSkipped) && !errors.As(err, &ie) && len(rec.posts) > 0 {
+		if serr := t.cache.Store(sum, file, rec.posts); serr != nil {
+			log.Printf("Error caching %s: %v", file, serr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/* -------------------------------
+   Truncation (-truncate)
+
+   Collections with display limits
+   get content cut to max_chars of
+   text, plus a link back to the
+   source. Per collection, INI
+   style:
+
+   [*]                  # any other
+   max_chars = 4000
+   [12]
+   max_chars = 1200
+   more = <p><a href="{link}">Continue reading at {host}</a></p>
+
+   {link}, {host} and {title} are
+   filled in (escaped). The cut
+   falls after the last block (</p>,
+   </li>, …) that fits, as long as
+   that keeps half the allowance;
+   otherwise at the last sentence or
+   word end, with "…". Open elements
+   are closed.
+
+   Cross-posting to several
+   collections uses the smallest
+   limit among them.
+--------------------------------*/
+
+const defaultMore = `<p class="read-more"><a href="{link}">Continue reading at the source</a></p>`
+
+type truncRule struct {
+	maxChars int
+	more     string
+}
+
+type Truncation struct {
+	rules map[int]truncRule
+	def   *truncRule // [*]
+}
+
+func LoadTruncation(path string) (*Truncation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("truncate: %w", err)
+	}
+	defer f.Close()
+
+	tr := &Truncation{rules: make(map[int]truncRule)}
+	var cur *truncRule
+	var section string
+	finish := func() error {
+		if cur == nil {
+			return nil
+		}
+		if cur.maxChars <= 0 {
+			return fmt.Errorf("%s: [%s]: max_chars must be set and positive", path, section)
+		}
+		if section == "*" {
+			tr.def = cur
+		} else {
+			id, _ := strconv.Atoi(section)
+			tr.rules[id] = *cur
+		}
+		return nil
+	}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if id, err := strconv.Atoi(section); section != "*" && (err != nil || id <= 0) {
+				return nil, fmt.Errorf("%s:%d: want [collection ID] or [*]", path, n)
+			}
+			cur = &truncRule{more: defaultMore}
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok || cur == nil {
+			return nil, fmt.Errorf("%s:%d: want key = value inside a [collection] section", path, n)
+		}
+		switch k, v = strings.TrimSpace(k), strings.TrimSpace(v); k {
+		case "max_chars":
+			if cur.maxChars, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("%s:%d: max_chars: %w", path, n, err)
+			}
+		case "more":
+			cur.more = v
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown setting %q", path, n, k)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// rule returns the strictest rule among ids (nil: no limit).
+func (tr *Truncation) rule(ids []int) *truncRule {
+	var best *truncRule
+	pick := func(r *truncRule) {
+		if r != nil && (best == nil || r.maxChars < best.maxChars) {
+			best = r
+		}
+	}
+	if len(ids) == 0 {
+		pick(tr.def)
+	}
+	for _, id := range ids {
+		if r, ok := tr.rules[id]; ok {
+			pick(&r)
+		} else {
+			pick(tr.def)
+		}
+	}
+	return best
+}
+
+// blockEnds close the elements truncation prefers to cut after.
+var blockEnds = map[string]bool{
+	"p": true, "li": true, "ul": true, "ol": true, "blockquote": true, "pre": true, "table": true,
+	"figure": true, "div": true, "section": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "dl": true,
+}
+
+// Apply cuts content for a collection's rule; cut is false when the
+// content already fits.
+func (r *truncRule) Apply(content string, a *Article) (out string, cut bool) {
+	toks := tokenizeHTML(content)
+	var open []string // stack of open elements
+	chars := 0
+	lastBlock, lastBlockOpen := -1, []string(nil)
+	for i, t := range toks {
+		switch t.kind {
+		case htmlStartTag:
+			if !voidElems[t.data] {
+				open = append(open, t.data)
+			}
+		case htmlEndTag:
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == t.data {
+					open = open[:j]
+					break
+				}
+			}
+			if blockEnds[t.data] && chars >= r.maxChars/2 {
+				lastBlock, lastBlockOpen = i, append([]string(nil), open...)
+			}
+		case htmlText:
+			text := html.UnescapeString(t.data)
+			n := utf8.RuneCountInString(text)
+			if chars+n <= r.maxChars {
+				chars += n
+				continue
+			}
+			var kept []htmlToken
+			if lastBlock >= 0 {
+				kept, open = toks[:lastBlock+1], lastBlockOpen
+			} else {
+				head := cutText(text, r.maxChars-chars)
+				kept = append(append([]htmlToken(nil), toks[:i]...), htmlToken{kind: htmlText, data: escapeText(head) + "…"})
+			}
+			for j := len(open) - 1; j >= 0; j-- {
+				kept = append(kept, htmlToken{kind: htmlEndTag, data: open[j]})
+			}
+			return renderHTML(kept) + "\n" + r.moreLink(a), true
+		}
+	}
+	return content, false
+}
+
+// cutText returns at most n runes of s, ending at a sentence end if one
+// falls in the second half, else at a word end.
+func cutText(s string, n int) string {
+	runes := []rune(s)
+	if n >= len(runes) {
+		return s
+	}
+	head := string(runes[:n])
+	if i := strings.LastIndexAny(head, ".!?"); i >= len(head)/2 {
+		return head[:i+1]
+	}
+	if i := strings.LastIndexAny(head, " \t\n"); i > 0 {
+		return strings.TrimRight(head[:i], " \t\n,;:")
+	}
+	return head
+}
+
+// moreLink fills the rule's template; articles without a link get none.
+func (r *truncRule) moreLink(a *Article) string {
+	link := strings.TrimSpace(a.Link)
+	if link == "" {
+		return ""
+	}
+	host := link
+	if u, err := url.Parse(link); err == nil && u.Host != "" {
+		host = strings.TrimPrefix(u.Hostname(), "www.")
+	}
+	return strings.NewReplacer(
+		"{link}", html.EscapeString(link),
+		"{host}", html.EscapeString(host),
+		"{title}", html.EscapeString(a.Title),
+	).Replace(r.more)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/* -------------------------------
+   Terminal dashboard (-tui)
+
+   Redraws the terminal once a
+   second while a batch runs:
+
+   - progress bar and counts
+   - throughput graph of the last
+     minutes, ETA and retries
+   - one row per worker: the file
+     it is uploading, or idle
+   - the latest failures and the
+     tail of the log
+
+   Log lines are kept for the log
+   panel and still written to
+   -log-file or a redirected
+   stderr.
+   Needs stdout on a terminal.
+--------------------------------*/
+
+const (
+	dashboardInterval = time.Second
+	dashboardSamples  = 240 // throughput history, one per redraw
+	dashboardLogLines = 200
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// stdoutIsTerminal reports whether the dashboard has a screen to draw on.
+func stdoutIsTerminal() bool {
+	st, err := os.Stdout.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// logTail keeps the last log lines for the dashboard, passing them on to
+// out when that is not the terminal being drawn on.
+type logTail struct {
+	out io.Writer // nil drops lines once kept
+
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *logTail) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		l.lines = append(l.lines, line)
+	}
+	if n := len(l.lines) - dashboardLogLines; n > 0 {
+		l.lines = append(l.lines[:0], l.lines[n:]...)
+	}
+	l.mu.Unlock()
+	if l.out != nil {
+		return l.out.Write(b)
+	}
+	return len(b), nil
+}
+
+func (l *logTail) last(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines[max(0, len(l.lines)-n):]...)
+}
+
+type dashboard struct {
+	ctl  *runControl
+	out  io.Writer
+	logs *logTail
+
+	rates    []float64 // files/s per redraw, oldest first
+	lastDone int
+	lastAt   time.Time
+}
+
+// startDashboard takes over the terminal until stop is called, which
+// restores the screen and the log output.
+func startDashboard(ctl *runControl) (stop func()) {
+	prevLog := log.Writer()
+	logs := &logTail{out: prevLog}
+	if st, err := os.Stderr.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 && prevLog == os.Stderr {
+		logs.out = nil
+	}
+	log.SetOutput(logs)
+
+	d := &dashboard{ctl: ctl, out: os.Stdout, logs: logs, lastAt: time.Now()}
+	fmt.Fprint(d.out, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		tick := time.NewTicker(dashboardInterval)
+		defer tick.Stop()
+		for {
+			d.draw()
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+		fmt.Fprint(d.out, "\x1b[?25h\x1b[?1049l")
+		log.SetOutput(prevLog)
+	}
+}
+
+func (d *dashboard) draw() {
+	width, height := termSize(os.Stdout)
+	st := d.ctl.status()
+	d.sample(st.Done)
+
+	var lines []string
+	add := func(format string, args ...any) { lines = append(lines, fmt.Sprintf(format, args...)) }
+
+	state := "running"
+	if st.Paused {
+		state = "PAUSED"
+	}
+	add("Omnipub upload %s – %s, %s", st.RunID, state, st.Elapsed.Round(time.Second))
+	pct := 0.0
+	if st.Total > 0 {
+		pct = 100 * float64(st.Done) / float64(st.Total)
+	}
+	bar := max(10, width-40)
+	filled := min(bar, int(float64(bar)*pct/100))
+	add("%s%s %d/%d %5.1f%%", strings.Repeat("█", filled), strings.Repeat("░", bar-filled), st.Done, st.Total, pct)
+	add("success %d  failure %d  skipped %d  retries %s", st.Success, st.Failure, st.Skipped, d.retries())
+	add("throughput %.1f/s overall, %.1f/s recent  ETA %s", st.RatePerSec, st.RecentRate, st.eta())
+	if s := st.RateLimit; s != nil && s.Throttled > 0 {
+		add("throttled %d times (%.0f s)", s.Throttled, s.ThrottledSec)
+	}
+	graph, peak := d.graph(width - 12)
+	add("%s peak %.1f/s", graph, peak)
+	if len(st.ByKind) > 0 {
+		add("failures: %s", kindBreakdown(st.ByKind))
+	}
+
+	add("")
+	add("Workers (%d/%d busy)", len(st.InFlight), st.Workers)
+	rows := max(st.Workers, len(st.InFlight))
+	for i := range rows {
+		if i < len(st.InFlight) {
+			it := st.InFlight[i]
+			add("  %2d %8s  %s", i+1, it.Duration.Round(100*time.Millisecond), it.File)
+		} else {
+			add("  %2d %8s", i+1, "idle")
+		}
+	}
+
+	add("")
+	add("Recent failures (%d)", len(st.RecentError))
+	for _, e := range st.RecentError[max(0, len(st.RecentError)-5):] {
+		add("  %s  %s → %s", e.At.Format(time.TimeOnly), e.File, e.Err)
+	}
+
+	add("")
+	add("Log")
+	for _, l := range d.logs.last(max(0, height-len(lines)-1)) {
+		add("  %s", l)
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, l := range lines[:min(len(lines), height)] {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(fitLine(l, width) + "\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	io.WriteString(d.out, b.String())
+}
+
+// sample records the throughput since the previous redraw.
+func (d *dashboard) sample(done int) {
+	now := time.Now()
+	if secs := now.Sub(d.lastAt).Seconds(); secs > 0 {
+		d.rates = append(d.rates, float64(done-d.lastDone)/secs)
+	}
+	if n := len(d.rates) - dashboardSamples; n > 0 {
+		d.rates = d.rates[n:]
+	}
+	d.lastDone, d.lastAt = done, now
+}
+
+// graph draws the latest throughput samples that fit in width.
+func (d *dashboard) graph(width int) (string, float64) {
+	samples := d.rates[max(0, len(d.rates)-max(1, width)):]
+	peak := 0.0
+	for _, r := range samples {
+		peak = max(peak, r)
+	}
+	var b strings.Builder
+	for _, r := range samples {
+		i := 0
+		if peak > 0 {
+			i = min(len(sparkBlocks)-1, int(r/peak*float64(len(sparkBlocks)-1)+0.5))
+		}
+		b.WriteRune(sparkBlocks[i])
+	}
+	return b.String(), peak
+}
+
+func (d *dashboard) retries() string {
+	d.ctl.mu.Lock()
+	p := d.ctl.progress
+	d.ctl.mu.Unlock()
+	if p == nil {
+		return "0"
+	}
+	if p.budget == nil || p.budget.limit == 0 {
+		return fmt.Sprint(p.budget.Used())
+	}
+	return fmt.Sprintf("%d/%d", p.budget.Used(), p.budget.limit)
+}
+
+// fitLine cuts s to width runes.
+func fitLine(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	r := []rune(s)
+	return string(r[:max(0, width-1)]) + "…"
+}
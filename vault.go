@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* -------------------------------
+   HashiCorp Vault key source
+   (-vault-path)
+
+   Logs in (token, approle or
+   kubernetes auth), reads the key
+   from a KV v1/v2 or dynamic secret
+   path, and keeps both the login
+   token and the secret lease alive
+   for the length of the run. When a
+   lease cannot be renewed any more,
+   the secret is read again and the
+   new key swapped in.
+--------------------------------*/
+
+const k8sTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+type VaultKey struct {
+	addr      string
+	namespace string
+	auth      string // token, approle or kubernetes
+	role      string // approle role_id, or kubernetes role name
+	path      string // secret path, without /v1/
+	field     string
+	client    *http.Client
+
+	mu             sync.Mutex
+	token          string
+	tokenTTL       time.Duration
+	tokenRenewable bool
+	leaseID        string
+	leaseTTL       time.Duration
+	leaseRenewable bool
+}
+
+func NewVaultKey(addr, auth, role, path, field string) (*VaultKey, error) {
+	if addr == "" {
+		return nil, errors.New("vault: no address (set -vault-addr or VAULT_ADDR)")
+	}
+	switch auth {
+	case "token":
+	case "approle", "kubernetes":
+		if role == "" {
+			return nil, fmt.Errorf("vault: -vault-auth %s needs -vault-role", auth)
+		}
+	default:
+		return nil, fmt.Errorf("vault-auth %q: want token, approle or kubernetes", auth)
+	}
+	return &VaultKey{
+		addr:      strings.TrimSuffix(addr, "/"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		auth:      auth,
+		role:      role,
+		path:      strings.Trim(path, "/"),
+		field:     field,
+		client:    &http.Client{Timeout: 15 * time.Second},
+	}, nil
+}
+
+func (v *VaultKey) String() string { return "vault " + v.path }
+
+// Key logs in if needed and reads the secret.
+func (v *VaultKey) Key(ctx context.Context) (string, error) {
+	v.mu.Lock()
+	haveToken := v.token != ""
+	v.mu.Unlock()
+	if !haveToken {
+		if err := v.login(ctx); err != nil {
+			return "", err
+		}
+	}
+	return v.read(ctx)
+}
+
+type vaultResponse struct {
+	LeaseID       string          `json:"lease_id"`
+	LeaseDuration int             `json:"lease_duration"`
+	Renewable     bool            `json:"renewable"`
+	Data          json.RawMessage `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (v *VaultKey) do(ctx context.Context, method, path string, body any) (*vaultResponse, error) {
+	var rd io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.addr+"/v1/"+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	v.mu.Lock()
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	v.mu.Unlock()
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+	var vr vaultResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&vr); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("vault %s %s: http %d: %w", method, path, resp.StatusCode, err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("vault %s %s: http %d %s", method, path, resp.StatusCode, strings.Join(vr.Errors, "; "))
+	}
+	return &vr, nil
+}
+
+func (v *VaultKey) login(ctx context.Context) error {
+	var path string
+	var body map[string]string
+	switch v.auth {
+	case "token":
+		token := os.Getenv("VAULT_TOKEN")
+		if token == "" {
+			home, _ := os.UserHomeDir()
+			raw, err := os.ReadFile(filepath.Join(home, ".vault-token"))
+			if err != nil {
+				return errors.New("vault: no token (set VAULT_TOKEN or run vault login)")
+			}
+			token = strings.TrimSpace(string(raw))
+		}
+		v.mu.Lock()
+		v.token = token
+		v.mu.Unlock()
+		// learn the TTL so the token can be renewed
+		vr, err := v.do(ctx, http.MethodGet, "auth/token/lookup-self", nil)
+		if err != nil {
+			return err
+		}
+		var self struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		}
+		_ = json.Unmarshal(vr.Data, &self)
+		v.mu.Lock()
+		v.tokenTTL, v.tokenRenewable = time.Duration(self.TTL)*time.Second, self.Renewable
+		v.mu.Unlock()
+		return nil
+	case "approle":
+		path = "auth/approle/login"
+		body = map[string]string{"role_id": v.role, "secret_id": os.Getenv("VAULT_SECRET_ID")}
+	case "kubernetes":
+		jwt, err := os.ReadFile(k8sTokenPath)
+		if err != nil {
+			return fmt.Errorf("vault: kubernetes auth: %w", err)
+		}
+		path = "auth/kubernetes/login"
+		body = map[string]string{"role": v.role, "jwt": strings.TrimSpace(string(jwt))}
+	}
+	vr, err := v.do(ctx, http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	if vr.Auth == nil || vr.Auth.ClientToken == "" {
+		return fmt.Errorf("vault: %s login returned no token", v.auth)
+	}
+	v.mu.Lock()
+	v.token = vr.Auth.ClientToken
+	v.tokenTTL = time.Duration(vr.Auth.LeaseDuration) * time.Second
+	v.tokenRenewable = vr.Auth.Renewable
+	v.mu.Unlock()
+	return nil
+}
+
+func (v *VaultKey) read(ctx context.Context) (string, error) {
+	vr, err := v.do(ctx, http.MethodGet, v.path, nil)
+	if err != nil {
+		return "", err
+	}
+	var data map[string]any
+	if err := json.Unmarshal(vr.Data, &data); err != nil {
+		return "", fmt.Errorf("vault %s: %w", v.path, err)
+	}
+	// KV v2 nests the secret one level deeper
+	if inner, ok := data["data"].(map[string]any); ok && data["metadata"] != nil {
+		data = inner
+	}
+	key, _ := data[v.field].(string)
+	if key == "" {
+		return "", fmt.Errorf("vault %s: no string field %q", v.path, v.field)
+	}
+	v.mu.Lock()
+	v.leaseID = vr.LeaseID
+	v.leaseTTL = time.Duration(vr.LeaseDuration) * time.Second
+	v.leaseRenewable = vr.Renewable
+	v.mu.Unlock()
+	return strings.TrimSpace(key), nil
+}
+
+// Renew keeps the token and lease alive until ctx is done, calling onKey
+// whenever the secret had to be read again.
+func (v *VaultKey) Renew(ctx context.Context, onKey func(string)) {
+	for {
+		v.mu.Lock()
+		ttl := v.leaseTTL
+		if v.tokenTTL > 0 && (ttl == 0 || v.tokenTTL < ttl) {
+			ttl = v.tokenTTL
+		}
+		v.mu.Unlock()
+		if ttl == 0 {
+			return // nothing expires
+		}
+		wait := max(ttl*2/3, 5*time.Second)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+		if err := v.renewOnce(ctx, onKey); err != nil {
+			log.Printf("vault: %v", err)
+		}
+	}
+}
+
+func (v *VaultKey) renewOnce(ctx context.Context, onKey func(string)) error {
+	v.mu.Lock()
+	tokenRenewable, leaseID, leaseRenewable, leaseTTL := v.tokenRenewable, v.leaseID, v.leaseRenewable, v.leaseTTL
+	v.mu.Unlock()
+
+	if tokenRenewable {
+		vr, err := v.do(ctx, http.MethodPost, "auth/token/renew-self", nil)
+		if err == nil && vr.Auth != nil {
+			v.mu.Lock()
+			v.tokenTTL = time.Duration(vr.Auth.LeaseDuration) * time.Second
+			v.mu.Unlock()
+		} else if v.auth == "token" {
+			return fmt.Errorf("renewing token: %v", err)
+		} else if err := v.login(ctx); err != nil {
+			return err
+		}
+	} else if v.auth != "token" {
+		if err := v.login(ctx); err != nil {
+			return err
+		}
+	}
+
+	if leaseID != "" && leaseRenewable {
+		vr, err := v.do(ctx, http.MethodPut, "sys/leases/renew",
+			map[string]any{"lease_id": leaseID, "increment": int(leaseTTL.Seconds())})
+		// a lease that stops growing has hit its max TTL; fetch a fresh secret
+		if err == nil && time.Duration(vr.LeaseDuration)*time.Second >= leaseTTL/2 {
+			v.mu.Lock()
+			v.leaseTTL = time.Duration(vr.LeaseDuration) * time.Second
+			v.mu.Unlock()
+			return nil
+		}
+	} else if leaseTTL == 0 {
+		return nil // static secret, only the token needed renewing
+	}
+
+	key, err := v.read(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("vault: read a fresh key from %s", v.path)
+	onKey(key)
+	return nil
+}
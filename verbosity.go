@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+/* -------------------------------
+   Output and verbosity
+
+   -quiet   no log while uploading,
+            only the final summary
+   default  a line per file
+   -v       and a line per request
+   -vv      and request headers
+            (credentials redacted)
+            and response bodies
+
+   -output json prints each run's
+   summary as one line of JSON on
+   stdout – the -notify-url payload
+   – and moves the other reports
+   to stderr, for scripts.
+--------------------------------*/
+
+var outputFormats = map[string]bool{"text": true, "json": true}
+
+// secretHeaders are never logged with -vv.
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// debugf logs when -v was given at least level times.
+func (t *Transformer) debugf(level int, format string, args ...any) {
+	if t.verbose >= level {
+		log.Printf(format, args...)
+	}
+}
+
+// debugRequest logs the headers of an upload with -vv.
+func (t *Transformer) debugRequest(req *http.Request, key, reqID string) {
+	if t.verbose < 2 {
+		return
+	}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		for _, v := range req.Header[name] {
+			if slices.Contains(secretHeaders, name) || (key != "" && strings.Contains(v, key)) {
+				v = "[redacted]"
+			}
+			b.WriteString("\n    " + name + ": " + v)
+		}
+	}
+	log.Printf("HTTP  %s %s (request %s)%s", req.Method, req.URL, reqID, b.String())
+}
+
+// debugBody logs a response body with -vv.
+func (t *Transformer) debugBody(reqID string, body []byte) {
+	if t.verbose < 2 || len(body) == 0 {
+		return
+	}
+	s := strings.TrimSpace(string(body))
+	if len(s) > 1024 {
+		s = truncateUTF8(s, 1024) + "…"
+	}
+	log.Printf("HTTP  response %s: %s", reqID, s)
+}
+
+// quietLog silences the log on stderr until restore is called; a -log-file
+// still gets everything.
+func quietLog() (restore func()) {
+	prev := log.Writer()
+	if prev != os.Stderr {
+		return func() {}
+	}
+	log.SetOutput(io.Discard)
+	return func() { log.SetOutput(prev) }
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   Web archives (.mhtml, .warc)
+
+   An MHTML file (.mhtml, .mht) is
+   one saved page with the images
+   and styles it loaded; the page
+   is one article.
+
+   A WARC (.warc, .warc.gz) is a
+   crawl: every response record
+   with an HTML page becomes an
+   article if the page says it is
+   one (og:type article or a
+   JSON-LD Article). -archive-pages
+   all takes every page with text.
+
+   Pages are extracted as saved
+   .html pages are (htmlpage.go).
+   Images found in the archive are
+   inlined as data: URIs, so
+   -data-uri extract sends them as
+   attachments.
+--------------------------------*/
+
+var archivePageModes = map[string]bool{"articles": true, "all": true}
+
+const archiveMaxRecord = 64 << 20 // bytes of one record or part kept
+
+// isWebArchiveFile reports whether file is read as an MHTML or WARC archive.
+func isWebArchiveFile(file string) bool {
+	return isMHTMLFile(file) || isWARCFile(file)
+}
+
+func isMHTMLFile(file string) bool {
+	f := strings.ToLower(file)
+	return strings.HasSuffix(f, ".mhtml") || strings.HasSuffix(f, ".mht")
+}
+
+func isWARCFile(file string) bool {
+	f := strings.ToLower(file)
+	return strings.HasSuffix(f, ".warc") || strings.HasSuffix(f, ".warc.gz")
+}
+
+// archiveResource is a file the archive holds, by URL.
+type archiveResource struct {
+	ctype string
+	body  []byte
+}
+
+// archivePage is an HTML page from an archive, in archive order.
+type archivePage struct {
+	url  string
+	body []byte
+}
+
+// decodeWebArchive reads an MHTML or WARC file and returns its articles;
+// mode (-archive-pages) picks the WARC pages.
+func decodeWebArchive(raw []byte, file, mode string) ([]Article, error) {
+	res := make(map[string]archiveResource)
+	var pages []archivePage
+	var err error
+	if isMHTMLFile(file) {
+		pages, err = readMHTML(raw, res)
+		mode = "all" // the page was saved on purpose
+	} else {
+		pages, err = readWARC(raw, res)
+	}
+	if err != nil {
+		return nil, err
+	}
+	images := archiveImages(res)
+	var arts []Article
+	for _, p := range pages {
+		body, err := toUTF8(p.body, "auto")
+		if err != nil {
+			continue
+		}
+		a, article, err := decodeHTMLPage(body, p.url, images)
+		if err != nil || (mode == "articles" && !article) {
+			continue
+		}
+		arts = append(arts, a)
+	}
+	if len(arts) == 0 {
+		if mode == "articles" {
+			return nil, fmt.Errorf("archive: none of %d HTML pages is an article (-archive-pages all takes every page)", len(pages))
+		}
+		return nil, fmt.Errorf("archive: none of %d HTML pages has article text", len(pages))
+	}
+	return arts, nil
+}
+
+// archiveImages finds a page's images among the archive's resources, by
+// absolute URL or, in MHTML, by cid: reference.
+func archiveImages(res map[string]archiveResource) pageImages {
+	return func(ref, base *url.URL) (string, bool) {
+		u := *ref
+		if base != nil {
+			u = *base.ResolveReference(ref)
+		}
+		u.Fragment = ""
+		r, ok := res[u.String()]
+		if !ok || !strings.HasPrefix(r.ctype, "image/") {
+			return "", false
+		}
+		return encodeDataURI(r.ctype, r.body), true
+	}
+}
+
+func isHTMLType(ctype string) bool {
+	return ctype == "text/html" || ctype == "application/xhtml+xml"
+}
+
+/* ---------- MHTML ---------- */
+
+// readMHTML reads the multipart/related message: the first HTML part is
+// the page, the other parts its resources.
+func readMHTML(raw []byte, res map[string]archiveResource) ([]archivePage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("mhtml: %w", err)
+	}
+	mt, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mt, "multipart/") || params["boundary"] == "" {
+		return nil, errors.New("mhtml: not a multipart message")
+	}
+	var pages []archivePage
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mhtml: %w", err)
+		}
+		body, err := readMIMEPart(part)
+		if err != nil {
+			return nil, fmt.Errorf("mhtml: %w", err)
+		}
+		ctype, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		loc := strings.TrimSpace(part.Header.Get("Content-Location"))
+		if isHTMLType(ctype) && len(pages) == 0 {
+			pages = append(pages, archivePage{url: loc, body: body})
+			continue
+		}
+		if loc != "" {
+			res[loc] = archiveResource{ctype: ctype, body: body}
+		}
+		if id := strings.Trim(part.Header.Get("Content-ID"), "<> "); id != "" {
+			res["cid:"+id] = archiveResource{ctype: ctype, body: body}
+		}
+	}
+	if len(pages) == 0 {
+		return nil, errors.New("mhtml: no HTML part")
+	}
+	return pages, nil
+}
+
+// readMIMEPart reads a part's body, undoing its transfer encoding.
+func readMIMEPart(part *multipart.Part) ([]byte, error) {
+	var r io.Reader = part
+	switch strings.ToLower(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, part) // skips line breaks
+	case "quoted-printable":
+		r = quotedprintable.NewReader(part)
+	}
+	return readLimited(r)
+}
+
+/* ---------- WARC ---------- */
+
+// readWARC reads the response and resource records of a WARC, gzipped
+// or not. HTML pages are returned; everything else is a resource.
+func readWARC(raw []byte, res map[string]archiveResource) ([]archivePage, error) {
+	var r io.Reader = bytes.NewReader(raw)
+	if bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(r) // one member per record; read as one stream
+		if err != nil {
+			return nil, fmt.Errorf("warc: %w", err)
+		}
+		r = zr
+	}
+	br := bufio.NewReader(r)
+	tp := textproto.NewReader(br)
+	var pages []archivePage
+	for n := 0; ; n++ {
+		line, err := tp.ReadLine()
+		for err == nil && line == "" {
+			line, err = tp.ReadLine() // blank lines between records
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("warc: record %d: %w", n, err)
+		}
+		if !strings.HasPrefix(line, "WARC/") {
+			return nil, fmt.Errorf("warc: record %d: not a WARC record (%.20q)", n, line)
+		}
+		h, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return nil, fmt.Errorf("warc: record %d: %w", n, err)
+		}
+		size, err := strconv.ParseInt(h.Get("Content-Length"), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("warc: record %d: bad Content-Length %q", n, h.Get("Content-Length"))
+		}
+		if size > archiveMaxRecord {
+			if _, err := io.CopyN(io.Discard, br, size); err != nil {
+				return nil, fmt.Errorf("warc: record %d: %w", n, err)
+			}
+			continue
+		}
+		block := make([]byte, size)
+		if _, err := io.ReadFull(br, block); err != nil {
+			return nil, fmt.Errorf("warc: record %d: truncated: %w", n, err)
+		}
+
+		target := strings.Trim(h.Get("WARC-Target-URI"), "<> ")
+		var ctype string
+		var body []byte
+		switch h.Get("WARC-Type") {
+		case "response":
+			if ctype, body, err = readHTTPResponse(block); err != nil || body == nil {
+				continue // not a 200, or a body we can't decode
+			}
+		case "resource":
+			ctype, _, _ = mime.ParseMediaType(h.Get("Content-Type"))
+			body = block
+		default:
+			continue
+		}
+		if isHTMLType(ctype) {
+			pages = append(pages, archivePage{url: target, body: body})
+		} else if target != "" {
+			res[target] = archiveResource{ctype: ctype, body: body}
+		}
+	}
+	return pages, nil
+}
+
+// readHTTPResponse parses the HTTP response a WARC response record holds.
+// The body is nil unless the status is 200.
+func readHTTPResponse(block []byte) (string, []byte, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, nil
+	}
+	var r io.Reader = resp.Body
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return "", nil, err
+		}
+		r = zr
+	case "deflate":
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return "", nil, err
+		}
+		r = zr
+	default:
+		return "", nil, nil
+	}
+	body, err := readLimited(r)
+	if err != nil {
+		return "", nil, err
+	}
+	ctype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if ctype == "" {
+		ctype, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+	return ctype, body, nil
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, archiveMaxRecord+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > archiveMaxRecord {
+		return nil, fmt.Errorf("larger than %s", formatSize(archiveMaxRecord))
+	}
+	return body, nil
+}
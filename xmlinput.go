@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   XML input (-xpath)
+
+   Legacy CMS exports that are not
+   WordPress-shaped come as XML of
+   every shape. With -xpath rules,
+   files ending in .xml are parsed
+   and each field is taken from an
+   XPath expression (xpath.go):
+
+     -xpath-item //story
+     -xpath title=headline
+     -xpath content=body
+     -xpath link=@href
+     -xpath tags=keywords/kw
+
+   -xpath-item splits a file into
+   one article per matching node,
+   and the field paths are relative
+   to it; without it, the document
+   is one article.
+
+   content and excerpt keep the
+   node's markup (or its text, for
+   HTML escaped or in CDATA); other
+   fields take the trimmed text.
+   tags, categories and collections
+   take every node matched.
+--------------------------------*/
+
+// xmlListFields are the fields that take every node a path matches.
+var xmlListFields = map[string]bool{"tags": true, "categories": true, "collections": true}
+
+type xmlRule struct {
+	field string
+	expr  *xpathExpr
+}
+
+// XMLMapper builds Articles from XML documents.
+type XMLMapper struct {
+	item  *xpathExpr // nil: the document is one article
+	rules []xmlRule
+}
+
+// NewXMLMapper compiles the -xpath-item expression and "field=expr" rules.
+func NewXMLMapper(item string, specs []string) (*XMLMapper, error) {
+	m := &XMLMapper{}
+	if item != "" {
+		expr, err := compileXPath(item)
+		if err != nil {
+			return nil, fmt.Errorf("xpath-item: %w", err)
+		}
+		m.item = expr
+	}
+	for _, spec := range specs {
+		field, src, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("xpath %q: want field=expr", spec)
+		}
+		field = strings.TrimSpace(field)
+		if _, known := articleFields[field]; !known && !xmlListFields[field] {
+			return nil, fmt.Errorf("xpath %q: unknown field %q", spec, field)
+		}
+		expr, err := compileXPath(strings.TrimSpace(src))
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, xmlRule{field: field, expr: expr})
+	}
+	return m, nil
+}
+
+// isXMLFile reports whether file is read with the -xpath rules.
+func isXMLFile(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".xml")
+}
+
+// Decode parses raw XML and maps each item onto an Article.
+func (m *XMLMapper) Decode(raw []byte) ([]Article, error) {
+	doc, err := parseXMLTree(raw)
+	if err != nil {
+		return nil, err
+	}
+	items := []*xmlNode{doc}
+	if m.item != nil {
+		v, err := m.item.eval(doc)
+		if err != nil {
+			return nil, fmt.Errorf("xpath-item %s: %w", m.item.src, err)
+		}
+		nodes, ok := v.([]*xmlNode)
+		if !ok {
+			return nil, fmt.Errorf("xpath-item %s: not a node set", m.item.src)
+		}
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("xpath-item %s: no match", m.item.src)
+		}
+		items = nodes
+	}
+	arts := make([]Article, 0, len(items))
+	for _, item := range items {
+		var a Article
+		for _, r := range m.rules {
+			if err := r.apply(item, &a); err != nil {
+				return nil, err
+			}
+		}
+		arts = append(arts, a)
+	}
+	return arts, nil
+}
+
+func (r xmlRule) apply(item *xmlNode, a *Article) error {
+	v, err := r.expr.eval(item)
+	if err != nil {
+		return fmt.Errorf("xpath %s=%s: %w", r.field, r.expr.src, err)
+	}
+	nodes, isNodes := v.([]*xmlNode)
+	if xmlListFields[r.field] {
+		var values []string
+		if isNodes {
+			for _, n := range nodes {
+				if s := strings.TrimSpace(n.stringValue()); s != "" {
+					values = append(values, s)
+				}
+			}
+		} else if s := xpathString(v); s != "" {
+			values = strings.Split(s, ",")
+		}
+		switch r.field {
+		case "tags":
+			a.Tags = termsFromJSON(toAnySlice(values))
+		case "categories":
+			a.Categories = termsFromJSON(toAnySlice(values))
+		case "collections":
+			for _, s := range values {
+				id, err := strconv.Atoi(strings.TrimSpace(s))
+				if err != nil || id <= 0 {
+					return fmt.Errorf("xpath collections=%s: %q is not a collection ID", r.expr.src, s)
+				}
+				a.Collections = append(a.Collections, id)
+			}
+		}
+		return nil
+	}
+	var s string
+	switch {
+	case !isNodes:
+		s = xpathString(v)
+	case len(nodes) == 0:
+	case r.field == "content" || r.field == "excerpt":
+		s = nodes[0].innerMarkup()
+	default:
+		s = nodes[0].stringValue()
+	}
+	*articleFields[r.field](a) = strings.TrimSpace(s)
+	return nil
+}
+
+func toAnySlice(ss []string) []any {
+	out := make([]any, len(ss))
+	for i, s := range ss {
+		out[i] = s
+	}
+	return out
+}
+
+/* ---------- tree ---------- */
+
+const (
+	xmlRoot = iota
+	xmlElement
+	xmlAttr
+	xmlText
+)
+
+type xmlNode struct {
+	kind     int
+	name     xml.Name // Space is the namespace URI
+	prefix   string   // as written, for name()
+	data     string   // text and attribute values
+	attrs    []*xmlNode
+	children []*xmlNode
+	parent   *xmlNode
+	order    int               // position in the document
+	ns       map[string]string // on the root: prefix → URI, as first bound
+}
+
+// parseXMLTree reads raw into a tree. Input is already UTF-8 (see
+// toUTF8), so the declared encoding is not applied again; HTML entities
+// such as &nbsp; are accepted.
+func parseXMLTree(raw []byte) (*xmlNode, error) {
+	d := xml.NewDecoder(bytes.NewReader(raw))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	root := &xmlNode{kind: xmlRoot, ns: make(map[string]string)}
+	cur, order := root, 0
+	sawElement := false
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("xml: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			sawElement = true
+			order++
+			n := &xmlNode{kind: xmlElement, parent: cur, order: order}
+			n.prefix, n.name.Local = t.Name.Space, t.Name.Local
+			for _, a := range t.Attr {
+				if a.Name.Space == "xmlns" {
+					if _, seen := root.ns[a.Name.Local]; !seen {
+						root.ns[a.Name.Local] = a.Value
+					}
+					continue
+				}
+				if a.Name.Space == "" && a.Name.Local == "xmlns" {
+					continue
+				}
+				order++
+				n.attrs = append(n.attrs, &xmlNode{kind: xmlAttr, name: a.Name, prefix: a.Name.Space, data: a.Value, parent: n, order: order})
+			}
+			cur.children = append(cur.children, n)
+			cur = n
+		case xml.EndElement:
+			// close up to the matching element: legacy markup leaves
+			// <br> and <p> open; an end tag matching nothing is dropped
+			for n := cur; n.kind == xmlElement; n = n.parent {
+				if n.prefix == t.Name.Space && n.name.Local == t.Name.Local {
+					cur = n.parent
+					break
+				}
+			}
+		case xml.CharData:
+			if last := len(cur.children) - 1; last >= 0 && cur.children[last].kind == xmlText {
+				cur.children[last].data += string(t)
+				continue
+			}
+			order++
+			cur.children = append(cur.children, &xmlNode{kind: xmlText, data: string(t), parent: cur, order: order})
+		}
+	}
+	if !sawElement {
+		return nil, errors.New("xml: no elements")
+	}
+	if cur != root {
+		line, _ := d.InputPos()
+		return nil, &xml.SyntaxError{Msg: fmt.Sprintf("<%s> not closed at the end of the file", cur.qualifiedName()), Line: line}
+	}
+	// names keep the prefix as written; a prefix bound anywhere in the
+	// document stands for its namespace
+	for _, n := range root.descendantsOrSelf() {
+		for _, a := range n.attrs {
+			a.name.Space = root.ns[a.prefix]
+		}
+		if n.kind == xmlElement {
+			n.name.Space = root.ns[n.prefix]
+		}
+	}
+	return root, nil
+}
+
+func (n *xmlNode) root() *xmlNode {
+	for n.parent != nil {
+		n = n.parent
+	}
+	return n
+}
+
+// descendantsOrSelf lists n and the nodes below it, attributes excluded,
+// in document order.
+func (n *xmlNode) descendantsOrSelf() []*xmlNode {
+	out := []*xmlNode{n}
+	for _, c := range n.children {
+		out = append(out, c.descendantsOrSelf()...)
+	}
+	return out
+}
+
+func (n *xmlNode) qualifiedName() string {
+	if n.prefix != "" {
+		return n.prefix + ":" + n.name.Local
+	}
+	return n.name.Local
+}
+
+// stringValue is the node's text: its value, or all text below it.
+func (n *xmlNode) stringValue() string {
+	if n.kind == xmlText || n.kind == xmlAttr {
+		return n.data
+	}
+	var b strings.Builder
+	for _, c := range n.descendantsOrSelf() {
+		if c.kind == xmlText {
+			b.WriteString(c.data)
+		}
+	}
+	return b.String()
+}
+
+// innerMarkup is the node's content as HTML: the text itself when there
+// are no child elements (escaped or CDATA HTML), else the child markup.
+func (n *xmlNode) innerMarkup() string {
+	if n.kind != xmlElement && n.kind != xmlRoot {
+		return n.stringValue()
+	}
+	hasElements := false
+	for _, c := range n.children {
+		if c.kind == xmlElement {
+			hasElements = true
+			break
+		}
+	}
+	if !hasElements {
+		return n.stringValue()
+	}
+	var b strings.Builder
+	for _, c := range n.children {
+		c.writeMarkup(&b)
+	}
+	return b.String()
+}
+
+func (n *xmlNode) writeMarkup(b *strings.Builder) {
+	if n.kind == xmlText {
+		b.WriteString(html.EscapeString(n.data))
+		return
+	}
+	b.WriteString("<" + n.name.Local)
+	for _, a := range n.attrs {
+		fmt.Fprintf(b, " %s=\"%s\"", a.name.Local, html.EscapeString(a.data))
+	}
+	b.WriteByte('>')
+	if len(n.children) == 0 && htmlVoidElements[strings.ToLower(n.name.Local)] {
+		return
+	}
+	for _, c := range n.children {
+		c.writeMarkup(b)
+	}
+	b.WriteString("</" + n.name.Local + ">")
+}
+
+var htmlVoidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   XPath subset (for -xpath)
+
+   XPath 1.0 location paths over
+   the tree in xmlinput.go:
+
+   /a/b  //a  a/b  .  ..  *  @x
+   text()  node()  prefix:name
+   [2]  [last()]  [@x='v']
+   [title]  [not(@draft)]
+
+   with = != < <= > >=, and, or,
+   | (union) and the functions
+   contains, starts-with, not,
+   concat, string, normalize-space,
+   count, position, last, name,
+   local-name, true and false.
+   Other axes and arithmetic are
+   not supported.
+--------------------------------*/
+
+// xpathExpr is a compiled expression.
+type xpathExpr struct {
+	src  string
+	root xpathNode
+}
+
+// xpathNode is one node of the expression tree.
+type xpathNode interface {
+	eval(c xpathContext) (any, error) // []*xmlNode, string, float64 or bool
+}
+
+type xpathContext struct {
+	node      *xmlNode
+	pos, size int
+}
+
+func compileXPath(src string) (*xpathExpr, error) {
+	toks, err := xpathTokens(src)
+	if err != nil {
+		return nil, fmt.Errorf("xpath %q: %w", src, err)
+	}
+	p := &xpathParser{toks: toks}
+	root, err := p.expr()
+	if err == nil && p.i < len(p.toks) {
+		err = fmt.Errorf("unexpected %q", p.toks[p.i])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("xpath %q: %w", src, err)
+	}
+	return &xpathExpr{src: src, root: root}, nil
+}
+
+// mustCompileXPath compiles a fixed expression, for package-level
+// variables; it panics if src does not compile.
+func mustCompileXPath(src string) *xpathExpr {
+	e, err := compileXPath(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// eval runs e with n as the context node.
+func (e *xpathExpr) eval(n *xmlNode) (any, error) {
+	return e.root.eval(xpathContext{node: n, pos: 1, size: 1})
+}
+
+/* ---------- tokens ---------- */
+
+func xpathTokens(s string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "//") || strings.HasPrefix(s[i:], "..") ||
+			strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			toks = append(toks, s[i:i+2])
+			i += 2
+		case strings.IndexByte("/[]()@,|.*=<>", c) >= 0 && !(c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'):
+			toks = append(toks, s[i:i+1])
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			toks = append(toks, s[i:i+end+2])
+			i += end + 2
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		case isXPathNameByte(c, true):
+			j := xpathNameEnd(s, i)
+			// prefix:name or prefix:*
+			if j+1 < len(s) && s[j] == ':' {
+				if s[j+1] == '*' {
+					j += 2
+				} else if isXPathNameByte(s[j+1], true) {
+					j = xpathNameEnd(s, j+1)
+				}
+			}
+			toks = append(toks, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q", c)
+		}
+	}
+	return toks, nil
+}
+
+func xpathNameEnd(s string, i int) int {
+	for i < len(s) && isXPathNameByte(s[i], false) {
+		i++
+	}
+	return i
+}
+
+func isXPathNameByte(c byte, first bool) bool {
+	if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80 {
+		return true
+	}
+	return !first && (c == '-' || c == '.' || c >= '0' && c <= '9')
+}
+
+/* ---------- parser ---------- */
+
+type xpathParser struct {
+	toks []string
+	i    int
+}
+
+func (p *xpathParser) peek() string {
+	if p.i < len(p.toks) {
+		return p.toks[p.i]
+	}
+	return ""
+}
+
+func (p *xpathParser) next() string {
+	t := p.peek()
+	p.i++
+	return t
+}
+
+func (p *xpathParser) expect(t string) error {
+	if got := p.next(); got != t {
+		if got == "" {
+			return fmt.Errorf("want %q at the end", t)
+		}
+		return fmt.Errorf("want %q, got %q", t, got)
+	}
+	return nil
+}
+
+func (p *xpathParser) expr() (xpathNode, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "or" {
+		p.next()
+		var right xpathNode
+		if right, err = p.and(); err == nil {
+			left = xpathLogic{or: true, left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *xpathParser) and() (xpathNode, error) {
+	left, err := p.compare()
+	for err == nil && p.peek() == "and" {
+		p.next()
+		var right xpathNode
+		if right, err = p.compare(); err == nil {
+			left = xpathLogic{left: left, right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *xpathParser) compare() (xpathNode, error) {
+	left, err := p.union()
+	if err != nil {
+		return nil, err
+	}
+	switch op := p.peek(); op {
+	case "=", "!=", "<", "<=", ">", ">=":
+		p.next()
+		right, err := p.union()
+		if err != nil {
+			return nil, err
+		}
+		return xpathCompare{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *xpathParser) union() (xpathNode, error) {
+	first, err := p.path()
+	if err != nil || p.peek() != "|" {
+		return first, err
+	}
+	u := xpathUnion{first}
+	for p.peek() == "|" {
+		p.next()
+		next, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		u = append(u, next)
+	}
+	return u, nil
+}
+
+func (p *xpathParser) path() (xpathNode, error) {
+	t := p.peek()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end")
+	case t[0] == '"' || t[0] == '\'':
+		p.next()
+		return xpathLiteral(t[1 : len(t)-1]), nil
+	case t[0] >= '0' && t[0] <= '9' || t[0] == '.' && len(t) > 1 && t != "..":
+		p.next()
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", t)
+		}
+		return xpathNumber(f), nil
+	case t == "(":
+		p.next()
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case p.i+1 < len(p.toks) && p.toks[p.i+1] == "(" && t != "text" && t != "node":
+		return p.call()
+	}
+	loc := &xpathPath{}
+	switch t {
+	case "/":
+		p.next()
+		loc.absolute = true
+		if s := p.peek(); s == "" || s == "]" || s == ")" || s == "|" || s == "," {
+			return loc, nil // the root itself
+		}
+	case "//":
+		p.next()
+		loc.absolute = true
+		loc.steps = append(loc.steps, xpathStep{descendants: true})
+	}
+	for {
+		step, err := p.step()
+		if err != nil {
+			return nil, err
+		}
+		loc.steps = append(loc.steps, step)
+		switch p.peek() {
+		case "/":
+			p.next()
+		case "//":
+			p.next()
+			loc.steps = append(loc.steps, xpathStep{descendants: true})
+		default:
+			return loc, nil
+		}
+	}
+}
+
+func (p *xpathParser) step() (xpathStep, error) {
+	var s xpathStep
+	switch t := p.next(); {
+	case t == ".":
+		s.self = true
+	case t == "..":
+		s.parent = true
+	case t == "@":
+		s.attr = true
+		name := p.next()
+		if name == "" || !(name == "*" || isXPathNameByte(name[0], true)) {
+			return s, fmt.Errorf("want an attribute name after @")
+		}
+		s.prefix, s.local = splitXPathName(name)
+	case (t == "text" || t == "node") && p.peek() == "(":
+		p.next()
+		if err := p.expect(")"); err != nil {
+			return s, err
+		}
+		s.text, s.anyNode = t == "text", t == "node"
+	case t == "*" || t != "" && isXPathNameByte(t[0], true):
+		s.prefix, s.local = splitXPathName(t)
+	default:
+		return s, fmt.Errorf("want a step, got %q", t)
+	}
+	for p.peek() == "[" {
+		p.next()
+		e, err := p.expr()
+		if err != nil {
+			return s, err
+		}
+		if err := p.expect("]"); err != nil {
+			return s, err
+		}
+		s.preds = append(s.preds, e)
+	}
+	return s, nil
+}
+
+func splitXPathName(name string) (prefix, local string) {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+func (p *xpathParser) call() (xpathNode, error) {
+	name := p.next()
+	p.next() // (
+	var args []xpathNode
+	for p.peek() != ")" {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		a, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+	}
+	p.next()
+	arity, ok := xpathFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s()", name)
+	}
+	if len(args) < arity[0] || arity[1] >= 0 && len(args) > arity[1] {
+		return nil, fmt.Errorf("%s(): wrong number of arguments", name)
+	}
+	return xpathCall{name: name, args: args}, nil
+}
+
+// xpathFunctions gives the minimum and maximum (-1: any) arguments.
+var xpathFunctions = map[string][2]int{
+	"contains": {2, 2}, "starts-with": {2, 2}, "not": {1, 1}, "concat": {2, -1},
+	"string": {0, 1}, "normalize-space": {0, 1}, "count": {1, 1},
+	"position": {0, 0}, "last": {0, 0}, "name": {0, 1}, "local-name": {0, 1},
+	"true": {0, 0}, "false": {0, 0},
+}
+
+/* ---------- evaluation ---------- */
+
+type xpathLiteral string
+
+func (l xpathLiteral) eval(xpathContext) (any, error) { return string(l), nil }
+
+type xpathNumber float64
+
+func (n xpathNumber) eval(xpathContext) (any, error) { return float64(n), nil }
+
+type xpathStep struct {
+	descendants  bool // the "//" between steps: descendant-or-self::node()
+	self, parent bool
+	attr         bool
+	text         bool // text()
+	anyNode      bool // node()
+	prefix       string
+	local        string // "*" matches any name
+	preds        []xpathNode
+}
+
+type xpathPath struct {
+	absolute bool
+	steps    []xpathStep
+}
+
+func (l *xpathPath) eval(c xpathContext) (any, error) {
+	nodes := []*xmlNode{c.node}
+	if l.absolute {
+		nodes = []*xmlNode{c.node.root()}
+	}
+	for _, s := range l.steps {
+		var out []*xmlNode
+		for _, n := range nodes {
+			matched, err := s.apply(n)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, matched...)
+		}
+		nodes = inDocumentOrder(out)
+	}
+	return nodes, nil
+}
+
+// apply returns the nodes step s selects from n, filtered by its
+// predicates.
+func (s *xpathStep) apply(n *xmlNode) ([]*xmlNode, error) {
+	var cand []*xmlNode
+	switch {
+	case s.descendants:
+		return n.descendantsOrSelf(), nil
+	case s.self:
+		cand = []*xmlNode{n}
+	case s.parent:
+		if n.parent != nil {
+			cand = []*xmlNode{n.parent}
+		}
+	case s.attr:
+		for _, a := range n.attrs {
+			if s.matches(a) {
+				cand = append(cand, a)
+			}
+		}
+	default:
+		for _, ch := range n.children {
+			if s.matches(ch) {
+				cand = append(cand, ch)
+			}
+		}
+	}
+	for _, pred := range s.preds {
+		var kept []*xmlNode
+		for i, m := range cand {
+			v, err := pred.eval(xpathContext{node: m, pos: i + 1, size: len(cand)})
+			if err != nil {
+				return nil, err
+			}
+			if f, ok := v.(float64); ok {
+				if f == float64(i+1) {
+					kept = append(kept, m)
+				}
+			} else if xpathBool(v) {
+				kept = append(kept, m)
+			}
+		}
+		cand = kept
+	}
+	return cand, nil
+}
+
+func (s *xpathStep) matches(n *xmlNode) bool {
+	switch {
+	case s.anyNode:
+		return true
+	case s.text:
+		return n.kind == xmlText
+	case n.kind != xmlElement && n.kind != xmlAttr:
+		return false
+	case s.local != "*" && n.name.Local != s.local:
+		return false
+	case s.prefix == "":
+		return true
+	}
+	// a prefix names the namespace the document bound it to
+	uri, ok := n.root().ns[s.prefix]
+	return n.name.Space == s.prefix || ok && n.name.Space == uri
+}
+
+type xpathUnion []xpathNode
+
+func (u xpathUnion) eval(c xpathContext) (any, error) {
+	var all []*xmlNode
+	for _, e := range u {
+		v, err := e.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		nodes, ok := v.([]*xmlNode)
+		if !ok {
+			return nil, fmt.Errorf("| joins node sets only")
+		}
+		all = append(all, nodes...)
+	}
+	return inDocumentOrder(all), nil
+}
+
+type xpathLogic struct {
+	or          bool
+	left, right xpathNode
+}
+
+func (l xpathLogic) eval(c xpathContext) (any, error) {
+	a, err := l.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	if xpathBool(a) == l.or {
+		return l.or, nil
+	}
+	b, err := l.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	return xpathBool(b), nil
+}
+
+type xpathCompare struct {
+	op          string
+	left, right xpathNode
+}
+
+func (x xpathCompare) eval(c xpathContext) (any, error) {
+	a, err := x.left.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	b, err := x.right.eval(c)
+	if err != nil {
+		return nil, err
+	}
+	// a node set compares true if any of its nodes does
+	for _, l := range xpathOperands(a) {
+		for _, r := range xpathOperands(b) {
+			if x.compare(l, r) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// xpathOperands spreads a node set into the string values of its nodes.
+func xpathOperands(v any) []any {
+	nodes, ok := v.([]*xmlNode)
+	if !ok {
+		return []any{v}
+	}
+	out := make([]any, len(nodes))
+	for i, n := range nodes {
+		out[i] = n.stringValue()
+	}
+	return out
+}
+
+func (x xpathCompare) compare(a, b any) bool {
+	if x.op == "=" || x.op == "!=" {
+		var eq bool
+		_, an := a.(float64)
+		_, bn := b.(float64)
+		switch {
+		case isBool(a) || isBool(b):
+			eq = xpathBool(a) == xpathBool(b)
+		case an || bn:
+			eq = xpathNum(a) == xpathNum(b)
+		default:
+			eq = xpathString(a) == xpathString(b)
+		}
+		return eq == (x.op == "=")
+	}
+	l, r := xpathNum(a), xpathNum(b)
+	switch x.op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	}
+	return l >= r
+}
+
+func isBool(v any) bool {
+	_, ok := v.(bool)
+	return ok
+}
+
+type xpathCall struct {
+	name string
+	args []xpathNode
+}
+
+func (f xpathCall) eval(c xpathContext) (any, error) {
+	args := make([]any, len(f.args))
+	for i, a := range f.args {
+		v, err := a.eval(c)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	self := []*xmlNode{c.node}
+	arg := func(i int) any {
+		if i < len(args) {
+			return args[i]
+		}
+		return self
+	}
+	switch f.name {
+	case "contains":
+		return strings.Contains(xpathString(args[0]), xpathString(args[1])), nil
+	case "starts-with":
+		return strings.HasPrefix(xpathString(args[0]), xpathString(args[1])), nil
+	case "not":
+		return !xpathBool(args[0]), nil
+	case "concat":
+		var b strings.Builder
+		for _, a := range args {
+			b.WriteString(xpathString(a))
+		}
+		return b.String(), nil
+	case "string":
+		return xpathString(arg(0)), nil
+	case "normalize-space":
+		return strings.Join(strings.Fields(xpathString(arg(0))), " "), nil
+	case "count":
+		nodes, ok := args[0].([]*xmlNode)
+		if !ok {
+			return nil, fmt.Errorf("count() takes a node set")
+		}
+		return float64(len(nodes)), nil
+	case "position":
+		return float64(c.pos), nil
+	case "last":
+		return float64(c.size), nil
+	case "name", "local-name":
+		nodes, ok := arg(0).([]*xmlNode)
+		if !ok {
+			return nil, fmt.Errorf("%s() takes a node set", f.name)
+		}
+		if len(nodes) == 0 {
+			return "", nil
+		}
+		if f.name == "local-name" {
+			return nodes[0].name.Local, nil
+		}
+		return nodes[0].qualifiedName(), nil
+	case "true":
+		return true, nil
+	}
+	return false, nil // false()
+}
+
+/* ---------- conversions ---------- */
+
+func xpathString(v any) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		if x == math.Trunc(x) && !math.IsInf(x, 0) {
+			return strconv.FormatInt(int64(x), 10)
+		}
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case []*xmlNode:
+		if len(x) == 0 {
+			return ""
+		}
+		return x[0].stringValue()
+	}
+	return ""
+}
+
+func xpathNum(v any) float64 {
+	switch x := v.(type) {
+	case float64:
+		return x
+	case bool:
+		if x {
+			return 1
+		}
+		return 0
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(xpathString(v)), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func xpathBool(v any) bool {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case float64:
+		return x != 0 && !math.IsNaN(x)
+	case string:
+		return x != ""
+	case []*xmlNode:
+		return len(x) > 0
+	}
+	return false
+}
+
+// inDocumentOrder sorts nodes as they appear in the document and drops
+// repeats.
+func inDocumentOrder(nodes []*xmlNode) []*xmlNode {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].order < nodes[j].order })
+	out := nodes[:0]
+	for i, n := range nodes {
+		if i == 0 || n != nodes[i-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
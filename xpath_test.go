@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const xpathTestDoc = `<?xml version="1.0"?>
+<feed xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <item id="1" lang="en">
+    <title>First</title>
+    <dc:creator>Ann</dc:creator>
+    <tag>a</tag><tag>b</tag>
+    <body><p>One <b>bold</b></p></body>
+  </item>
+  <item id="2" draft="yes">
+    <title>  Second   post </title>
+    <tag>c</tag>
+  </item>
+  <item id="3">
+    <title>Third</title>
+  </item>
+</feed>`
+
+// xpathResult renders a result for comparison: node-sets as their string
+// values joined by |.
+func xpathResult(v any) string {
+	if nodes, ok := v.([]*xmlNode); ok {
+		var s []string
+		for _, n := range nodes {
+			s = append(s, strings.TrimSpace(n.stringValue()))
+		}
+		return strings.Join(s, "|")
+	}
+	return fmt.Sprint(v)
+}
+
+func TestXPathEval(t *testing.T) {
+	doc, err := parseXMLTree([]byte(xpathTestDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := compileXPath("/feed/item[1]")
+	v, err := first.eval(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := v.([]*xmlNode)[0] // a context node below the root
+	for _, tc := range []struct {
+		ctx  *xmlNode
+		expr string
+		want string
+	}{
+		{doc, "/feed/item/title", "First|Second   post|Third"},
+		{doc, "//title", "First|Second   post|Third"},
+		{doc, "//item/@id", "1|2|3"},
+		{doc, "//item[2]/title", "Second   post"},
+		{doc, "//item[last()]/@id", "3"},
+		{doc, "//item[@draft]/@id", "2"},
+		{doc, "//item[not(@draft)]/@id", "1|3"},
+		{doc, "//item[@id='3']/title", "Third"},
+		{doc, "//item[@id!='1' and tag]/@id", "2"},
+		{doc, "//item[@id=1 or @id=3]/@id", "1|3"},
+		{doc, "//item[@id>=2]/@id", "2|3"},
+		{doc, "//item[@id<2]/@id", "1"},
+		{doc, "//item[tag='b']/@id", "1"}, // any tag matches
+		{doc, "//item[title][position()=2]/@id", "2"},
+		{doc, "//item[contains(title, 'ir')]/@id", "1|3"},
+		{doc, "//item[starts-with(title, 'T')]/@id", "3"},
+		{doc, "//dc:creator", "Ann"},
+		{doc, "//*[local-name()='creator']", "Ann"},
+		{doc, "//tag | //title", "First|a|b|Second   post|c|Third"}, // document order
+		{doc, "//p/text()", "One"},
+		{doc, "//b/..", "One bold"},
+		{doc, "//tag[.='c']/../@id", "2"},
+		{doc, "count(//tag)", "3"},
+		{doc, "count(//item[@lang])", "1"},
+		{doc, "normalize-space(//item[2]/title)", "Second post"},
+		{doc, "concat(//item[1]/@id, '-', //item[3]/@id)", "1-3"},
+		{doc, "string(//item/title)", "First"}, // the first node
+		{doc, "string(//nothing)", ""},
+		{doc, "name(//dc:creator)", "dc:creator"},
+		{doc, "true() and not(false())", "true"},
+		{doc, "//missing", ""},
+		{item, "title", "First"},
+		{item, "./tag[2]", "b"},
+		{item, "@lang", "en"},
+		{item, "body//b", "bold"},
+		{item, "../item[3]/title", "Third"},
+		{item, "/feed/item[2]/@id", "2"},
+		{item, "node()[2]", "First"}, // the whitespace before it is node 1
+		{item, "*", "First|Ann|a|b|One bold"},
+	} {
+		e, err := compileXPath(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		v, err := e.eval(tc.ctx)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+		} else if got := xpathResult(v); got != tc.want {
+			t.Errorf("%s = %q, want %q", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestCompileXPathErrors(t *testing.T) {
+	for _, tc := range []struct{ expr, want string }{
+		{"", "unexpected end"},
+		{"//item[", "unexpected end"},
+		{"//item[@id='1'", `want "]"`},
+		{"//item[@id=\"1]", "unterminated string"},
+		{"//item/following-sibling::x", "unexpected"},
+		{"1 + 2", "unexpected '+'"},
+		{"upper-case(title)", "unknown function"},
+		{"contains(title)", "contains"},
+		{"//item)", `unexpected ")"`},
+		{"a $b", `unexpected '$'`},
+	} {
+		_, err := compileXPath(tc.expr)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got error %v, want one containing %q", tc.expr, err, tc.want)
+		}
+	}
+}
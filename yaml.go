@@ -0,0 +1,731 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/* -------------------------------
+   YAML input (.yaml, .yml)
+
+   Files ending in .yaml or .yml
+   are read as YAML with the same
+   keys as the JSON schema, and
+   then decoded like JSON, so
+   -strict, -map-expr and schema
+   versions apply unchanged.
+
+   The reader covers what article
+   front matter uses: block and
+   flow mappings and sequences,
+   plain and quoted scalars, and
+   | and > block scalars for the
+   HTML. Anchors, aliases, tags
+   and several documents in one
+   file are input errors.
+
+   Plain scalars are typed as YAML
+   1.2 does, except where the
+   article schema wants a string:
+   title: 2024 is the title "2024".
+--------------------------------*/
+
+// isYAMLFile reports whether file is read as YAML.
+func isYAMLFile(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// yamlToJSON converts a YAML document to JSON.
+func yamlToJSON(raw []byte) ([]byte, error) {
+	p := &yamlParser{}
+	started := false
+lines:
+	for i, line := range strings.Split(strings.ReplaceAll(string(raw), "\r\n", "\n"), "\n") {
+		switch {
+		case line == "---" || strings.HasPrefix(line, "--- "):
+			if started || len(p.lines) > 0 {
+				return nil, &yamlError{i + 1, "several documents; want one article per file"}
+			}
+			started = true
+			if rest := strings.TrimSpace(line[3:]); rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, &yamlError{i + 1, "content after ---"}
+			}
+		case line == "...":
+			break lines
+		case strings.HasPrefix(line, "%"):
+			// directives
+		default:
+			p.lines = append(p.lines, line)
+			p.nums = append(p.nums, i+1)
+		}
+	}
+	v, err := p.node(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank(); p.i < len(p.lines) {
+		return nil, p.errorf("unexpected %q", strings.TrimSpace(p.lines[p.i]))
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, &yamlError{0, "want a mapping of article fields"}
+	}
+	// an unknown version is left for decodeArticle to report
+	version := 1
+	switch sv := m["schema_version"].(type) {
+	case yamlScalar:
+		version, _ = strconv.Atoi(sv.text)
+	case string:
+		version, _ = strconv.Atoi(sv)
+	}
+	if shape, ok := articleShapes[version]; ok {
+		yamlStrings(m, shape)
+	}
+	return json.Marshal(m)
+}
+
+// yamlScalar is a plain scalar typed as a number or boolean. Its text is
+// kept for fields that want a string.
+type yamlScalar struct {
+	text  string
+	value any
+}
+
+func (s yamlScalar) MarshalJSON() ([]byte, error) { return json.Marshal(s.value) }
+
+// yamlStrings turns plain scalars in v back into their text where shape,
+// the type v is decoded into, has a string.
+func yamlStrings(v any, shape reflect.Type) any {
+	s, isScalar := v.(yamlScalar)
+	switch shape.Kind() {
+	case reflect.String:
+		if isScalar {
+			return s.text
+		}
+	case reflect.Slice:
+		if shape.Elem().Kind() != reflect.String {
+			break
+		}
+		if isScalar {
+			return s.text // a comma-separated list
+		}
+		if list, ok := v.([]any); ok {
+			for i := range list {
+				list[i] = yamlStrings(list[i], shape.Elem())
+			}
+		}
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			break
+		}
+		for i := range shape.NumField() {
+			f := shape.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if fv, ok := m[name]; ok && name != "" && name != "-" {
+				m[name] = yamlStrings(fv, f.Type)
+			}
+		}
+	}
+	return v
+}
+
+// yamlError is a YAML syntax error; line 0 is the whole document.
+type yamlError struct {
+	line int
+	msg  string
+}
+
+func (e *yamlError) Error() string {
+	if e.line == 0 {
+		return "yaml: " + e.msg
+	}
+	return fmt.Sprintf("yaml: line %d: %s", e.line, e.msg)
+}
+
+type yamlParser struct {
+	lines []string
+	nums  []int // line numbers in the file, for errors
+	i     int
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	n := 0
+	if p.i < len(p.nums) {
+		n = p.nums[p.i]
+	} else if len(p.nums) > 0 {
+		n = p.nums[len(p.nums)-1]
+	}
+	return &yamlError{n, fmt.Sprintf(format, args...)}
+}
+
+// skipBlank moves past empty and comment-only lines.
+func (p *yamlParser) skipBlank() {
+	for p.i < len(p.lines) {
+		if t := strings.TrimSpace(p.lines[p.i]); t != "" && !strings.HasPrefix(t, "#") {
+			return
+		}
+		p.i++
+	}
+}
+
+// indent returns the indentation of the current line.
+func (p *yamlParser) indent() (int, error) {
+	line := p.lines[p.i]
+	n := len(line) - len(strings.TrimLeft(line, " "))
+	if n < len(line) && line[n] == '\t' {
+		return 0, p.errorf("tabs are not allowed for indentation")
+	}
+	return n, nil
+}
+
+// node parses the block at the current line, which must be indented at
+// least min; nil if there is none.
+func (p *yamlParser) node(min int) (any, error) {
+	p.skipBlank()
+	if p.i >= len(p.lines) {
+		return nil, nil
+	}
+	ind, err := p.indent()
+	if err != nil || ind < min {
+		return nil, err
+	}
+	text := stripYAMLComment(p.lines[p.i][ind:])
+	switch {
+	case text == "-" || strings.HasPrefix(text, "- "):
+		return p.sequence(ind)
+	case yamlKey(text) >= 0:
+		return p.mapping(ind)
+	}
+	p.i++
+	return p.scalar(text, ind-1)
+}
+
+func (p *yamlParser) mapping(ind int) (any, error) {
+	m := make(map[string]any)
+	for {
+		p.skipBlank()
+		if p.i >= len(p.lines) {
+			return m, nil
+		}
+		n, err := p.indent()
+		if err != nil {
+			return nil, err
+		}
+		if n < ind {
+			return m, nil
+		}
+		if n > ind {
+			return nil, p.errorf("bad indentation")
+		}
+		text := stripYAMLComment(p.lines[p.i][ind:])
+		colon := yamlKey(text)
+		if colon < 0 {
+			return nil, p.errorf("want key: value, got %q", text)
+		}
+		key, err := yamlKeyName(strings.TrimSpace(text[:colon]))
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if _, dup := m[key]; dup {
+			return nil, p.errorf("key %q given twice", key)
+		}
+		rest := strings.TrimSpace(text[colon+1:])
+		p.i++
+		var v any
+		if rest == "" {
+			v, err = p.nested(ind)
+		} else {
+			v, err = p.scalar(rest, ind)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+}
+
+// nested parses the value on the lines after "key:" at indentation ind. A
+// sequence may sit at the key's own indentation.
+func (p *yamlParser) nested(ind int) (any, error) {
+	p.skipBlank()
+	if p.i >= len(p.lines) {
+		return nil, nil
+	}
+	n, err := p.indent()
+	if err != nil {
+		return nil, err
+	}
+	if n == ind {
+		if text := stripYAMLComment(p.lines[p.i][n:]); text == "-" || strings.HasPrefix(text, "- ") {
+			return p.sequence(ind)
+		}
+	}
+	return p.node(ind + 1)
+}
+
+func (p *yamlParser) sequence(ind int) (any, error) {
+	list := []any{}
+	for {
+		p.skipBlank()
+		if p.i >= len(p.lines) {
+			return list, nil
+		}
+		n, err := p.indent()
+		if err != nil {
+			return nil, err
+		}
+		text := stripYAMLComment(p.lines[p.i][n:])
+		if n < ind || (n == ind && text != "-" && !strings.HasPrefix(text, "- ")) {
+			return list, nil
+		}
+		if n > ind {
+			return nil, p.errorf("bad indentation")
+		}
+		var v any
+		if strings.TrimSpace(text[1:]) == "" {
+			p.i++
+			v, err = p.node(ind + 1)
+		} else {
+			// read "- item" as the item indented past the dash
+			line := p.lines[p.i]
+			p.lines[p.i] = line[:ind] + " " + line[ind+1:]
+			v, err = p.node(ind + 1)
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+}
+
+// scalar parses the value text that followed "key:" or "-"; continuation
+// lines must be indented past parent.
+func (p *yamlParser) scalar(text string, parent int) (any, error) {
+	start := p.i - 1 // the line text is on, for errors
+	switch text[0] {
+	case '&', '*', '!':
+		return nil, p.errorf("anchors, aliases and tags are not supported")
+	case '|', '>':
+		return p.blockScalar(text, parent)
+	case '[', '{':
+		for !flowClosed(text) && p.i < len(p.lines) {
+			text += " " + strings.TrimSpace(stripYAMLComment(p.lines[p.i]))
+			p.i++
+		}
+		f := &yamlFlow{s: text}
+		v, err := f.value()
+		if err == nil {
+			if f.skipSpace(); f.pos < len(f.s) {
+				err = fmt.Errorf("unexpected %q after %c…%c", f.s[f.pos:], text[0], text[len(text)-1])
+			}
+		}
+		if err != nil {
+			p.i = start
+			return nil, p.errorf("%v", err)
+		}
+		return v, nil
+	case '"', '\'':
+		for !quoteClosed(text) && p.i < len(p.lines) {
+			text += " " + strings.TrimSpace(p.lines[p.i])
+			p.i++
+		}
+		s, rest, err := yamlQuoted(text)
+		if err == nil && strings.TrimSpace(rest) != "" {
+			err = fmt.Errorf("unexpected %q after the quoted string", rest)
+		}
+		if err != nil {
+			p.i = start
+			return nil, p.errorf("%v", err)
+		}
+		return s, nil
+	}
+	// plain scalar, possibly continued on deeper lines
+	for p.i < len(p.lines) {
+		line := p.lines[p.i]
+		t := strings.TrimSpace(line)
+		if t == "" || strings.HasPrefix(t, "#") {
+			break
+		}
+		if n := len(line) - len(strings.TrimLeft(line, " ")); n <= parent {
+			break
+		}
+		if yamlKey(t) >= 0 {
+			break
+		}
+		text += " " + stripYAMLComment(t)
+		p.i++
+	}
+	return resolvePlain(text), nil
+}
+
+// blockScalar reads a | (literal) or > (folded) scalar.
+func (p *yamlParser) blockScalar(header string, parent int) (any, error) {
+	folded := header[0] == '>'
+	chomp, explicit := byte(0), 0
+	for _, c := range header[1:] {
+		switch {
+		case c == '-' || c == '+':
+			chomp = byte(c)
+		case c >= '1' && c <= '9':
+			explicit = int(c - '0')
+		case c == ' ':
+		default:
+			return nil, p.errorf("bad block scalar header %q", header)
+		}
+	}
+	var lines []string
+	ind := -1
+	if explicit > 0 {
+		ind = max(parent, 0) + explicit
+	}
+	for p.i < len(p.lines) {
+		line := p.lines[p.i]
+		if strings.TrimSpace(line) == "" {
+			lines = append(lines, "")
+			p.i++
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " "))
+		if ind < 0 {
+			if n <= parent {
+				break
+			}
+			ind = n
+		}
+		if n < ind {
+			break
+		}
+		lines = append(lines, line[ind:])
+		p.i++
+	}
+	// trailing blank lines belong to chomping, and the lines after
+	body := len(lines)
+	for body > 0 && lines[body-1] == "" {
+		body--
+	}
+	trailing := len(lines) - body
+	var b strings.Builder
+	for k, line := range lines[:body] {
+		if k > 0 {
+			prev := lines[k-1]
+			moreIndented := strings.HasPrefix(line, " ") || strings.HasPrefix(prev, " ")
+			switch {
+			case !folded || line == "":
+				b.WriteByte('\n')
+			case prev == "":
+				// the blank lines before stood for this break
+				if moreIndented {
+					b.WriteByte('\n')
+				}
+			case moreIndented:
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(line)
+	}
+	s := b.String()
+	switch chomp {
+	case '-':
+	case '+':
+		if body > 0 {
+			s += "\n"
+		}
+		s += strings.Repeat("\n", trailing)
+	default:
+		if body > 0 {
+			s += "\n"
+		}
+	}
+	return s, nil
+}
+
+var (
+	yamlInt   = regexp.MustCompile(`^[-+]?[0-9]+$`)
+	yamlFloat = regexp.MustCompile(`^[-+]?(\.[0-9]+|[0-9]+(\.[0-9]*)?)([eE][-+]?[0-9]+)?$`)
+)
+
+// resolvePlain types an unquoted scalar the way YAML 1.2's core schema
+// does: null, booleans and numbers (as yamlScalar); everything else is a
+// string.
+func resolvePlain(s string) any {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return yamlScalar{s, true}
+	case "false", "False", "FALSE":
+		return yamlScalar{s, false}
+	}
+	if yamlInt.MatchString(s) {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return yamlScalar{s, json.Number(strconv.FormatInt(n, 10))}
+		}
+	}
+	if yamlFloat.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return yamlScalar{s, json.Number(strconv.FormatFloat(f, 'g', -1, 64))}
+		}
+	}
+	return s
+}
+
+// yamlKey returns the index of the colon ending a mapping key in text, or
+// -1 if text is not "key: value".
+func yamlKey(text string) int {
+	if text == "" {
+		return -1
+	}
+	start := 0
+	if text[0] == '"' || text[0] == '\'' {
+		_, rest, err := yamlQuoted(text)
+		if err != nil {
+			return -1
+		}
+		start = len(text) - len(rest)
+	} else if strings.IndexByte("[{&*!|>", text[0]) >= 0 || text == "-" || strings.HasPrefix(text, "- ") {
+		return -1
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+func yamlKeyName(k string) (string, error) {
+	if k != "" && (k[0] == '"' || k[0] == '\'') {
+		s, _, err := yamlQuoted(k)
+		return s, err
+	}
+	if k == "" {
+		return "", errors.New("empty key")
+	}
+	return k, nil
+}
+
+// stripYAMLComment drops a " #" comment outside quotes and trailing space.
+func stripYAMLComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++ // '' is a quote inside the string
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || s[i-1] == ' ' || strings.IndexByte("[{,:", s[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return strings.TrimRight(s[:i], " \t")
+		}
+	}
+	return strings.TrimRight(s, " \t")
+}
+
+func quoteClosed(s string) bool {
+	_, _, err := yamlQuoted(s)
+	return err == nil
+}
+
+// yamlQuoted reads the quoted string at the start of s and returns it and
+// what follows.
+func yamlQuoted(s string) (string, string, error) {
+	q := s[0]
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case q == '\'' && c == '\'':
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), s[i+1:], nil
+		case q == '"' && c == '"':
+			return b.String(), s[i+1:], nil
+		case q == '"' && c == '\\' && i+1 < len(s):
+			i++
+			switch e := s[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '0':
+				b.WriteByte(0)
+			case '/', '\\', '"', ' ':
+				b.WriteByte(e)
+			case 'x', 'u', 'U':
+				size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[e]
+				if i+size >= len(s) {
+					return "", "", errors.New("short escape")
+				}
+				r, err := strconv.ParseUint(s[i+1:i+1+size], 16, 32)
+				if err != nil {
+					return "", "", fmt.Errorf("bad escape \\%c%s", e, s[i+1:i+1+size])
+				}
+				b.WriteRune(rune(r))
+				i += size
+			default:
+				return "", "", fmt.Errorf("unknown escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated quoted string")
+}
+
+// flowClosed reports whether the brackets in s balance, outside quotes.
+func flowClosed(s string) bool {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0
+}
+
+// yamlFlow parses [a, b] and {k: v} values.
+type yamlFlow struct {
+	s   string
+	pos int
+}
+
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *yamlFlow) value() (any, error) {
+	f.skipSpace()
+	if f.pos >= len(f.s) {
+		return nil, errors.New("unexpected end of flow collection")
+	}
+	switch f.s[f.pos] {
+	case '[':
+		f.pos++
+		list := []any{}
+		for {
+			if f.skipSpace(); f.pos < len(f.s) && f.s[f.pos] == ']' {
+				f.pos++
+				return list, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		m := make(map[string]any)
+		for {
+			if f.skipSpace(); f.pos < len(f.s) && f.s[f.pos] == '}' {
+				f.pos++
+				return m, nil
+			}
+			k, err := f.scalar(true)
+			if err != nil {
+				return nil, err
+			}
+			var key string
+			switch k := k.(type) {
+			case string:
+				key = k
+			case yamlScalar:
+				key = k.text
+			default:
+				key = fmt.Sprint(k) // null
+			}
+			if f.skipSpace(); f.pos >= len(f.s) || f.s[f.pos] != ':' {
+				return nil, fmt.Errorf("want : after key %q", key)
+			}
+			f.pos++
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f.scalar(false)
+}
+
+// separator consumes a comma, or leaves the closing bracket.
+func (f *yamlFlow) separator(end byte) error {
+	f.skipSpace()
+	switch {
+	case f.pos < len(f.s) && f.s[f.pos] == ',':
+		f.pos++
+		return nil
+	case f.pos < len(f.s) && f.s[f.pos] == end:
+		return nil
+	}
+	return fmt.Errorf("want , or %c", end)
+}
+
+func (f *yamlFlow) scalar(key bool) (any, error) {
+	f.skipSpace()
+	if f.pos < len(f.s) && (f.s[f.pos] == '"' || f.s[f.pos] == '\'') {
+		s, rest, err := yamlQuoted(f.s[f.pos:])
+		if err != nil {
+			return nil, err
+		}
+		f.pos = len(f.s) - len(rest)
+		return s, nil
+	}
+	start := f.pos
+	for f.pos < len(f.s) {
+		c := f.s[f.pos]
+		if c == ',' || c == ']' || c == '}' || (c == ':' && (key || f.pos+1 == len(f.s) || f.s[f.pos+1] == ' ')) {
+			break
+		}
+		f.pos++
+	}
+	return resolvePlain(f.s[start:f.pos]), nil
+}
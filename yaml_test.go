@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name, in, want string
+	}{
+		{"scalars", "title: Hello\ncount: 3\nratio: 1.50\nok: true\nnone: ~\nempty:\n",
+			`{"count":3,"empty":null,"none":null,"ok":true,"ratio":1.5,"title":"Hello"}`},
+		{"strings that look typed", "a: '007'\nb: \"true\"\nc: 1.2.3\nd: yes\n",
+			`{"a":"007","b":"true","c":"1.2.3","d":"yes"}`},
+		{"document markers and comments", "%YAML 1.2\n--- # front matter\ntitle: T # the title\n# whole line\nlink: https://e.com/a#frag\n...\nignored: after the end\n",
+			`{"link":"https://e.com/a#frag","title":"T"}`},
+		{"quoted", `a: 'it''s # not a comment'` + "\n" + `b: "tab\there \u00e9 \"q\""` + "\n",
+			`{"a":"it's # not a comment","b":"tab\there é \"q\""}`},
+		{"quoted over lines", "a: \"one\n  two\"\n", `{"a":"one two"}`},
+		{"plain continued", "excerpt: a long\n  excerpt here\nnext: x\n", `{"excerpt":"a long excerpt here","next":"x"}`},
+		{"colon in value", "link: https://e.com/x\ntime: 10:30\n", `{"link":"https://e.com/x","time":"10:30"}`},
+		{"nested mapping", "author:\n  name: Ann\n  email: a@e.com\ntitle: T\n",
+			`{"author":{"email":"a@e.com","name":"Ann"},"title":"T"}`},
+		{"sequence at key indent", "tags:\n- a\n- b\ntitle: T\n", `{"tags":["a","b"],"title":"T"}`},
+		{"indented sequence of mappings", "authors:\n  - name: A\n    role: x\n  - name: B\n",
+			`{"authors":[{"name":"A","role":"x"},{"name":"B"}]}`},
+		{"nested sequences", "m:\n  - - 1\n    - 2\n  -\n    - 3\n", `{"m":[[1,2],[3]]}`},
+		{"flow", "tags: [a, 'b, c', 3]\nmeta: {k: v, n: [1, 2], \"q\": 'x'}\n",
+			`{"meta":{"k":"v","n":[1,2],"q":"x"},"tags":["a","b, c","3"]}`},
+		{"flow over lines", "tags: [a,\n  b]\n", `{"tags":["a","b"]}`},
+		{"literal", "content: |\n  <p>one</p>\n\n  <p>two</p>\ntitle: T\n",
+			`{"content":"\u003cp\u003eone\u003c/p\u003e\n\n\u003cp\u003etwo\u003c/p\u003e\n","title":"T"}`},
+		{"literal keep and strip", "a: |+\n  x\n\nb: |-\n  y\n\n", `{"a":"x\n\n","b":"y"}`},
+		{"literal more indented", "a: |\n  x\n    y\n", `{"a":"x\n  y\n"}`},
+		{"explicit indent", "a: |2\n    x\n", `{"a":"  x\n"}`},
+		{"folded", "a: >\n  one\n  two\n\n  three\n    kept\n  four\n",
+			`{"a":"one two\nthree\n  kept\nfour\n"}`},
+		{"crlf", "title: T\r\nlink: L\r\n", `{"link":"L","title":"T"}`},
+		{"quoted key", "\"a b\": 1\n'c:d': 2\n", `{"a b":1,"c:d":2}`},
+		// string fields of the schema keep the scalar's text
+		{"typed-looking strings", "title: 2024\nauthor: 1e3\nexcerpt: True\nlink: 0x1F\npublished_date: 2024\nupdated_date: false\n",
+			`{"author":"1e3","excerpt":"True","link":"0x1F","published_date":"2024","title":"2024","updated_date":"false"}`},
+		{"typed-looking list items", "tags: [2024, true, news]\ncategories:\n- 1.0\ncollections: [3, 4]\ncollection_id: 5\n",
+			`{"categories":["1.0"],"collection_id":5,"collections":[3,4],"tags":["2024","true","news"]}`},
+		{"schema 2", "schema_version: 2\ntitle: 1984\nbody:\n  html: 42\ndates: {published: 2024, updated: true}\nauthor: {name: 7}\n",
+			`{"author":{"name":"7"},"body":{"html":"42"},"dates":{"published":"2024","updated":"true"},"schema_version":2,"title":"1984"}`},
+		{"flow keys", "meta: {1: a, true: b}\n", `{"meta":{"1":"a","true":"b"}}`},
+	} {
+		got, err := yamlToJSON([]byte(tc.in))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if string(got) != tc.want {
+			t.Errorf("%s:\n got %s\nwant %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestYAMLArticle(t *testing.T) {
+	raw, err := yamlToJSON([]byte("title: 2024\npublished_date: 2024-01-02\nauthor: yes\nexcerpt: true\ntags: 1999, 2000\ncontent: |\n  <p>x</p>\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := decodeArticle(raw, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Title != "2024" || a.PublishDate != "2024-01-02" || a.Author != "yes" || a.Excerpt != "true" ||
+		strings.Join(a.Tags, "|") != "1999|2000" || a.Content != "<p>x</p>\n" {
+		t.Errorf("decoded %+v", a)
+	}
+}
+
+func TestYAMLToJSONErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"a: 1\n---\nb: 2\n", "line 2: several documents"},
+		{"--- a: 1\n", "line 1: content after ---"},
+		{"a: 1\na: 2\n", `line 2: key "a" given twice`},
+		{"a:\n\tb: 1\n", "line 2: tabs are not allowed"},
+		{"a: 1\n   b: 2\n", "line 2: bad indentation"},
+		{"a: &x 1\n", "anchors, aliases and tags"},
+		{"a: *x\n", "anchors, aliases and tags"},
+		{"a: !tag 1\n", "anchors, aliases and tags"},
+		{"a: \"open\n", "line 1: unterminated quoted string"},
+		{"a: \"bad \\q\"\n", `unknown escape \q`},
+		{"a: \"x\" y\n", "after the quoted string"},
+		{"a: [1, 2\n", "line 1: want , or ]"},
+		{"a: {k v}\n", "want : after key"},
+		{"a: [1] x\n", "after ["},
+		{"a: |x\n  y\n", "bad block scalar header"},
+		{"- a\n- b\n", "want a mapping of article fields"},
+		{"just text\n", "want a mapping of article fields"},
+		{"", "want a mapping of article fields"},
+	} {
+		_, err := yamlToJSON([]byte(tc.in))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%q: got error %v, want one containing %q", tc.in, err, tc.want)
+		}
+	}
+}